@@ -21,6 +21,10 @@ var templateFlags = []cli.Flag{
 		Name:  "validators",
 		Usage: "Number of Validators",
 	},
+	cli.IntFlag{
+		Name:  "maxgroups",
+		Usage: "Maximum number of validator groups, validators are spread across larger groups beyond it",
+	},
 	cli.IntFlag{
 		Name:  "dev.accounts",
 		Usage: "Number of developer accounts",
@@ -109,9 +113,15 @@ func envFromTemplate(ctx *cli.Context, workdir string) (*env.Environment, *genes
 	if ctx.IsSet("validators") {
 		env.Accounts().NumValidators = ctx.Int("validators")
 	}
+	if ctx.IsSet("maxgroups") {
+		env.Accounts().MaxGroups = ctx.Int("maxgroups")
+	}
 	if ctx.IsSet("dev.accounts") {
 		env.Accounts().NumDeveloperAccounts = ctx.Int("dev.accounts")
 	}
+	if err := env.Accounts().Validate(); err != nil {
+		return nil, nil, err
+	}
 	if ctx.IsSet("mnemonic") {
 		env.Accounts().Mnemonic = ctx.String("mnemonic")
 	}
@@ -132,6 +142,9 @@ func envFromTemplate(ctx *cli.Context, workdir string) (*env.Environment, *genes
 	if ctx.IsSet("blockgaslimit") {
 		genesisConfig.Blockchain.BlockGasLimit = ctx.Uint64("blockgaslimit")
 	}
+	if err := env.Accounts().ValidateMaxGroupSize(genesisConfig.Validators.MaxGroupSize); err != nil {
+		return nil, nil, err
+	}
 
 	return env, genesisConfig, nil
 }
@@ -252,8 +252,15 @@ func loadBot(ctx *cli.Context) error {
 		clients = append(clients, client)
 	}
 
+	developerAccounts := env.Accounts().DeveloperAccounts()
+	for _, acc := range developerAccounts {
+		if acc.IsExternal() {
+			return fmt.Errorf("load test needs the private keys of the developer accounts, but %s is external", acc.Address.Hex())
+		}
+	}
+
 	return loadbot.Start(runCtx, &loadbot.Config{
-		Accounts:              env.Accounts().DeveloperAccounts(),
+		Accounts:              developerAccounts,
 		Amount:                big.NewInt(10000000),
 		TransactionsPerSecond: ctx.Int(loadTestTPSFlag.Name),
 		Clients:               clients,
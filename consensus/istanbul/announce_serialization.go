@@ -0,0 +1,133 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package istanbul
+
+import (
+	"errors"
+
+	"github.com/celo-org/celo-blockchain/consensus/istanbul/announcepb"
+	"github.com/celo-org/celo-blockchain/rlp"
+	"github.com/golang/protobuf/proto"
+)
+
+// protobufWireFormatVersion is the message version byte that prefixes protobuf encoded announce data.
+// RLP encoded announce data is always a list, whose first byte is at least 0xc0, so a lower first byte
+// identifies another wire format.
+const protobufWireFormatVersion byte = 0x01
+
+var (
+	errEmptyAnnounceData          = errors.New("empty announce data")
+	errUnknownAnnounceDataVersion = errors.New("unknown announce data version")
+)
+
+// ProtoMessage is announce data that can also be encoded with protobuf, in addition to RLP.
+// The protobuf encodings are the ones of the messages of the announcepb schema.
+type ProtoMessage interface {
+	MarshalProto() ([]byte, error)
+	UnmarshalProto(data []byte) error
+}
+
+// AnnounceSerializer encodes and decodes announce data in a wire format
+type AnnounceSerializer interface {
+	Encode(val ProtoMessage) ([]byte, error)
+	Decode(data []byte, val ProtoMessage) error
+}
+
+// RLPSerializer is the serializer of the original RLP wire format, which every node understands
+type RLPSerializer struct{}
+
+// Encode implements AnnounceSerializer.Encode
+func (RLPSerializer) Encode(val ProtoMessage) ([]byte, error) {
+	return rlp.EncodeToBytes(val)
+}
+
+// Decode implements AnnounceSerializer.Decode
+func (RLPSerializer) Decode(data []byte, val ProtoMessage) error {
+	return rlp.DecodeBytes(data, val)
+}
+
+// ProtobufSerializer is the serializer of the protobuf wire format.  The encodings are prefixed
+// with a message version byte, so that they can be told apart from RLP encodings.
+type ProtobufSerializer struct{}
+
+// Encode implements AnnounceSerializer.Encode
+func (ProtobufSerializer) Encode(val ProtoMessage) ([]byte, error) {
+	encoded, err := val.MarshalProto()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{protobufWireFormatVersion}, encoded...), nil
+}
+
+// Decode implements AnnounceSerializer.Decode
+func (ProtobufSerializer) Decode(data []byte, val ProtoMessage) error {
+	if len(data) == 0 || data[0] != protobufWireFormatVersion {
+		return errUnknownAnnounceDataVersion
+	}
+	return val.UnmarshalProto(data[1:])
+}
+
+// NewAnnounceSerializer returns the serializer of the wire format
+func NewAnnounceSerializer(wireFormat WireFormat) AnnounceSerializer {
+	if wireFormat == ProtobufWireFormat {
+		return ProtobufSerializer{}
+	}
+	return RLPSerializer{}
+}
+
+// EncodeAnnounceData encodes announce data in the wire format
+func EncodeAnnounceData(wireFormat WireFormat, val ProtoMessage) ([]byte, error) {
+	return NewAnnounceSerializer(wireFormat).Encode(val)
+}
+
+// DecodeAnnounceData decodes announce data in any wire format, which is negotiated by its first byte
+func DecodeAnnounceData(data []byte, val ProtoMessage) error {
+	if len(data) == 0 {
+		return errEmptyAnnounceData
+	}
+	switch {
+	case data[0] >= 0xc0:
+		return RLPSerializer{}.Decode(data, val)
+	case data[0] == protobufWireFormatVersion:
+		return ProtobufSerializer{}.Decode(data, val)
+	default:
+		return errUnknownAnnounceDataVersion
+	}
+}
+
+// MarshalProto implements ProtoMessage.MarshalProto, with the announcepb.EnodeCertificate encoding
+func (ec *EnodeCertificate) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&announcepb.EnodeCertificate{
+		EnodeUrl: ec.EnodeURL,
+		Version:  uint64(ec.Version),
+		NodeTag:  ec.NodeTag,
+	})
+}
+
+// UnmarshalProto implements ProtoMessage.UnmarshalProto
+func (ec *EnodeCertificate) UnmarshalProto(data []byte) error {
+	var pb announcepb.EnodeCertificate
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return err
+	}
+	*ec = EnodeCertificate{
+		EnodeURL: pb.EnodeUrl,
+		Version:  uint(pb.Version),
+		NodeTag:  SanitizeNodeTag(pb.NodeTag),
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package istanbul
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/rlp"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestEnodeCertificateSerializationRoundTrip(t *testing.T) {
+	for _, ec := range []*EnodeCertificate{
+		{EnodeURL: "enode://abc@127.0.0.1:30303", Version: 1600000000},
+		{EnodeURL: "enode://abc@127.0.0.1:30303", Version: 1600000000, NodeTag: "validator-1"},
+		{},
+	} {
+		for _, wireFormat := range []WireFormat{RLPWireFormat, ProtobufWireFormat} {
+			encoded, err := EncodeAnnounceData(wireFormat, ec)
+			if err != nil {
+				t.Fatalf("Error in encoding the enode certificate.  Wire format: %v, Error: %v", wireFormat, err)
+			}
+			var decoded EnodeCertificate
+			if err := DecodeAnnounceData(encoded, &decoded); err != nil {
+				t.Fatalf("Error in decoding the enode certificate.  Wire format: %v, Error: %v", wireFormat, err)
+			}
+			// Only the protobuf wire format carries the node tag
+			want := *ec
+			if wireFormat == RLPWireFormat {
+				want.NodeTag = ""
+			}
+			if !reflect.DeepEqual(want, decoded) {
+				t.Errorf("Incorrect decoded enode certificate.  Wire format: %v, Want: %v, Have: %v", wireFormat, want, decoded)
+			}
+		}
+	}
+}
+
+func TestAnnounceDataDefaultsToRLP(t *testing.T) {
+	ec := &EnodeCertificate{EnodeURL: "enode://abc@127.0.0.1:30303", Version: 1}
+	encoded, err := EncodeAnnounceData(RLPWireFormat, ec)
+	if err != nil {
+		t.Fatalf("Error in encoding the enode certificate.  Error: %v", err)
+	}
+	want, err := rlp.EncodeToBytes(ec)
+	if err != nil {
+		t.Fatalf("Error in RLP encoding the enode certificate.  Error: %v", err)
+	}
+	if !bytes.Equal(encoded, want) {
+		t.Errorf("The default wire format should be RLP.  Want: %x, Have: %x", want, encoded)
+	}
+	if zero := (Config{}).AnnounceWireFormat; zero != RLPWireFormat {
+		t.Errorf("The zero wire format should be RLP")
+	}
+}
+
+func TestDecodeAnnounceDataVersionByte(t *testing.T) {
+	ec := &EnodeCertificate{EnodeURL: "enode://abc@127.0.0.1:30303", Version: 1}
+	protobufEncoded, err := EncodeAnnounceData(ProtobufWireFormat, ec)
+	if err != nil {
+		t.Fatalf("Error in encoding the enode certificate.  Error: %v", err)
+	}
+	if protobufEncoded[0] != protobufWireFormatVersion {
+		t.Errorf("Incorrect message version byte.  Want: %d, Have: %d", protobufWireFormatVersion, protobufEncoded[0])
+	}
+
+	// The RLP serializer doesn't accept protobuf encodings, nor the protobuf serializer RLP ones
+	var decoded EnodeCertificate
+	if err := (RLPSerializer{}).Decode(protobufEncoded, &decoded); err == nil {
+		t.Errorf("The RLP serializer should reject a protobuf encoding")
+	}
+	rlpEncoded, _ := rlp.EncodeToBytes(ec)
+	if err := (ProtobufSerializer{}).Decode(rlpEncoded, &decoded); err != errUnknownAnnounceDataVersion {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errUnknownAnnounceDataVersion, err)
+	}
+
+	// Unknown version bytes and empty data are rejected
+	for _, data := range [][]byte{{0x02, 0x08, 0x01}, {}} {
+		if err := DecodeAnnounceData(data, &decoded); err == nil {
+			t.Errorf("Decoding %x should fail", data)
+		}
+	}
+
+	// So are truncated protobuf encodings
+	if err := DecodeAnnounceData(protobufEncoded[:len(protobufEncoded)-1], &decoded); err == nil {
+		t.Errorf("Decoding a truncated protobuf encoding should fail")
+	}
+}
+
+func TestUnmarshalProtoSkipsUnknownFields(t *testing.T) {
+	ec := &EnodeCertificate{EnodeURL: "enode://abc@127.0.0.1:30303", Version: 1, NodeTag: "tag"}
+	marshaled, err := ec.MarshalProto()
+	if err != nil {
+		t.Fatalf("Error in encoding the enode certificate.  Error: %v", err)
+	}
+
+	// Fields added to the schema by a newer version are ignored
+	buf := proto.NewBuffer(nil)
+	buf.EncodeVarint(100<<3 | proto.WireVarint)
+	buf.EncodeVarint(42)
+	buf.EncodeVarint(101<<3 | proto.WireBytes)
+	buf.EncodeRawBytes([]byte("new field"))
+	encoded := append(buf.Bytes(), marshaled...)
+
+	var decoded EnodeCertificate
+	if err := decoded.UnmarshalProto(encoded); err != nil {
+		t.Fatalf("Error in decoding the enode certificate.  Error: %v", err)
+	}
+	if !reflect.DeepEqual(*ec, decoded) {
+		t.Errorf("Incorrect decoded enode certificate.  Want: %v, Have: %v", *ec, decoded)
+	}
+}
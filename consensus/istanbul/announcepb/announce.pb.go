@@ -0,0 +1,321 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: announce.proto
+
+package announcepb
+
+import (
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+// The enode certificate of a node, see istanbul.EnodeCertificate
+type EnodeCertificate struct {
+	EnodeUrl             string   `protobuf:"bytes,1,opt,name=enode_url,json=enodeUrl,proto3" json:"enode_url,omitempty"`
+	Version              uint64   `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	NodeTag              string   `protobuf:"bytes,3,opt,name=node_tag,json=nodeTag,proto3" json:"node_tag,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *EnodeCertificate) Reset()         { *m = EnodeCertificate{} }
+func (m *EnodeCertificate) String() string { return proto.CompactTextString(m) }
+func (*EnodeCertificate) ProtoMessage()    {}
+func (*EnodeCertificate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4442f6fce4730d4e, []int{0}
+}
+
+func (m *EnodeCertificate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_EnodeCertificate.Unmarshal(m, b)
+}
+func (m *EnodeCertificate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_EnodeCertificate.Marshal(b, m, deterministic)
+}
+func (m *EnodeCertificate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EnodeCertificate.Merge(m, src)
+}
+func (m *EnodeCertificate) XXX_Size() int {
+	return xxx_messageInfo_EnodeCertificate.Size(m)
+}
+func (m *EnodeCertificate) XXX_DiscardUnknown() {
+	xxx_messageInfo_EnodeCertificate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EnodeCertificate proto.InternalMessageInfo
+
+func (m *EnodeCertificate) GetEnodeUrl() string {
+	if m != nil {
+		return m.EnodeUrl
+	}
+	return ""
+}
+
+func (m *EnodeCertificate) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *EnodeCertificate) GetNodeTag() string {
+	if m != nil {
+		return m.NodeTag
+	}
+	return ""
+}
+
+// An enode url encrypted for a destination validator
+type EncryptedEnodeURL struct {
+	DestAddress          []byte   `protobuf:"bytes,1,opt,name=dest_address,json=destAddress,proto3" json:"dest_address,omitempty"`
+	EncryptedEnodeUrl    []byte   `protobuf:"bytes,2,opt,name=encrypted_enode_url,json=encryptedEnodeUrl,proto3" json:"encrypted_enode_url,omitempty"`
+	Plaintext            bool     `protobuf:"varint,3,opt,name=plaintext,proto3" json:"plaintext,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *EncryptedEnodeURL) Reset()         { *m = EncryptedEnodeURL{} }
+func (m *EncryptedEnodeURL) String() string { return proto.CompactTextString(m) }
+func (*EncryptedEnodeURL) ProtoMessage()    {}
+func (*EncryptedEnodeURL) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4442f6fce4730d4e, []int{1}
+}
+
+func (m *EncryptedEnodeURL) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_EncryptedEnodeURL.Unmarshal(m, b)
+}
+func (m *EncryptedEnodeURL) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_EncryptedEnodeURL.Marshal(b, m, deterministic)
+}
+func (m *EncryptedEnodeURL) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EncryptedEnodeURL.Merge(m, src)
+}
+func (m *EncryptedEnodeURL) XXX_Size() int {
+	return xxx_messageInfo_EncryptedEnodeURL.Size(m)
+}
+func (m *EncryptedEnodeURL) XXX_DiscardUnknown() {
+	xxx_messageInfo_EncryptedEnodeURL.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EncryptedEnodeURL proto.InternalMessageInfo
+
+func (m *EncryptedEnodeURL) GetDestAddress() []byte {
+	if m != nil {
+		return m.DestAddress
+	}
+	return nil
+}
+
+func (m *EncryptedEnodeURL) GetEncryptedEnodeUrl() []byte {
+	if m != nil {
+		return m.EncryptedEnodeUrl
+	}
+	return nil
+}
+
+func (m *EncryptedEnodeURL) GetPlaintext() bool {
+	if m != nil {
+		return m.Plaintext
+	}
+	return false
+}
+
+// The content of a query enode message
+type QueryEnodeData struct {
+	EncryptedEnodeUrls   []*EncryptedEnodeURL `protobuf:"bytes,1,rep,name=encrypted_enode_urls,json=encryptedEnodeUrls,proto3" json:"encrypted_enode_urls,omitempty"`
+	Version              uint64               `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	Timestamp            uint64               `protobuf:"varint,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	NodeTag              string               `protobuf:"bytes,4,opt,name=node_tag,json=nodeTag,proto3" json:"node_tag,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *QueryEnodeData) Reset()         { *m = QueryEnodeData{} }
+func (m *QueryEnodeData) String() string { return proto.CompactTextString(m) }
+func (*QueryEnodeData) ProtoMessage()    {}
+func (*QueryEnodeData) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4442f6fce4730d4e, []int{2}
+}
+
+func (m *QueryEnodeData) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_QueryEnodeData.Unmarshal(m, b)
+}
+func (m *QueryEnodeData) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_QueryEnodeData.Marshal(b, m, deterministic)
+}
+func (m *QueryEnodeData) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_QueryEnodeData.Merge(m, src)
+}
+func (m *QueryEnodeData) XXX_Size() int {
+	return xxx_messageInfo_QueryEnodeData.Size(m)
+}
+func (m *QueryEnodeData) XXX_DiscardUnknown() {
+	xxx_messageInfo_QueryEnodeData.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_QueryEnodeData proto.InternalMessageInfo
+
+func (m *QueryEnodeData) GetEncryptedEnodeUrls() []*EncryptedEnodeURL {
+	if m != nil {
+		return m.EncryptedEnodeUrls
+	}
+	return nil
+}
+
+func (m *QueryEnodeData) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *QueryEnodeData) GetTimestamp() uint64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *QueryEnodeData) GetNodeTag() string {
+	if m != nil {
+		return m.NodeTag
+	}
+	return ""
+}
+
+// A signed announce version.  Like with RLP, the public key and address are
+// recovered from the signature
+type VersionCertificate struct {
+	Version              uint64   `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	Signature            []byte   `protobuf:"bytes,2,opt,name=signature,proto3" json:"signature,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *VersionCertificate) Reset()         { *m = VersionCertificate{} }
+func (m *VersionCertificate) String() string { return proto.CompactTextString(m) }
+func (*VersionCertificate) ProtoMessage()    {}
+func (*VersionCertificate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4442f6fce4730d4e, []int{3}
+}
+
+func (m *VersionCertificate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_VersionCertificate.Unmarshal(m, b)
+}
+func (m *VersionCertificate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_VersionCertificate.Marshal(b, m, deterministic)
+}
+func (m *VersionCertificate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_VersionCertificate.Merge(m, src)
+}
+func (m *VersionCertificate) XXX_Size() int {
+	return xxx_messageInfo_VersionCertificate.Size(m)
+}
+func (m *VersionCertificate) XXX_DiscardUnknown() {
+	xxx_messageInfo_VersionCertificate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_VersionCertificate proto.InternalMessageInfo
+
+func (m *VersionCertificate) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *VersionCertificate) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+// The content of a version certificates message
+type VersionCertificates struct {
+	VersionCertificates  []*VersionCertificate `protobuf:"bytes,1,rep,name=version_certificates,json=versionCertificates,proto3" json:"version_certificates,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}              `json:"-"`
+	XXX_unrecognized     []byte                `json:"-"`
+	XXX_sizecache        int32                 `json:"-"`
+}
+
+func (m *VersionCertificates) Reset()         { *m = VersionCertificates{} }
+func (m *VersionCertificates) String() string { return proto.CompactTextString(m) }
+func (*VersionCertificates) ProtoMessage()    {}
+func (*VersionCertificates) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4442f6fce4730d4e, []int{4}
+}
+
+func (m *VersionCertificates) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_VersionCertificates.Unmarshal(m, b)
+}
+func (m *VersionCertificates) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_VersionCertificates.Marshal(b, m, deterministic)
+}
+func (m *VersionCertificates) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_VersionCertificates.Merge(m, src)
+}
+func (m *VersionCertificates) XXX_Size() int {
+	return xxx_messageInfo_VersionCertificates.Size(m)
+}
+func (m *VersionCertificates) XXX_DiscardUnknown() {
+	xxx_messageInfo_VersionCertificates.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_VersionCertificates proto.InternalMessageInfo
+
+func (m *VersionCertificates) GetVersionCertificates() []*VersionCertificate {
+	if m != nil {
+		return m.VersionCertificates
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*EnodeCertificate)(nil), "istanbul.announce.EnodeCertificate")
+	proto.RegisterType((*EncryptedEnodeURL)(nil), "istanbul.announce.EncryptedEnodeURL")
+	proto.RegisterType((*QueryEnodeData)(nil), "istanbul.announce.QueryEnodeData")
+	proto.RegisterType((*VersionCertificate)(nil), "istanbul.announce.VersionCertificate")
+	proto.RegisterType((*VersionCertificates)(nil), "istanbul.announce.VersionCertificates")
+}
+
+func init() { proto.RegisterFile("announce.proto", fileDescriptor_4442f6fce4730d4e) }
+
+var fileDescriptor_4442f6fce4730d4e = []byte{
+	// 335 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x92, 0xcb, 0x6e, 0xe2, 0x30,
+	0x14, 0x86, 0x15, 0x40, 0x03, 0x39, 0x20, 0x34, 0x18, 0x16, 0x19, 0x0d, 0x0b, 0x26, 0x9a, 0x4a,
+	0xac, 0xb2, 0x68, 0x9f, 0xa0, 0x17, 0x76, 0x6c, 0x6a, 0x15, 0x54, 0x75, 0x13, 0x99, 0xe4, 0x14,
+	0x59, 0x0a, 0x4e, 0x64, 0x9f, 0xa0, 0xb2, 0xef, 0x5b, 0xf5, 0xe5, 0xaa, 0x18, 0xa2, 0x80, 0x8c,
+	0xba, 0xf4, 0x17, 0xff, 0x97, 0xfc, 0x09, 0x0c, 0x85, 0x52, 0x79, 0xa9, 0x12, 0x8c, 0x0a, 0x9d,
+	0x53, 0xce, 0x46, 0xd2, 0x90, 0x50, 0x9b, 0x32, 0x8b, 0xea, 0x07, 0x61, 0x0a, 0xbf, 0x17, 0x2a,
+	0x4f, 0xf1, 0x11, 0x35, 0xc9, 0x77, 0x99, 0x08, 0x42, 0xf6, 0x17, 0x7c, 0xac, 0x58, 0x5c, 0xea,
+	0x2c, 0xf0, 0x66, 0xde, 0xdc, 0xe7, 0x3d, 0x0b, 0x56, 0x3a, 0x63, 0x01, 0x74, 0xf7, 0xa8, 0x8d,
+	0xcc, 0x55, 0xd0, 0x9a, 0x79, 0xf3, 0x0e, 0xaf, 0x8f, 0xec, 0x0f, 0xf4, 0xac, 0x8a, 0xc4, 0x36,
+	0x68, 0x5b, 0x55, 0xb7, 0x3a, 0xbf, 0x88, 0x6d, 0xf8, 0xe9, 0xc1, 0x68, 0xa1, 0x12, 0x7d, 0x28,
+	0x08, 0x53, 0x9b, 0xb7, 0xe2, 0x4b, 0xf6, 0x0f, 0x06, 0x29, 0x1a, 0x8a, 0x45, 0x9a, 0x6a, 0x34,
+	0xc6, 0x46, 0x0d, 0x78, 0xbf, 0x62, 0xf7, 0x47, 0xc4, 0x22, 0x18, 0x63, 0xad, 0x8b, 0x9b, 0x52,
+	0x2d, 0x7b, 0x73, 0x84, 0x97, 0x96, 0x3a, 0x63, 0x53, 0xf0, 0x8b, 0x4c, 0x48, 0x45, 0xf8, 0x41,
+	0xb6, 0x44, 0x8f, 0x37, 0x20, 0xfc, 0xf2, 0x60, 0xf8, 0x5c, 0xa2, 0x3e, 0xd8, 0xfb, 0x4f, 0x82,
+	0x04, 0x5b, 0xc3, 0xe4, 0x4a, 0x40, 0xd5, 0xa5, 0x3d, 0xef, 0xdf, 0xfe, 0x8f, 0x9c, 0xc5, 0x22,
+	0xe7, 0x3d, 0x38, 0x73, 0x7a, 0x98, 0x1f, 0x66, 0x9a, 0x82, 0x4f, 0x72, 0x87, 0x86, 0xc4, 0xae,
+	0xb0, 0x15, 0x3b, 0xbc, 0x01, 0x17, 0x23, 0x76, 0x2e, 0x47, 0x5c, 0x02, 0x5b, 0x1f, 0x3d, 0xce,
+	0x3f, 0xd6, 0x59, 0x90, 0xe7, 0x04, 0x19, 0xb9, 0x55, 0x82, 0x4a, 0x8d, 0xa7, 0xc5, 0x1a, 0x10,
+	0xe6, 0x30, 0x76, 0xdd, 0x0c, 0x7b, 0x85, 0xc9, 0x49, 0x1f, 0x27, 0x67, 0xfc, 0xb4, 0xc7, 0xcd,
+	0x95, 0x3d, 0x5c, 0x17, 0x3e, 0xde, 0xbb, 0xce, 0x0f, 0x83, 0x37, 0xa8, 0x35, 0xc5, 0x66, 0xf3,
+	0xcb, 0xfe, 0x91, 0x77, 0xdf, 0x03, 0x00, 0xf9, 0x62, 0x22, 0x34, 0xa3, 0x02, 0x00, 0x00,
+}
@@ -17,26 +17,39 @@
 package backend
 
 import (
+	"bytes"
 	"crypto/ecdsa"
-	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"net"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/celo-org/celo-blockchain/accounts"
 	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/common/hexutil"
+	"github.com/celo-org/celo-blockchain/common/mclock"
 	"github.com/celo-org/celo-blockchain/consensus"
 	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	enodesdb "github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/db"
 	vet "github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/enodes"
 	"github.com/celo-org/celo-blockchain/consensus/istanbul/proxy"
+	"github.com/celo-org/celo-blockchain/contract_comm/validators"
+	"github.com/celo-org/celo-blockchain/core/types"
 	"github.com/celo-org/celo-blockchain/crypto"
+	blscrypto "github.com/celo-org/celo-blockchain/crypto/bls"
 	"github.com/celo-org/celo-blockchain/crypto/ecies"
+	"github.com/celo-org/celo-blockchain/event"
+	"github.com/celo-org/celo-blockchain/log"
 	"github.com/celo-org/celo-blockchain/p2p"
 	"github.com/celo-org/celo-blockchain/p2p/enode"
 	"github.com/celo-org/celo-blockchain/rlp"
+	"golang.org/x/time/rate"
 )
 
 // ==============================================
@@ -46,12 +59,110 @@ import (
 const (
 	queryEnodeGossipCooldownDuration         = 5 * time.Minute
 	versionCertificateGossipCooldownDuration = 5 * time.Minute
+
+	// The window within which a query enode message from the same origin isn't answered with
+	// the same enode certificate again, e.g. when the origin retries its query
+	queryEnodeAnswerDedupWindow = 30 * time.Second
+
+	// The number of times an announce message gossip is attempted before giving up, when this node has no peers
+	announceGossipMaxAttempts = 3
 )
 
 var (
+	// The wait before retrying a failed announce message gossip.  It doubles after every failed attempt.
+	// This is a var so that tests can shorten it.
+	announceGossipRetryBackoff = 2 * time.Second
+
+	// The wait before retrying a failed send of enode certificates to the proxies, and its maximum.
+	// It doubles after every failed attempt.  These are vars so that tests can shorten them.
+	proxyEnodeCertsRetryBackoff    = 2 * time.Second
+	proxyEnodeCertsMaxRetryBackoff = 1 * time.Minute
+
+	// The wait before retrying to send enode certificates to the validator conn set members that
+	// were unreachable, and its maximum.  It doubles after every attempt that leaves some of them
+	// unreachable.  These are vars so that tests can shorten them.
+	enodeCertsRetryBackoff    = 5 * time.Second
+	enodeCertsMaxRetryBackoff = 1 * time.Minute
+
 	errInvalidEnodeCertMsgMapInconsistentVersion = errors.New("invalid enode certificate message map because of inconsistent version")
 
 	errNodeMissingEnodeCertificate = errors.New("Node is missing enode certificate")
+
+	errEncryptedEnodeURLTooLong = errors.New("encrypted enode url is too long")
+
+	errEnodeURLTooLong = errors.New("enode url is too long")
+
+	errMalformedDecryptedEnodeURL = errors.New("decrypt succeeded but enode url is malformed")
+
+	errPlaintextEnodeURLNotAllowed = errors.New("plaintext enode urls are not allowed")
+
+	// errECIESParamsMismatch is returned when an encrypted enode url fails its MAC check, which
+	// is most likely caused by the sender using different ECIES shared information
+	errECIESParamsMismatch = errors.New("encrypted enode url failed the mac check, the sender's ecies params may not match")
+
+	// errECIESSharedInfoTooLong is returned when starting to announce with ECIES shared
+	// information longer than maxECIESSharedInfoLength
+	errECIESSharedInfoTooLong = fmt.Errorf("ecies shared info is longer than %d bytes", maxECIESSharedInfoLength)
+
+	errInvalidQueryEnodeMsg = errors.New("invalid query enode message")
+
+	// errInvalidEnodeCertificateMsg is returned when a payload is not an enode certificate message
+	errInvalidEnodeCertificateMsg = errors.New("invalid enode certificate message")
+
+	// errMalformedEnodeCertificateURL is returned when the enode url of an enode certificate can't be parsed
+	errMalformedEnodeCertificateURL = errors.New("enode certificate enode url is malformed")
+
+	errEnodeCertificateRequestNotSupported = errors.New("peer does not support enode certificate requests")
+
+	// errEnodeCertificateRequestRateLimited is returned when a peer requests enode certificates too often
+	errEnodeCertificateRequestRateLimited = errors.New("peer exceeded the enode certificate request rate limit")
+
+	errUnknownBLSPublicKey = errors.New("announce message sender's BLS public key is unknown")
+
+	// errQueryEnodeRegossipOnCooldown is returned for a BLS signed query enode message that is rejected
+	// without verification, since this node would only regossip it and its source address is on the cooldown
+	errQueryEnodeRegossipOnCooldown = errors.New("query enode message source address is on the regossip cooldown")
+
+	errAnnounceVersionNotNewer = errors.New("announce version is not newer than the current one")
+
+	// errPruneByAgeUnsupported is returned when pruning version certificates by age in the
+	// epoch+counter version mode, where versions aren't timestamps
+	errPruneByAgeUnsupported = errors.New("version certificates can't be pruned by age in epoch+counter version mode")
+
+	errAnnounceVersionTooFarAhead = errors.New("announce version is too far ahead of the current time or epoch")
+
+	errNoOwnVersionCertificate = errors.New("this node has not generated a version certificate")
+
+	errEnodeCertificateNotAllowlisted = errors.New("enode certificate sender is not in the enode certificate allowlist")
+
+	errEnodeCertificateExpired = errors.New("enode certificate version is older than the maximum age")
+
+	// errDecryptionKeyUnavailable is returned when this node's key can't be used to decrypt enode urls,
+	// e.g. because the account is locked.  This is a local issue, so the sender isn't at fault.
+	errDecryptionKeyUnavailable = errors.New("key to decrypt enode urls is unavailable")
+)
+
+// maxConsecutiveMalformedEnodeURLs is the number of consecutive query enode messages from a
+// validator with a malformed enode URL that are tolerated before the error is propagated.
+// A single malformed URL is more likely caused by a corrupted ciphertext than by a malicious sender.
+const maxConsecutiveMalformedEnodeURLs = 3
+
+// maxECIESSharedInfoLength is the maximum length of each configured ECIES shared information.
+const maxECIESSharedInfoLength = 64
+
+// maxConcurrentReachabilityProbes is the maximum number of reachability probes in flight.
+// Enode certificates received while all of them are busy aren't probed.
+const maxConcurrentReachabilityProbes = 16
+
+// announcingStateEventBufferSize is the number of announcing state events that can be pending delivery.
+// Events posted while the buffer is full are dropped.
+const announcingStateEventBufferSize = 16
+
+// A peer can request an enode certificate once per enodeCertificateRequestInterval, with bursts of
+// enodeCertificateRequestBurst requests.  Requests beyond that aren't answered.
+const (
+	enodeCertificateRequestInterval = 10 * time.Second
+	enodeCertificateRequestBurst    = 3
 )
 
 // QueryEnodeGossipFrequencyState specifies how frequently to gossip query enode messages
@@ -72,31 +183,40 @@ const (
 
 // The announceThread will:
 // 1) Periodically poll to see if this node should be announcing
-// 2) Periodically share the entire version certificate table with all peers
+// 2) Periodically share the version certificate table with all peers. Only the entries
+//    that changed since the previous share are sent, except for a less frequent full share
 // 3) Periodically prune announce-related data structures
 // 4) Gossip announce messages periodically when requested
 // 5) Update announce version when requested
+// 6) Stop announcing and querying while paused, and resume right away when unpaused
 func (sb *Backend) announceThread() {
 	logger := sb.logger.New("func", "announceThread")
 
 	sb.announceThreadWg.Add(1)
 	defer sb.announceThreadWg.Done()
+	defer sb.announceGoroutines.track("announceThread")()
+
+	// Poll if istanbul core is running and if this node is in the validator conn set.
+	// If both conditions are true, then this node should announce.
+	// Occasionally share the entire version certificate table with all peers, and prune
+	// the announce data structures.
+	scheduler := newAnnounceScheduler(sb.announceClock, announceIntervalsFromConfig(sb.config))
+	scheduler.Start(checkIfShouldAnnounceTask, scheduler.intervals.CheckIfShouldAnnounce)
+	scheduler.Start(shareVersionCertificatesTask, scheduler.intervals.ShareVersionCertificates)
+	scheduler.Start(pruneAnnounceDataStructuresTask, scheduler.intervals.PruneAnnounceDataStructures)
+	if scheduler.intervals.ReconcilePeers > 0 {
+		scheduler.Start(reconcilePeersTask, scheduler.intervals.ReconcilePeers)
+	}
+	defer scheduler.StopAll()
+	sb.announceGoroutines.setScheduler(scheduler)
+	defer sb.announceGoroutines.setScheduler(nil)
 
-	// Create a ticker to poll if istanbul core is running and if this node is in
-	// the validator conn set. If both conditions are true, then this node should announce.
-	checkIfShouldAnnounceTicker := time.NewTicker(5 * time.Second)
-	// Occasionally share the entire version certificate table with all peers
-	shareVersionCertificatesTicker := time.NewTicker(5 * time.Minute)
-	pruneAnnounceDataStructuresTicker := time.NewTicker(10 * time.Minute)
-
-	var queryEnodeTicker *time.Ticker
-	var queryEnodeTickerCh <-chan time.Time
 	var queryEnodeFrequencyState QueryEnodeGossipFrequencyState
-	var currentQueryEnodeTickerDuration time.Duration
 	var numQueryEnodesInHighFreqAfterFirstPeerState int
-	// TODO: this can be removed once we have more faith in this protocol
-	var updateAnnounceVersionTicker *time.Ticker
-	var updateAnnounceVersionTickerCh <-chan time.Time
+
+	// The first share is a full one
+	var lastFullShare mclock.AbsTime
+	hasFullShared := false
 
 	// Replica validators listen & query for enodes       (query true, announce false)
 	// Primary validators annouce (updateAnnounceVersion) (query true, announce true)
@@ -106,121 +226,160 @@ func (sb *Backend) announceThread() {
 	var querying, announcing bool
 
 	updateAnnounceVersionFunc := func() {
-		version := getTimestamp()
-		if version <= sb.GetAnnounceVersion() {
-			logger.Debug("Announce version is not newer than the existing version", "existing version", sb.announceVersion, "attempted new version", version)
-			return
-		}
-		if err := sb.setAndShareUpdatedAnnounceVersion(version); err != nil {
+		version := sb.nextAnnounceVersion()
+		if err := sb.SetAnnounceVersion(version); err == errAnnounceVersionNotNewer {
+			logger.Debug("Announce version is not newer than the existing version", "existing version", sb.GetAnnounceVersion(), "attempted new version", version)
+		} else if err != nil {
 			logger.Warn("Error updating announce version", "err", err)
-			return
 		}
-		sb.announceVersionMu.Lock()
-		logger.Debug("Updating announce version", "announceVersion", version)
-		sb.announceVersion = version
-		sb.announceVersionMu.Unlock()
 	}
 
-	for {
-		select {
-		case <-checkIfShouldAnnounceTicker.C:
-			logger.Trace("Checking if this node should announce it's enode")
+	checkIfShouldAnnounce := func() {
+		logger.Trace("Checking if this node should announce it's enode")
 
-			var err error
-			shouldQuery, err = sb.shouldParticipateInAnnounce()
-			if err != nil {
-				logger.Warn("Error in checking if should announce", err)
-				break
+		inConnSet, err := sb.shouldParticipateInAnnounce()
+		if err != nil {
+			logger.Warn("Error in checking if should announce", err)
+			return
+		}
+		paused := sb.IsAnnouncePaused()
+		shouldQuery = inConnSet && !paused
+		shouldAnnounce = shouldQuery && sb.IsValidating()
+
+		if shouldQuery && !querying {
+			logger.Info("Starting to query")
+
+			// Gossip the announce after the initial delay (a minute by default).
+			// The delay allows for all receivers of the announce message to
+			// have a more up-to-date cached registered/elected valset, and
+			// hence more likely that they will be aware that this node is
+			// within that set.
+			scheduler.StartOnce(initialQueryEnodeTask, scheduler.intervals.InitialQueryEnode)
+
+			queryEnodeInterval := scheduler.intervals.QueryEnode
+			if sb.config.AnnounceAggressiveQueryEnodeGossipOnEnablement {
+				queryEnodeFrequencyState = HighFreqBeforeFirstPeerState
+				// Send an query enode message once a minute
+				queryEnodeInterval = scheduler.intervals.AggressiveQueryEnode
+				numQueryEnodesInHighFreqAfterFirstPeerState = 0
+			} else {
+				queryEnodeFrequencyState = LowFreqState
 			}
-			shouldAnnounce = shouldQuery && sb.IsValidating()
-
-			if shouldQuery && !querying {
-				logger.Info("Starting to query")
-
-				// Gossip the announce after a minute.
-				// The delay allows for all receivers of the announce message to
-				// have a more up-to-date cached registered/elected valset, and
-				// hence more likely that they will be aware that this node is
-				// within that set.
-				waitPeriod := 1 * time.Minute
-				if sb.config.Epoch <= 10 {
-					waitPeriod = 5 * time.Second
-				}
-				time.AfterFunc(waitPeriod, func() {
-					sb.startGossipQueryEnodeTask()
-				})
 
-				if sb.config.AnnounceAggressiveQueryEnodeGossipOnEnablement {
-					queryEnodeFrequencyState = HighFreqBeforeFirstPeerState
-					// Send an query enode message once a minute
-					currentQueryEnodeTickerDuration = 1 * time.Minute
-					numQueryEnodesInHighFreqAfterFirstPeerState = 0
-				} else {
-					queryEnodeFrequencyState = LowFreqState
-					currentQueryEnodeTickerDuration = time.Duration(sb.config.AnnounceQueryEnodeGossipPeriod) * time.Second
-				}
+			// Enable periodic gossiping
+			scheduler.Start(queryEnodeTask, queryEnodeInterval)
 
-				// Enable periodic gossiping by setting announceGossipTickerCh to non nil value
-				queryEnodeTicker = time.NewTicker(currentQueryEnodeTickerDuration)
-				queryEnodeTickerCh = queryEnodeTicker.C
+			querying = true
+			logger.Trace("Enabled periodic gossiping of announce message (query mode)")
 
-				querying = true
-				logger.Trace("Enabled periodic gossiping of announce message (query mode)")
+		} else if !shouldQuery && querying {
+			logger.Info("Stopping querying")
 
-			} else if !shouldQuery && querying {
-				logger.Info("Stopping querying")
+			// Disable periodic queryEnode msgs, including a pending initial one
+			scheduler.Stop(queryEnodeTask)
+			scheduler.Stop(initialQueryEnodeTask)
+			querying = false
+			logger.Trace("Disabled periodic gossiping of announce message (query mode)")
+		}
 
-				// Disable periodic queryEnode msgs by setting queryEnodeTickerCh to nil
-				queryEnodeTicker.Stop()
-				queryEnodeTickerCh = nil
-				querying = false
-				logger.Trace("Disabled periodic gossiping of announce message (query mode)")
-			}
+		if shouldAnnounce && !announcing {
+			logger.Info("Starting to announce")
+			sb.postAnnouncingStateEvent(true, "validating and in the validator connection set")
 
-			if shouldAnnounce && !announcing {
-				logger.Info("Starting to announce")
+			updateAnnounceVersionFunc()
 
-				updateAnnounceVersionFunc()
+			scheduler.Start(updateAnnounceVersionTask, scheduler.intervals.UpdateAnnounceVersion)
 
-				updateAnnounceVersionTicker = time.NewTicker(5 * time.Minute)
-				updateAnnounceVersionTickerCh = updateAnnounceVersionTicker.C
+			announcing = true
+			logger.Trace("Enabled periodic gossiping of announce message")
+		} else if !shouldAnnounce && announcing {
+			logger.Info("Stopping announcing")
+			if paused {
+				sb.postAnnouncingStateEvent(false, "announcing paused")
+			} else if !shouldQuery {
+				sb.postAnnouncingStateEvent(false, "not in the validator connection set")
+			} else {
+				sb.postAnnouncingStateEvent(false, "not validating")
+			}
 
-				announcing = true
-				logger.Trace("Enabled periodic gossiping of announce message")
-			} else if !shouldAnnounce && announcing {
-				logger.Info("Stopping announcing")
+			// Disable periodic updating of announce version
+			scheduler.Stop(updateAnnounceVersionTask)
 
-				// Disable periodic updating of announce version
-				updateAnnounceVersionTicker.Stop()
-				updateAnnounceVersionTickerCh = nil
+			announcing = false
+			logger.Trace("Disabled periodic gossiping of announce message")
+		}
+	}
 
-				announcing = false
-				logger.Trace("Disabled periodic gossiping of announce message")
-			}
+	for {
+		select {
+		case task := <-scheduler.Events():
+			switch task {
+			case checkIfShouldAnnounceTask:
+				checkIfShouldAnnounce()
+
+			case shareVersionCertificatesTask:
+				// Send the changed version certificates, or all of them if a full share
+				// is due, to every peer. Only the entries that are new to a node will end
+				// up being regossiped throughout the network.
+				if sb.IsAnnouncePaused() {
+					logger.Trace("Announcing is paused, not sharing version certificates")
+					break
+				}
+				now := sb.announceClock.Now()
+				full := !hasFullShared || time.Duration(now-lastFullShare) >= scheduler.intervals.FullShareVersionCertificates
+				versionCertificates, err := sb.getVersionCertificatesToShare(full)
+				if err != nil {
+					logger.Warn("Error getting version certificates to share", "full", full, "err", err)
+					break
+				}
+				if full {
+					lastFullShare = now
+					hasFullShared = true
+				}
+				if len(versionCertificates) == 0 {
+					logger.Trace("No changed version certificates to share")
+					break
+				}
+				if err := sb.gossipVersionCertificatesMsg(versionCertificates, nil); err != nil {
+					sb.announceWarnings.Warn(logger, "Error gossiping version certificates", "full", full, "err", err)
+				}
 
-		case <-shareVersionCertificatesTicker.C:
-			// Send all version certificates to every peer. Only the entries
-			// that are new to a node will end up being regossiped throughout the
-			// network.
-			allVersionCertificates, err := sb.getAllVersionCertificates()
-			if err != nil {
-				logger.Warn("Error getting all version certificates", "err", err)
-				break
-			}
-			if err := sb.gossipVersionCertificatesMsg(allVersionCertificates); err != nil {
-				logger.Warn("Error gossiping all version certificates")
-			}
+			case updateAnnounceVersionTask:
+				if shouldAnnounce {
+					updateAnnounceVersionFunc()
+				}
 
-		case <-updateAnnounceVersionTickerCh:
-			if shouldAnnounce {
-				updateAnnounceVersionFunc()
-			}
+			case queryEnodeTask, initialQueryEnodeTask:
+				// Events of a stopped query enode task may still be queued
+				if querying {
+					sb.startGossipQueryEnodeTask()
+				} else {
+					logger.Debug("Skipping query enode task, this node is not querying", "task", task)
+				}
+
+			case pruneAnnounceDataStructuresTask:
+				if err := sb.pruneAnnounceDataStructures(); err != nil {
+					logger.Warn("Error in pruning announce data structures", "err", err)
+				}
+				// Also keeps the silent validators gauge up to date
+				if silentValidators, err := sb.PartitionDiagnostics(); err != nil {
+					logger.Warn("Error in generating partition diagnostics", "err", err)
+				} else if len(silentValidators) > 0 {
+					logger.Debug("Validators in the validator conn set are silent, this node may be partitioned from them", "count", len(silentValidators))
+				}
 
-		case <-queryEnodeTickerCh:
-			sb.startGossipQueryEnodeTask()
+			case reconcilePeersTask:
+				if reconciliation, err := sb.ReconcilePeers(); err != nil {
+					logger.Warn("Error in reconciling peers", "err", err)
+				} else if len(reconciliation.Disconnected) > 0 || len(reconciliation.UntrackedPeers) > 0 {
+					logger.Debug("Reconciled the val enode table with the validator peers", "disconnected", reconciliation.Disconnected, "untrackedPeers", reconciliation.UntrackedPeers)
+				}
+			}
 
 		case <-sb.generateAndGossipQueryEnodeCh:
-			if shouldQuery {
+			if !shouldQuery {
+				logger.Debug("Skipping query enode gossip, this node should not query")
+			} else {
 				switch queryEnodeFrequencyState {
 				case HighFreqBeforeFirstPeerState:
 					if len(sb.broadcaster.FindPeers(nil, p2p.AnyPurpose)) > 0 {
@@ -234,12 +393,9 @@ func (sb *Backend) announceThread() {
 					numQueryEnodesInHighFreqAfterFirstPeerState++
 
 				case LowFreqState:
-					if currentQueryEnodeTickerDuration != time.Duration(sb.config.AnnounceQueryEnodeGossipPeriod)*time.Second {
-						// Reset the ticker
-						currentQueryEnodeTickerDuration = time.Duration(sb.config.AnnounceQueryEnodeGossipPeriod) * time.Second
-						queryEnodeTicker.Stop()
-						queryEnodeTicker = time.NewTicker(currentQueryEnodeTickerDuration)
-						queryEnodeTickerCh = queryEnodeTicker.C
+					if querying && scheduler.Interval(queryEnodeTask) != scheduler.intervals.QueryEnode {
+						// Reset the query enode task's interval
+						scheduler.Start(queryEnodeTask, scheduler.intervals.QueryEnode)
 					}
 				}
 				// This node may have recently sent out an announce message within
@@ -248,7 +404,7 @@ func (sb *Backend) announceThread() {
 				// processed by this node's peers. This is especially helpful when a network
 				// is first starting up.
 				if _, err := sb.generateAndGossipQueryEnode(sb.GetAnnounceVersion(), queryEnodeFrequencyState == LowFreqState); err != nil {
-					logger.Warn("Error in generating and gossiping queryEnode", "err", err)
+					sb.announceWarnings.Warn(logger, "Error in generating and gossiping queryEnode", "err", err)
 				}
 			}
 
@@ -257,26 +413,60 @@ func (sb *Backend) announceThread() {
 				updateAnnounceVersionFunc()
 			}
 
-		case <-pruneAnnounceDataStructuresTicker.C:
-			if err := sb.pruneAnnounceDataStructures(); err != nil {
-				logger.Warn("Error in pruning announce data structures", "err", err)
+		case <-sb.announcePausedToggledCh:
+			// Apply the pause or resume right away instead of at the next check
+			checkIfShouldAnnounce()
+			if querying {
+				// Gossip a query enode message now, instead of waiting for the initial one
+				sb.startGossipQueryEnodeTask()
 			}
 
 		case <-sb.announceThreadQuit:
-			checkIfShouldAnnounceTicker.Stop()
-			pruneAnnounceDataStructuresTicker.Stop()
-			if querying {
-				queryEnodeTicker.Stop()
-
-			}
 			if announcing {
-				updateAnnounceVersionTicker.Stop()
+				sb.postAnnouncingStateEvent(false, "announce thread stopped")
 			}
 			return
 		}
 	}
 }
 
+// postAnnouncingStateEvent notifies subscribers that this node started or stopped announcing.
+// The event is queued for announcingStateEventLoop without blocking, and dropped if the queue is full,
+// so that slow subscribers can't stall the announce thread.  Every posted event gets the next sequence
+// number, so subscribers can detect dropped events.
+func (sb *Backend) postAnnouncingStateEvent(announcing bool, reason string) {
+	ev := istanbul.AnnouncingStateEvent{
+		Announcing: announcing,
+		Reason:     reason,
+		Seq:        atomic.AddUint64(&sb.announcingStateSeq, 1),
+	}
+	select {
+	case sb.announcingStateEventCh <- ev:
+	default:
+		sb.announcingStateEventsDroppedCounter.Inc(1)
+		sb.logger.Warn("Dropped announcing state event, the queue is full", "func", "postAnnouncingStateEvent", "announcing", announcing, "reason", reason, "seq", ev.Seq)
+	}
+}
+
+// announcingStateEventLoop sends the queued announcing state events to the subscribers, in the order
+// they were posted, until the backend is closed.
+func (sb *Backend) announcingStateEventLoop() {
+	for {
+		select {
+		case ev := <-sb.announcingStateEventCh:
+			sb.announcingStateFeed.Send(ev)
+		case <-sb.announcingStateQuit:
+			return
+		}
+	}
+}
+
+// SubscribeAnnouncingState subscribes a channel to events posted when this node starts or stops announcing.
+// Events are dropped, rather than blocking the announce thread, while the subscribers fall behind.
+func (sb *Backend) SubscribeAnnouncingState(ch chan<- istanbul.AnnouncingStateEvent) event.Subscription {
+	return sb.announcingStateScope.Track(sb.announcingStateFeed.Subscribe(ch))
+}
+
 // startGossipQueryEnodeTask will schedule a task for the announceThread to
 // generate and gossip a queryEnode message
 func (sb *Backend) startGossipQueryEnodeTask() {
@@ -306,6 +496,8 @@ func (sb *Backend) shouldParticipateInAnnounce() (bool, error) {
 // 2)  valEnodeTable
 // 3)  lastVersionCertificatesGossiped
 // 4)  versionCertificateTable
+// All four are pruned against the same snapshot of the validator connection set, and the removed
+// entries are reported to the prune callback, if there is one.
 func (sb *Backend) pruneAnnounceDataStructures() error {
 	logger := sb.logger.New("func", "pruneAnnounceDataStructures")
 
@@ -315,30 +507,67 @@ func (sb *Backend) pruneAnnounceDataStructures() error {
 		return err
 	}
 
+	summary := &PruneSummary{}
+
+	// Prune both gossip timestamp maps in a single critical section, using the same time for the cooldowns
+	now := time.Now()
+	monoNow := sb.announceClock.Now()
 	sb.lastQueryEnodeGossipedMu.Lock()
-	for remoteAddress := range sb.lastQueryEnodeGossiped {
-		if !validatorConnSet[remoteAddress] && time.Since(sb.lastQueryEnodeGossiped[remoteAddress]) >= queryEnodeGossipCooldownDuration {
-			logger.Trace("Deleting entry from lastQueryEnodeGossiped", "address", remoteAddress, "gossip timestamp", sb.lastQueryEnodeGossiped[remoteAddress])
-			delete(sb.lastQueryEnodeGossiped, remoteAddress)
+	sb.lastVersionCertificatesGossipedMu.Lock()
+	summary.LastQueryEnodeGossiped = pruneGossipTimes(logger.New("map", "lastQueryEnodeGossiped"), sb.lastQueryEnodeGossiped, validatorConnSet, queryEnodeGossipCooldownDuration, monoNow)
+	summary.LastVersionCertificatesGossiped = pruneGossipTimes(logger.New("map", "lastVersionCertificatesGossiped"), sb.lastVersionCertificatesGossiped, validatorConnSet, versionCertificateGossipCooldownDuration, monoNow)
+	sb.lastVersionCertificatesGossipedMu.Unlock()
+	sb.lastQueryEnodeGossipedMu.Unlock()
+
+	sb.versionHistoryMu.Lock()
+	for remoteAddress := range sb.versionHistory {
+		if !validatorConnSet[remoteAddress] {
+			delete(sb.versionHistory, remoteAddress)
 		}
 	}
-	sb.lastQueryEnodeGossipedMu.Unlock()
+	sb.versionHistoryMu.Unlock()
 
-	if err := sb.valEnodeTable.PruneEntries(validatorConnSet); err != nil {
-		logger.Trace("Error in pruning valEnodeTable", "err", err)
-		return err
+	sb.malformedEnodeURLCountsMu.Lock()
+	for remoteAddress := range sb.malformedEnodeURLCounts {
+		if !validatorConnSet[remoteAddress] {
+			delete(sb.malformedEnodeURLCounts, remoteAddress)
+		}
 	}
+	sb.malformedEnodeURLCountsMu.Unlock()
 
-	sb.lastVersionCertificatesGossipedMu.Lock()
-	for remoteAddress := range sb.lastVersionCertificatesGossiped {
-		if !validatorConnSet[remoteAddress] && time.Since(sb.lastVersionCertificatesGossiped[remoteAddress]) >= versionCertificateGossipCooldownDuration {
-			logger.Trace("Deleting entry from lastVersionCertificatesGossiped", "address", remoteAddress, "gossip timestamp", sb.lastVersionCertificatesGossiped[remoteAddress])
-			delete(sb.lastVersionCertificatesGossiped, remoteAddress)
+	sb.lastQueryEnodeAnswersMu.Lock()
+	for remoteAddress, answer := range sb.lastQueryEnodeAnswers {
+		if !validatorConnSet[remoteAddress] || monoNow.Sub(answer.answered) >= queryEnodeAnswerDedupWindow {
+			delete(sb.lastQueryEnodeAnswers, remoteAddress)
 		}
 	}
-	sb.lastVersionCertificatesGossipedMu.Unlock()
+	sb.lastQueryEnodeAnswersMu.Unlock()
+
+	// The removals are reported even if a later step fails
+	defer sb.notifyPrune(summary)
+
+	if summary.ValEnodeTable, err = sb.valEnodeTable.PruneEntries(validatorConnSet); err != nil {
+		logger.Trace("Error in pruning valEnodeTable", "err", err)
+		return err
+	}
 
-	if err := sb.versionCertificateTable.Prune(validatorConnSet); err != nil {
+	maxAge := sb.config.AnnounceVersionCertificateMaxAge
+	if maxAge > 0 && sb.config.AnnounceVersionMode == istanbul.EpochCounterVersion {
+		// Epoch+counter versions aren't timestamps, so their age is unknown
+		logger.Debug("Skipping the pruning of version certificates by age in epoch+counter version mode")
+		maxAge = 0
+	}
+	if maxAge > 0 {
+		// Versions are unix timestamps, so entries with a version older than maxAge seconds are pruned
+		var minVersion uint
+		if timestamp := uint(now.Unix()); uint64(timestamp) > maxAge {
+			minVersion = timestamp - uint(maxAge)
+		}
+		if summary.VersionCertificateTable, err = sb.versionCertificateTable.PruneByAge(validatorConnSet, minVersion, sb.ValidatorAddress()); err != nil {
+			logger.Trace("Error in pruning versionCertificateTable", "err", err)
+			return err
+		}
+	} else if summary.VersionCertificateTable, err = sb.versionCertificateTable.Prune(validatorConnSet); err != nil {
 		logger.Trace("Error in pruning versionCertificateTable", "err", err)
 		return err
 	}
@@ -346,6 +575,74 @@ func (sb *Backend) pruneAnnounceDataStructures() error {
 	return nil
 }
 
+// gossipTime is when a message from a source address was last regossiped.  The gossip cooldowns
+// are measured on the monotonic announce clock, so that steps of the wall clock (e.g. by NTP)
+// neither expire nor extend them.  The wall clock time is only kept for reporting.  Neither is
+// persisted, so all cooldowns start out expired after a restart.
+type gossipTime struct {
+	mono mclock.AbsTime
+	wall time.Time
+}
+
+// newGossipTime returns the current gossip time
+func (sb *Backend) newGossipTime() gossipTime {
+	return gossipTime{mono: sb.announceClock.Now(), wall: time.Now()}
+}
+
+// pruneGossipTimes removes the entries of gossipTimes for addresses that are not in the validator
+// connection set and whose gossip cooldown has expired, and returns their addresses.  The caller
+// must hold the map's lock.
+func pruneGossipTimes(logger log.Logger, gossipTimes map[common.Address]gossipTime, validatorConnSet map[common.Address]bool, cooldown time.Duration, now mclock.AbsTime) []common.Address {
+	var removed []common.Address
+	for remoteAddress, gossipTime := range gossipTimes {
+		if !validatorConnSet[remoteAddress] && now.Sub(gossipTime.mono) >= cooldown {
+			logger.Trace("Deleting entry", "address", remoteAddress, "gossip timestamp", gossipTime.wall)
+			delete(gossipTimes, remoteAddress)
+			removed = append(removed, remoteAddress)
+		}
+	}
+	return removed
+}
+
+// PruneSummary holds the addresses of the entries removed from each of the announce data
+// structures by a prune, e.g. because they left the validator connection set
+type PruneSummary struct {
+	LastQueryEnodeGossiped          []common.Address
+	LastVersionCertificatesGossiped []common.Address
+	ValEnodeTable                   []common.Address
+	VersionCertificateTable         []common.Address
+}
+
+// NumRemoved returns the total number of entries removed by the prune
+func (ps *PruneSummary) NumRemoved() int {
+	return len(ps.LastQueryEnodeGossiped) + len(ps.LastVersionCertificatesGossiped) + len(ps.ValEnodeTable) + len(ps.VersionCertificateTable)
+}
+
+// PruneCallback is notified of the entries removed by a prune of the announce data structures.
+// It's called from the announce thread, so it shouldn't block.
+type PruneCallback func(summary *PruneSummary)
+
+// SetPruneCallback sets the callback notified after every prune that removed entries.  A nil
+// callback disables the notifications.
+func (sb *Backend) SetPruneCallback(callback PruneCallback) {
+	sb.pruneCallbackMu.Lock()
+	defer sb.pruneCallbackMu.Unlock()
+	sb.pruneCallback = callback
+}
+
+// notifyPrune notifies the prune callback, if there is one, of the entries removed by a prune
+func (sb *Backend) notifyPrune(summary *PruneSummary) {
+	if summary.NumRemoved() == 0 {
+		return
+	}
+	sb.pruneCallbackMu.RLock()
+	callback := sb.pruneCallback
+	sb.pruneCallbackMu.RUnlock()
+	if callback != nil {
+		callback(summary)
+	}
+}
+
 // ===============================================================
 //
 // define the IstanbulQueryEnode message format, the QueryEnodeMsgCache entries, the queryEnode send function (both the gossip version and the "retrieve from cache" version), and the announce get function
@@ -353,9 +650,15 @@ func (sb *Backend) pruneAnnounceDataStructures() error {
 type encryptedEnodeURL struct {
 	DestAddress       common.Address
 	EncryptedEnodeURL []byte
+	// Specifies if EncryptedEnodeURL holds the enode URL unencrypted.  Only used when
+	// AnnounceInsecurePlaintextEnodeURLs is set.
+	Plaintext bool
 }
 
 func (ee *encryptedEnodeURL) String() string {
+	if ee.Plaintext {
+		return fmt.Sprintf("{DestAddress: %s, Plaintext EnodeURL length: %d}", ee.DestAddress.String(), len(ee.EncryptedEnodeURL))
+	}
 	return fmt.Sprintf("{DestAddress: %s, EncryptedEnodeURL length: %d}", ee.DestAddress.String(), len(ee.EncryptedEnodeURL))
 }
 
@@ -365,10 +668,58 @@ type queryEnodeData struct {
 	// The timestamp of the node when the message is generated.
 	// This results in a new hash for a newly generated message so it gets regossiped by other nodes
 	Timestamp uint
+	// An optional human-readable tag of the sending node. It's informational only and must not be trusted.
+	NodeTag string
 }
 
 func (qed *queryEnodeData) String() string {
-	return fmt.Sprintf("{Version: %v, Timestamp: %v, EncryptedEnodeURLs: %v}", qed.Version, qed.Timestamp, qed.EncryptedEnodeURLs)
+	return fmt.Sprintf("{Version: %v, Timestamp: %v, NodeTag: %q, EncryptedEnodeURLs: %v}", qed.Version, qed.Timestamp, qed.NodeTag, qed.EncryptedEnodeURLs)
+}
+
+type encryptedEnodeURLLogEntry struct {
+	DestAddress             common.Address `json:"destAddress"`
+	EncryptedEnodeURLLength int            `json:"encryptedEnodeURLLength"`
+	Plaintext               bool           `json:"plaintext,omitempty"`
+}
+
+type queryEnodeDataLogEntry struct {
+	Version            uint                        `json:"version"`
+	Timestamp          uint                        `json:"timestamp"`
+	NodeTag            string                      `json:"nodeTag,omitempty"`
+	EncryptedEnodeURLs []encryptedEnodeURLLogEntry `json:"encryptedEnodeURLs"`
+}
+
+// JSONString returns the queryEnodeData content as a JSON object.  The encrypted enode urls are
+// represented by their lengths.
+func (qed *queryEnodeData) JSONString() string {
+	logEntry := queryEnodeDataLogEntry{
+		Version:            qed.Version,
+		Timestamp:          qed.Timestamp,
+		NodeTag:            qed.NodeTag,
+		EncryptedEnodeURLs: make([]encryptedEnodeURLLogEntry, 0, len(qed.EncryptedEnodeURLs)),
+	}
+	for _, ee := range qed.EncryptedEnodeURLs {
+		logEntry.EncryptedEnodeURLs = append(logEntry.EncryptedEnodeURLs, encryptedEnodeURLLogEntry{DestAddress: ee.DestAddress, EncryptedEnodeURLLength: len(ee.EncryptedEnodeURL), Plaintext: ee.Plaintext})
+	}
+	return jsonLogString(logEntry)
+}
+
+// jsonLogString marshals v into a JSON string, for the purpose of logging it.
+func jsonLogString(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("{\"error\": %q}", err.Error())
+	}
+	return string(b)
+}
+
+// queryEnodeDataLogValue returns the representation of the queryEnodeData to be used in log statements,
+// which is JSON if AnnounceJSONLogs is enabled.
+func (sb *Backend) queryEnodeDataLogValue(qed *queryEnodeData) string {
+	if sb.config.AnnounceJSONLogs {
+		return qed.JSONString()
+	}
+	return qed.String()
 }
 
 // ==============================================
@@ -376,8 +727,12 @@ func (qed *queryEnodeData) String() string {
 // define the functions that needs to be provided for rlp Encoder/Decoder.
 
 // EncodeRLP serializes ar into the Ethereum RLP format.
+// The plaintext flag is only encoded when set, so that encrypted enode urls keep the original encoding.
 func (ee *encryptedEnodeURL) EncodeRLP(w io.Writer) error {
-	return rlp.Encode(w, []interface{}{ee.DestAddress, ee.EncryptedEnodeURL})
+	if !ee.Plaintext {
+		return rlp.Encode(w, []interface{}{ee.DestAddress, ee.EncryptedEnodeURL})
+	}
+	return rlp.Encode(w, []interface{}{ee.DestAddress, ee.EncryptedEnodeURL, ee.Plaintext})
 }
 
 // DecodeRLP implements rlp.Decoder, and load the ar fields from a RLP stream.
@@ -385,16 +740,22 @@ func (ee *encryptedEnodeURL) DecodeRLP(s *rlp.Stream) error {
 	var msg struct {
 		DestAddress       common.Address
 		EncryptedEnodeURL []byte
+		Optional          []bool `rlp:"tail"`
 	}
 
 	if err := s.Decode(&msg); err != nil {
 		return err
 	}
-	ee.DestAddress, ee.EncryptedEnodeURL = msg.DestAddress, msg.EncryptedEnodeURL
+	ee.DestAddress, ee.EncryptedEnodeURL, ee.Plaintext = msg.DestAddress, msg.EncryptedEnodeURL, false
+	if len(msg.Optional) > 0 {
+		ee.Plaintext = msg.Optional[0]
+	}
 	return nil
 }
 
 // EncodeRLP serializes ad into the Ethereum RLP format.
+// The node tag is never encoded, since older versions reject messages with more fields.  It's
+// only carried by the protobuf wire format.
 func (qed *queryEnodeData) EncodeRLP(w io.Writer) error {
 	return rlp.Encode(w, []interface{}{qed.EncryptedEnodeURLs, qed.Version, qed.Timestamp})
 }
@@ -405,12 +766,16 @@ func (qed *queryEnodeData) DecodeRLP(s *rlp.Stream) error {
 		EncryptedEnodeURLs []*encryptedEnodeURL
 		Version            uint
 		Timestamp          uint
+		Optional           []string `rlp:"tail"`
 	}
 
 	if err := s.Decode(&msg); err != nil {
 		return err
 	}
-	qed.EncryptedEnodeURLs, qed.Version, qed.Timestamp = msg.EncryptedEnodeURLs, msg.Version, msg.Timestamp
+	qed.EncryptedEnodeURLs, qed.Version, qed.Timestamp, qed.NodeTag = msg.EncryptedEnodeURLs, msg.Version, msg.Timestamp, ""
+	if len(msg.Optional) > 0 {
+		qed.NodeTag = istanbul.SanitizeNodeTag(msg.Optional[0])
+	}
 	return nil
 }
 
@@ -419,7 +784,7 @@ func (qed *queryEnodeData) DecodeRLP(s *rlp.Stream) error {
 // If this is a proxied validator, it will set external node to the proxy's external node.
 func (sb *Backend) getValProxyAssignments(valAddresses []common.Address) (map[common.Address]*enode.Node, error) {
 	var valProxyAssignments map[common.Address]*enode.Node = make(map[common.Address]*enode.Node)
-	var selfEnode *enode.Node = sb.SelfNode()
+	var selfEnode *enode.Node = sb.advertisedSelfNode()
 	var proxies map[common.Address]*proxy.Proxy // This var is only used if this is a proxied validator
 
 	for _, valAddress := range valAddresses {
@@ -438,7 +803,7 @@ func (sb *Backend) getValProxyAssignments(valAddresses []common.Address) (map[co
 				continue
 			}
 
-			externalNode = proxyObj.ExternalNode()
+			externalNode = sb.selectEnodeURL(sb.enodeURLSelectorForValidator(valAddress), proxyObj.InternalNode(), proxyObj.ExternalNode())
 		} else {
 			externalNode = selfEnode
 		}
@@ -449,6 +814,70 @@ func (sb *Backend) getValProxyAssignments(valAddresses []common.Address) (map[co
 	return valProxyAssignments, nil
 }
 
+// resolveAdvertiseIP returns the IP of an advertise address, which is either an IP or the name
+// of a network interface.  IPv4 addresses of an interface are preferred.
+func resolveAdvertiseIP(address string) (net.IP, error) {
+	if ip := net.ParseIP(address); ip != nil {
+		return ip, nil
+	}
+	iface, err := net.InterfaceByName(address)
+	if err != nil {
+		return nil, fmt.Errorf("advertise address %q is neither an IP nor a network interface: %w", address, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var ip net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP, nil
+		}
+		if ip == nil {
+			ip = ipNet.IP
+		}
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("network interface %q has no IP address", address)
+	}
+	return ip, nil
+}
+
+// advertisedSelfNode returns this node's own enode, with its IP replaced by the one of
+// config.AnnounceAdvertiseAddress if it's set.  The enode ID and ports are unchanged.
+func (sb *Backend) advertisedSelfNode() *enode.Node {
+	self := sb.SelfNode()
+	if sb.advertiseIP == nil || self == nil {
+		return self
+	}
+	return enode.NewV4(self.Pubkey(), sb.advertiseIP, self.TCP(), self.UDP())
+}
+
+// enodeURLSelectorForValidator returns which enode URL should be advertised to the remote validator
+// with address valAddress.  This is the external enode URL, unless the validator is configured
+// in AnnounceInternalEnodeURLValidators.
+func (sb *Backend) enodeURLSelectorForValidator(valAddress common.Address) istanbul.EnodeURLSelector {
+	for _, address := range sb.config.AnnounceInternalEnodeURLValidators {
+		if address == valAddress {
+			return istanbul.InternalEnodeURL
+		}
+	}
+	return istanbul.ExternalEnodeURL
+}
+
+// selectEnodeURL returns the enode chosen by selector.  If the internal enode is selected but
+// is not set, the external enode is returned.
+func (sb *Backend) selectEnodeURL(selector istanbul.EnodeURLSelector, internalNode, externalNode *enode.Node) *enode.Node {
+	if selector == istanbul.InternalEnodeURL && internalNode != nil {
+		return internalNode
+	}
+	return externalNode
+}
+
 // generateAndGossipAnnounce will generate the lastest announce msg from this node
 // and then broadcast it to it's peers, which should then gossip the announce msg
 // message throughout the p2p network if there has not been a message sent from
@@ -510,9 +939,7 @@ func (sb *Backend) generateAndGossipQueryEnode(version uint, enforceRetryBackoff
 			return nil, err
 		}
 
-		if err = sb.Gossip(payload, istanbul.QueryEnodeMsg); err != nil {
-			return nil, err
-		}
+		sb.gossipAnnounceMsg(payload, istanbul.QueryEnodeMsg, nil)
 
 		if err = sb.valEnodeTable.UpdateQueryEnodeStats(valEnodeEntries); err != nil {
 			return nil, err
@@ -522,27 +949,72 @@ func (sb *Backend) generateAndGossipQueryEnode(version uint, enforceRetryBackoff
 	return qeMsg, err
 }
 
-func (sb *Backend) getQueryEnodeValEnodeEntries(enforceRetryBackoff bool) ([]*istanbul.AddressEntry, error) {
-	logger := sb.logger.New("func", "getQueryEnodeValEnodeEntries")
-	valEnodeEntries, err := sb.valEnodeTable.GetValEnodes(nil)
+// gossipAnnounceMsg gossips an announce message.  If it can't be gossiped because this node has no
+// connected peers, e.g. a proxied validator that isn't connected to its proxies yet at startup, the
+// gossip is retried in the background, so that the announce message isn't dropped.
+// done, if not nil, is called once with the outcome: nil once the message is gossiped, or the error
+// of the last attempt once the retries gave up.  It's called synchronously if the first attempt
+// succeeds, so the caller must not hold locks that done acquires.
+func (sb *Backend) gossipAnnounceMsg(payload []byte, ethMsgCode uint64, done func(err error)) {
+	err := sb.Gossip(payload, ethMsgCode)
+	if err == nil {
+		if done != nil {
+			done(nil)
+		}
+		return
+	}
+	sb.announceGossipFailuresCounter.Inc(1)
+	sb.logger.Debug("Error in gossiping announce message, will retry", "func", "gossipAnnounceMsg", "ethMsgCode", ethMsgCode, "backoff", announceGossipRetryBackoff, "err", err)
+	go sb.retryGossipAnnounceMsg(payload, ethMsgCode, done)
+}
+
+// retryGossipAnnounceMsg retries gossiping an announce message, waiting with an exponential backoff
+// before each attempt, until it's gossiped or announceGossipMaxAttempts attempts in total failed,
+// and then calls done with the outcome.  The retries are bounded, so they aren't stopped with the
+// announce thread.  Every failed attempt is counted in the announce gossip failures metric.
+func (sb *Backend) retryGossipAnnounceMsg(payload []byte, ethMsgCode uint64, done func(err error)) {
+	logger := sb.logger.New("func", "retryGossipAnnounceMsg", "ethMsgCode", ethMsgCode)
+	defer sb.announceGoroutines.track("retryGossipAnnounceMsg")()
+
+	backoff := announceGossipRetryBackoff
+	var err error
+	for attempt := 2; attempt <= announceGossipMaxAttempts; attempt++ {
+		time.Sleep(backoff)
+		if err = sb.Gossip(payload, ethMsgCode); err == nil {
+			logger.Debug("Gossiped announce message after retrying", "attempts", attempt)
+			break
+		}
+		sb.announceGossipFailuresCounter.Inc(1)
+		backoff *= 2
+		logger.Debug("Error in gossiping announce message", "attempt", attempt, "err", err)
+	}
+
 	if err != nil {
-		return nil, err
+		sb.announceWarnings.Warn(logger, "Error in gossiping announce message, giving up", "attempts", announceGossipMaxAttempts, "err", err)
+	}
+	if done != nil {
+		done(err)
 	}
+}
 
-	var queryEnodeValEnodeEntries []*istanbul.AddressEntry
-	for address, valEnodeEntry := range valEnodeEntries {
+// getQueryEnodeValEnodeEntries returns the val enode entries of the validators that should be queried.
+// At most AnnounceMaxQueriesPerRound entries are returned, prioritizing the ones with the fewest query
+// attempts, so that the rest are queried in subsequent rounds instead of all at once.
+func (sb *Backend) getQueryEnodeValEnodeEntries(enforceRetryBackoff bool) ([]*istanbul.AddressEntry, error) {
+	logger := sb.logger.New("func", "getQueryEnodeValEnodeEntries")
+	valEnodeEntries, err := sb.valEnodeTable.GetValEnodesWithFilter(func(valEnodeEntry *istanbul.AddressEntry) bool {
 		// Don't generate an announce record for ourselves
-		if address == sb.Address() {
-			continue
+		if valEnodeEntry.Address == sb.Address() {
+			return false
 		}
 
 		if valEnodeEntry.Version == valEnodeEntry.HighestKnownVersion {
-			continue
+			return false
 		}
 
 		if valEnodeEntry.PublicKey == nil {
-			logger.Warn("Cannot generate encrypted enode URL for a val enode entry without a PublicKey", "address", address)
-			continue
+			logger.Warn("Cannot generate encrypted enode URL for a val enode entry without a PublicKey", "address", valEnodeEntry.Address)
+			return false
 		}
 
 		if enforceRetryBackoff && valEnodeEntry.NumQueryAttemptsForHKVersion > 0 {
@@ -551,14 +1023,25 @@ func (sb *Backend) getQueryEnodeValEnodeEntries(enforceRetryBackoff bool) ([]*is
 			timeoutForQuery := time.Duration(timeoutMinutes) * time.Minute
 
 			if time.Since(*valEnodeEntry.LastQueryTimestamp) < timeoutForQuery {
-				continue
+				return false
 			}
 		}
 
-		queryEnodeValEnodeEntries = append(queryEnodeValEnodeEntries, valEnodeEntry)
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return queryEnodeValEnodeEntries, nil
+	maxQueries := int(sb.config.AnnounceMaxQueriesPerRound)
+	if maxQueries > 0 && len(valEnodeEntries) > maxQueries {
+		sort.SliceStable(valEnodeEntries, func(i, j int) bool {
+			return valEnodeEntries[i].NumQueryAttemptsForHKVersion < valEnodeEntries[j].NumQueryAttemptsForHKVersion
+		})
+		logger.Debug("Deferring queries to subsequent rounds", "numQueries", len(valEnodeEntries), "maxQueries", maxQueries)
+		valEnodeEntries = valEnodeEntries[:maxQueries]
+	}
+	return valEnodeEntries, nil
 }
 
 // generateQueryEnodeMsg returns a queryEnode message from this node with a given version.
@@ -573,22 +1056,24 @@ func (sb *Backend) generateQueryEnodeMsg(version uint, enodeQueries []*enodeQuer
 
 	encryptedEnodeURLs, err := sb.generateEncryptedEnodeURLs(enodeQueries)
 	if err != nil {
-		logger.Warn("Error generating encrypted enodeURLs", "err", err)
+		sb.announceWarnings.Warn(logger, "Error generating encrypted enodeURLs", "err", err)
 		return nil, err
 	}
 	if len(encryptedEnodeURLs) == 0 {
 		logger.Trace("No encrypted enodeURLs were generated, will not generate encryptedEnodeMsg")
 		return nil, nil
 	}
+	sb.announceEncryptedEnodeURLsHistogram.Update(int64(len(encryptedEnodeURLs)))
 	queryEnodeData := &queryEnodeData{
 		EncryptedEnodeURLs: encryptedEnodeURLs,
 		Version:            version,
 		Timestamp:          getTimestamp(),
+		NodeTag:            istanbul.SanitizeNodeTag(sb.config.AnnounceNodeTag),
 	}
 
-	queryEnodeBytes, err := rlp.EncodeToBytes(queryEnodeData)
+	queryEnodeBytes, err := istanbul.EncodeAnnounceData(sb.config.AnnounceWireFormat, queryEnodeData)
 	if err != nil {
-		logger.Error("Error encoding queryEnode content", "QueryEnodeData", queryEnodeData.String(), "err", err)
+		logger.Error("Error encoding queryEnode content", "QueryEnodeData", sb.queryEnodeDataLogValue(queryEnodeData), "err", err)
 		return nil, err
 	}
 
@@ -600,86 +1085,264 @@ func (sb *Backend) generateQueryEnodeMsg(version uint, enodeQueries []*enodeQuer
 	}
 
 	// Sign the announce message
-	if err := msg.Sign(sb.Sign); err != nil {
+	if err := sb.signQueryEnodeMsg(msg); err != nil {
 		logger.Error("Error in signing a QueryEnode Message", "QueryEnodeMsg", msg.String(), "err", err)
 		return nil, err
 	}
 
-	logger.Debug("Generated a queryEnode message", "IstanbulMsg", msg.String(), "QueryEnodeData", queryEnodeData.String())
+	logger.Debug("Generated a queryEnode message", "IstanbulMsg", msg.String(), "QueryEnodeData", sb.queryEnodeDataLogValue(queryEnodeData))
 
 	return msg, nil
 }
 
-type enodeQuery struct {
-	recipientAddress   common.Address
-	recipientPublicKey *ecdsa.PublicKey
-	enodeURL           string
+// signQueryEnodeMsg signs a query enode message with the signature scheme set in the
+// istanbul config. ECDSA signatures use the EIP-191 personal message prefix if it's enabled.
+func (sb *Backend) signQueryEnodeMsg(msg *istanbul.Message) error {
+	if sb.config.AnnounceSignatureScheme == istanbul.BLSScheme {
+		return msg.Sign(sb.signAnnounceBLS)
+	}
+	if sb.config.AnnounceEIP191SignedQueryEnode {
+		return msg.SignEIP191(sb.Sign)
+	}
+	return msg.Sign(sb.Sign)
 }
 
-// generateEncryptedEnodeURLs returns the encryptedEnodeURLs to be sent in an enode query.
-func (sb *Backend) generateEncryptedEnodeURLs(enodeQueries []*enodeQuery) ([]*encryptedEnodeURL, error) {
-	logger := sb.logger.New("func", "generateEncryptedEnodeURLs")
-
-	var encryptedEnodeURLs []*encryptedEnodeURL
-	for _, param := range enodeQueries {
-		logger.Debug("encrypting enodeURL", "externalEnodeURL", param.enodeURL, "publicKey", param.recipientPublicKey)
-		publicKey := ecies.ImportECDSAPublic(param.recipientPublicKey)
-		encEnodeURL, err := ecies.Encrypt(rand.Reader, publicKey, []byte(param.enodeURL), nil, nil)
-		if err != nil {
-			logger.Error("Error in encrypting enodeURL", "enodeURL", param.enodeURL, "publicKey", publicKey)
-			return nil, err
+// queryEnodeSignatureAddressFn returns the function used to recover the signer
+// of a query enode message.  Both signature schemes are accepted regardless of the one set in
+// the istanbul config, so that validators can switch schemes independently.  They are told apart
+// by the length of the signature, and ECDSA signatures match the prefixing of signQueryEnodeMsg.
+func (sb *Backend) queryEnodeSignatureAddressFn() func([]byte, []byte) (common.Address, error) {
+	ecdsaSignatureAddressFn := istanbul.GetCanonicalSignatureAddress
+	if sb.config.AnnounceEIP191SignedQueryEnode {
+		ecdsaSignatureAddressFn = istanbul.GetCanonicalEIP191SignatureAddress
+	}
+	return func(data []byte, sig []byte) (common.Address, error) {
+		if len(sig) == blscrypto.SIGNATUREBYTES {
+			return sb.verifyAnnounceBLSSignature(data, sig)
 		}
-
-		encryptedEnodeURLs = append(encryptedEnodeURLs, &encryptedEnodeURL{
-			DestAddress:       param.recipientAddress,
-			EncryptedEnodeURL: encEnodeURL,
-		})
+		return ecdsaSignatureAddressFn(data, sig)
 	}
-
-	return encryptedEnodeURLs, nil
 }
 
-// This function will handle a queryEnode message.
-func (sb *Backend) handleQueryEnodeMsg(addr common.Address, peer consensus.Peer, payload []byte) error {
-	logger := sb.logger.New("func", "handleQueryEnodeMsg")
-
-	msg := new(istanbul.Message)
-
-	// Since this is a gossiped messaged, mark that the peer gossiped it (and presumably processed it) and check to see if this node already processed it
-	sb.markMessageProcessedByPeer(addr, payload)
-	if sb.checkIfMessageProcessedBySelf(payload) {
-		return nil
+// signAnnounceBLS signs the payload of an announce message with this node's BLS key
+func (sb *Backend) signAnnounceBLS(data []byte) ([]byte, error) {
+	sig, err := sb.SignBLS(data, []byte{}, false, false)
+	if err != nil {
+		return nil, err
 	}
-	defer sb.markMessageProcessedBySelf(payload)
+	return sig[:], nil
+}
 
-	// Decode message
-	err := msg.FromPayload(payload, istanbul.GetSignatureAddress)
-	if err != nil {
-		logger.Error("Error in decoding received Istanbul Announce message", "err", err, "payload", hex.EncodeToString(payload))
-		return err
+// verifyAnnounceBLSSignature verifies the BLS signature of the payload of a query enode message
+// against the BLS key of the message's address, and returns that address.
+// A BLS signature doesn't commit to a recoverable signer, so the address is read from the payload,
+// and its BLS key is looked up with announceBLSPublicKey.  Verifying is expensive, so the messages
+// of addresses outside of the validator conn set, and the ones this node would only regossip while
+// their address is on the regossip cooldown, are rejected beforehand.
+func (sb *Backend) verifyAnnounceBLSSignature(data []byte, sig []byte) (common.Address, error) {
+	var msg istanbul.Message
+	if err := rlp.DecodeBytes(data, &msg); err != nil {
+		return common.Address{}, err
 	}
-	logger.Trace("Handling a queryEnode message", "from", msg.Address)
 
-	// Check if the sender is within the validator connection set
 	validatorConnSet, err := sb.RetrieveValidatorConnSet()
 	if err != nil {
-		logger.Trace("Error in retrieving validator connection set", "err", err)
-		return err
+		return common.Address{}, err
 	}
-
 	if !validatorConnSet[msg.Address] {
-		logger.Debug("Received a message from a validator not within the validator connection set. Ignoring it.", "sender", msg.Address)
-		return errUnauthorizedAnnounceMessage
+		return common.Address{}, errUnknownBLSPublicKey
+	}
+	if !validatorConnSet[sb.Address()] && sb.isQueryEnodeRegossipOnCooldown(msg.Address) {
+		return common.Address{}, errQueryEnodeRegossipOnCooldown
 	}
 
-	var qeData queryEnodeData
-	err = rlp.DecodeBytes(msg.Msg, &qeData)
+	blsPublicKey, err := sb.announceBLSPublicKey(msg.Address)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if err := blscrypto.VerifySignature(blsPublicKey, data, []byte{}, sig, false, false); err != nil {
+		return common.Address{}, err
+	}
+	return msg.Address, nil
+}
+
+// isQueryEnodeRegossipOnCooldown returns whether a query enode message of the source address
+// was regossiped within queryEnodeGossipCooldownDuration.  Messages of this node's validator
+// address are never throttled.
+func (sb *Backend) isQueryEnodeRegossipOnCooldown(address common.Address) bool {
+	if address == sb.ValidatorAddress() {
+		return false
+	}
+	sb.lastQueryEnodeGossipedMu.RLock()
+	defer sb.lastQueryEnodeGossipedMu.RUnlock()
+	lastGossiped, ok := sb.lastQueryEnodeGossiped[address]
+	return ok && sb.announceClock.Now().Sub(lastGossiped.mono) < queryEnodeGossipCooldownDuration
+}
+
+// announceBLSKey is the key of a BLS public key in the announceBLSPublicKeys cache
+type announceBLSKey struct {
+	epoch   uint64
+	address common.Address
+}
+
+// announceBLSPublicKey returns the BLS public key of a member of the validator conn set.
+// The keys of elected validators are read from the current validator set, and the keys of the
+// other members, e.g. registered validators that may be elected next epoch, from the validators contract.
+// The keys are cached for the current epoch.
+func (sb *Backend) announceBLSPublicKey(address common.Address) (blscrypto.SerializedPublicKey, error) {
+	block := sb.currentBlock()
+	cacheKey := announceBLSKey{epoch: istanbul.GetEpochNumber(block.Number().Uint64(), sb.config.Epoch), address: address}
+	if cached, ok := sb.announceBLSPublicKeys.Get(cacheKey); ok {
+		return cached.(blscrypto.SerializedPublicKey), nil
+	}
+
+	var blsPublicKey blscrypto.SerializedPublicKey
+	if _, val := sb.getValidators(block.Number().Uint64(), block.Hash()).GetByAddress(address); val != nil {
+		blsPublicKey = val.BLSPublicKey()
+	} else {
+		var err error
+		if blsPublicKey, err = sb.registeredBLSPublicKeyFn(block.Header(), address); err != nil {
+			sb.logger.Debug("Error in looking up the BLS public key of a registered validator", "address", address, "err", err)
+			return blscrypto.SerializedPublicKey{}, errUnknownBLSPublicKey
+		}
+	}
+	sb.announceBLSPublicKeys.Add(cacheKey, blsPublicKey)
+	return blsPublicKey, nil
+}
+
+// registeredBLSPublicKey looks up the BLS public key of the registered validator with the given
+// signer address in the validators contract, as of the given header
+func (sb *Backend) registeredBLSPublicKey(header *types.Header, address common.Address) (blscrypto.SerializedPublicKey, error) {
+	state, err := sb.stateAt(header.Hash())
+	if err != nil {
+		return blscrypto.SerializedPublicKey{}, err
+	}
+	validatorData, err := validators.GetValidatorData(header, state, []common.Address{address})
+	if err != nil {
+		return blscrypto.SerializedPublicKey{}, err
+	}
+	return validatorData[0].BLSPublicKey, nil
+}
+
+type enodeQuery struct {
+	recipientAddress   common.Address
+	recipientPublicKey *ecdsa.PublicKey
+	enodeURL           string
+}
+
+// generateEncryptedEnodeURLs returns the encryptedEnodeURLs to be sent in an enode query.
+func (sb *Backend) generateEncryptedEnodeURLs(enodeQueries []*enodeQuery) ([]*encryptedEnodeURL, error) {
+	logger := sb.logger.New("func", "generateEncryptedEnodeURLs")
+
+	var encryptedEnodeURLs []*encryptedEnodeURL
+	roundStart := time.Now()
+	for _, param := range enodeQueries {
+		if sb.config.AnnounceInsecurePlaintextEnodeURLs {
+			// INSECURE: anyone relaying the signed message can read the enode URL
+			encryptedEnodeURLs = append(encryptedEnodeURLs, &encryptedEnodeURL{
+				DestAddress:       param.recipientAddress,
+				EncryptedEnodeURL: []byte(param.enodeURL),
+				Plaintext:         true,
+			})
+			continue
+		}
+
+		start := time.Now()
+		encEnodeURL, err := sb.encryptEnodeURL(logger, param.recipientPublicKey, param.enodeURL)
+		if err != nil {
+			return nil, err
+		}
+		sb.announceEnodeURLEncryptionTimer.UpdateSince(start)
+
+		encryptedEnodeURLs = append(encryptedEnodeURLs, &encryptedEnodeURL{
+			DestAddress:       param.recipientAddress,
+			EncryptedEnodeURL: encEnodeURL,
+		})
+	}
+	if len(enodeQueries) > 0 && !sb.config.AnnounceInsecurePlaintextEnodeURLs {
+		sb.announceEncryptionRoundHistogram.Update(int64(time.Since(roundStart)))
+	}
+
+	return encryptedEnodeURLs, nil
+}
+
+// encryptEnodeURL encrypts an enode URL for the recipient with the given public key.  With
+// config.AnnounceCacheEncryptedEnodeURLs, the ciphertext of an unchanged enode URL is reused.
+func (sb *Backend) encryptEnodeURL(logger log.Logger, recipientPublicKey *ecdsa.PublicKey, enodeURL string) ([]byte, error) {
+	s1, s2 := sb.eciesSharedInfo()
+	var cacheKey string
+	if sb.config.AnnounceCacheEncryptedEnodeURLs {
+		// The shared info is part of the key, since changing it invalidates the ciphertexts
+		cacheKey = string(crypto.FromECDSAPub(recipientPublicKey)) + "\x00" + enodeURL + "\x00" + string(s1) + "\x00" + string(s2)
+		if encEnodeURL, ok := sb.encryptedEnodeURLs.Get(cacheKey); ok {
+			return encEnodeURL.([]byte), nil
+		}
+	}
+
+	logger.Debug("encrypting enodeURL", "externalEnodeURL", enodeURL, "publicKey", recipientPublicKey)
+	publicKey := ecies.ImportECDSAPublic(recipientPublicKey)
+	encEnodeURL, err := ecies.Encrypt(sb.encryptionRand, publicKey, []byte(enodeURL), s1, s2)
+	if err != nil {
+		logger.Error("Error in encrypting enodeURL", "enodeURL", enodeURL, "publicKey", publicKey)
+		return nil, err
+	}
+
+	if sb.config.AnnounceCacheEncryptedEnodeURLs {
+		sb.encryptedEnodeURLs.Add(cacheKey, encEnodeURL)
+	}
+	return encEnodeURL, nil
+}
+
+// This function will handle a queryEnode message.
+func (sb *Backend) handleQueryEnodeMsg(addr common.Address, peer consensus.Peer, payload []byte) error {
+	logger := sb.logger.New("func", "handleQueryEnodeMsg")
+
+	msg := new(istanbul.Message)
+
+	// Since this is a gossiped messaged, mark that the peer gossiped it (and presumably processed it) and check to see if this node already processed it
+	sb.markMessageProcessedByPeer(addr, payload)
+	if sb.checkIfMessageProcessedBySelf(payload) {
+		return nil
+	}
+	defer sb.markMessageProcessedBySelf(payload)
+
+	// Nodes that won't process the message can skip straight to regossiping it
+	if sb.shouldLightweightRegossipQueryEnode() {
+		return sb.lightweightRegossipQueryEnode(peer, payload)
+	}
+
+	// Decode message
+	err := msg.FromPayload(payload, sb.queryEnodeSignatureAddressFn())
+	if err == errQueryEnodeRegossipOnCooldown {
+		logger.Trace("Already regossiped msg from this source address within the cooldown period, not verifying it.", "sender", msg.Address)
+		sb.onRegossipQueryEnodeDecision(msg.Address, false, "cooldown")
+		return err
+	} else if err != nil {
+		logger.Error("Error in decoding received Istanbul Announce message", "err", err, "payload", hex.EncodeToString(payload))
+		return err
+	}
+	logger.Trace("Handling a queryEnode message", "from", msg.Address)
+
+	// Check if the sender is within the validator connection set
+	validatorConnSet, err := sb.RetrieveValidatorConnSet()
+	if err != nil {
+		logger.Trace("Error in retrieving validator connection set", "err", err)
+		return err
+	}
+
+	if !validatorConnSet[msg.Address] {
+		logger.Debug("Received a message from a validator not within the validator connection set. Ignoring it.", "sender", msg.Address)
+		return errUnauthorizedAnnounceMessage
+	}
+
+	var qeData queryEnodeData
+	err = istanbul.DecodeAnnounceData(msg.Msg, &qeData)
 	if err != nil {
 		logger.Warn("Error in decoding received Istanbul QueryEnode message content", "err", err, "IstanbulMsg", msg.String())
 		return err
 	}
 
-	logger = logger.New("msgAddress", msg.Address, "msgVersion", qeData.Version)
+	logger = logger.New("msgAddress", msg.Address, "msgVersion", qeData.Version, "nodeTag", qeData.NodeTag)
 
 	// Do some validation checks on the queryEnodeData
 	if isValid, err := sb.validateQueryEnode(msg.Address, &qeData); !isValid || err != nil {
@@ -694,23 +1357,38 @@ func (sb *Backend) handleQueryEnodeMsg(addr common.Address, peer consensus.Peer,
 	}
 
 	if shouldProcess {
-		logger.Trace("Processing an queryEnode message", "queryEnode records", qeData.EncryptedEnodeURLs)
+		logger.Trace("Processing an queryEnode message", "QueryEnodeData", sb.queryEnodeDataLogValue(&qeData))
 		for _, encEnodeURL := range qeData.EncryptedEnodeURLs {
-			// Only process an encEnodURL intended for this node
+			// Only process an encEnodURL intended for this node.  validateQueryEnode rejects messages
+			// with multiple entries for this node, so the first one is the only one.
 			if encEnodeURL.DestAddress != sb.Address() {
 				continue
 			}
-			enodeBytes, err := sb.decryptFn(accounts.Account{Address: sb.Address()}, encEnodeURL.EncryptedEnodeURL, nil, nil)
-			if err != nil {
-				sb.logger.Warn("Error decrypting endpoint", "err", err, "encEnodeURL.EncryptedEnodeURL", encEnodeURL.EncryptedEnodeURL)
-				return err
+			var node *enode.Node
+			if encEnodeURL.Plaintext {
+				node, err = sb.parsePlaintextEnodeURL(encEnodeURL.EncryptedEnodeURL)
+			} else {
+				node, err = sb.decryptEnodeURL(encEnodeURL.EncryptedEnodeURL)
 			}
-			enodeURL := string(enodeBytes)
-			node, err := enode.ParseV4(enodeURL)
-			if err != nil {
-				logger.Warn("Error parsing enodeURL", "enodeUrl", enodeURL)
+			if errors.Is(err, errMalformedDecryptedEnodeURL) {
+				if count := sb.recordMalformedEnodeURL(msg.Address); count < maxConsecutiveMalformedEnodeURLs {
+					logger.Warn("Ignoring malformed enode url", "err", err, "consecutiveCount", count)
+					break
+				}
+				return err
+			} else if errors.Is(err, errDecryptionKeyUnavailable) {
+				// Still regossip the message, the sender isn't at fault
+				logger.Warn("Unable to decrypt enode url, skipping processing of queryEnode message", "err", err)
+				break
+			} else if errors.Is(err, errECIESParamsMismatch) {
+				// Still regossip the message, the relaying peer isn't at fault for a config mismatch.
+				// decryptEnodeURL already warned about it.
+				logger.Debug("Unable to decrypt enode url, skipping processing of queryEnode message", "err", err)
+				break
+			} else if err != nil {
 				return err
 			}
+			sb.resetMalformedEnodeURLs(msg.Address)
 
 			// queryEnode messages should only be processed once because selfRecentMessages
 			// will cache seen queryEnode messages, so it's safe to answer without any throttling
@@ -727,6 +1405,115 @@ func (sb *Backend) handleQueryEnodeMsg(addr common.Address, peer consensus.Peer,
 	return sb.regossipQueryEnode(msg, qeData.Version, payload)
 }
 
+// decryptEnodeURL decrypts and parses an encrypted enode URL intended for this node.
+// Encrypted enode URLs and enode URLs that are longer than the configured maximums
+// are rejected, so that a malicious validator can't force large allocations.
+func (sb *Backend) decryptEnodeURL(encryptedEnodeURL []byte) (*enode.Node, error) {
+	logger := sb.logger.New("func", "decryptEnodeURL")
+
+	if maxLen := sb.config.AnnounceMaxEncryptedEnodeURLLength; maxLen > 0 && uint64(len(encryptedEnodeURL)) > maxLen {
+		logger.Warn("Encrypted enodeURL is too long", "length", len(encryptedEnodeURL), "maxLength", maxLen)
+		return nil, errEncryptedEnodeURLTooLong
+	}
+
+	sb.signFnMu.RLock()
+	decryptFn := sb.decryptFn
+	sb.signFnMu.RUnlock()
+	if decryptFn == nil {
+		return nil, errDecryptionKeyUnavailable
+	}
+
+	s1, s2 := sb.eciesSharedInfo()
+	enodeBytes, err := decryptFn(accounts.Account{Address: sb.Address()}, encryptedEnodeURL, s1, s2)
+	var authNeededErr *accounts.AuthNeededError
+	if errors.As(err, &authNeededErr) || errors.Is(err, accounts.ErrUnknownAccount) {
+		return nil, fmt.Errorf("%w: %v", errDecryptionKeyUnavailable, err)
+	} else if errors.Is(err, ecies.ErrInvalidMessage) {
+		sb.announceWarnings.Warn(logger, "Error decrypting endpoint, check that AnnounceECIESKDFSharedInfo and AnnounceECIESMACSharedInfo match across the network", "err", err)
+		return nil, errECIESParamsMismatch
+	} else if err != nil {
+		sb.announceWarnings.Warn(logger, "Error decrypting endpoint", "err", err, "encEnodeURL.EncryptedEnodeURL", encryptedEnodeURL)
+		return nil, err
+	}
+
+	return sb.parseEnodeURL(logger, enodeBytes)
+}
+
+// validateECIESSharedInfo checks the configured ECIES shared information, and logs its
+// fingerprint so that operators can compare it across the network.
+func validateECIESSharedInfo(config *istanbul.Config, logger log.Logger) error {
+	if len(config.AnnounceECIESKDFSharedInfo) > maxECIESSharedInfoLength || len(config.AnnounceECIESMACSharedInfo) > maxECIESSharedInfoLength {
+		return errECIESSharedInfoTooLong
+	}
+	if config.AnnounceECIESKDFSharedInfo != "" || config.AnnounceECIESMACSharedInfo != "" {
+		fingerprint := crypto.Keccak256Hash([]byte(config.AnnounceECIESKDFSharedInfo), []byte{0}, []byte(config.AnnounceECIESMACSharedInfo))
+		logger.Info("Encrypting enode urls with non-default ECIES shared info", "fingerprint", fingerprint.TerminalString())
+	}
+	return nil
+}
+
+// eciesSharedInfo returns the configured ECIES shared information that enode URLs are encrypted
+// and decrypted with.  The cipher and hash function can't be configured, as decryption is done by
+// the account's wallet, which always uses the defaults of the key's curve.
+func (sb *Backend) eciesSharedInfo() (s1, s2 []byte) {
+	if sb.config.AnnounceECIESKDFSharedInfo != "" {
+		s1 = []byte(sb.config.AnnounceECIESKDFSharedInfo)
+	}
+	if sb.config.AnnounceECIESMACSharedInfo != "" {
+		s2 = []byte(sb.config.AnnounceECIESMACSharedInfo)
+	}
+	return s1, s2
+}
+
+// parsePlaintextEnodeURL parses an unencrypted enode URL intended for this node, which is
+// only accepted when AnnounceInsecurePlaintextEnodeURLs is set
+func (sb *Backend) parsePlaintextEnodeURL(enodeBytes []byte) (*enode.Node, error) {
+	logger := sb.logger.New("func", "parsePlaintextEnodeURL")
+
+	if !sb.config.AnnounceInsecurePlaintextEnodeURLs {
+		logger.Warn("Received a plaintext enodeURL, but plaintext enode urls are disabled")
+		return nil, errPlaintextEnodeURLNotAllowed
+	}
+	return sb.parseEnodeURL(logger, enodeBytes)
+}
+
+// parseEnodeURL parses a decrypted (or plaintext) enode URL, rejecting enode URLs that are
+// longer than the configured maximum
+func (sb *Backend) parseEnodeURL(logger log.Logger, enodeBytes []byte) (*enode.Node, error) {
+	if maxLen := sb.config.AnnounceMaxEnodeURLLength; maxLen > 0 && uint64(len(enodeBytes)) > maxLen {
+		logger.Warn("Decrypted enodeURL is too long", "length", len(enodeBytes), "maxLength", maxLen)
+		return nil, errEnodeURLTooLong
+	}
+
+	enodeURL := string(enodeBytes)
+	node, err := enode.ParseV4(enodeURL)
+	if err != nil {
+		logger.Warn("Error parsing decrypted enodeURL", "enodeUrl", enodeURL, "err", err)
+		sb.announceMalformedEnodeURLCounter.Inc(1)
+		return nil, fmt.Errorf("%w: %v", errMalformedDecryptedEnodeURL, err)
+	}
+	return node, nil
+}
+
+// recordMalformedEnodeURL increments and returns the number of consecutive malformed enode URLs
+// received from the validator with the given address
+func (sb *Backend) recordMalformedEnodeURL(address common.Address) int {
+	sb.malformedEnodeURLCountsMu.Lock()
+	defer sb.malformedEnodeURLCountsMu.Unlock()
+
+	sb.malformedEnodeURLCounts[address]++
+	return sb.malformedEnodeURLCounts[address]
+}
+
+// resetMalformedEnodeURLs clears the number of consecutive malformed enode URLs received from
+// the validator with the given address
+func (sb *Backend) resetMalformedEnodeURLs(address common.Address) {
+	sb.malformedEnodeURLCountsMu.Lock()
+	defer sb.malformedEnodeURLCountsMu.Unlock()
+
+	delete(sb.malformedEnodeURLCounts, address)
+}
+
 // answerQueryEnodeMsg will answer a received queryEnode message from an origin
 // node. If the origin node is already a peer of any kind, an enodeCertificate will be sent.
 // Regardless, the origin node will be upserted into the val enode table
@@ -750,17 +1537,37 @@ func (sb *Backend) answerQueryEnodeMsg(address common.Address, node *enode.Node,
 			return errNodeMissingEnodeCertificate
 		}
 
-		payload, err := enodeCertMsg.Msg.Payload()
-		if err != nil {
-			logger.Warn("Error getting payload of enode certificate message", "err", err)
-			return err
-		}
+		sb.enodeCertificateMsgMapMu.RLock()
+		enodeCertVersion := sb.enodeCertificateMsgVersion
+		sb.enodeCertificateMsgMapMu.RUnlock()
 
-		if err := sb.Multicast([]common.Address{address}, payload, istanbul.EnodeCertificateMsg, false); err != nil {
-			return err
+		if sb.isDuplicateQueryEnodeAnswer(address, enodeCertVersion, version, sb.announceClock.Now()) {
+			logger.Trace("Already answered a query enode message from this origin within the dedup window", "version", version)
+		} else {
+			payload, err := enodeCertMsg.Msg.Payload()
+			if err != nil {
+				logger.Warn("Error getting payload of enode certificate message", "err", err)
+				return err
+			}
+
+			sent, err := sb.multicastToPeers([]common.Address{address}, payload, istanbul.EnodeCertificateMsg)
+			if err != nil {
+				return err
+			}
+			// Only an answer that was actually sent suppresses the answers to the origin's retries
+			if sent {
+				sb.recordQueryEnodeAnswer(address, enodeCertVersion, version, sb.announceClock.Now())
+			} else {
+				logger.Debug("Enode certificate wasn't sent to any peer, not recording the answer", "version", version)
+			}
 		}
 	}
 
+	if !sb.shouldUpsertQueryEnodeOrigin(address, node) {
+		logger.Debug("Not upserting query enode origin because of the answer policy", "answerPolicy", sb.config.AnnounceAnswerPolicy)
+		return nil
+	}
+
 	// Upsert regardless to account for the case that the target is a non-ValidatorPurpose
 	// peer but should be.
 	// If the target is not a peer and should be a ValidatorPurpose peer, this
@@ -772,6 +1579,94 @@ func (sb *Backend) answerQueryEnodeMsg(address common.Address, node *enode.Node,
 	return nil
 }
 
+// queryEnodeAnswer is the most recent answer to the query enode messages of an origin
+type queryEnodeAnswer struct {
+	enodeCertVersion uint // The version of the enode certificate that was sent
+	queryVersion     uint // The version of the answered query enode message
+	answered         mclock.AbsTime
+}
+
+// isDuplicateQueryEnodeAnswer returns whether the origin was already sent the enode certificate
+// in answer to a query enode message of the same version within queryEnodeAnswerDedupWindow.
+func (sb *Backend) isDuplicateQueryEnodeAnswer(address common.Address, enodeCertVersion uint, queryVersion uint, now mclock.AbsTime) bool {
+	sb.lastQueryEnodeAnswersMu.Lock()
+	defer sb.lastQueryEnodeAnswersMu.Unlock()
+
+	last, ok := sb.lastQueryEnodeAnswers[address]
+	return ok && last.enodeCertVersion == enodeCertVersion && last.queryVersion == queryVersion &&
+		now.Sub(last.answered) < queryEnodeAnswerDedupWindow
+}
+
+// recordQueryEnodeAnswer records that the origin was sent the enode certificate in answer to a
+// query enode message.
+func (sb *Backend) recordQueryEnodeAnswer(address common.Address, enodeCertVersion uint, queryVersion uint, now mclock.AbsTime) {
+	sb.lastQueryEnodeAnswersMu.Lock()
+	defer sb.lastQueryEnodeAnswersMu.Unlock()
+
+	sb.lastQueryEnodeAnswers[address] = &queryEnodeAnswer{enodeCertVersion: enodeCertVersion, queryVersion: queryVersion, answered: now}
+}
+
+// shouldUpsertQueryEnodeOrigin returns whether the origin of an answered query enode message
+// should be upserted into the val enode table, according to the configured answer policy
+func (sb *Backend) shouldUpsertQueryEnodeOrigin(address common.Address, node *enode.Node) bool {
+	switch sb.config.AnnounceAnswerPolicy {
+	case istanbul.OnlyExistingPeers:
+		peers := sb.broadcaster.FindPeers(map[enode.ID]bool{node.ID(): true}, p2p.AnyPurpose)
+		return len(peers) > 0
+	case istanbul.Allowlist:
+		for _, allowed := range sb.config.AnnounceAnswerAllowlist {
+			if allowed == address {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// VerifyQueryEnodePayload verifies a raw queryEnode message payload without processing it:
+// its signature and structure, that its sender is in the validator connection set, the same
+// checks as validateQueryEnode, and the encrypted enode URL size limits.  No state is mutated
+// and no enode URL is decrypted.  It returns the sender of the message.
+func (sb *Backend) VerifyQueryEnodePayload(payload []byte) (common.Address, error) {
+	var msg istanbul.Message
+	if err := msg.FromPayload(payload, sb.queryEnodeSignatureAddressFn()); err != nil {
+		return common.Address{}, err
+	}
+	if msg.Code != istanbul.QueryEnodeMsg {
+		return msg.Address, errInvalidQueryEnodeMsg
+	}
+
+	validatorConnSet, err := sb.RetrieveValidatorConnSet()
+	if err != nil {
+		return msg.Address, err
+	}
+	if !validatorConnSet[msg.Address] {
+		return msg.Address, errUnauthorizedAnnounceMessage
+	}
+
+	var qeData queryEnodeData
+	if err := istanbul.DecodeAnnounceData(msg.Msg, &qeData); err != nil {
+		return msg.Address, err
+	}
+
+	if isValid, err := sb.validateQueryEnode(msg.Address, &qeData); err != nil {
+		return msg.Address, err
+	} else if !isValid {
+		return msg.Address, errInvalidQueryEnodeMsg
+	}
+
+	if maxLen := sb.config.AnnounceMaxEncryptedEnodeURLLength; maxLen > 0 {
+		for _, encEnodeURL := range qeData.EncryptedEnodeURLs {
+			if uint64(len(encEnodeURL.EncryptedEnodeURL)) > maxLen {
+				return msg.Address, errEncryptedEnodeURLTooLong
+			}
+		}
+	}
+	return msg.Address, nil
+}
+
 // validateQueryEnode will do some validation to check the contents of the queryEnode
 // message. This is to force all validators that send a queryEnode message to
 // create as succint message as possible, and prevent any possible network DOS attacks
@@ -779,11 +1674,23 @@ func (sb *Backend) answerQueryEnodeMsg(address common.Address, node *enode.Node,
 func (sb *Backend) validateQueryEnode(msgAddress common.Address, qeData *queryEnodeData) (bool, error) {
 	logger := sb.logger.New("func", "validateQueryEnode", "msg address", msgAddress)
 
-	// Check if there are any duplicates in the queryEnode message
+	// Bound the message's timestamp in both directions, so that a sender with a wildly
+	// wrong clock can't make its messages look perpetually fresh
+	if !sb.validQueryEnodeTimestamp(logger, qeData.Timestamp) {
+		return false, nil
+	}
+
+	// Check if there are any duplicates in the queryEnode message.  Multiple entries for this node
+	// are rejected as malformed rather than each answered, so that a single message can't make
+	// this node answer more than once.
 	var encounteredAddresses = make(map[common.Address]bool)
 	for _, encEnodeURL := range qeData.EncryptedEnodeURLs {
 		if encounteredAddresses[encEnodeURL.DestAddress] {
-			logger.Info("QueryEnode message has duplicate entries", "address", encEnodeURL.DestAddress)
+			if encEnodeURL.DestAddress == sb.Address() {
+				logger.Warn("QueryEnode message has multiple entries for this node, rejecting it as malformed")
+			} else {
+				logger.Info("QueryEnode message has duplicate entries", "address", encEnodeURL.DestAddress)
+			}
 			return false, nil
 		}
 
@@ -805,6 +1712,28 @@ func (sb *Backend) validateQueryEnode(msgAddress common.Address, qeData *queryEn
 	return true, nil
 }
 
+// validQueryEnodeTimestamp returns whether a query enode message's timestamp is neither more than
+// AnnounceMaxTimestampSkew ahead of the local time, nor more than AnnounceQueryEnodeMaxAge behind it.
+func (sb *Backend) validQueryEnodeTimestamp(logger log.Logger, timestamp uint) bool {
+	now := time.Now().Unix()
+	if maxSkew := sb.config.AnnounceMaxTimestampSkew; maxSkew > 0 && int64(timestamp) > now+int64(maxSkew) {
+		logger.Warn("QueryEnode message timestamp is too far in the future", "timestamp", timestamp, "now", now, "maxSkew", maxSkew)
+		return false
+	}
+	if maxAge := sb.config.AnnounceQueryEnodeMaxAge; maxAge > 0 && int64(timestamp) < now-int64(maxAge) {
+		logger.Warn("QueryEnode message is expired", "timestamp", timestamp, "now", now, "maxAge", maxAge)
+		return false
+	}
+	return true
+}
+
+// shouldSuppressSelfRegossip returns whether received announce messages originating from the
+// address aren't regossiped, because it's this node's own address and
+// config.AnnounceSuppressSelfRegossip is set.  Proxies always regossip their proxied validator's messages.
+func (sb *Backend) shouldSuppressSelfRegossip(address common.Address) bool {
+	return sb.config.AnnounceSuppressSelfRegossip && !sb.IsProxy() && address == sb.ValidatorAddress()
+}
+
 // regossipQueryEnode will regossip a received queryEnode message.
 // If this node regossiped a queryEnode from the same source address within the last
 // 5 minutes, then it won't regossip. This is to prevent a malicious validator from
@@ -816,30 +1745,151 @@ func (sb *Backend) validateQueryEnode(msgAddress common.Address, qeData *queryEn
 // with sb.selfRecentMessages to prevent future regossips.
 func (sb *Backend) regossipQueryEnode(msg *istanbul.Message, msgTimestamp uint, payload []byte) error {
 	logger := sb.logger.New("func", "regossipQueryEnode", "queryEnodeSourceAddress", msg.Address, "msgTimestamp", msgTimestamp)
-	sb.lastQueryEnodeGossipedMu.Lock()
-	defer sb.lastQueryEnodeGossipedMu.Unlock()
+
+	if sb.shouldSuppressSelfRegossip(msg.Address) {
+		logger.Trace("Not regossiping a query enode message from this node's own address")
+		sb.onRegossipQueryEnodeDecision(msg.Address, false, "self")
+		return nil
+	}
 
 	// Don't throttle messages from our own address so that proxies always regossip
 	// query enode messages sent from the proxied validator
-	if msg.Address != sb.ValidatorAddress() {
-		if lastGossiped, ok := sb.lastQueryEnodeGossiped[msg.Address]; ok {
-			if time.Since(lastGossiped) < queryEnodeGossipCooldownDuration {
-				logger.Trace("Already regossiped msg from this source address within the cooldown period, not regossiping.")
-				return nil
-			}
+	throttled := msg.Address != sb.ValidatorAddress()
+	var reserved, previous gossipTime
+	var hadPrevious bool
+	if throttled {
+		// The cooldown is reserved before gossiping, so that concurrent messages from the same
+		// source address don't each start a gossip and its retries
+		sb.lastQueryEnodeGossipedMu.Lock()
+		previous, hadPrevious = sb.lastQueryEnodeGossiped[msg.Address]
+		if hadPrevious && sb.announceClock.Now().Sub(previous.mono) < queryEnodeGossipCooldownDuration {
+			sb.lastQueryEnodeGossipedMu.Unlock()
+			logger.Trace("Already regossiped msg from this source address within the cooldown period, not regossiping.")
+			sb.onRegossipQueryEnodeDecision(msg.Address, false, "cooldown")
+			return nil
 		}
+		reserved = sb.newGossipTime()
+		sb.lastQueryEnodeGossiped[msg.Address] = reserved
+		sb.lastQueryEnodeGossipedMu.Unlock()
 	}
 
 	logger.Trace("Regossiping the istanbul queryEnode message", "IstanbulMsg", msg.String())
-	if err := sb.Gossip(payload, istanbul.QueryEnodeMsg); err != nil {
+	sb.onRegossipQueryEnodeDecision(msg.Address, true, "")
+
+	// The cooldown only holds once the message is actually gossiped, so the reservation is
+	// rolled back if the gossip and its retries fail
+	sb.gossipAnnounceMsg(payload, istanbul.QueryEnodeMsg, func(err error) {
+		if err == nil {
+			return
+		}
+		logger.Debug("Error in regossiping the istanbul queryEnode message", "err", err)
+		if !throttled {
+			return
+		}
+		sb.lastQueryEnodeGossipedMu.Lock()
+		defer sb.lastQueryEnodeGossipedMu.Unlock()
+		// Unless a later regossip replaced the reservation in the meantime
+		if sb.lastQueryEnodeGossiped[msg.Address] != reserved {
+			return
+		}
+		if hadPrevious {
+			sb.lastQueryEnodeGossiped[msg.Address] = previous
+		} else {
+			delete(sb.lastQueryEnodeGossiped, msg.Address)
+		}
+	})
+
+	return nil
+}
+
+// shouldLightweightRegossipQueryEnode returns whether query enode messages are regossiped
+// without decoding their content.  Validators and proxies always fully validate query enode
+// messages, since they may need to process them.
+func (sb *Backend) shouldLightweightRegossipQueryEnode() bool {
+	return sb.config.AnnounceLightweightQueryEnodeRegossip && !sb.IsValidator() && !sb.IsProxy()
+}
+
+// lightweightRegossipQueryEnode regossips a query enode message after only decoding its
+// envelope and verifying its signer, which must be within the validator connection set.  The
+// content isn't decoded nor decrypted, so its version isn't validated: the message is only
+// deduplicated by its payload hash, and instead of the address keyed regossip cooldown, the
+// messages regossiped on behalf of each delivering peer are rate limited.  Messages with invalid
+// content are still dropped by the validators and proxies that receive them.
+func (sb *Backend) lightweightRegossipQueryEnode(peer consensus.Peer, payload []byte) error {
+	logger := sb.logger.New("func", "lightweightRegossipQueryEnode")
+	msg := new(istanbul.Message)
+	if err := msg.FromPayload(payload, sb.queryEnodeSignatureAddressFn()); err != nil {
+		logger.Debug("Error in decoding received Istanbul QueryEnode message envelope", "err", err)
 		return err
 	}
+	if msg.Code != istanbul.QueryEnodeMsg {
+		return errInvalidQueryEnodeMsg
+	}
 
-	sb.lastQueryEnodeGossiped[msg.Address] = time.Now()
+	validatorConnSet, err := sb.RetrieveValidatorConnSet()
+	if err != nil {
+		logger.Trace("Error in retrieving validator connection set", "err", err)
+		return err
+	}
+	if !validatorConnSet[msg.Address] {
+		logger.Debug("Received a message from a validator not within the validator connection set. Ignoring it.", "sender", msg.Address)
+		return errUnauthorizedAnnounceMessage
+	}
 
+	if !sb.allowLightweightRegossip(peer, len(validatorConnSet)) {
+		logger.Trace("Peer exceeded its lightweight regossip rate limit, not regossiping.", "sender", msg.Address)
+		sb.onRegossipQueryEnodeDecision(msg.Address, false, "rate limit")
+		return nil
+	}
+
+	logger.Trace("Regossiping the istanbul queryEnode message without decoding its content", "sender", msg.Address)
+	sb.gossipAnnounceMsg(payload, istanbul.QueryEnodeMsg, nil)
+	sb.onRegossipQueryEnodeDecision(msg.Address, true, "")
 	return nil
 }
 
+// lightweightRegossipLimiter is a peer's rate limiter of lightweight query enode regossips,
+// along with the budget it was created for
+type lightweightRegossipLimiter struct {
+	limiter *rate.Limiter
+	budget  int
+}
+
+// allowLightweightRegossip returns whether a query enode message delivered by the peer can be
+// regossiped without decoding its content.  Each validator's messages are regossiped at most once per
+// queryEnodeGossipCooldownDuration after full validation, so a peer gets a budget of twice the size of
+// the validator connection set per cooldown period, the same heuristic validateQueryEnode uses for
+// the number of entries in a message.  Messages that weren't delivered by a peer are always allowed.
+func (sb *Backend) allowLightweightRegossip(peer consensus.Peer, validatorConnSetSize int) bool {
+	if peer == nil {
+		return true
+	}
+	budget := 2 * validatorConnSetSize
+	if budget == 0 {
+		return false
+	}
+
+	sb.lightweightRegossipLimitersMu.Lock()
+	defer sb.lightweightRegossipLimitersMu.Unlock()
+	id := peer.Node().ID()
+	cached, ok := sb.lightweightRegossipLimiters.Get(id)
+	if !ok || cached.(*lightweightRegossipLimiter).budget != budget {
+		cached = &lightweightRegossipLimiter{
+			limiter: rate.NewLimiter(rate.Every(queryEnodeGossipCooldownDuration/time.Duration(budget)), budget),
+			budget:  budget,
+		}
+		sb.lightweightRegossipLimiters.Add(id, cached)
+	}
+	return cached.(*lightweightRegossipLimiter).limiter.Allow()
+}
+
+// onRegossipQueryEnodeDecision calls the regossipQueryEnodeHook, if it is set.
+func (sb *Backend) onRegossipQueryEnodeDecision(address common.Address, regossiped bool, reason string) {
+	if sb.regossipQueryEnodeHook != nil {
+		sb.regossipQueryEnodeHook(address, regossiped, reason)
+	}
+}
+
 // Used as a salt when signing versionCertificate. This is to account for
 // the unlikely case where a different signed struct with the same field types
 // is used elsewhere and shared with other nodes. If that were to happen, a
@@ -873,12 +1923,15 @@ func (vc *versionCertificate) Sign(signingFn func(data []byte) ([]byte, error))
 }
 
 // RecoverPublicKeyAndAddress recovers the ECDSA public key and corresponding
-// address from the Signature
+// address from the Signature, which must be canonical
 func (vc *versionCertificate) RecoverPublicKeyAndAddress() error {
 	payloadToSign, err := vc.payloadToSign()
 	if err != nil {
 		return err
 	}
+	if err := istanbul.CheckCanonicalSignature(vc.Signature); err != nil {
+		return err
+	}
 	payloadHash := crypto.Keccak256(payloadToSign)
 	publicKey, err := crypto.SigToPub(payloadHash, vc.Signature)
 	if err != nil {
@@ -934,21 +1987,86 @@ func (vc *versionCertificate) payloadToSign() ([]byte, error) {
 	return payload, nil
 }
 
+// VersionCertificateEncoding holds the encodings of a version certificate, from the payload
+// that is signed to the RLP that is sent in version certificates messages.  Other implementations
+// of the announce protocol can validate their interoperability against them.
+type VersionCertificateEncoding struct {
+	PayloadToSign []byte // The RLP encoding of versionCertificateSalt and the version
+	Signature     []byte // The signature of the Keccak256 hash of PayloadToSign, with the recovery id as the last byte
+	RLP           []byte // The RLP encoding of the version and the signature
+}
+
+// EncodeVersionCertificate signs a version certificate with the private key the way a validator
+// does, and returns its encodings.  The signatures are deterministic (RFC 6979), so the encodings
+// can be used as test vectors.
+func EncodeVersionCertificate(privateKey *ecdsa.PrivateKey, version uint) (*VersionCertificateEncoding, error) {
+	vc := &versionCertificate{Version: version}
+	err := vc.Sign(func(data []byte) ([]byte, error) {
+		return crypto.Sign(crypto.Keccak256(data), privateKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+	payloadToSign, err := vc.payloadToSign()
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := rlp.EncodeToBytes(vc)
+	if err != nil {
+		return nil, err
+	}
+	return &VersionCertificateEncoding{
+		PayloadToSign: payloadToSign,
+		Signature:     vc.Signature,
+		RLP:           encoded,
+	}, nil
+}
+
 func (sb *Backend) generateVersionCertificate(version uint) (*versionCertificate, error) {
 	vc := &versionCertificate{
 		Address:   sb.Address(),
 		PublicKey: sb.publicKey,
 		Version:   version,
 	}
-	err := vc.Sign(sb.Sign)
+	err := vc.Sign(sb.cachedSignFn("versionCertificate"))
 	if err != nil {
 		return nil, err
 	}
 	return vc, nil
 }
 
+// cachedSignature is the last payload signed for an announce signing purpose, and its signature
+type cachedSignature struct {
+	payload   []byte
+	signature []byte
+}
+
+// cachedSignFn returns a signing function for the given purpose. With config.AnnounceCacheSignatures,
+// it reuses the signature of the purpose's last signed payload if the payload is unchanged, and
+// replaces it otherwise.
+func (sb *Backend) cachedSignFn(purpose string) func(data []byte) ([]byte, error) {
+	return func(data []byte) ([]byte, error) {
+		if !sb.config.AnnounceCacheSignatures {
+			return sb.Sign(data)
+		}
+
+		sb.cachedSignaturesMu.Lock()
+		defer sb.cachedSignaturesMu.Unlock()
+		if cached, ok := sb.cachedSignatures[purpose]; ok && bytes.Equal(cached.payload, data) {
+			return common.CopyBytes(cached.signature), nil
+		}
+		signature, err := sb.Sign(data)
+		if err != nil {
+			return nil, err
+		}
+		sb.cachedSignatures[purpose] = &cachedSignature{payload: common.CopyBytes(data), signature: common.CopyBytes(signature)}
+		return signature, nil
+	}
+}
+
 func (sb *Backend) encodeVersionCertificatesMsg(versionCertificates []*versionCertificate) ([]byte, error) {
-	payload, err := rlp.EncodeToBytes(versionCertificates)
+	list := versionCertificateList(versionCertificates)
+	payload, err := istanbul.EncodeAnnounceData(sb.config.AnnounceWireFormat, &list)
 	if err != nil {
 		return nil, err
 	}
@@ -963,15 +2081,17 @@ func (sb *Backend) encodeVersionCertificatesMsg(versionCertificates []*versionCe
 	return msgPayload, nil
 }
 
-func (sb *Backend) gossipVersionCertificatesMsg(versionCertificates []*versionCertificate) error {
+// gossipVersionCertificatesMsg gossips the version certificates.  done is passed on to gossipAnnounceMsg.
+func (sb *Backend) gossipVersionCertificatesMsg(versionCertificates []*versionCertificate, done func(err error)) error {
 	logger := sb.logger.New("func", "gossipVersionCertificatesMsg")
 
 	payload, err := sb.encodeVersionCertificatesMsg(versionCertificates)
 	if err != nil {
-		logger.Warn("Error encoding version certificate msg", "err", err)
+		sb.announceWarnings.Warn(logger, "Error encoding version certificate msg", "err", err)
 		return err
 	}
-	return sb.Gossip(payload, istanbul.VersionCertificatesMsg)
+	sb.gossipAnnounceMsg(payload, istanbul.VersionCertificatesMsg, done)
+	return nil
 }
 
 func (sb *Backend) getAllVersionCertificates() ([]*versionCertificate, error) {
@@ -986,22 +2106,180 @@ func (sb *Backend) getAllVersionCertificates() ([]*versionCertificate, error) {
 	return allVersionCertificates, nil
 }
 
+// getVersionCertificatesToShare returns all version certificates if full is true, and otherwise
+// only the ones that changed since the previous call. Either way, the changed entries are reset.
+func (sb *Backend) getVersionCertificatesToShare(full bool) ([]*versionCertificate, error) {
+	sb.changedVersionCertificatesMu.Lock()
+	defer sb.changedVersionCertificatesMu.Unlock()
+
+	var versionCertificates []*versionCertificate
+	if full {
+		allVersionCertificates, err := sb.getAllVersionCertificates()
+		if err != nil {
+			return nil, err
+		}
+		versionCertificates = allVersionCertificates
+	} else {
+		for address := range sb.changedVersionCertificates {
+			entry, err := sb.versionCertificateTable.Get(address)
+			if err == enodesdb.ErrNotFound {
+				// The entry was pruned since it changed
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			versionCertificates = append(versionCertificates, newVersionCertificateFromEntry(entry))
+		}
+	}
+	sb.changedVersionCertificates = make(map[common.Address]struct{})
+	return versionCertificates, nil
+}
+
+// recordChangedVersionCertificates marks the entries to be included in the next share of
+// the version certificate table
+func (sb *Backend) recordChangedVersionCertificates(entries []*vet.VersionCertificateEntry) {
+	sb.changedVersionCertificatesMu.Lock()
+	defer sb.changedVersionCertificatesMu.Unlock()
+
+	for _, entry := range entries {
+		sb.changedVersionCertificates[entry.Address] = struct{}{}
+	}
+}
+
+// OwnVersionCertificate returns the version certificate that this node currently advertises,
+// which is its entry in the version certificate table, or the most recently generated one if the
+// table has no entry for it
+func (sb *Backend) OwnVersionCertificate() (*versionCertificate, error) {
+	entry, err := sb.versionCertificateTable.Get(sb.Address())
+	if err == nil {
+		return newVersionCertificateFromEntry(entry), nil
+	} else if err != enodesdb.ErrNotFound {
+		return nil, err
+	}
+
+	sb.lastVersionCertificateMu.RLock()
+	defer sb.lastVersionCertificateMu.RUnlock()
+	if sb.lastVersionCertificate == nil {
+		return nil, errNoOwnVersionCertificate
+	}
+	return sb.lastVersionCertificate, nil
+}
+
+// VersionCertificateInfo gives the fields of a version certificate, for RPC use
+type VersionCertificateInfo struct {
+	Address   common.Address `json:"address"`
+	PublicKey hexutil.Bytes  `json:"publicKey"`
+	Version   uint           `json:"version"`
+	Signature hexutil.Bytes  `json:"signature"`
+}
+
+// Info returns the fields of the version certificate, for RPC use
+func (vc *versionCertificate) Info() *VersionCertificateInfo {
+	info := &VersionCertificateInfo{
+		Address:   vc.Address,
+		Version:   vc.Version,
+		Signature: vc.Signature,
+	}
+	if vc.PublicKey != nil {
+		info.PublicKey = crypto.FromECDSAPub(vc.PublicKey)
+	}
+	return info
+}
+
+// VersionHistoryEntry is a version of a validator's version certificate, and when it was received
+type VersionHistoryEntry struct {
+	Version    uint      `json:"version"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// recordVersionHistory appends the versions of the entries to the version history of their
+// validators, dropping the oldest versions beyond the configured history depth
+func (sb *Backend) recordVersionHistory(entries []*vet.VersionCertificateEntry) {
+	depth := int(sb.config.AnnounceVersionHistoryDepth)
+	if depth == 0 || len(entries) == 0 {
+		return
+	}
+	now := time.Now()
+
+	sb.versionHistoryMu.Lock()
+	defer sb.versionHistoryMu.Unlock()
+	for _, entry := range entries {
+		history := append(sb.versionHistory[entry.Address], VersionHistoryEntry{Version: entry.Version, ReceivedAt: now})
+		if len(history) > depth {
+			// Copy into a new slice so that the dropped versions can be garbage collected
+			history = append([]VersionHistoryEntry(nil), history[len(history)-depth:]...)
+		}
+		sb.versionHistory[entry.Address] = history
+	}
+}
+
+// GetVersionHistory returns the most recent versions of the version certificates received
+// for address, oldest first.  It's empty unless AnnounceVersionHistoryDepth is set.
+func (sb *Backend) GetVersionHistory(address common.Address) []VersionHistoryEntry {
+	sb.versionHistoryMu.Lock()
+	defer sb.versionHistoryMu.Unlock()
+	return append([]VersionHistoryEntry(nil), sb.versionHistory[address]...)
+}
+
+// SetVersionCertificateTableRequest sets the addresses of the validators whose version
+// certificates are sent to the peer of node when it registers, e.g. the members of the
+// peer's own validator group.  An empty list sends the full table again.
+func (sb *Backend) SetVersionCertificateTableRequest(node *enode.Node, addresses []common.Address) {
+	sb.versionCertificateTableRequestsMu.Lock()
+	defer sb.versionCertificateTableRequestsMu.Unlock()
+	if len(addresses) == 0 {
+		delete(sb.versionCertificateTableRequests, node.ID())
+		return
+	}
+	sb.versionCertificateTableRequests[node.ID()] = append([]common.Address(nil), addresses...)
+}
+
+// getVersionCertificatesForPeer returns the version certificates to send to a registering
+// peer, which are all of them unless a subset was requested for the peer
+func (sb *Backend) getVersionCertificatesForPeer(peer consensus.Peer) ([]*versionCertificate, error) {
+	var addresses []common.Address
+	if node := peer.Node(); node != nil {
+		sb.versionCertificateTableRequestsMu.RLock()
+		addresses = sb.versionCertificateTableRequests[node.ID()]
+		sb.versionCertificateTableRequestsMu.RUnlock()
+	}
+	if addresses == nil {
+		return sb.getAllVersionCertificates()
+	}
+
+	versionCertificates := make([]*versionCertificate, 0, len(addresses))
+	for _, address := range addresses {
+		entry, err := sb.versionCertificateTable.Get(address)
+		if err == enodesdb.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		versionCertificates = append(versionCertificates, newVersionCertificateFromEntry(entry))
+	}
+	return versionCertificates, nil
+}
+
 // sendVersionCertificateTable sends all VersionCertificates this node
-// has to a peer
+// has to a peer, or the subset requested for the peer
 func (sb *Backend) sendVersionCertificateTable(peer consensus.Peer) error {
 	logger := sb.logger.New("func", "sendVersionCertificateTable")
-	allVersionCertificates, err := sb.getAllVersionCertificates()
+	versionCertificates, err := sb.getVersionCertificatesForPeer(peer)
 	if err != nil {
-		logger.Warn("Error getting all version certificates", "err", err)
+		logger.Warn("Error getting version certificates", "err", err)
 		return err
 	}
-	payload, err := sb.encodeVersionCertificatesMsg(allVersionCertificates)
+	payload, err := sb.encodeVersionCertificatesMsg(versionCertificates)
 	if err != nil {
-		logger.Warn("Error encoding version certificate msg", "err", err)
+		sb.announceWarnings.Warn(logger, "Error encoding version certificate msg", "err", err)
 		return err
 	}
 
-	return peer.Send(istanbul.VersionCertificatesMsg, payload)
+	if err := sb.SendToPeer(peer, payload, istanbul.VersionCertificatesMsg); err != errAnnounceRateLimited {
+		return err
+	}
+	logger.Debug("Not sending version certificate table that exceeds the peer's outbound rate limit", "peer", peer, "size", len(payload))
+	return nil
 }
 
 func (sb *Backend) handleVersionCertificatesMsg(addr common.Address, peer consensus.Peer, payload []byte) error {
@@ -1023,7 +2301,7 @@ func (sb *Backend) handleVersionCertificatesMsg(addr common.Address, peer consen
 	logger = logger.New("msg address", msg.Address)
 
 	var versionCertificates []*versionCertificate
-	if err := rlp.DecodeBytes(msg.Msg, &versionCertificates); err != nil {
+	if err := istanbul.DecodeAnnounceData(msg.Msg, (*versionCertificateList)(&versionCertificates)); err != nil {
 		logger.Warn("Error in decoding received version certificates msg", "err", err)
 		return err
 	}
@@ -1049,6 +2327,24 @@ func (sb *Backend) handleVersionCertificatesMsg(addr common.Address, peer consen
 			logger.Debug("Found version certificate from an address not in the validator conn set", "address", versionCertificate.Address)
 			continue
 		}
+		// While validating, this node's own version certificate is only ever generated locally.
+		// Echoes of it are expected, but a newer one than the highest known version shouldn't exist.
+		// Replicas share the address of their primary, so they store and regossip its version
+		// certificates like any other validator's.
+		if versionCertificate.Address == sb.Address() {
+			highestKnownVersion := sb.highestKnownOwnVersion()
+			// Recorded regardless of whether the certificate is stored, so that the next announce
+			// version of this node is newer than any of its address that peers have seen
+			sb.recordSeenOwnVersion(versionCertificate.Version)
+			if sb.IsValidating() {
+				if versionCertificate.Version > highestKnownVersion {
+					logger.Warn("Rejecting version certificate with this node's address and an unknown version", "version", versionCertificate.Version, "highestKnownVersion", highestKnownVersion)
+				} else {
+					logger.Trace("Ignoring version certificate with this node's address", "version", versionCertificate.Version)
+				}
+				continue
+			}
+		}
 		if _, ok := validAddresses[versionCertificate.Address]; ok {
 			logger.Debug("Found duplicate version certificate in message", "address", versionCertificate.Address)
 			continue
@@ -1056,14 +2352,81 @@ func (sb *Backend) handleVersionCertificatesMsg(addr common.Address, peer consen
 		validAddresses[versionCertificate.Address] = true
 		validEntries = append(validEntries, versionCertificate.Entry())
 	}
-	if err := sb.upsertAndGossipVersionCertificateEntries(validEntries); err != nil {
+	logger.Trace("Verified version certificates", "versionCertificates", sb.versionCertificatesLogValue(validEntries))
+	if err := sb.upsertAndGossipVersionCertificateEntries(validEntries, true); err != nil {
 		logger.Warn("Error upserting and gossiping entries", "err", err)
 		return err
 	}
 	return nil
 }
 
-func (sb *Backend) upsertAndGossipVersionCertificateEntries(entries []*vet.VersionCertificateEntry) error {
+// recordSeenOwnVersion records the version of a version certificate with this node's address
+// received from a peer, if it is the highest one seen so far.
+func (sb *Backend) recordSeenOwnVersion(version uint) {
+	sb.announceVersionMu.Lock()
+	defer sb.announceVersionMu.Unlock()
+	if version > sb.highestSeenOwnVersion {
+		sb.highestSeenOwnVersion = version
+	}
+}
+
+// highestKnownOwnVersion returns the highest version of this node's address that is known, which is
+// the highest of the announce version, the highest version seen from peers and the version of the
+// stored version certificate
+func (sb *Backend) highestKnownOwnVersion() uint {
+	sb.announceVersionMu.RLock()
+	version := sb.announceVersion
+	if sb.highestSeenOwnVersion > version {
+		version = sb.highestSeenOwnVersion
+	}
+	sb.announceVersionMu.RUnlock()
+	if stored, err := sb.versionCertificateTable.GetVersion(sb.Address()); err == nil && stored > version {
+		version = stored
+	}
+	return version
+}
+
+type versionCertificateLogEntry struct {
+	Address common.Address `json:"address"`
+	Version uint           `json:"version"`
+}
+
+// versionCertificatesLogValue returns the representation of the version certificate entries to be used
+// in log statements, which is JSON if AnnounceJSONLogs is enabled.
+func (sb *Backend) versionCertificatesLogValue(entries []*vet.VersionCertificateEntry) string {
+	if sb.config.AnnounceJSONLogs {
+		logEntries := make([]versionCertificateLogEntry, 0, len(entries))
+		for _, entry := range entries {
+			logEntries = append(logEntries, versionCertificateLogEntry{Address: entry.Address, Version: entry.Version})
+		}
+		return jsonLogString(logEntries)
+	}
+	return fmt.Sprintf("%v", entries)
+}
+
+// detectVersionRegressions counts the received version certificate entries whose version is
+// strictly lower than the stored one for the same address.  The upsert ignores them.  A sustained
+// increase of the counter may indicate a stolen key or a rolled back validator, but false positives
+// are expected while a new version propagates, since peers that haven't received it yet keep
+// sharing the previous one.  That's why the entries are only logged at debug level.
+func (sb *Backend) detectVersionRegressions(entries []*vet.VersionCertificateEntry) {
+	logger := sb.logger.New("func", "detectVersionRegressions")
+	for _, entry := range entries {
+		storedVersion, err := sb.versionCertificateTable.GetVersion(entry.Address)
+		if err != nil {
+			continue
+		}
+		if entry.Version < storedVersion {
+			logger.Debug("Received a version certificate with a lower version than the stored one", "address", entry.Address, "version", entry.Version, "storedVersion", storedVersion)
+			sb.announceVersionRegressionsCounter.Inc(1)
+		}
+	}
+}
+
+// upsertAndGossipVersionCertificateEntries upserts the version certificate entries and gossips the new
+// ones.  fromPeer is true for entries received from peers, and false for this node's own freshly
+// generated version certificate, which is always gossiped.
+func (sb *Backend) upsertAndGossipVersionCertificateEntries(entries []*vet.VersionCertificateEntry, fromPeer bool) error {
 	logger := sb.logger.New("func", "upsertAndGossipVersionCertificateEntries")
 	shouldProcess, err := sb.shouldParticipateInAnnounce()
 	if err != nil {
@@ -1093,31 +2456,97 @@ func (sb *Backend) upsertAndGossipVersionCertificateEntries(entries []*vet.Versi
 		}
 	}
 
+	sb.detectVersionRegressions(entries)
+
 	newEntries, err := sb.versionCertificateTable.Upsert(entries)
 	if err != nil {
 		logger.Warn("Error upserting version certificate table entries", "err", err)
 	}
+	sb.recordChangedVersionCertificates(newEntries)
+	sb.recordVersionHistory(newEntries)
 
 	// Only regossip entries that do not originate from an address that we have
 	// gossiped a version certificate for within the last 5 minutes, excluding
 	// our own address.
 	var versionCertificatesToRegossip []*versionCertificate
+	var numRegossiped, numSkippedCooldown, numSelf int64
 	sb.lastVersionCertificatesGossipedMu.Lock()
 	for _, entry := range newEntries {
+		isSelf := entry.Address == sb.ValidatorAddress()
+		if isSelf && fromPeer && sb.shouldSuppressSelfRegossip(entry.Address) {
+			logger.Debug("Not regossiping version certificate", "reason", "self", "address", entry.Address, "version", entry.Version)
+			continue
+		}
 		lastGossipTime, ok := sb.lastVersionCertificatesGossiped[entry.Address]
-		if ok && time.Since(lastGossipTime) >= versionCertificateGossipCooldownDuration && entry.Address != sb.ValidatorAddress() {
+		if ok && sb.announceClock.Now().Sub(lastGossipTime.mono) >= versionCertificateGossipCooldownDuration && !isSelf {
+			logger.Debug("Not regossiping version certificate", "reason", "cooldown", "address", entry.Address, "version", entry.Version, "lastGossipTime", lastGossipTime.wall)
+			numSkippedCooldown++
 			continue
 		}
+		if isSelf {
+			logger.Debug("Regossiping own version certificate", "address", entry.Address, "version", entry.Version)
+			numSelf++
+		} else {
+			logger.Debug("Regossiping version certificate", "address", entry.Address, "version", entry.Version)
+			numRegossiped++
+		}
 		versionCertificatesToRegossip = append(versionCertificatesToRegossip, newVersionCertificateFromEntry(entry))
-		sb.lastVersionCertificatesGossiped[entry.Address] = time.Now()
 	}
 	sb.lastVersionCertificatesGossipedMu.Unlock()
+
+	sb.announceVersionCertificatesRegossipedCounter.Inc(numRegossiped)
+	sb.announceVersionCertificatesSkippedCooldownCounter.Inc(numSkippedCooldown)
+	sb.announceVersionCertificatesSelfCounter.Inc(numSelf)
+	if len(newEntries) > 0 {
+		logger.Debug("Version certificate regossip decisions", "numNewEntries", len(newEntries), "regossiped", numRegossiped, "skippedCooldown", numSkippedCooldown, "self", numSelf)
+	}
 	if len(versionCertificatesToRegossip) > 0 {
-		return sb.gossipVersionCertificatesMsg(versionCertificatesToRegossip)
+		// The gossip times are only recorded once the version certificates are actually gossiped
+		return sb.gossipVersionCertificatesMsg(versionCertificatesToRegossip, func(err error) {
+			if err != nil {
+				logger.Debug("Error in regossiping version certificates", "err", err)
+				return
+			}
+			sb.lastVersionCertificatesGossipedMu.Lock()
+			defer sb.lastVersionCertificatesGossipedMu.Unlock()
+			for _, versionCertificate := range versionCertificatesToRegossip {
+				sb.lastVersionCertificatesGossiped[versionCertificate.Address] = sb.newGossipTime()
+			}
+		})
 	}
 	return nil
 }
 
+// PauseAnnounce stops this node from announcing and querying, e.g. during maintenance, until
+// ResumeAnnounce is called.  Received announce messages are still handled while paused.
+func (sb *Backend) PauseAnnounce() {
+	sb.setAnnouncePaused(true)
+}
+
+// ResumeAnnounce resumes announcing and querying after PauseAnnounce, gossiping right away
+func (sb *Backend) ResumeAnnounce() {
+	sb.setAnnouncePaused(false)
+}
+
+// IsAnnouncePaused returns true if announcing was paused with PauseAnnounce
+func (sb *Backend) IsAnnouncePaused() bool {
+	sb.announcePausedMu.RLock()
+	defer sb.announcePausedMu.RUnlock()
+	return sb.announcePaused
+}
+
+func (sb *Backend) setAnnouncePaused(paused bool) {
+	sb.announcePausedMu.Lock()
+	sb.announcePaused = paused
+	sb.announcePausedMu.Unlock()
+
+	// Send to the channel iff it does not already have a message.
+	select {
+	case sb.announcePausedToggledCh <- struct{}{}:
+	default:
+	}
+}
+
 // UpdateAnnounceVersion will asynchronously update the announce version.
 func (sb *Backend) UpdateAnnounceVersion() {
 	// Send to the channel iff it does not already have a message.
@@ -1134,6 +2563,48 @@ func (sb *Backend) GetAnnounceVersion() uint {
 	return sb.announceVersion
 }
 
+// SetAnnounceVersion generates and shares the announce data structures for the given
+// announce version, and then sets it as this node's announce version.  The version must be
+// newer than the current one, otherwise errAnnounceVersionNotNewer is returned.
+// Operators can use it to explicitly bump the version, e.g. for a coordinated network
+// upgrade.  Periodic version updates are skipped until the time catches up with it, so the
+// version can't be more than AnnounceMaxVersionSkew ahead of the local time, or belong to a
+// later epoch than the current one, otherwise errAnnounceVersionTooFarAhead is returned.
+// Concurrent updates run one at a time, so an update that waited on a newer one fails.
+func (sb *Backend) SetAnnounceVersion(version uint) error {
+	sb.announceVersionUpdateMu.Lock()
+	defer sb.announceVersionUpdateMu.Unlock()
+
+	if version <= sb.GetAnnounceVersion() {
+		return errAnnounceVersionNotNewer
+	}
+	if !sb.announceVersionWithinBounds(version) {
+		return errAnnounceVersionTooFarAhead
+	}
+	if err := sb.setAndShareUpdatedAnnounceVersion(version); err != nil {
+		return err
+	}
+
+	sb.announceVersionMu.Lock()
+	defer sb.announceVersionMu.Unlock()
+	sb.logger.Debug("Updating announce version", "func", "SetAnnounceVersion", "announceVersion", version)
+	sb.announceVersion = version
+	return nil
+}
+
+// announceVersionWithinBounds returns whether the announce version isn't ahead of the versions the
+// periodic updates will use soon, according to the configured version mode.  Timestamp versions
+// may be at most AnnounceMaxVersionSkew ahead of the local time, and epoch+counter versions
+// may not belong to a later epoch than the current one.
+func (sb *Backend) announceVersionWithinBounds(version uint) bool {
+	if sb.config.AnnounceVersionMode == istanbul.EpochCounterVersion {
+		epoch, _ := decodeEpochVersion(version)
+		return epoch <= istanbul.GetEpochNumber(sb.currentBlock().Number().Uint64(), sb.config.Epoch)
+	}
+	maxSkew := sb.config.AnnounceMaxVersionSkew
+	return maxSkew == 0 || uint64(version) <= uint64(time.Now().Unix())+maxSkew
+}
+
 // setAndShareUpdatedAnnounceVersion generates announce data structures and
 // and shares them with relevant nodes.
 // It will:
@@ -1143,6 +2614,7 @@ func (sb *Backend) GetAnnounceVersion() uint {
 //       message to the proxy, which will in turn send the enode certificate to remote validators.
 //  3) Generate a new version certificate
 //  4) Gossip the new version certificate to all peers
+// Callers other than tests must hold announceVersionUpdateMu.
 func (sb *Backend) setAndShareUpdatedAnnounceVersion(version uint) error {
 	logger := sb.logger.New("func", "setAndShareUpdatedAnnounceVersion")
 	// Send new versioned enode msg to all other registered or elected validators
@@ -1157,7 +2629,7 @@ func (sb *Backend) setAndShareUpdatedAnnounceVersion(version uint) error {
 		return nil
 	}
 
-	enodeCertificateMsgs, err := sb.generateEnodeCertificateMsgs(version)
+	enodeCertificateMsgs, internalEnodeCertificateMsgs, err := sb.generateEnodeCertificateMsgs(version)
 	if err != nil {
 		return err
 	}
@@ -1174,7 +2646,17 @@ func (sb *Backend) setAndShareUpdatedAnnounceVersion(version uint) error {
 		valConnArray = append(valConnArray, address)
 	}
 
+	// The certificates of internal enode URLs are only sent to their destinations, the proxies
+	// only hand out the certificates of their external enode URLs
+	allEnodeCertificateMsgs := make([]*istanbul.EnodeCertMsg, 0, len(enodeCertificateMsgs)+len(internalEnodeCertificateMsgs))
 	for _, enodeCertMsg := range enodeCertificateMsgs {
+		allEnodeCertificateMsgs = append(allEnodeCertificateMsgs, enodeCertMsg)
+	}
+	allEnodeCertificateMsgs = append(allEnodeCertificateMsgs, internalEnodeCertificateMsgs...)
+
+	// The enode certificate payloads of the recipients that couldn't be reached
+	unreachable := make(map[common.Address][]byte)
+	for _, enodeCertMsg := range allEnodeCertificateMsgs {
 		var destAddresses []common.Address
 		if enodeCertMsg.DestAddresses != nil {
 			destAddresses = enodeCertMsg.DestAddresses
@@ -1189,13 +2671,22 @@ func (sb *Backend) setAndShareUpdatedAnnounceVersion(version uint) error {
 			return err
 		}
 
-		if err := sb.Multicast(destAddresses, payload, istanbul.EnodeCertificateMsg, false); err != nil {
-			return err
+		if sb.IsProxiedValidator() {
+			// The proxies forward the message, so delivery isn't tracked per recipient
+			if err := sb.Multicast(destAddresses, payload, istanbul.EnodeCertificateMsg, false); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, address := range sb.multicastEnodeCertificate(destAddresses, payload) {
+			unreachable[address] = payload
 		}
 	}
 
 	if sb.IsProxiedValidator() {
-		sb.proxiedValidatorEngine.SendEnodeCertsToAllProxies(enodeCertificateMsgs)
+		sb.sendEnodeCertsToProxies(enodeCertificateMsgs)
+	} else {
+		sb.scheduleEnodeCertsRetry(unreachable)
 	}
 
 	// Generate and gossip a new version certificate
@@ -1203,9 +2694,223 @@ func (sb *Backend) setAndShareUpdatedAnnounceVersion(version uint) error {
 	if err != nil {
 		return err
 	}
-	return sb.upsertAndGossipVersionCertificateEntries([]*vet.VersionCertificateEntry{
+	sb.lastVersionCertificateMu.Lock()
+	sb.lastVersionCertificate = newVersionCertificate
+	sb.lastVersionCertificateMu.Unlock()
+	if err := sb.upsertAndGossipVersionCertificateEntries([]*vet.VersionCertificateEntry{
 		newVersionCertificate.Entry(),
-	})
+	}, false); err != nil {
+		return err
+	}
+
+	// Both were generated with the same version, so a divergence is a bug
+	if _, err := sb.verifyAnnounceVersionConsistency(); err != nil {
+		logger.Warn("Error verifying announce version consistency", "err", err)
+	}
+	return nil
+}
+
+// sendEnodeCertsToProxies sends the enode certificates to this proxied validator's proxies.
+// The enode certificates that couldn't be sent to their proxy, e.g. because of a send error or
+// a proxy that isn't connected, are resent in the background with a backoff until they're
+// delivered, newer enode certificates are sent, or the announce thread is stopped.
+func (sb *Backend) sendEnodeCertsToProxies(enodeCertificateMsgs map[enode.ID]*istanbul.EnodeCertMsg) {
+	logger := sb.logger.New("func", "sendEnodeCertsToProxies")
+
+	sb.proxyEnodeCertsRetryMu.Lock()
+	defer sb.proxyEnodeCertsRetryMu.Unlock()
+
+	// Newer enode certificates supersede any pending retry
+	if sb.proxyEnodeCertsRetryCancel != nil {
+		close(sb.proxyEnodeCertsRetryCancel)
+		sb.proxyEnodeCertsRetryCancel = nil
+	}
+
+	unsent, err := sb.trySendEnodeCertsToProxies(logger, enodeCertificateMsgs)
+	if err != nil {
+		logger.Warn("Error in sending enode certificates to proxies", "err", err)
+		return
+	}
+	if len(unsent) == 0 {
+		return
+	}
+	sb.announceWarnings.Warn(logger, "Error in sending enode certificates to some proxies, will retry", "unsent", len(unsent), "backoff", proxyEnodeCertsRetryBackoff)
+
+	cancel := make(chan struct{})
+	sb.proxyEnodeCertsRetryCancel = cancel
+	go sb.retrySendEnodeCertsToProxies(unsent, cancel, sb.announceThreadQuit)
+}
+
+// trySendEnodeCertsToProxies sends the enode certificates to the proxies, and returns the ones that couldn't
+// be sent to their proxy.  Every failed send is counted in the announce gossip failures metric.  An error is
+// only returned if the proxied validator engine isn't running, in which case there's nothing to retry.
+func (sb *Backend) trySendEnodeCertsToProxies(logger log.Logger, enodeCertificateMsgs map[enode.ID]*istanbul.EnodeCertMsg) (map[enode.ID]*istanbul.EnodeCertMsg, error) {
+	failures, err := sb.GetProxiedValidatorEngine().SendEnodeCertsToAllProxies(enodeCertificateMsgs)
+	if err != nil {
+		return nil, err
+	}
+	unsent := make(map[enode.ID]*istanbul.EnodeCertMsg)
+	for proxyID, err := range failures {
+		sb.announceGossipFailuresCounter.Inc(1)
+		logger.Debug("Error in sending enode certificate to proxy", "proxyID", proxyID, "err", err)
+		unsent[proxyID] = enodeCertificateMsgs[proxyID]
+	}
+	return unsent, nil
+}
+
+// retrySendEnodeCertsToProxies retries sending the unsent enode certificates to their proxies with an
+// exponential backoff, until all are sent, the proxied validator engine is stopped, or either cancel
+// or quit is closed.
+func (sb *Backend) retrySendEnodeCertsToProxies(unsent map[enode.ID]*istanbul.EnodeCertMsg, cancel <-chan struct{}, quit <-chan struct{}) {
+	logger := sb.logger.New("func", "retrySendEnodeCertsToProxies")
+	defer sb.announceGoroutines.track("retrySendEnodeCertsToProxies")()
+
+	backoff := proxyEnodeCertsRetryBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-cancel:
+			logger.Debug("Enode certificates were superseded, not retrying")
+			return
+		case <-quit:
+			return
+		case <-time.After(backoff):
+		}
+
+		sb.proxyEnodeCertsRetryMu.Lock()
+		select {
+		case <-cancel:
+			// Superseded while waiting for the lock
+			sb.proxyEnodeCertsRetryMu.Unlock()
+			return
+		default:
+		}
+		var err error
+		unsent, err = sb.trySendEnodeCertsToProxies(logger, unsent)
+		if err != nil || len(unsent) == 0 {
+			sb.proxyEnodeCertsRetryCancel = nil
+			sb.proxyEnodeCertsRetryMu.Unlock()
+			if err != nil {
+				logger.Debug("Proxied validator engine stopped, not retrying", "err", err)
+			} else {
+				logger.Info("Sent enode certificates to proxies after retrying", "attempts", attempt)
+			}
+			return
+		}
+		sb.proxyEnodeCertsRetryMu.Unlock()
+
+		if backoff *= 2; backoff > proxyEnodeCertsMaxRetryBackoff {
+			backoff = proxyEnodeCertsMaxRetryBackoff
+		}
+		logger.Debug("Error in sending enode certificates to some proxies, will retry", "attempt", attempt, "unsent", len(unsent), "backoff", backoff)
+	}
+}
+
+// multicastEnodeCertificate sends the enode certificate message payload to the destAddresses that
+// are connected peers, and returns the destAddresses that couldn't be reached.  A destination is
+// unreachable if its enode isn't in the val enode table yet, or if it isn't a connected peer.
+// This node's own address is never unreachable.
+func (sb *Backend) multicastEnodeCertificate(destAddresses []common.Address, payload []byte) []common.Address {
+	nodeIDs := make(map[common.Address]enode.ID)
+	targets := make(map[enode.ID]bool)
+	for _, address := range destAddresses {
+		if address == sb.Address() {
+			continue
+		}
+		if valNode, err := sb.valEnodeTable.GetNodeFromAddress(address); valNode != nil && err == nil {
+			nodeIDs[address] = valNode.ID()
+			targets[valNode.ID()] = true
+		}
+	}
+
+	var destPeers map[enode.ID]consensus.Peer
+	if len(targets) > 0 {
+		destPeers = sb.broadcaster.FindPeers(targets, p2p.AnyPurpose)
+		sb.asyncMulticast(destPeers, payload, istanbul.EnodeCertificateMsg)
+	}
+
+	var unreachable []common.Address
+	for _, address := range destAddresses {
+		if address == sb.Address() {
+			continue
+		}
+		if nodeID, ok := nodeIDs[address]; !ok || destPeers[nodeID] == nil {
+			unreachable = append(unreachable, address)
+		}
+	}
+	return unreachable
+}
+
+// scheduleEnodeCertsRetry retries sending the enode certificate payloads to the given unreachable
+// recipients in the background, replacing any pending retry of older enode certificates.
+func (sb *Backend) scheduleEnodeCertsRetry(unreachable map[common.Address][]byte) {
+	sb.enodeCertsRetryMu.Lock()
+	defer sb.enodeCertsRetryMu.Unlock()
+
+	// Newer enode certificates supersede any pending retry
+	if sb.enodeCertsRetryCancel != nil {
+		close(sb.enodeCertsRetryCancel)
+		sb.enodeCertsRetryCancel = nil
+	}
+	if len(unreachable) == 0 {
+		return
+	}
+	sb.logger.Debug("Some validator conn set members were unreachable, will retry sending enode certificates", "func", "scheduleEnodeCertsRetry", "unreachable", len(unreachable), "backoff", enodeCertsRetryBackoff)
+
+	cancel := make(chan struct{})
+	sb.enodeCertsRetryCancel = cancel
+	go sb.retryEnodeCerts(unreachable, cancel, sb.announceThreadQuit)
+}
+
+// retryEnodeCerts retries sending the enode certificate payloads to the unreachable recipients with
+// an exponential backoff, until all of them have been reached or either cancel or quit is closed.
+// Recipients that left the validator conn set are dropped.
+func (sb *Backend) retryEnodeCerts(unreachable map[common.Address][]byte, cancel <-chan struct{}, quit <-chan struct{}) {
+	logger := sb.logger.New("func", "retryEnodeCerts")
+	defer sb.announceGoroutines.track("retryEnodeCerts")()
+
+	backoff := enodeCertsRetryBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-cancel:
+			logger.Debug("Enode certificates were superseded, not retrying")
+			return
+		case <-quit:
+			return
+		case <-time.After(backoff):
+		}
+
+		validatorConnSet, err := sb.RetrieveValidatorConnSet()
+		if err != nil {
+			logger.Debug("Error in retrieving the validator conn set, will retry", "err", err)
+			continue
+		}
+
+		sb.enodeCertsRetryMu.Lock()
+		select {
+		case <-cancel:
+			// Superseded while waiting for the lock
+			sb.enodeCertsRetryMu.Unlock()
+			return
+		default:
+		}
+		for address, payload := range unreachable {
+			if !validatorConnSet[address] || len(sb.multicastEnodeCertificate([]common.Address{address}, payload)) == 0 {
+				delete(unreachable, address)
+			}
+		}
+		if len(unreachable) == 0 {
+			sb.enodeCertsRetryCancel = nil
+			sb.enodeCertsRetryMu.Unlock()
+			logger.Debug("Sent enode certificates to all reachable validator conn set members after retrying", "attempts", attempt)
+			return
+		}
+		sb.enodeCertsRetryMu.Unlock()
+
+		if backoff *= 2; backoff > enodeCertsMaxRetryBackoff {
+			backoff = enodeCertsMaxRetryBackoff
+		}
+		logger.Trace("Some validator conn set members are still unreachable, will retry", "attempt", attempt, "unreachable", len(unreachable), "backoff", backoff)
+	}
 }
 
 func getTimestamp() uint {
@@ -1213,6 +2918,62 @@ func getTimestamp() uint {
 	return uint(time.Now().Unix())
 }
 
+// PruneVersionCertificates removes the version certificates that are older than olderThan from
+// the version certificate table, so that they are re-synced from peers. Unlike the periodic prune,
+// the val enode table isn't affected. This node's own version certificate is never removed.
+// Returns the number of removed version certificates.  Versions are only timestamps in the
+// timestamp version mode, so errPruneByAgeUnsupported is returned in epoch+counter version mode.
+func (sb *Backend) PruneVersionCertificates(olderThan time.Duration) (int, error) {
+	logger := sb.logger.New("func", "PruneVersionCertificates")
+
+	if sb.config.AnnounceVersionMode == istanbul.EpochCounterVersion {
+		logger.Warn("Not pruning version certificates by age in epoch+counter version mode")
+		return 0, errPruneByAgeUnsupported
+	}
+
+	// Versions are unix timestamps
+	var minVersion uint
+	if cutoff := time.Now().Add(-olderThan).Unix(); cutoff > 0 {
+		minVersion = uint(cutoff)
+	}
+	numPruned, err := sb.versionCertificateTable.PruneOlderThan(minVersion, sb.ValidatorAddress())
+	if err != nil {
+		logger.Warn("Error in pruning the version certificate table", "err", err)
+		return 0, err
+	}
+	logger.Debug("Pruned version certificates", "olderThan", olderThan, "numPruned", numPruned)
+	return numPruned, nil
+}
+
+// encodeEpochVersion returns the announce version of the counter-th update within an epoch.
+// Versions are ordered by epoch first, and by counter within an epoch.
+func encodeEpochVersion(epoch uint64, counter uint32) uint {
+	return uint(epoch<<32 | uint64(counter))
+}
+
+// decodeEpochVersion returns the epoch and counter of an announce version created by encodeEpochVersion
+func decodeEpochVersion(version uint) (epoch uint64, counter uint32) {
+	return uint64(version) >> 32, uint32(version)
+}
+
+// nextAnnounceVersion returns the version of the next announce version update, according to
+// the configured version mode.  Epoch+counter versions restart their counter at every epoch,
+// and otherwise increment the counter of the highest known version of this node's address.
+// Besides the announce version, that includes the version of this node's own entry in the
+// version certificate table, e.g. after a restart within the same epoch, and the versions
+// received from peers, e.g. of the primary after a replica is promoted.
+func (sb *Backend) nextAnnounceVersion() uint {
+	if sb.config.AnnounceVersionMode != istanbul.EpochCounterVersion {
+		return getTimestamp()
+	}
+	epoch := istanbul.GetEpochNumber(sb.currentBlock().Number().Uint64(), sb.config.Epoch)
+	version := encodeEpochVersion(epoch, 0)
+	if highest := sb.highestKnownOwnVersion(); version <= highest {
+		version = highest + 1
+	}
+	return version
+}
+
 // RetrieveEnodeCertificateMsgMap gets the most recent enode certificate messages.
 // May be nil if no message was generated as a result of the core not being
 // started, or if a proxy has not received a message from its proxied validator
@@ -1226,8 +2987,10 @@ func (sb *Backend) RetrieveEnodeCertificateMsgMap() map[enode.ID]*istanbul.Enode
 // (one for each of it's proxies, or itself for standalone validators) for the purposes of generating enode certificates
 // for those enodes.  It will also return the destination validators for each enode certificate.  If the destAddress is a
 // `nil` value, then that means that the associated enode certificate should be sent to all of the connected validators.
-func (sb *Backend) getEnodeCertNodesAndDestAddresses() ([]*enode.Node, map[enode.ID][]common.Address, error) {
+// The internal nodes of the proxies are returned by the ID of their external node.
+func (sb *Backend) getEnodeCertNodesAndDestAddresses() ([]*enode.Node, map[enode.ID]*enode.Node, map[enode.ID][]common.Address, error) {
 	var externalEnodes []*enode.Node
+	var internalEnodes map[enode.ID]*enode.Node
 	var valDestinations map[enode.ID][]common.Address
 	if sb.IsProxiedValidator() {
 		var proxies []*proxy.Proxy
@@ -1235,21 +2998,25 @@ func (sb *Backend) getEnodeCertNodesAndDestAddresses() ([]*enode.Node, map[enode
 
 		proxies, valDestinations, err = sb.proxiedValidatorEngine.GetProxiesAndValAssignments()
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 
 		externalEnodes = make([]*enode.Node, len(proxies))
+		internalEnodes = make(map[enode.ID]*enode.Node, len(proxies))
 		for i, proxy := range proxies {
 			externalEnodes[i] = proxy.ExternalNode()
+			if internalNode := proxy.InternalNode(); internalNode != nil {
+				internalEnodes[proxy.ExternalNode().ID()] = internalNode
+			}
 		}
 	} else {
 		externalEnodes = make([]*enode.Node, 1)
-		externalEnodes[0] = sb.p2pserver.Self()
+		externalEnodes[0] = sb.advertisedSelfNode()
 		valDestinations = make(map[enode.ID][]common.Address)
 		valDestinations[externalEnodes[0].ID()] = nil
 	}
 
-	return externalEnodes, valDestinations, nil
+	return externalEnodes, internalEnodes, valDestinations, nil
 }
 
 // generateEnodeCertificateMsgs generates a map of enode certificate messages.
@@ -1257,39 +3024,91 @@ func (sb *Backend) getEnodeCertNodesAndDestAddresses() ([]*enode.Node, map[enode
 // each external enode this node possesses. A unproxied validator will have one enode, while a
 // proxied validator may have one for each proxy.. Each enode is a key in the returned map, and the
 // value is the certificate message.
-func (sb *Backend) generateEnodeCertificateMsgs(version uint) (map[enode.ID]*istanbul.EnodeCertMsg, error) {
+// The validators that are advertised a proxy's internal enode URL (see enodeURLSelectorForValidator)
+// aren't destinations of the proxy's certificate.  Instead, a certificate of the internal enode URL
+// is generated for them, and returned in the slice.
+func (sb *Backend) generateEnodeCertificateMsgs(version uint) (map[enode.ID]*istanbul.EnodeCertMsg, []*istanbul.EnodeCertMsg, error) {
 	logger := sb.logger.New("func", "generateEnodeCertificateMsgs")
 
 	enodeCertificateMsgs := make(map[enode.ID]*istanbul.EnodeCertMsg)
-	externalEnodes, valDestinations, err := sb.getEnodeCertNodesAndDestAddresses()
+	var internalEnodeCertificateMsgs []*istanbul.EnodeCertMsg
+	externalEnodes, internalEnodes, valDestinations, err := sb.getEnodeCertNodesAndDestAddresses()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	for _, externalNode := range externalEnodes {
-		enodeCertificate := &istanbul.EnodeCertificate{
-			EnodeURL: externalNode.URLv4(),
-			Version:  version,
+		destAddresses := valDestinations[externalNode.ID()]
+		if internalNode := internalEnodes[externalNode.ID()]; destAddresses != nil && internalNode != nil && internalNode.URLv4() != externalNode.URLv4() {
+			var internalDestAddresses []common.Address
+			destAddresses, internalDestAddresses = sb.splitByEnodeURLSelector(destAddresses)
+			if len(internalDestAddresses) > 0 {
+				msg, err := sb.generateEnodeCertificateMsg(internalNode, version, "internalEnodeCertificate:")
+				if err != nil {
+					return nil, nil, err
+				}
+				internalEnodeCertificateMsgs = append(internalEnodeCertificateMsgs, &istanbul.EnodeCertMsg{Msg: msg, DestAddresses: internalDestAddresses})
+			}
 		}
-		enodeCertificateBytes, err := rlp.EncodeToBytes(enodeCertificate)
+
+		msg, err := sb.generateEnodeCertificateMsg(externalNode, version, "enodeCertificate:")
 		if err != nil {
-			return nil, err
-		}
-		msg := &istanbul.Message{
-			Code:    istanbul.EnodeCertificateMsg,
-			Address: sb.Address(),
-			Msg:     enodeCertificateBytes,
-		}
-		// Sign the message
-		if err := msg.Sign(sb.Sign); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		enodeCertificateMsgs[externalNode.ID()] = &istanbul.EnodeCertMsg{Msg: msg, DestAddresses: destAddresses}
+	}
+
+	logger.Trace("Generated Istanbul Enode Certificate messages", "enodeCertificateMsgs", enodeCertificateMsgs, "internalEnodeCertificateMsgs", internalEnodeCertificateMsgs)
+	return enodeCertificateMsgs, internalEnodeCertificateMsgs, nil
+}
+
+// generateEnodeCertificateMsg generates the signed enode certificate message of node.  Its
+// signature is cached under signatureKey followed by the node's ID.
+func (sb *Backend) generateEnodeCertificateMsg(node *enode.Node, version uint, signatureKey string) (*istanbul.Message, error) {
+	enodeCertificate := &istanbul.EnodeCertificate{
+		EnodeURL: node.URLv4(),
+		Version:  version,
+		NodeTag:  istanbul.SanitizeNodeTag(sb.config.AnnounceNodeTag),
+	}
+	enodeCertificateBytes, err := istanbul.EncodeAnnounceData(sb.config.AnnounceWireFormat, enodeCertificate)
+	if err != nil {
+		return nil, err
+	}
+	msg := &istanbul.Message{
+		Code:    istanbul.EnodeCertificateMsg,
+		Address: sb.Address(),
+		Msg:     enodeCertificateBytes,
+	}
+	// Sign the message
+	if err := msg.Sign(sb.cachedSignFn(signatureKey + node.ID().String())); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
 
-		enodeCertificateMsgs[externalNode.ID()] = &istanbul.EnodeCertMsg{Msg: msg, DestAddresses: valDestinations[externalNode.ID()]}
+// splitByEnodeURLSelector splits the validator addresses into those that are advertised the
+// external enode URL, and those that are advertised the internal one
+func (sb *Backend) splitByEnodeURLSelector(addresses []common.Address) (external, internal []common.Address) {
+	external = make([]common.Address, 0, len(addresses))
+	for _, address := range addresses {
+		if sb.enodeURLSelectorForValidator(address) == istanbul.InternalEnodeURL {
+			internal = append(internal, address)
+		} else {
+			external = append(external, address)
+		}
 	}
+	return external, internal
+}
 
-	logger.Trace("Generated Istanbul Enode Certificate messages", "enodeCertificateMsgs", enodeCertificateMsgs)
-	return enodeCertificateMsgs, nil
+// isEnodeCertificateExpired returns whether an enode certificate's version is more than
+// AnnounceEnodeCertificateMaxAge behind the local time.  Epoch+counter versions aren't
+// timestamps, so they never expire.
+func (sb *Backend) isEnodeCertificateExpired(version uint) bool {
+	maxAge := sb.config.AnnounceEnodeCertificateMaxAge
+	if maxAge == 0 || sb.config.AnnounceVersionMode == istanbul.EpochCounterVersion {
+		return false
+	}
+	return int64(version) < time.Now().Unix()-int64(maxAge)
 }
 
 // handleEnodeCertificateMsg handles an enode certificate message for proxied and standalone validators.
@@ -1298,7 +3117,7 @@ func (sb *Backend) handleEnodeCertificateMsg(_ consensus.Peer, payload []byte) e
 
 	var msg istanbul.Message
 	// Decode payload into msg
-	err := msg.FromPayload(payload, istanbul.GetSignatureAddress)
+	err := msg.FromPayload(payload, istanbul.GetCanonicalSignatureAddress)
 	if err != nil {
 		logger.Error("Error in decoding received Istanbul Enode Certificate message", "err", err, "payload", hex.EncodeToString(payload))
 		return err
@@ -1306,7 +3125,7 @@ func (sb *Backend) handleEnodeCertificateMsg(_ consensus.Peer, payload []byte) e
 	logger = logger.New("msg address", msg.Address)
 
 	var enodeCertificate istanbul.EnodeCertificate
-	if err := rlp.DecodeBytes(msg.Msg, &enodeCertificate); err != nil {
+	if err := istanbul.DecodeAnnounceData(msg.Msg, &enodeCertificate); err != nil {
 		logger.Warn("Error in decoding received Istanbul Enode Certificate message content", "err", err, "IstanbulMsg", msg.String())
 		return err
 	}
@@ -1318,6 +3137,11 @@ func (sb *Backend) handleEnodeCertificateMsg(_ consensus.Peer, payload []byte) e
 		return err
 	}
 
+	if sb.isEnodeCertificateExpired(enodeCertificate.Version) {
+		logger.Debug("Received expired Istanbul Enode Certificate message", "version", enodeCertificate.Version, "maxAge", sb.config.AnnounceEnodeCertificateMaxAge)
+		return errEnodeCertificateExpired
+	}
+
 	// Ensure this node is a validator in the validator conn set
 	shouldSave, err := sb.shouldParticipateInAnnounce()
 	if err != nil {
@@ -1340,11 +3164,28 @@ func (sb *Backend) handleEnodeCertificateMsg(_ consensus.Peer, payload []byte) e
 		return errUnauthorizedAnnounceMessage
 	}
 
+	if !sb.isEnodeCertificateAllowlisted(msg.Address) {
+		logger.Debug("Received Istanbul Enode Certificate message originating from a node not in the enode certificate allowlist")
+		return errEnodeCertificateNotAllowlisted
+	}
+
 	if err := sb.valEnodeTable.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: msg.Address, Node: parsedNode, Version: enodeCertificate.Version}}); err != nil {
 		logger.Warn("Error in upserting a val enode table entry", "error", err)
 		return err
 	}
 
+	if sb.config.AnnounceProbeReachability {
+		select {
+		case sb.reachabilityProbeSem <- struct{}{}:
+			go func() {
+				defer func() { <-sb.reachabilityProbeSem }()
+				sb.probeReachability(msg.Address, parsedNode)
+			}()
+		default:
+			logger.Debug("Skipping reachability probe, too many probes in flight")
+		}
+	}
+
 	// Send a valEnodesShare message to the proxy when it's the primary
 	if sb.IsProxiedValidator() && sb.IsValidating() {
 		sb.proxiedValidatorEngine.SendValEnodesShareMsgToAllProxies()
@@ -1353,15 +3194,154 @@ func (sb *Backend) handleEnodeCertificateMsg(_ consensus.Peer, payload []byte) e
 	return nil
 }
 
+// VerifyEnodeCertificatePayload verifies a raw enode certificate message payload without processing
+// it: its signature and structure, and that its enode URL is a valid v4 node within the configured
+// maximum length.  No state is mutated.  It returns the signer of the message and the certificate.
+func (sb *Backend) VerifyEnodeCertificatePayload(payload []byte) (common.Address, *istanbul.EnodeCertificate, error) {
+	var msg istanbul.Message
+	if err := msg.FromPayload(payload, istanbul.GetCanonicalSignatureAddress); err != nil {
+		return common.Address{}, nil, err
+	}
+	if msg.Code != istanbul.EnodeCertificateMsg {
+		return msg.Address, nil, errInvalidEnodeCertificateMsg
+	}
+
+	var enodeCertificate istanbul.EnodeCertificate
+	if err := istanbul.DecodeAnnounceData(msg.Msg, &enodeCertificate); err != nil {
+		return msg.Address, nil, err
+	}
+
+	if maxLen := sb.config.AnnounceMaxEnodeURLLength; maxLen > 0 && uint64(len(enodeCertificate.EnodeURL)) > maxLen {
+		return msg.Address, nil, errEnodeURLTooLong
+	}
+	if _, err := enode.ParseV4(enodeCertificate.EnodeURL); err != nil {
+		return msg.Address, nil, fmt.Errorf("%w: %v", errMalformedEnodeCertificateURL, err)
+	}
+	return msg.Address, &enodeCertificate, nil
+}
+
+// probeReachability dials the TCP endpoint of a validator's node, and records in the val enode
+// table whether a connection could be established.  The connection is closed right away.
+func (sb *Backend) probeReachability(address common.Address, node *enode.Node) {
+	logger := sb.logger.New("func", "probeReachability", "address", address, "node", node)
+	defer sb.announceGoroutines.track("probeReachability")()
+
+	timeout := sb.config.AnnounceReachabilityProbeTimeout
+	if timeout == 0 {
+		timeout = istanbul.DefaultConfig.AnnounceReachabilityProbeTimeout
+	}
+	reachability := istanbul.Reachable
+	conn, err := sb.reachabilityDialFn("tcp", fmt.Sprintf("%v:%d", node.IP(), node.TCP()), time.Duration(timeout)*time.Second)
+	if err != nil {
+		logger.Debug("Validator enode is unreachable", "err", err)
+		reachability = istanbul.Unreachable
+	} else {
+		conn.Close()
+	}
+
+	if err := sb.valEnodeTable.UpdateReachability(address, node, reachability); err != nil {
+		logger.Warn("Error in updating the reachability of a val enode table entry", "err", err)
+	}
+}
+
+// isEnodeCertificateAllowlisted returns whether enode certificates from address are accepted.
+// All addresses are accepted if AnnounceEnodeCertificateAllowlist is empty.
+func (sb *Backend) isEnodeCertificateAllowlisted(address common.Address) bool {
+	if len(sb.config.AnnounceEnodeCertificateAllowlist) == 0 {
+		return true
+	}
+	for _, allowed := range sb.config.AnnounceEnodeCertificateAllowlist {
+		if allowed == address {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestEnodeCertificate requests the peer's current enode certificate.  The peer
+// will respond with an enode certificate message if it has one.
+func (sb *Backend) RequestEnodeCertificate(peer consensus.Peer) error {
+	if !istanbul.SupportsEnodeCertificateRequest(peer.Version()) {
+		return errEnodeCertificateRequestNotSupported
+	}
+	return peer.Send(istanbul.EnodeCertificateRequestMsg, []byte{})
+}
+
+// handleEnodeCertificateRequestMsg will respond to an enode certificate request with
+// this node's current enode certificate.
+func (sb *Backend) handleEnodeCertificateRequestMsg(peer consensus.Peer) error {
+	logger := sb.logger.New("func", "handleEnodeCertificateRequestMsg", "peer", peer)
+
+	if !sb.allowEnodeCertificateRequest(peer) {
+		logger.Debug("Ignoring enode certificate request that exceeds the peer's rate limit")
+		return errEnodeCertificateRequestRateLimited
+	}
+
+	// A proxied validator's certificates are keyed by the external nodes of its proxies, so a
+	// proxy requesting its certificate is responded to with the one for its external node
+	nodeID := sb.SelfNode().ID()
+	if sb.IsProxiedValidator() {
+		proxies, _, err := sb.proxiedValidatorEngine.GetProxiesAndValAssignments()
+		if err != nil {
+			return err
+		}
+		for _, proxyObj := range proxies {
+			if proxyObj.ID() == peer.Node().ID() {
+				nodeID = proxyObj.ExternalNode().ID()
+				break
+			}
+		}
+	}
+
+	enodeCertMsg := sb.RetrieveEnodeCertificateMsgMap()[nodeID]
+	if enodeCertMsg == nil {
+		logger.Debug("No enode certificate to respond to the enode certificate request with")
+		return errNodeMissingEnodeCertificate
+	}
+
+	payload, err := enodeCertMsg.Msg.Payload()
+	if err != nil {
+		logger.Warn("Error getting payload of enode certificate message", "err", err)
+		return err
+	}
+
+	sb.Unicast(peer, payload, istanbul.EnodeCertificateMsg)
+	return nil
+}
+
+// allowEnodeCertificateRequest returns whether an enode certificate request of the peer is within
+// the peer's request rate limit.
+func (sb *Backend) allowEnodeCertificateRequest(peer consensus.Peer) bool {
+	sb.enodeCertRequestLimitersMu.Lock()
+	defer sb.enodeCertRequestLimitersMu.Unlock()
+	id := peer.Node().ID()
+	cached, ok := sb.enodeCertRequestLimiters.Get(id)
+	if !ok {
+		cached = rate.NewLimiter(rate.Every(enodeCertificateRequestInterval), enodeCertificateRequestBurst)
+		sb.enodeCertRequestLimiters.Add(id, cached)
+	}
+	return cached.(*rate.Limiter).Allow()
+}
+
 // SetEnodeCertificateMsgMap will verify the given enode certificate message map, then update it on this struct.
 func (sb *Backend) SetEnodeCertificateMsgMap(enodeCertMsgMap map[enode.ID]*istanbul.EnodeCertMsg) error {
+	return sb.setEnodeCertificateMsgMap(enodeCertMsgMap, false)
+}
+
+// OverrideEnodeCertificateMsgMap is SetEnodeCertificateMsgMap, except that the given enode
+// certificate message map also replaces a more recent one.
+func (sb *Backend) OverrideEnodeCertificateMsgMap(enodeCertMsgMap map[enode.ID]*istanbul.EnodeCertMsg) error {
+	return sb.setEnodeCertificateMsgMap(enodeCertMsgMap, true)
+}
+
+func (sb *Backend) setEnodeCertificateMsgMap(enodeCertMsgMap map[enode.ID]*istanbul.EnodeCertMsg, override bool) error {
 	logger := sb.logger.New("func", "SetEnodeCertificateMsgMap")
 	var enodeCertVersion *uint
 
 	// Verify that all of the certificates have the same version
 	for _, enodeCertMsg := range enodeCertMsgMap {
 		var enodeCert istanbul.EnodeCertificate
-		if err := rlp.DecodeBytes(enodeCertMsg.Msg.Msg, &enodeCert); err != nil {
+		if err := istanbul.DecodeAnnounceData(enodeCertMsg.Msg.Msg, &enodeCert); err != nil {
 			return err
 		}
 
@@ -1379,7 +3359,7 @@ func (sb *Backend) SetEnodeCertificateMsgMap(enodeCertMsgMap map[enode.ID]*istan
 	defer sb.enodeCertificateMsgMapMu.Unlock()
 
 	// Already have a more recent enodeCertificate
-	if *enodeCertVersion < sb.enodeCertificateMsgVersion {
+	if *enodeCertVersion < sb.enodeCertificateMsgVersion && !override {
 		logger.Error("Ignoring enode certificate msgs since it's an older version", "enodeCertVersion", *enodeCertVersion, "sb.enodeCertificateMsgVersion", sb.enodeCertificateMsgVersion)
 		return istanbul.ErrInvalidEnodeCertMsgMapOldVersion
 	} else if *enodeCertVersion == sb.enodeCertificateMsgVersion {
@@ -1388,7 +3368,11 @@ func (sb *Backend) SetEnodeCertificateMsgMap(enodeCertMsgMap map[enode.ID]*istan
 		// to ensure that the proxies to eventually get their enode certificates.
 		logger.Trace("Attempting to set an enode certificate with the same version as the previous set enode certificate's")
 	} else {
-		logger.Debug("Setting enode certificate", "version", *enodeCertVersion)
+		if *enodeCertVersion < sb.enodeCertificateMsgVersion {
+			logger.Warn("Overriding enode certificate msgs with an older version", "enodeCertVersion", *enodeCertVersion, "sb.enodeCertificateMsgVersion", sb.enodeCertificateMsgVersion)
+		} else {
+			logger.Debug("Setting enode certificate", "version", *enodeCertVersion)
+		}
 		sb.enodeCertificateMsgMap = enodeCertMsgMap
 		sb.enodeCertificateMsgVersion = *enodeCertVersion
 	}
@@ -1426,3 +3410,46 @@ func (sb *Backend) RewriteValEnodeTableEntries(entries map[common.Address]*istan
 
 	return nil
 }
+
+// SeedValEnodeTable will upsert operator provided validator address to enode mappings (e.g. from a
+// static list of known validators) into the val enode table, so that this node can connect to those
+// validators before learning their enodes via the announce protocol.  Entries whose enode is invalid
+// or whose address is not within the validator connection set are skipped.  Seeded entries will not
+// overwrite entries that have a higher version, so they will be superseded by announced enodes.
+func (sb *Backend) SeedValEnodeTable(entries []*istanbul.AddressEntry) error {
+	logger := sb.logger.New("func", "SeedValEnodeTable")
+
+	validatorConnSet, err := sb.RetrieveValidatorConnSet()
+	if err != nil {
+		logger.Warn("Error in retrieving validator conn set", "err", err)
+		return err
+	}
+
+	entriesToUpsert := make([]*istanbul.AddressEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry == nil || entry.Node == nil {
+			logger.Warn("Skipping seed entry without an enode")
+			continue
+		}
+		if entry.Node.Pubkey() == nil || entry.Node.IP() == nil || entry.Node.TCP() == 0 {
+			logger.Warn("Skipping seed entry with an invalid enode", "address", entry.Address, "enodeURL", entry.Node.String())
+			continue
+		}
+		if entry.Address == sb.ValidatorAddress() {
+			logger.Debug("Skipping seed entry for own address", "address", entry.Address)
+			continue
+		}
+		if !validatorConnSet[entry.Address] {
+			logger.Debug("Skipping seed entry that is not within the validator connection set", "address", entry.Address)
+			continue
+		}
+		entriesToUpsert = append(entriesToUpsert, &istanbul.AddressEntry{Address: entry.Address, Node: entry.Node, Version: entry.Version})
+	}
+
+	if len(entriesToUpsert) == 0 {
+		return nil
+	}
+
+	logger.Info("Seeding val enode table", "numEntries", len(entriesToUpsert))
+	return sb.valEnodeTable.UpsertVersionAndEnode(entriesToUpsert)
+}
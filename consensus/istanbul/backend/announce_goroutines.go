@@ -0,0 +1,116 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// AnnounceGoroutineStatus is the start and stop accounting of the announce goroutines with the same name
+type AnnounceGoroutineStatus struct {
+	Name        string    `json:"name"`
+	Running     int       `json:"running"`     // The number of goroutines that were started and haven't stopped yet
+	Started     uint64    `json:"started"`     // The number of goroutines that were ever started
+	Stopped     uint64    `json:"stopped"`     // The number of goroutines that were ever stopped
+	LastStarted time.Time `json:"lastStarted"` // Zero if none was started
+	LastStopped time.Time `json:"lastStopped"` // Zero if none was stopped
+}
+
+// AnnounceGoroutinesStatus is the status of the announce goroutines, to detect leaked or dead ones
+type AnnounceGoroutinesStatus struct {
+	Goroutines []*AnnounceGoroutineStatus `json:"goroutines"` // Sorted by name
+	// The tasks of the running announce thread whose timer callbacks are pending, sorted by name
+	ScheduledTasks []string `json:"scheduledTasks"`
+}
+
+// announceGoroutineRegistry keeps the start and stop accounting of the announce goroutines,
+// and the scheduler of the running announce thread
+type announceGoroutineRegistry struct {
+	mu         sync.Mutex
+	goroutines map[string]*AnnounceGoroutineStatus
+	scheduler  *announceScheduler
+}
+
+func newAnnounceGoroutineRegistry() *announceGoroutineRegistry {
+	return &announceGoroutineRegistry{goroutines: make(map[string]*AnnounceGoroutineStatus)}
+}
+
+// track accounts for the start of a goroutine with the given name, and returns the function
+// that accounts for its stop. It's meant to be deferred at the start of the goroutine:
+//
+//	defer sb.announceGoroutines.track("name")()
+func (r *announceGoroutineRegistry) track(name string) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status, ok := r.goroutines[name]
+	if !ok {
+		status = &AnnounceGoroutineStatus{Name: name}
+		r.goroutines[name] = status
+	}
+	status.Running++
+	status.Started++
+	status.LastStarted = time.Now()
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		status.Running--
+		status.Stopped++
+		status.LastStopped = time.Now()
+	}
+}
+
+// setScheduler sets the scheduler of the running announce thread, or nil when it stops
+func (r *announceGoroutineRegistry) setScheduler(scheduler *announceScheduler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.scheduler = scheduler
+}
+
+// status returns a copy of the registry's accounting
+func (r *announceGoroutineRegistry) status() *AnnounceGoroutinesStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := &AnnounceGoroutinesStatus{
+		Goroutines:     make([]*AnnounceGoroutineStatus, 0, len(r.goroutines)),
+		ScheduledTasks: []string{},
+	}
+	for _, goroutine := range r.goroutines {
+		goroutineCopy := *goroutine
+		status.Goroutines = append(status.Goroutines, &goroutineCopy)
+	}
+	sort.Slice(status.Goroutines, func(i, j int) bool { return status.Goroutines[i].Name < status.Goroutines[j].Name })
+
+	if r.scheduler != nil {
+		for _, task := range r.scheduler.RunningTasks() {
+			status.ScheduledTasks = append(status.ScheduledTasks, task.String())
+		}
+		sort.Strings(status.ScheduledTasks)
+	}
+	return status
+}
+
+// AnnounceGoroutines returns the status of the announce thread and the goroutines it spawns
+func (sb *Backend) AnnounceGoroutines() *AnnounceGoroutinesStatus {
+	return sb.announceGoroutines.status()
+}
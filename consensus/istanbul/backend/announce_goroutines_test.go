@@ -0,0 +1,92 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAnnounceGoroutineRegistry(t *testing.T) {
+	registry := newAnnounceGoroutineRegistry()
+
+	stop1 := registry.track("retryEnodeCerts")
+	stop2 := registry.track("retryEnodeCerts")
+	stop1()
+	registry.track("probeReachability")
+
+	goroutines := registry.status().Goroutines
+	if len(goroutines) != 2 {
+		t.Fatalf("Incorrect number of goroutine statuses.  Want: 2, Have: %d", len(goroutines))
+	}
+	for i, want := range []AnnounceGoroutineStatus{
+		{Name: "probeReachability", Running: 1, Started: 1, Stopped: 0},
+		{Name: "retryEnodeCerts", Running: 1, Started: 2, Stopped: 1},
+	} {
+		have := *goroutines[i]
+		have.LastStarted, have.LastStopped = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("Incorrect goroutine status.  Want: %+v, Have: %+v", want, have)
+		}
+	}
+
+	stop2()
+	if status := registry.status().Goroutines[1]; status.Running != 0 || status.LastStopped.IsZero() {
+		t.Errorf("Incorrect goroutine status after all stopped.  Have: %+v", status)
+	}
+}
+
+func TestAnnounceGoroutinesThreadStartAndStop(t *testing.T) {
+	engine := newBackend()
+
+	// The announce thread is started by newBackend, and registers itself once running
+	announceThreadStatus := func() (*AnnounceGoroutineStatus, []string) {
+		status := engine.AnnounceGoroutines()
+		for _, goroutine := range status.Goroutines {
+			if goroutine.Name == "announceThread" {
+				return goroutine, status.ScheduledTasks
+			}
+		}
+		return nil, status.ScheduledTasks
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if status, _ := announceThreadStatus(); status != nil && status.Running == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The announce thread wasn't registered as running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, scheduledTasks := announceThreadStatus(); len(scheduledTasks) == 0 {
+		t.Errorf("The running announce thread should have scheduled tasks")
+	}
+
+	// Stopping announcing waits for the announce thread to exit
+	if err := engine.StopAnnouncing(); err != nil {
+		t.Fatalf("Error in stopping announcing.  Error: %v", err)
+	}
+	status, scheduledTasks := announceThreadStatus()
+	if status.Running != 0 || status.Started != 1 || status.Stopped != 1 {
+		t.Errorf("Incorrect announce thread status after stopping.  Want: 0 running, 1 started, 1 stopped, Have: %+v", status)
+	}
+	if len(scheduledTasks) != 0 {
+		t.Errorf("Incorrect scheduled tasks after stopping.  Want: [], Have: %v", scheduledTasks)
+	}
+}
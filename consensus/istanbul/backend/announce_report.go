@@ -0,0 +1,399 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/common/mclock"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	vet "github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/enodes"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul/proxy"
+	"github.com/celo-org/celo-blockchain/crypto"
+)
+
+// AnnounceReport bundles diagnostic information about this node's participation
+// in the announce protocol.  Intended for RPC use.
+type AnnounceReport struct {
+	AnnounceVersion  uint   `json:"announceVersion"`
+	AnnounceRunning  bool   `json:"announceRunning"`
+	ValidatorAddress string `json:"validatorAddress"`
+
+	InValidatorConnSet   bool `json:"inValidatorConnSet"`
+	ValidatorConnSetSize int  `json:"validatorConnSetSize"`
+
+	ValEnodeTableSize     int      `json:"valEnodeTableSize"`
+	NumKnownEnodes        int      `json:"numKnownEnodes"`
+	NumReachableEnodes    int      `json:"numReachableEnodes"`    // Known enodes whose latest reachability probe succeeded
+	UnreachableValidators []string `json:"unreachableValidators"` // Validators in the conn set whose enode is unknown, or failed its latest reachability probe
+
+	LastQueryEnodeGossiped          map[string]time.Time `json:"lastQueryEnodeGossiped"`
+	LastVersionCertificatesGossiped map[string]time.Time `json:"lastVersionCertificatesGossiped"`
+
+	IsProxy            bool               `json:"isProxy"`
+	IsProxiedValidator bool               `json:"isProxiedValidator"`
+	Proxies            []*proxy.ProxyInfo `json:"proxies,omitempty"`
+}
+
+// IsAnnounceRunning returns true if the announce thread is running
+func (sb *Backend) IsAnnounceRunning() bool {
+	sb.announceMu.RLock()
+	defer sb.announceMu.RUnlock()
+	return sb.announceRunning
+}
+
+// GenerateAnnounceReport aggregates the state of the announce protocol into a single report
+func (sb *Backend) GenerateAnnounceReport() (*AnnounceReport, error) {
+	report := &AnnounceReport{
+		AnnounceVersion:    sb.GetAnnounceVersion(),
+		AnnounceRunning:    sb.IsAnnounceRunning(),
+		ValidatorAddress:   sb.ValidatorAddress().Hex(),
+		IsProxy:            sb.IsProxy(),
+		IsProxiedValidator: sb.IsProxiedValidator(),
+	}
+
+	validatorConnSet, err := sb.RetrieveValidatorConnSet()
+	if err != nil {
+		return nil, err
+	}
+	report.InValidatorConnSet = validatorConnSet[sb.ValidatorAddress()]
+	report.ValidatorConnSetSize = len(validatorConnSet)
+
+	valEnodeEntries, err := sb.valEnodeTable.GetValEnodes(nil)
+	if err != nil {
+		return nil, err
+	}
+	report.ValEnodeTableSize = len(valEnodeEntries)
+	for _, entry := range valEnodeEntries {
+		if entry.Node != nil {
+			report.NumKnownEnodes++
+			if entry.Reachability == istanbul.Reachable {
+				report.NumReachableEnodes++
+			}
+		}
+	}
+
+	report.UnreachableValidators = make([]string, 0)
+	for address := range validatorConnSet {
+		if address == sb.ValidatorAddress() {
+			continue
+		}
+		if entry, ok := valEnodeEntries[address]; !ok || entry.Node == nil || entry.Reachability == istanbul.Unreachable {
+			report.UnreachableValidators = append(report.UnreachableValidators, address.Hex())
+		}
+	}
+
+	sb.lastQueryEnodeGossipedMu.RLock()
+	report.LastQueryEnodeGossiped = copyGossipTimes(sb.lastQueryEnodeGossiped)
+	sb.lastQueryEnodeGossipedMu.RUnlock()
+
+	sb.lastVersionCertificatesGossipedMu.RLock()
+	report.LastVersionCertificatesGossiped = copyGossipTimes(sb.lastVersionCertificatesGossiped)
+	sb.lastVersionCertificatesGossipedMu.RUnlock()
+
+	if report.IsProxiedValidator {
+		proxies, valAssignments, err := sb.proxiedValidatorEngine.GetProxiesAndValAssignments()
+		if err != nil {
+			return nil, err
+		}
+		for _, proxyObj := range proxies {
+			report.Proxies = append(report.Proxies, proxy.NewProxyInfo(proxyObj, valAssignments[proxyObj.ID()]))
+		}
+	}
+
+	return report, nil
+}
+
+func copyGossipTimes(gossipTimes map[common.Address]gossipTime) map[string]time.Time {
+	gossipTimesCopy := make(map[string]time.Time, len(gossipTimes))
+	for address, gossipTime := range gossipTimes {
+		gossipTimesCopy[address.Hex()] = gossipTime.wall
+	}
+	return gossipTimesCopy
+}
+
+// SilentValidator is a validator in the validator conn set that this node hasn't received a
+// version certificate from within the partition window.  Intended for RPC use.
+type SilentValidator struct {
+	Address      common.Address `json:"address"`
+	LastReceived time.Time      `json:"lastReceived"` // The zero time if a version certificate was never received
+}
+
+// PartitionDiagnostics lists the validators in the validator conn set that this node hasn't
+// received a version certificate from within config.AnnouncePartitionWindow, which indicates
+// that this node's announce gossip is partitioned from them
+func (sb *Backend) PartitionDiagnostics() ([]*SilentValidator, error) {
+	return sb.partitionDiagnostics(time.Now())
+}
+
+func (sb *Backend) partitionDiagnostics(now time.Time) ([]*SilentValidator, error) {
+	window := sb.config.AnnouncePartitionWindow
+	if window == 0 {
+		window = istanbul.DefaultConfig.AnnouncePartitionWindow
+	}
+	cutoff := now.Add(-time.Duration(window) * time.Second)
+
+	validatorConnSet, err := sb.RetrieveValidatorConnSet()
+	if err != nil {
+		return nil, err
+	}
+	versionCertificateEntries, err := sb.versionCertificateTable.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	lastReceived := make(map[common.Address]time.Time, len(versionCertificateEntries))
+	for _, entry := range versionCertificateEntries {
+		lastReceived[entry.Address] = entry.LastUpdated
+	}
+
+	silentValidators := make([]*SilentValidator, 0)
+	for address := range validatorConnSet {
+		if address == sb.ValidatorAddress() {
+			continue
+		}
+		if received := lastReceived[address]; received.Before(cutoff) {
+			silentValidators = append(silentValidators, &SilentValidator{Address: address, LastReceived: received})
+		}
+	}
+	sort.Slice(silentValidators, func(i, j int) bool {
+		return bytes.Compare(silentValidators[i].Address.Bytes(), silentValidators[j].Address.Bytes()) < 0
+	})
+
+	sb.announceSilentValidatorsGauge.Update(int64(len(silentValidators)))
+	return silentValidators, nil
+}
+
+// ConsistencyReport lists the entries of the val enode table and the version certificate
+// table that are inconsistent with each other.  Intended for RPC use.
+type ConsistencyReport struct {
+	PublicKeyMismatches []string            `json:"publicKeyMismatches"` // Validators whose public key differs between the two tables
+	VersionInversions   []*VersionInversion `json:"versionInversions"`   // Validators whose highest known version exceeds their version certificate's version
+	// Set if this node's enode certificates and version certificate have different versions
+	AnnounceVersionMismatch *AnnounceVersionMismatch `json:"announceVersionMismatch,omitempty"`
+}
+
+// AnnounceVersionMismatch describes this node's enode certificates and version certificate
+// having different versions, although they are generated together
+type AnnounceVersionMismatch struct {
+	EnodeCertificateVersion   uint `json:"enodeCertificateVersion"`
+	VersionCertificateVersion uint `json:"versionCertificateVersion"`
+}
+
+// VersionInversion describes a val enode table entry whose HighestKnownVersion is
+// greater than the version of the validator's version certificate
+type VersionInversion struct {
+	Address                   string `json:"address"`
+	HighestKnownVersion       uint   `json:"highestKnownVersion"`
+	VersionCertificateVersion uint   `json:"versionCertificateVersion"`
+}
+
+// VerifyConsistency compares the val enode table with the version certificate table
+// and reports any mismatches between them.  Neither table is modified.
+func (sb *Backend) VerifyConsistency() (*ConsistencyReport, error) {
+	valEnodeEntries, err := sb.valEnodeTable.GetValEnodes(nil)
+	if err != nil {
+		return nil, err
+	}
+	versionCertificateEntries, err := sb.versionCertificateTable.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ConsistencyReport{
+		PublicKeyMismatches: make([]string, 0),
+		VersionInversions:   make([]*VersionInversion, 0),
+	}
+	for _, versionCertificateEntry := range versionCertificateEntries {
+		valEnodeEntry, ok := valEnodeEntries[versionCertificateEntry.Address]
+		if !ok {
+			continue
+		}
+		if valEnodeEntry.PublicKey != nil && versionCertificateEntry.PublicKey != nil &&
+			!bytes.Equal(crypto.FromECDSAPub(valEnodeEntry.PublicKey), crypto.FromECDSAPub(versionCertificateEntry.PublicKey)) {
+			report.PublicKeyMismatches = append(report.PublicKeyMismatches, versionCertificateEntry.Address.Hex())
+		}
+		if valEnodeEntry.HighestKnownVersion > versionCertificateEntry.Version {
+			report.VersionInversions = append(report.VersionInversions, &VersionInversion{
+				Address:                   versionCertificateEntry.Address.Hex(),
+				HighestKnownVersion:       valEnodeEntry.HighestKnownVersion,
+				VersionCertificateVersion: versionCertificateEntry.Version,
+			})
+		}
+	}
+
+	report.AnnounceVersionMismatch, err = sb.verifyAnnounceVersionConsistency()
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// verifyAnnounceVersionConsistency checks that the version of this node's enode certificates
+// equals the version of its own version certificate, and logs a warning if they diverged.
+// Returns nil if they're equal, or if this node hasn't generated both yet.
+func (sb *Backend) verifyAnnounceVersionConsistency() (*AnnounceVersionMismatch, error) {
+	if sb.IsProxy() {
+		// A proxy's enode certificates are generated by its proxied validator
+		return nil, nil
+	}
+
+	sb.enodeCertificateMsgMapMu.RLock()
+	enodeCertificateVersion := sb.enodeCertificateMsgVersion
+	sb.enodeCertificateMsgMapMu.RUnlock()
+	if enodeCertificateVersion == 0 {
+		return nil, nil
+	}
+
+	vc, err := sb.OwnVersionCertificate()
+	if err == errNoOwnVersionCertificate {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if vc.Version == enodeCertificateVersion {
+		return nil, nil
+	}
+	sb.logger.Warn("Enode certificate version and version certificate version diverged", "enodeCertificateVersion", enodeCertificateVersion, "versionCertificateVersion", vc.Version)
+	return &AnnounceVersionMismatch{
+		EnodeCertificateVersion:   enodeCertificateVersion,
+		VersionCertificateVersion: vc.Version,
+	}, nil
+}
+
+// CooldownStatus is the time remaining in the regossip cooldowns of a source address.  Zero
+// means that the cooldown expired, or that nothing from the address was regossiped.  Intended
+// for RPC use, e.g. to find out why the messages of a validator aren't regossiped.
+type CooldownStatus struct {
+	QueryEnode          time.Duration `json:"queryEnode"`          // Until a query enode message from the address is regossiped again
+	VersionCertificates time.Duration `json:"versionCertificates"` // Until a version certificate from the address is regossiped again
+}
+
+// CooldownStatus returns the regossip cooldown status of every tracked source address
+func (sb *Backend) CooldownStatus() map[common.Address]*CooldownStatus {
+	return sb.cooldownStatus(sb.announceClock.Now())
+}
+
+func (sb *Backend) cooldownStatus(now mclock.AbsTime) map[common.Address]*CooldownStatus {
+	statuses := make(map[common.Address]*CooldownStatus)
+	status := func(address common.Address) *CooldownStatus {
+		if _, ok := statuses[address]; !ok {
+			statuses[address] = &CooldownStatus{}
+		}
+		return statuses[address]
+	}
+	remaining := func(lastGossiped gossipTime, cooldown time.Duration) time.Duration {
+		if remaining := cooldown - now.Sub(lastGossiped.mono); remaining > 0 {
+			return remaining
+		}
+		return 0
+	}
+
+	sb.lastQueryEnodeGossipedMu.RLock()
+	for address, lastGossiped := range sb.lastQueryEnodeGossiped {
+		status(address).QueryEnode = remaining(lastGossiped, queryEnodeGossipCooldownDuration)
+	}
+	sb.lastQueryEnodeGossipedMu.RUnlock()
+
+	sb.lastVersionCertificatesGossipedMu.RLock()
+	for address, lastGossiped := range sb.lastVersionCertificatesGossiped {
+		status(address).VersionCertificates = remaining(lastGossiped, versionCertificateGossipCooldownDuration)
+	}
+	sb.lastVersionCertificatesGossipedMu.RUnlock()
+
+	return statuses
+}
+
+// AnnounceStateDump is a dump of this node's in-memory announce state together with the
+// contents of the announce tables, for offline analysis such as diffing the state of nodes
+type AnnounceStateDump struct {
+	Time             time.Time `json:"time"`
+	ValidatorAddress string    `json:"validatorAddress"`
+	AnnounceRunning  bool      `json:"announceRunning"`
+	AnnouncePaused   bool      `json:"announcePaused"`
+	AnnounceVersion  uint      `json:"announceVersion"`
+
+	EnodeCertificateVersion uint              `json:"enodeCertificateVersion"`
+	EnodeCertificates       map[string]string `json:"enodeCertificates"` // The enode URLs of this node's enode certificates, by the ID of the external node they're used for
+
+	LastQueryEnodeGossiped          map[string]time.Time `json:"lastQueryEnodeGossiped"`
+	LastVersionCertificatesGossiped map[string]time.Time `json:"lastVersionCertificatesGossiped"`
+
+	ValEnodeTable           map[string]*vet.ValEnodeEntryInfo           `json:"valEnodeTable"`
+	VersionCertificateTable map[string]*vet.VersionCertificateEntryInfo `json:"versionCertificateTable"`
+}
+
+// DumpAnnounceState writes a JSON dump of the announce state to w.  The in-memory state is
+// locked for the duration of the dump, so that it's from a single instant, and the tables are
+// read while it's locked.  The tables only have their own locks though, so they can still be
+// written to by other threads during the dump.
+func (sb *Backend) DumpAnnounceState(w io.Writer) error {
+	dump, err := sb.dumpAnnounceState()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(dump)
+}
+
+func (sb *Backend) dumpAnnounceState() (*AnnounceStateDump, error) {
+	sb.announceMu.RLock()
+	defer sb.announceMu.RUnlock()
+	sb.announcePausedMu.RLock()
+	defer sb.announcePausedMu.RUnlock()
+	sb.announceVersionMu.RLock()
+	defer sb.announceVersionMu.RUnlock()
+	sb.enodeCertificateMsgMapMu.RLock()
+	defer sb.enodeCertificateMsgMapMu.RUnlock()
+	sb.lastQueryEnodeGossipedMu.RLock()
+	defer sb.lastQueryEnodeGossipedMu.RUnlock()
+	sb.lastVersionCertificatesGossipedMu.RLock()
+	defer sb.lastVersionCertificatesGossipedMu.RUnlock()
+
+	dump := &AnnounceStateDump{
+		Time:                            time.Now(),
+		ValidatorAddress:                sb.ValidatorAddress().Hex(),
+		AnnounceRunning:                 sb.announceRunning,
+		AnnouncePaused:                  sb.announcePaused,
+		AnnounceVersion:                 sb.announceVersion,
+		EnodeCertificateVersion:         sb.enodeCertificateMsgVersion,
+		EnodeCertificates:               make(map[string]string, len(sb.enodeCertificateMsgMap)),
+		LastQueryEnodeGossiped:          copyGossipTimes(sb.lastQueryEnodeGossiped),
+		LastVersionCertificatesGossiped: copyGossipTimes(sb.lastVersionCertificatesGossiped),
+	}
+	for nodeID, enodeCertMsg := range sb.enodeCertificateMsgMap {
+		var enodeCertificate istanbul.EnodeCertificate
+		if err := istanbul.DecodeAnnounceData(enodeCertMsg.Msg.Msg, &enodeCertificate); err != nil {
+			return nil, err
+		}
+		dump.EnodeCertificates[nodeID.String()] = enodeCertificate.EnodeURL
+	}
+
+	var err error
+	if dump.ValEnodeTable, err = sb.valEnodeTable.ValEnodeTableInfo(); err != nil {
+		return nil, err
+	}
+	if dump.VersionCertificateTable, err = sb.versionCertificateTable.Info(); err != nil {
+		return nil, err
+	}
+	return dump, nil
+}
@@ -0,0 +1,350 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	vet "github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/enodes"
+	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/celo-org/celo-blockchain/p2p/enode"
+)
+
+func TestGenerateAnnounceReport(t *testing.T) {
+	numValidators := 2
+	genesisCfg, nodeKeys := getGenesisAndKeys(numValidators, true)
+
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	remoteAddress := crypto.PubkeyToAddress(nodeKeys[1].PublicKey)
+	lastGossiped := engine.newGossipTime()
+	engine.lastQueryEnodeGossipedMu.Lock()
+	engine.lastQueryEnodeGossiped[remoteAddress] = lastGossiped
+	engine.lastQueryEnodeGossipedMu.Unlock()
+
+	report, err := engine.GenerateAnnounceReport()
+	if err != nil {
+		t.Fatalf("Error in generating announce report.  Error: %v", err)
+	}
+
+	if report.AnnounceVersion != engine.GetAnnounceVersion() {
+		t.Errorf("Incorrect announce version.  Want: %d, Have: %d", engine.GetAnnounceVersion(), report.AnnounceVersion)
+	}
+	if !report.AnnounceRunning {
+		t.Errorf("Announce should be reported as running")
+	}
+	if report.ValidatorAddress != engine.ValidatorAddress().Hex() {
+		t.Errorf("Incorrect validator address.  Want: %s, Have: %s", engine.ValidatorAddress().Hex(), report.ValidatorAddress)
+	}
+	if !report.InValidatorConnSet || report.ValidatorConnSetSize != numValidators {
+		t.Errorf("Incorrect conn set membership.  Have: %v, %d", report.InValidatorConnSet, report.ValidatorConnSetSize)
+	}
+	if len(report.UnreachableValidators) != 1 || report.UnreachableValidators[0] != remoteAddress.Hex() {
+		t.Errorf("Incorrect unreachable validators.  Want: [%s], Have: %v", remoteAddress.Hex(), report.UnreachableValidators)
+	}
+	if have, ok := report.LastQueryEnodeGossiped[remoteAddress.Hex()]; !ok || !have.Equal(lastGossiped.wall) {
+		t.Errorf("Incorrect last query enode gossip time.  Want: %v, Have: %v", lastGossiped.wall, have)
+	}
+	if report.IsProxy || report.IsProxiedValidator || report.Proxies != nil {
+		t.Errorf("Incorrect proxy status.  Have: %v, %v, %v", report.IsProxy, report.IsProxiedValidator, report.Proxies)
+	}
+
+	// Once the remote validator's enode is known, it should no longer be unreachable
+	remoteNode := enode.NewV4(&nodeKeys[1].PublicKey, nil, 0, 0)
+	if err := engine.valEnodeTable.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: remoteAddress, Node: remoteNode, Version: 1}}); err != nil {
+		t.Fatalf("Error in upserting val enode table entry.  Error: %v", err)
+	}
+
+	report, err = engine.GenerateAnnounceReport()
+	if err != nil {
+		t.Fatalf("Error in generating announce report.  Error: %v", err)
+	}
+	if report.ValEnodeTableSize != 1 || report.NumKnownEnodes != 1 {
+		t.Errorf("Incorrect val enode table stats.  Have: %d, %d", report.ValEnodeTableSize, report.NumKnownEnodes)
+	}
+	if len(report.UnreachableValidators) != 0 {
+		t.Errorf("Incorrect unreachable validators.  Want: [], Have: %v", report.UnreachableValidators)
+	}
+}
+
+func TestReachabilityProbe(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	var dialedAddresses []string
+	reachable := false
+	engine.reachabilityDialFn = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		dialedAddresses = append(dialedAddresses, address)
+		if !reachable {
+			return nil, errors.New("connection refused")
+		}
+		conn, _ := net.Pipe()
+		return conn, nil
+	}
+
+	remoteAddress := crypto.PubkeyToAddress(nodeKeys[1].PublicKey)
+	remoteNode := enode.NewV4(&nodeKeys[1].PublicKey, net.ParseIP("10.0.0.1"), 30303, 30303)
+	if err := engine.valEnodeTable.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: remoteAddress, Node: remoteNode, Version: 1}}); err != nil {
+		t.Fatalf("Error in upserting val enode table entry.  Error: %v", err)
+	}
+
+	checkReachability := func(want istanbul.Reachability, wantUnreachable bool) {
+		entries, err := engine.valEnodeTable.GetValEnodes([]common.Address{remoteAddress})
+		if err != nil {
+			t.Fatalf("Error in getting val enode table entries.  Error: %v", err)
+		}
+		if have := entries[remoteAddress].Reachability; have != want {
+			t.Errorf("Incorrect reachability.  Want: %v, Have: %v", want, have)
+		}
+		report, err := engine.GenerateAnnounceReport()
+		if err != nil {
+			t.Fatalf("Error in generating announce report.  Error: %v", err)
+		}
+		if isUnreachable := len(report.UnreachableValidators) == 1 && report.UnreachableValidators[0] == remoteAddress.Hex(); isUnreachable != wantUnreachable {
+			t.Errorf("Incorrect unreachable validators.  Want unreachable: %v, Have: %v", wantUnreachable, report.UnreachableValidators)
+		}
+	}
+
+	// Known enodes that weren't probed aren't reported as unreachable
+	checkReachability(istanbul.ReachabilityUnknown, false)
+
+	engine.probeReachability(remoteAddress, remoteNode)
+	checkReachability(istanbul.Unreachable, true)
+
+	reachable = true
+	engine.probeReachability(remoteAddress, remoteNode)
+	checkReachability(istanbul.Reachable, false)
+
+	// The result of a probe of an outdated node is ignored
+	reachable = false
+	engine.probeReachability(remoteAddress, enode.NewV4(&nodeKeys[1].PublicKey, net.ParseIP("10.0.0.2"), 30303, 30303))
+	checkReachability(istanbul.Reachable, false)
+
+	// The reachability of a changed node is unknown
+	changedNode := enode.NewV4(&nodeKeys[1].PublicKey, net.ParseIP("10.0.0.3"), 30303, 30303)
+	if err := engine.valEnodeTable.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: remoteAddress, Node: changedNode, Version: 2}}); err != nil {
+		t.Fatalf("Error in upserting val enode table entry.  Error: %v", err)
+	}
+	checkReachability(istanbul.ReachabilityUnknown, false)
+
+	if want := []string{"10.0.0.1:30303", "10.0.0.1:30303", "10.0.0.2:30303"}; !reflect.DeepEqual(dialedAddresses, want) {
+		t.Errorf("Incorrect dialed addresses.  Want: %v, Have: %v", want, dialedAddresses)
+	}
+}
+
+func TestVerifyConsistency(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+
+	keyA, _ := crypto.GenerateKey()
+	keyB, _ := crypto.GenerateKey()
+	addressA := crypto.PubkeyToAddress(keyA.PublicKey)
+	addressB := crypto.PubkeyToAddress(keyB.PublicKey)
+	rotatedKeyA, _ := crypto.GenerateKey()
+
+	// addressA's val enode entry refers to a rotated key and a version newer than its version certificate.
+	// addressB's entries are consistent.
+	if err := engine.valEnodeTable.UpsertHighestKnownVersion([]*istanbul.AddressEntry{
+		{Address: addressA, PublicKey: &rotatedKeyA.PublicKey, HighestKnownVersion: 5},
+		{Address: addressB, PublicKey: &keyB.PublicKey, HighestKnownVersion: 3},
+	}); err != nil {
+		t.Fatalf("Error in upserting val enode entries.  Error: %v", err)
+	}
+	if _, err := engine.versionCertificateTable.Upsert([]*vet.VersionCertificateEntry{
+		{Address: addressA, PublicKey: &keyA.PublicKey, Version: 3, Signature: []byte("foo")},
+		{Address: addressB, PublicKey: &keyB.PublicKey, Version: 3, Signature: []byte("bar")},
+	}); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+
+	report, err := engine.VerifyConsistency()
+	if err != nil {
+		t.Fatalf("Error in verifying consistency.  Error: %v", err)
+	}
+	if len(report.PublicKeyMismatches) != 1 || report.PublicKeyMismatches[0] != addressA.Hex() {
+		t.Errorf("Incorrect public key mismatches.  Want: [%s], Have: %v", addressA.Hex(), report.PublicKeyMismatches)
+	}
+	if len(report.VersionInversions) != 1 {
+		t.Fatalf("Incorrect number of version inversions.  Want: 1, Have: %d", len(report.VersionInversions))
+	}
+	if inversion := report.VersionInversions[0]; inversion.Address != addressA.Hex() || inversion.HighestKnownVersion != 5 || inversion.VersionCertificateVersion != 3 {
+		t.Errorf("Incorrect version inversion.  Have: %+v", inversion)
+	}
+
+	// The tables are left untouched
+	if hkVersion, err := engine.valEnodeTable.GetHighestKnownVersionFromAddress(addressA); err != nil || hkVersion != 5 {
+		t.Errorf("Val enode entry was modified.  Have: %d, err: %v", hkVersion, err)
+	}
+	if version, err := engine.versionCertificateTable.GetVersion(addressA); err != nil || version != 3 {
+		t.Errorf("Version certificate entry was modified.  Have: %d, err: %v", version, err)
+	}
+}
+
+func TestVerifyAnnounceVersionConsistency(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(1, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	version := getTimestamp() + 100
+	if err := engine.SetAnnounceVersion(version); err != nil {
+		t.Fatalf("Error in setting announce version.  Error: %v", err)
+	}
+	report, err := engine.VerifyConsistency()
+	if err != nil {
+		t.Fatalf("Error in verifying consistency.  Error: %v", err)
+	}
+	if report.AnnounceVersionMismatch != nil {
+		t.Errorf("Unexpected announce version mismatch.  Have: %+v", report.AnnounceVersionMismatch)
+	}
+
+	// Artificially diverge the enode certificate version from the version certificate's
+	engine.enodeCertificateMsgMapMu.Lock()
+	engine.enodeCertificateMsgVersion = version + 1
+	engine.enodeCertificateMsgMapMu.Unlock()
+
+	report, err = engine.VerifyConsistency()
+	if err != nil {
+		t.Fatalf("Error in verifying consistency.  Error: %v", err)
+	}
+	want := &AnnounceVersionMismatch{EnodeCertificateVersion: version + 1, VersionCertificateVersion: version}
+	if !reflect.DeepEqual(report.AnnounceVersionMismatch, want) {
+		t.Errorf("Incorrect announce version mismatch.  Want: %+v, Have: %+v", want, report.AnnounceVersionMismatch)
+	}
+}
+
+func TestPartitionDiagnostics(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(4, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	addresses := make([]common.Address, len(nodeKeys))
+	for i, key := range nodeKeys {
+		addresses[i] = crypto.PubkeyToAddress(key.PublicKey)
+	}
+
+	// Only the first two remote validators have sent a version certificate
+	if _, err := engine.versionCertificateTable.Upsert([]*vet.VersionCertificateEntry{
+		{Address: addresses[1], PublicKey: &nodeKeys[1].PublicKey, Version: 1, Signature: []byte("foo")},
+		{Address: addresses[2], PublicKey: &nodeKeys[2].PublicKey, Version: 1, Signature: []byte("bar")},
+	}); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+
+	silentValidators, err := engine.PartitionDiagnostics()
+	if err != nil {
+		t.Fatalf("Error in getting partition diagnostics.  Error: %v", err)
+	}
+	if len(silentValidators) != 1 || silentValidators[0].Address != addresses[3] || !silentValidators[0].LastReceived.IsZero() {
+		t.Errorf("Incorrect silent validators.  Want: [%s], Have: %v", addresses[3].Hex(), silentValidators)
+	}
+
+	// Once the window has elapsed, every remote validator is silent
+	window := time.Duration(istanbul.DefaultConfig.AnnouncePartitionWindow) * time.Second
+	silentValidators, err = engine.partitionDiagnostics(time.Now().Add(window + time.Minute))
+	if err != nil {
+		t.Fatalf("Error in getting partition diagnostics.  Error: %v", err)
+	}
+	if len(silentValidators) != 3 {
+		t.Fatalf("Incorrect number of silent validators.  Want: 3, Have: %d", len(silentValidators))
+	}
+	for _, silentValidator := range silentValidators {
+		if silentValidator.Address == addresses[0] {
+			t.Errorf("The local validator should not be reported as silent")
+		}
+	}
+}
+
+func TestCooldownStatus(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+
+	now := engine.announceClock.Now()
+	ago := func(d time.Duration) gossipTime { return gossipTime{mono: now.Add(-d), wall: time.Now().Add(-d)} }
+	addressA := common.HexToAddress("0xa")
+	addressB := common.HexToAddress("0xb")
+	addressC := common.HexToAddress("0xc")
+
+	engine.lastQueryEnodeGossipedMu.Lock()
+	engine.lastQueryEnodeGossiped[addressA] = ago(2 * time.Minute)
+	engine.lastQueryEnodeGossiped[addressB] = ago(queryEnodeGossipCooldownDuration + time.Second)
+	engine.lastQueryEnodeGossipedMu.Unlock()
+	engine.lastVersionCertificatesGossipedMu.Lock()
+	engine.lastVersionCertificatesGossiped[addressA] = ago(versionCertificateGossipCooldownDuration)
+	engine.lastVersionCertificatesGossiped[addressC] = ago(time.Minute)
+	engine.lastVersionCertificatesGossipedMu.Unlock()
+
+	want := map[common.Address]*CooldownStatus{
+		addressA: {QueryEnode: queryEnodeGossipCooldownDuration - 2*time.Minute},
+		addressB: {},
+		addressC: {VersionCertificates: versionCertificateGossipCooldownDuration - time.Minute},
+	}
+	if statuses := engine.cooldownStatus(now); !reflect.DeepEqual(statuses, want) {
+		t.Errorf("Incorrect cooldown statuses.  Want: %v, Have: %v", want, statuses)
+	}
+}
+
+func TestDumpAnnounceState(t *testing.T) {
+	engine := newBackend()
+	engine.StopAnnouncing()
+
+	engine.SetValidatorConnSetProvider(fixedValidatorConnSetProvider{engine.Address(): true})
+	version := engine.GetAnnounceVersion() + 1
+	if err := engine.SetAnnounceVersion(version); err != nil {
+		t.Fatalf("Error in setting the announce version.  Error: %v", err)
+	}
+
+	remoteKey, _ := crypto.GenerateKey()
+	remoteAddress := crypto.PubkeyToAddress(remoteKey.PublicKey)
+	remoteNode := enode.NewV4(&remoteKey.PublicKey, net.ParseIP("127.0.0.1"), 30303, 30303)
+	if err := engine.valEnodeTable.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: remoteAddress, Node: remoteNode, Version: 1}}); err != nil {
+		t.Fatalf("Error in upserting val enode table entry.  Error: %v", err)
+	}
+	gossipWall := time.Now().Truncate(time.Second)
+	engine.lastQueryEnodeGossipedMu.Lock()
+	engine.lastQueryEnodeGossiped[remoteAddress] = gossipTime{mono: engine.announceClock.Now(), wall: gossipWall}
+	engine.lastQueryEnodeGossipedMu.Unlock()
+
+	var buf bytes.Buffer
+	if err := engine.DumpAnnounceState(&buf); err != nil {
+		t.Fatalf("Error in dumping announce state.  Error: %v", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatalf("Error in deserializing announce state dump.  Error: %v", err)
+	}
+	for _, field := range []string{"time", "validatorAddress", "announceRunning", "announcePaused", "announceVersion", "enodeCertificateVersion",
+		"enodeCertificates", "lastQueryEnodeGossiped", "lastVersionCertificatesGossiped", "valEnodeTable", "versionCertificateTable"} {
+		if _, ok := fields[field]; !ok {
+			t.Errorf("Announce state dump is missing a field.  Field: %s", field)
+		}
+	}
+
+	var dump AnnounceStateDump
+	if err := json.Unmarshal(buf.Bytes(), &dump); err != nil {
+		t.Fatalf("Error in deserializing announce state dump.  Error: %v", err)
+	}
+	if dump.AnnounceRunning || dump.AnnounceVersion != version || dump.EnodeCertificateVersion != version {
+		t.Errorf("Incorrect announce state.  Want: false %d %d, Have: %v %d %d", version, version, dump.AnnounceRunning, dump.AnnounceVersion, dump.EnodeCertificateVersion)
+	}
+	if want := engine.SelfNode().URLv4(); dump.EnodeCertificates[engine.SelfNode().ID().String()] != want {
+		t.Errorf("Incorrect enode certificates.  Want: %s, Have: %v", want, dump.EnodeCertificates)
+	}
+	if have := dump.LastQueryEnodeGossiped[remoteAddress.Hex()]; !have.Equal(gossipWall) {
+		t.Errorf("Incorrect query enode gossip time.  Want: %v, Have: %v", gossipWall, have)
+	}
+	if entry := dump.ValEnodeTable[remoteAddress.Hex()]; entry == nil || entry.Enode != remoteNode.String() {
+		t.Errorf("Incorrect val enode table entry.  Want: %s, Have: %v", remoteNode.String(), entry)
+	}
+	if entry := dump.VersionCertificateTable[engine.Address().Hex()]; entry == nil || entry.Version != version {
+		t.Errorf("Incorrect version certificate table entry.  Want: %d, Have: %v", version, entry)
+	}
+}
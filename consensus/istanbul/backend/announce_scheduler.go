@@ -0,0 +1,259 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"sync"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common/mclock"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+)
+
+// announceTask is a periodic (or delayed) task of the announce thread
+type announceTask int
+
+const (
+	// checkIfShouldAnnounceTask polls if this node should query and announce
+	checkIfShouldAnnounceTask announceTask = iota
+	// shareVersionCertificatesTask shares the version certificate table, or the entries that changed
+	// since the previous share, with all peers
+	shareVersionCertificatesTask
+	// pruneAnnounceDataStructuresTask prunes the announce related data structures
+	pruneAnnounceDataStructuresTask
+	// queryEnodeTask gossips a query enode message
+	queryEnodeTask
+	// initialQueryEnodeTask gossips the first query enode message after starting to query
+	initialQueryEnodeTask
+	// updateAnnounceVersionTask updates this node's announce version
+	updateAnnounceVersionTask
+	// reconcilePeersTask reconciles the val enode table with the active validator peers
+	reconcilePeersTask
+
+	numAnnounceTasks = int(reconcilePeersTask) + 1
+)
+
+// String returns the name of the announce task
+func (t announceTask) String() string {
+	switch t {
+	case checkIfShouldAnnounceTask:
+		return "checkIfShouldAnnounce"
+	case shareVersionCertificatesTask:
+		return "shareVersionCertificates"
+	case pruneAnnounceDataStructuresTask:
+		return "pruneAnnounceDataStructures"
+	case queryEnodeTask:
+		return "queryEnode"
+	case initialQueryEnodeTask:
+		return "initialQueryEnode"
+	case updateAnnounceVersionTask:
+		return "updateAnnounceVersion"
+	case reconcilePeersTask:
+		return "reconcilePeers"
+	default:
+		return "unknown"
+	}
+}
+
+// announceIntervals are the named intervals of the announce thread's periodic tasks
+type announceIntervals struct {
+	CheckIfShouldAnnounce    time.Duration
+	ShareVersionCertificates time.Duration
+	// The minimum interval between shares of the entire version certificate table
+	FullShareVersionCertificates time.Duration
+	PruneAnnounceDataStructures  time.Duration
+	UpdateAnnounceVersion        time.Duration
+	// The query enode interval used while aggressively querying after announce enablement
+	AggressiveQueryEnode time.Duration
+	// The query enode interval used otherwise
+	QueryEnode time.Duration
+	// The delay of the first query enode message after this node starts to query
+	InitialQueryEnode time.Duration
+	// The interval of peer reconciliation, which is disabled if 0
+	ReconcilePeers time.Duration
+}
+
+// defaultInitialQueryEnodeDelay is the delay of the first query enode message when it isn't configured
+const defaultInitialQueryEnodeDelay = 1 * time.Minute
+
+// announceIntervalsFromConfig returns the announce intervals set in the istanbul config.
+// Intervals that aren't set fall back to the ones of istanbul.DefaultConfig.
+func announceIntervalsFromConfig(config *istanbul.Config) announceIntervals {
+	seconds := func(value, defaultValue uint64) time.Duration {
+		if value == 0 {
+			value = defaultValue
+		}
+		return time.Duration(value) * time.Second
+	}
+	defaults := istanbul.DefaultConfig
+	return announceIntervals{
+		CheckIfShouldAnnounce:        seconds(config.AnnounceCheckIfShouldAnnouncePeriod, defaults.AnnounceCheckIfShouldAnnouncePeriod),
+		ShareVersionCertificates:     seconds(config.AnnounceShareVersionCertificatesPeriod, defaults.AnnounceShareVersionCertificatesPeriod),
+		FullShareVersionCertificates: seconds(config.AnnounceFullShareVersionCertificatesPeriod, defaults.AnnounceFullShareVersionCertificatesPeriod),
+		PruneAnnounceDataStructures:  seconds(config.AnnouncePruneDataStructuresPeriod, defaults.AnnouncePruneDataStructuresPeriod),
+		UpdateAnnounceVersion:        seconds(config.AnnounceUpdateVersionPeriod, defaults.AnnounceUpdateVersionPeriod),
+		AggressiveQueryEnode:         seconds(config.AnnounceAggressiveQueryEnodeGossipPeriod, defaults.AnnounceAggressiveQueryEnodeGossipPeriod),
+		QueryEnode:                   seconds(config.AnnounceQueryEnodeGossipPeriod, defaults.AnnounceQueryEnodeGossipPeriod),
+		InitialQueryEnode:            initialQueryEnodeDelay(config),
+		ReconcilePeers:               time.Duration(config.AnnounceReconcilePeersPeriod) * time.Second,
+	}
+}
+
+// initialQueryEnodeDelay returns the configured delay of the first query enode message.
+// When it isn't set, networks with short epochs, which are test networks, use a much
+// shorter delay than the default, since their validator conn sets are refreshed quickly.
+func initialQueryEnodeDelay(config *istanbul.Config) time.Duration {
+	if config.AnnounceInitialQueryEnodeDelay > 0 {
+		return time.Duration(config.AnnounceInitialQueryEnodeDelay) * time.Second
+	}
+	if config.Epoch <= 10 {
+		return 5 * time.Second
+	}
+	return defaultInitialQueryEnodeDelay
+}
+
+// announceScheduler produces the events of the announce thread's periodic tasks on a single
+// channel, so that the announce thread only has to read from one source.
+// Like a time.Ticker, it drops events if the reader falls behind.
+type announceScheduler struct {
+	clock     mclock.Clock
+	intervals announceIntervals
+	events    chan announceTask
+
+	mu      sync.Mutex
+	timers  map[announceTask]mclock.Timer
+	periods map[announceTask]time.Duration
+	// Incremented whenever a task is (re)started or stopped, so that callbacks of replaced timers are ignored
+	generations map[announceTask]uint64
+}
+
+// newAnnounceScheduler creates an announce scheduler with no running tasks
+func newAnnounceScheduler(clock mclock.Clock, intervals announceIntervals) *announceScheduler {
+	return &announceScheduler{
+		clock:       clock,
+		intervals:   intervals,
+		events:      make(chan announceTask, 2*numAnnounceTasks),
+		timers:      make(map[announceTask]mclock.Timer),
+		periods:     make(map[announceTask]time.Duration),
+		generations: make(map[announceTask]uint64),
+	}
+}
+
+// Events returns the channel on which the events of all tasks are produced
+func (s *announceScheduler) Events() <-chan announceTask {
+	return s.events
+}
+
+// Start periodically produces events for the task, every interval.
+// If the task is already running, it's restarted with the new interval.
+func (s *announceScheduler) Start(task announceTask, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopLocked(task)
+	s.periods[task] = interval
+	s.scheduleLocked(task, interval, s.generations[task])
+}
+
+// StartOnce produces a single event for the task after the delay.
+// If the task is already running, it's replaced.
+func (s *announceScheduler) StartOnce(task announceTask, delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopLocked(task)
+	s.scheduleLocked(task, delay, s.generations[task])
+}
+
+// Interval returns the interval of a running periodic task, and 0 otherwise
+func (s *announceScheduler) Interval(task announceTask) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.periods[task]
+}
+
+// Running returns whether the task will produce any more events
+func (s *announceScheduler) Running(task announceTask) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.timers[task]
+	return ok
+}
+
+// RunningTasks returns the tasks that will produce any more events
+func (s *announceScheduler) RunningTasks() []announceTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := make([]announceTask, 0, len(s.timers))
+	for task := range s.timers {
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// Stop stops producing events for the task.  Events that were already produced
+// are not removed from the events channel.
+func (s *announceScheduler) Stop(task announceTask) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopLocked(task)
+}
+
+// StopAll stops producing events for all tasks
+func (s *announceScheduler) StopAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for task := range s.timers {
+		s.stopLocked(task)
+	}
+}
+
+func (s *announceScheduler) stopLocked(task announceTask) {
+	if timer, ok := s.timers[task]; ok {
+		timer.Stop()
+		delete(s.timers, task)
+	}
+	delete(s.periods, task)
+	s.generations[task]++
+}
+
+func (s *announceScheduler) scheduleLocked(task announceTask, delay time.Duration, generation uint64) {
+	s.timers[task] = s.clock.AfterFunc(delay, func() {
+		s.mu.Lock()
+		if s.generations[task] != generation {
+			// The task was stopped or restarted after this timer was scheduled
+			s.mu.Unlock()
+			return
+		}
+		if period, ok := s.periods[task]; ok {
+			s.scheduleLocked(task, period, generation)
+		} else {
+			delete(s.timers, task)
+		}
+		s.mu.Unlock()
+
+		select {
+		case s.events <- task:
+		default:
+		}
+	})
+}
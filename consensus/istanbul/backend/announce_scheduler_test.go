@@ -0,0 +1,156 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common/mclock"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+)
+
+// drainAnnounceTasks returns the events that are currently queued in the scheduler
+func drainAnnounceTasks(scheduler *announceScheduler) []announceTask {
+	var tasks []announceTask
+	for {
+		select {
+		case task := <-scheduler.Events():
+			tasks = append(tasks, task)
+		default:
+			return tasks
+		}
+	}
+}
+
+func TestAnnounceSchedulerPeriodicTasks(t *testing.T) {
+	clock := &mclock.Simulated{}
+	scheduler := newAnnounceScheduler(clock, announceIntervals{})
+	defer scheduler.StopAll()
+
+	scheduler.Start(checkIfShouldAnnounceTask, 5*time.Second)
+	scheduler.Start(pruneAnnounceDataStructuresTask, 10*time.Second)
+
+	clock.Run(4 * time.Second)
+	if tasks := drainAnnounceTasks(scheduler); len(tasks) != 0 {
+		t.Errorf("Unexpected events before the first interval elapsed: %v", tasks)
+	}
+
+	// Both tasks' events are produced on the same channel
+	clock.Run(1 * time.Second)
+	if tasks, want := drainAnnounceTasks(scheduler), []announceTask{checkIfShouldAnnounceTask}; !reflect.DeepEqual(tasks, want) {
+		t.Errorf("Incorrect events.  Want: %v, Have: %v", want, tasks)
+	}
+	clock.Run(5 * time.Second)
+	tasks := drainAnnounceTasks(scheduler)
+	if len(tasks) != 2 {
+		t.Errorf("Incorrect number of events.  Want: 2, Have: %v", tasks)
+	}
+
+	// Restarting a task changes its interval
+	scheduler.Start(checkIfShouldAnnounceTask, 20*time.Second)
+	if interval := scheduler.Interval(checkIfShouldAnnounceTask); interval != 20*time.Second {
+		t.Errorf("Incorrect interval.  Want: %v, Have: %v", 20*time.Second, interval)
+	}
+	clock.Run(10 * time.Second)
+	if tasks, want := drainAnnounceTasks(scheduler), []announceTask{pruneAnnounceDataStructuresTask}; !reflect.DeepEqual(tasks, want) {
+		t.Errorf("Incorrect events.  Want: %v, Have: %v", want, tasks)
+	}
+
+	// A stopped task produces no more events
+	scheduler.Stop(pruneAnnounceDataStructuresTask)
+	if scheduler.Running(pruneAnnounceDataStructuresTask) {
+		t.Errorf("Stopped task is still running")
+	}
+	clock.Run(10 * time.Second)
+	if tasks, want := drainAnnounceTasks(scheduler), []announceTask{checkIfShouldAnnounceTask}; !reflect.DeepEqual(tasks, want) {
+		t.Errorf("Incorrect events.  Want: %v, Have: %v", want, tasks)
+	}
+}
+
+func TestAnnounceSchedulerStartOnce(t *testing.T) {
+	clock := &mclock.Simulated{}
+	scheduler := newAnnounceScheduler(clock, announceIntervals{})
+	defer scheduler.StopAll()
+
+	scheduler.StartOnce(initialQueryEnodeTask, time.Minute)
+	if !scheduler.Running(initialQueryEnodeTask) {
+		t.Errorf("Task should be running")
+	}
+
+	clock.Run(time.Minute)
+	if tasks, want := drainAnnounceTasks(scheduler), []announceTask{initialQueryEnodeTask}; !reflect.DeepEqual(tasks, want) {
+		t.Errorf("Incorrect events.  Want: %v, Have: %v", want, tasks)
+	}
+	if scheduler.Running(initialQueryEnodeTask) {
+		t.Errorf("Task should not be running after its only event")
+	}
+
+	clock.Run(time.Minute)
+	if tasks := drainAnnounceTasks(scheduler); len(tasks) != 0 {
+		t.Errorf("Unexpected events: %v", tasks)
+	}
+}
+
+func TestAnnounceSchedulerStopAll(t *testing.T) {
+	clock := &mclock.Simulated{}
+	scheduler := newAnnounceScheduler(clock, announceIntervals{})
+
+	scheduler.Start(queryEnodeTask, time.Second)
+	scheduler.Start(updateAnnounceVersionTask, time.Second)
+	scheduler.StopAll()
+
+	if n := clock.ActiveTimers(); n != 0 {
+		t.Errorf("Incorrect number of active timers.  Want: 0, Have: %d", n)
+	}
+	clock.Run(time.Second)
+	if tasks := drainAnnounceTasks(scheduler); len(tasks) != 0 {
+		t.Errorf("Unexpected events: %v", tasks)
+	}
+}
+
+func TestAnnounceIntervalsFromConfig(t *testing.T) {
+	// The default intervals are the announce thread's original ones
+	want := announceIntervals{
+		CheckIfShouldAnnounce:        5 * time.Second,
+		ShareVersionCertificates:     5 * time.Minute,
+		FullShareVersionCertificates: 30 * time.Minute,
+		PruneAnnounceDataStructures:  10 * time.Minute,
+		UpdateAnnounceVersion:        5 * time.Minute,
+		AggressiveQueryEnode:         1 * time.Minute,
+		QueryEnode:                   5 * time.Minute,
+		InitialQueryEnode:            1 * time.Minute,
+	}
+	if intervals := announceIntervalsFromConfig(istanbul.DefaultConfig); intervals != want {
+		t.Errorf("Incorrect default intervals.  Want: %+v, Have: %+v", want, intervals)
+	}
+
+	// Intervals that aren't set fall back to the defaults
+	config := &istanbul.Config{AnnouncePruneDataStructuresPeriod: 30, Epoch: istanbul.DefaultConfig.Epoch}
+	want.PruneAnnounceDataStructures = 30 * time.Second
+	if intervals := announceIntervalsFromConfig(config); intervals != want {
+		t.Errorf("Incorrect intervals.  Want: %+v, Have: %+v", want, intervals)
+	}
+}
+
+func TestInitialQueryEnodeDelay(t *testing.T) {
+	// Test networks with short epochs use a shorter delay when it isn't configured
+	if delay := initialQueryEnodeDelay(&istanbul.Config{Epoch: 10}); delay != 5*time.Second {
+		t.Errorf("Incorrect delay for a short epoch.  Want: %v, Have: %v", 5*time.Second, delay)
+	}
+
+	config := &istanbul.Config{Epoch: istanbul.DefaultConfig.Epoch, AnnounceInitialQueryEnodeDelay: 20}
+	clock := &mclock.Simulated{}
+	scheduler := newAnnounceScheduler(clock, announceIntervalsFromConfig(config))
+	defer scheduler.StopAll()
+
+	// The first query enode message is gossiped after the configured delay
+	scheduler.StartOnce(initialQueryEnodeTask, scheduler.intervals.InitialQueryEnode)
+	clock.Run(20*time.Second - 1)
+	if tasks := drainAnnounceTasks(scheduler); len(tasks) != 0 {
+		t.Errorf("Unexpected events before the initial delay: %v", tasks)
+	}
+	clock.Run(1)
+	if tasks, want := drainAnnounceTasks(scheduler), []announceTask{initialQueryEnodeTask}; !reflect.DeepEqual(tasks, want) {
+		t.Errorf("Incorrect events.  Want: %v, Have: %v", want, tasks)
+	}
+}
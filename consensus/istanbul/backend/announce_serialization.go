@@ -0,0 +1,171 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul/announcepb"
+	"github.com/celo-org/celo-blockchain/rlp"
+	"github.com/golang/protobuf/proto"
+)
+
+// The protobuf encodings of the announce data, see istanbul.ProtoMessage, are the ones of the
+// messages of the announcepb schema.
+
+func (ee *encryptedEnodeURL) toProto() *announcepb.EncryptedEnodeURL {
+	return &announcepb.EncryptedEnodeURL{
+		DestAddress:       ee.DestAddress.Bytes(),
+		EncryptedEnodeUrl: ee.EncryptedEnodeURL,
+		Plaintext:         ee.Plaintext,
+	}
+}
+
+func encryptedEnodeURLFromProto(pb *announcepb.EncryptedEnodeURL) (*encryptedEnodeURL, error) {
+	// A missing destination address is the zero address, like an omitted proto3 field
+	if len(pb.DestAddress) != 0 && len(pb.DestAddress) != common.AddressLength {
+		return nil, fmt.Errorf("invalid destination address length %d", len(pb.DestAddress))
+	}
+	return &encryptedEnodeURL{
+		DestAddress:       common.BytesToAddress(pb.DestAddress),
+		EncryptedEnodeURL: pb.EncryptedEnodeUrl,
+		Plaintext:         pb.Plaintext,
+	}, nil
+}
+
+// MarshalProto implements istanbul.ProtoMessage.MarshalProto, with the announcepb.EncryptedEnodeURL encoding
+func (ee *encryptedEnodeURL) MarshalProto() ([]byte, error) {
+	return proto.Marshal(ee.toProto())
+}
+
+// UnmarshalProto implements istanbul.ProtoMessage.UnmarshalProto
+func (ee *encryptedEnodeURL) UnmarshalProto(data []byte) error {
+	var pb announcepb.EncryptedEnodeURL
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return err
+	}
+	decoded, err := encryptedEnodeURLFromProto(&pb)
+	if err != nil {
+		return err
+	}
+	*ee = *decoded
+	return nil
+}
+
+// MarshalProto implements istanbul.ProtoMessage.MarshalProto, with the announcepb.QueryEnodeData encoding
+func (qed *queryEnodeData) MarshalProto() ([]byte, error) {
+	pb := &announcepb.QueryEnodeData{
+		Version:   uint64(qed.Version),
+		Timestamp: uint64(qed.Timestamp),
+		NodeTag:   qed.NodeTag,
+	}
+	for _, encryptedEnodeURL := range qed.EncryptedEnodeURLs {
+		pb.EncryptedEnodeUrls = append(pb.EncryptedEnodeUrls, encryptedEnodeURL.toProto())
+	}
+	return proto.Marshal(pb)
+}
+
+// UnmarshalProto implements istanbul.ProtoMessage.UnmarshalProto
+func (qed *queryEnodeData) UnmarshalProto(data []byte) error {
+	var pb announcepb.QueryEnodeData
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return err
+	}
+	decoded := queryEnodeData{
+		Version:   uint(pb.Version),
+		Timestamp: uint(pb.Timestamp),
+		NodeTag:   istanbul.SanitizeNodeTag(pb.NodeTag),
+	}
+	for _, encryptedEnodeURLPb := range pb.EncryptedEnodeUrls {
+		encryptedEnodeURL, err := encryptedEnodeURLFromProto(encryptedEnodeURLPb)
+		if err != nil {
+			return err
+		}
+		decoded.EncryptedEnodeURLs = append(decoded.EncryptedEnodeURLs, encryptedEnodeURL)
+	}
+	*qed = decoded
+	return nil
+}
+
+// The public key and address of a version certificate aren't encoded, since they're recovered
+// from the signature, like with RLP.
+func (vc *versionCertificate) toProto() *announcepb.VersionCertificate {
+	return &announcepb.VersionCertificate{
+		Version:   uint64(vc.Version),
+		Signature: vc.Signature,
+	}
+}
+
+func versionCertificateFromProto(pb *announcepb.VersionCertificate) *versionCertificate {
+	return &versionCertificate{
+		Version:   uint(pb.Version),
+		Signature: pb.Signature,
+	}
+}
+
+// MarshalProto implements istanbul.ProtoMessage.MarshalProto, with the announcepb.VersionCertificate encoding
+func (vc *versionCertificate) MarshalProto() ([]byte, error) {
+	return proto.Marshal(vc.toProto())
+}
+
+// UnmarshalProto implements istanbul.ProtoMessage.UnmarshalProto
+func (vc *versionCertificate) UnmarshalProto(data []byte) error {
+	var pb announcepb.VersionCertificate
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return err
+	}
+	*vc = *versionCertificateFromProto(&pb)
+	return nil
+}
+
+// versionCertificateList is the content of a version certificates message
+type versionCertificateList []*versionCertificate
+
+// EncodeRLP implements rlp.Encoder, with the encoding of a plain slice of version certificates
+func (l *versionCertificateList) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, []*versionCertificate(*l))
+}
+
+// DecodeRLP implements rlp.Decoder
+func (l *versionCertificateList) DecodeRLP(s *rlp.Stream) error {
+	return s.Decode((*[]*versionCertificate)(l))
+}
+
+// MarshalProto implements istanbul.ProtoMessage.MarshalProto, with the announcepb.VersionCertificates encoding
+func (l *versionCertificateList) MarshalProto() ([]byte, error) {
+	pb := &announcepb.VersionCertificates{}
+	for _, versionCertificate := range *l {
+		pb.VersionCertificates = append(pb.VersionCertificates, versionCertificate.toProto())
+	}
+	return proto.Marshal(pb)
+}
+
+// UnmarshalProto implements istanbul.ProtoMessage.UnmarshalProto
+func (l *versionCertificateList) UnmarshalProto(data []byte) error {
+	var pb announcepb.VersionCertificates
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return err
+	}
+	*l = nil
+	for _, versionCertificatePb := range pb.VersionCertificates {
+		*l = append(*l, versionCertificateFromProto(versionCertificatePb))
+	}
+	return nil
+}
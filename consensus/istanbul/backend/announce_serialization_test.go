@@ -0,0 +1,124 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	"github.com/celo-org/celo-blockchain/crypto"
+)
+
+var wireFormats = []istanbul.WireFormat{istanbul.RLPWireFormat, istanbul.ProtobufWireFormat}
+
+func TestQueryEnodeDataSerializationRoundTrip(t *testing.T) {
+	for _, qed := range []*queryEnodeData{
+		{
+			EncryptedEnodeURLs: []*encryptedEnodeURL{
+				{DestAddress: common.HexToAddress("0x1"), EncryptedEnodeURL: []byte("ciphertext")},
+				{DestAddress: common.HexToAddress("0x2"), EncryptedEnodeURL: []byte("enode://abc@127.0.0.1:30303"), Plaintext: true},
+			},
+			Version:   1600000000,
+			Timestamp: 1600000001,
+			NodeTag:   "validator-1",
+		},
+		{EncryptedEnodeURLs: []*encryptedEnodeURL{{DestAddress: common.HexToAddress("0x1"), EncryptedEnodeURL: []byte("ciphertext")}}, Version: 1, Timestamp: 2},
+	} {
+		for _, wireFormat := range wireFormats {
+			encoded, err := istanbul.EncodeAnnounceData(wireFormat, qed)
+			if err != nil {
+				t.Fatalf("Error in encoding the query enode data.  Wire format: %v, Error: %v", wireFormat, err)
+			}
+			var decoded queryEnodeData
+			if err := istanbul.DecodeAnnounceData(encoded, &decoded); err != nil {
+				t.Fatalf("Error in decoding the query enode data.  Wire format: %v, Error: %v", wireFormat, err)
+			}
+			// Only the protobuf wire format carries the node tag
+			want := *qed
+			if wireFormat == istanbul.RLPWireFormat {
+				want.NodeTag = ""
+			}
+			if !reflect.DeepEqual(&want, &decoded) {
+				t.Errorf("Incorrect decoded query enode data.  Wire format: %v, Want: %v, Have: %v", wireFormat, &want, &decoded)
+			}
+		}
+	}
+}
+
+func TestVersionCertificatesSerializationRoundTrip(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	var versionCertificates versionCertificateList
+	for _, version := range []uint{1, 1600000000} {
+		vc := &versionCertificate{Version: version}
+		if err := vc.Sign(func(data []byte) ([]byte, error) { return crypto.Sign(crypto.Keccak256(data), key) }); err != nil {
+			t.Fatalf("Error in signing version certificate.  Error: %v", err)
+		}
+		versionCertificates = append(versionCertificates, vc)
+	}
+
+	for _, wireFormat := range wireFormats {
+		encoded, err := istanbul.EncodeAnnounceData(wireFormat, &versionCertificates)
+		if err != nil {
+			t.Fatalf("Error in encoding the version certificates.  Wire format: %v, Error: %v", wireFormat, err)
+		}
+		var decoded versionCertificateList
+		if err := istanbul.DecodeAnnounceData(encoded, &decoded); err != nil {
+			t.Fatalf("Error in decoding the version certificates.  Wire format: %v, Error: %v", wireFormat, err)
+		}
+		if !reflect.DeepEqual(versionCertificates, decoded) {
+			t.Errorf("Incorrect decoded version certificates.  Wire format: %v, Want: %v, Have: %v", wireFormat, versionCertificates, decoded)
+		}
+		// The signer is recovered from the decoded signature, like with RLP
+		for _, vc := range decoded {
+			if err := vc.RecoverPublicKeyAndAddress(); err != nil || vc.Address != crypto.PubkeyToAddress(key.PublicKey) {
+				t.Errorf("Incorrect recovered address.  Want: %v, Have: %v, err: %v", crypto.PubkeyToAddress(key.PublicKey), vc.Address, err)
+			}
+		}
+	}
+}
+
+func TestHandleProtobufEnodeCertificateMsg(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	// An enode certificate sent in the protobuf wire format is decoded by a node using RLP
+	engine0.config.AnnounceWireFormat = istanbul.ProtobufWireFormat
+	version := getTimestamp()
+	enodeCertMsgs, _, err := engine0.generateEnodeCertificateMsgs(version)
+	if err != nil {
+		t.Fatalf("Error in generating enode certificate messages.  Error: %v", err)
+	}
+	payload, err := enodeCertMsgs[engine0.SelfNode().ID()].Msg.Payload()
+	if err != nil {
+		t.Fatalf("Error in encoding the enode certificate message.  Error: %v", err)
+	}
+	if err := engine1.handleEnodeCertificateMsg(nil, payload); err != nil {
+		t.Fatalf("Error in handling a protobuf enode certificate message.  Error: %v", err)
+	}
+	entries, err := engine1.GetValEnodeTableEntries([]common.Address{engine0.Address()})
+	if err != nil || entries[engine0.Address()] == nil {
+		t.Fatalf("Missing val enode table entry.  err: %v", err)
+	}
+	if have := entries[engine0.Address()].Version; have != version {
+		t.Errorf("Incorrect val enode table entry version.  Want: %d, Have: %d", version, have)
+	}
+}
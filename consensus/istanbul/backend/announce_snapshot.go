@@ -0,0 +1,255 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/consensus"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	vet "github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/enodes"
+	"github.com/celo-org/celo-blockchain/p2p"
+	"github.com/celo-org/celo-blockchain/p2p/enode"
+	"github.com/celo-org/celo-blockchain/rlp"
+)
+
+var (
+	// errUntrustedAnnounceSnapshotPeer is returned when an announce snapshot is requested from or
+	// by a peer that is not an explicitly trusted peer
+	errUntrustedAnnounceSnapshotPeer = errors.New("announce snapshots are only exchanged with trusted peers")
+
+	// errAnnounceSnapshotNotSupported is returned when an announce snapshot is requested from
+	// a peer whose protocol version doesn't support it
+	errAnnounceSnapshotNotSupported = errors.New("peer does not support announce snapshots")
+
+	// errAnnounceSnapshotSignerMismatch is returned when an announce snapshot isn't signed by
+	// the validator of the peer that sent it
+	errAnnounceSnapshotSignerMismatch = errors.New("announce snapshot not signed by the sending peer's validator")
+)
+
+// announceSnapshot is a copy of a node's announce state, used to bootstrap the
+// announce state of a new validator from a trusted peer.
+// The version certificates are signed by their validators and are re-verified by the
+// recipient.  The enode URLs aren't signed, and are only accepted from trusted peers.
+// The val enode entries include one for the snapshot's signer, which binds the signer's
+// validator address to the node of the peer sending the snapshot.
+type announceSnapshot struct {
+	VersionCertificates []*versionCertificate
+	ValEnodeEntries     []*announceSnapshotEntry
+}
+
+// announceSnapshotEntry is a val enode table entry within an announce snapshot
+type announceSnapshotEntry struct {
+	Address  common.Address
+	EnodeURL string
+	Version  uint
+}
+
+// isTrustedAnnounceSnapshotPeer returns whether announce snapshots can be exchanged with the peer
+func isTrustedAnnounceSnapshotPeer(peer consensus.Peer) bool {
+	return peer.PurposeIsSet(p2p.ExplicitTrustedPurpose)
+}
+
+// RequestAnnounceSnapshot requests a snapshot of the announce state of a trusted peer.
+// The peer will respond with an announce snapshot message, which is handled by
+// handleAnnounceSnapshotMsg.
+func (sb *Backend) RequestAnnounceSnapshot(peer consensus.Peer) error {
+	if !isTrustedAnnounceSnapshotPeer(peer) {
+		return errUntrustedAnnounceSnapshotPeer
+	}
+	if !istanbul.SupportsAnnounceSnapshot(peer.Version()) {
+		return errAnnounceSnapshotNotSupported
+	}
+	return peer.Send(istanbul.AnnounceSnapshotRequestMsg, []byte{})
+}
+
+// generateAnnounceSnapshotMsg creates a signed announce snapshot message of this
+// node's version certificate table and val enode table
+func (sb *Backend) generateAnnounceSnapshotMsg() (*istanbul.Message, error) {
+	versionCertificates, err := sb.getAllVersionCertificates()
+	if err != nil {
+		return nil, err
+	}
+
+	valEnodes, err := sb.valEnodeTable.GetValEnodes(nil)
+	if err != nil {
+		return nil, err
+	}
+	valEnodeEntries := make([]*announceSnapshotEntry, 0, len(valEnodes))
+	for address, entry := range valEnodes {
+		if entry.Node == nil {
+			continue
+		}
+		valEnodeEntries = append(valEnodeEntries, &announceSnapshotEntry{Address: address, EnodeURL: entry.Node.URLv4(), Version: entry.Version})
+	}
+	valEnodeEntries = append(valEnodeEntries, &announceSnapshotEntry{Address: sb.Address(), EnodeURL: sb.SelfNode().URLv4(), Version: sb.GetAnnounceVersion()})
+
+	snapshotBytes, err := rlp.EncodeToBytes(&announceSnapshot{
+		VersionCertificates: versionCertificates,
+		ValEnodeEntries:     valEnodeEntries,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &istanbul.Message{
+		Code:    istanbul.AnnounceSnapshotMsg,
+		Address: sb.Address(),
+		Msg:     snapshotBytes,
+	}
+	if err := msg.Sign(sb.Sign); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// handleAnnounceSnapshotRequestMsg responds to an announce snapshot request from a trusted peer
+func (sb *Backend) handleAnnounceSnapshotRequestMsg(peer consensus.Peer) error {
+	logger := sb.logger.New("func", "handleAnnounceSnapshotRequestMsg", "peer", peer)
+
+	// The snapshot contains the enode URLs of validators, which must not be shared with anyone
+	if !isTrustedAnnounceSnapshotPeer(peer) {
+		logger.Debug("Ignoring announce snapshot request from an untrusted peer")
+		return errUntrustedAnnounceSnapshotPeer
+	}
+
+	msg, err := sb.generateAnnounceSnapshotMsg()
+	if err != nil {
+		logger.Warn("Error in generating announce snapshot message", "err", err)
+		return err
+	}
+	payload, err := msg.Payload()
+	if err != nil {
+		logger.Warn("Error getting payload of announce snapshot message", "err", err)
+		return err
+	}
+
+	sb.Unicast(peer, payload, istanbul.AnnounceSnapshotMsg)
+	return nil
+}
+
+// isAnnounceSnapshotSignerNode returns whether the snapshot's entry for its signer is for the node
+func isAnnounceSnapshotSignerNode(snapshot *announceSnapshot, signer common.Address, node *enode.Node) bool {
+	for _, entry := range snapshot.ValEnodeEntries {
+		if entry.Address != signer {
+			continue
+		}
+		signerNode, err := enode.ParseV4(entry.EnodeURL)
+		return err == nil && signerNode.ID() == node.ID()
+	}
+	return false
+}
+
+// handleAnnounceSnapshotMsg verifies an announce snapshot received from a trusted peer
+// and upserts its content into this node's version certificate and val enode tables.
+func (sb *Backend) handleAnnounceSnapshotMsg(peer consensus.Peer, payload []byte) error {
+	logger := sb.logger.New("func", "handleAnnounceSnapshotMsg", "peer", peer)
+
+	if !isTrustedAnnounceSnapshotPeer(peer) {
+		logger.Warn("Ignoring announce snapshot from an untrusted peer")
+		return errUntrustedAnnounceSnapshotPeer
+	}
+
+	var msg istanbul.Message
+	if err := msg.FromPayload(payload, istanbul.GetCanonicalSignatureAddress); err != nil {
+		logger.Error("Error in decoding received announce snapshot message", "err", err, "payload", hex.EncodeToString(payload))
+		return err
+	}
+	logger = logger.New("msg address", msg.Address)
+
+	var snapshot announceSnapshot
+	if err := rlp.DecodeBytes(msg.Msg, &snapshot); err != nil {
+		logger.Warn("Error in decoding received announce snapshot message content", "err", err)
+		return err
+	}
+
+	// The snapshot's enode urls are trusted because of the peer, so it must be signed by the
+	// peer's validator.  The signer's own entry is signed with the rest of the snapshot, and
+	// must be for the peer's node.
+	if !isAnnounceSnapshotSignerNode(&snapshot, msg.Address, peer.Node()) {
+		logger.Warn("Ignoring announce snapshot not signed by the sending peer's validator")
+		return errAnnounceSnapshotSignerMismatch
+	}
+
+	validatorConnSet, err := sb.RetrieveValidatorConnSet()
+	if err != nil {
+		logger.Trace("Error in retrieving validator conn set", "err", err)
+		return err
+	}
+
+	// Re-verify the version certificates, as is done for gossiped ones
+	var versionCertificateEntries []*vet.VersionCertificateEntry
+	verifiedVersions := make(map[common.Address]uint)
+	for _, versionCertificate := range snapshot.VersionCertificates {
+		if err := versionCertificate.RecoverPublicKeyAndAddress(); err != nil {
+			logger.Warn("Error recovering version certificate public key and address from signature", "err", err)
+			continue
+		}
+		if !validatorConnSet[versionCertificate.Address] {
+			logger.Debug("Found version certificate from an address not in the validator conn set", "address", versionCertificate.Address)
+			continue
+		}
+		if _, ok := verifiedVersions[versionCertificate.Address]; ok {
+			logger.Debug("Found duplicate version certificate in announce snapshot", "address", versionCertificate.Address)
+			continue
+		}
+		verifiedVersions[versionCertificate.Address] = versionCertificate.Version
+		versionCertificateEntries = append(versionCertificateEntries, versionCertificate.Entry())
+	}
+	if err := sb.upsertAndGossipVersionCertificateEntries(versionCertificateEntries, true); err != nil {
+		logger.Warn("Error upserting and gossiping version certificate entries", "err", err)
+		return err
+	}
+
+	// Ensure this node is a validator in the validator conn set
+	shouldSave, err := sb.shouldParticipateInAnnounce()
+	if err != nil {
+		logger.Error("Error checking if should save received validator enode urls", "err", err)
+		return err
+	}
+	if !shouldSave {
+		logger.Debug("This node should not save validator enode urls, ignoring the announce snapshot's enode urls")
+		return nil
+	}
+
+	// Only accept enode URLs whose version is covered by a verified version certificate
+	var valEnodeEntries []*istanbul.AddressEntry
+	for _, entry := range snapshot.ValEnodeEntries {
+		if entry.Address == sb.Address() {
+			continue
+		}
+		if verifiedVersion, ok := verifiedVersions[entry.Address]; !ok || entry.Version > verifiedVersion {
+			logger.Debug("Found announce snapshot enode url without a matching version certificate", "address", entry.Address, "version", entry.Version)
+			continue
+		}
+		node, err := enode.ParseV4(entry.EnodeURL)
+		if err != nil {
+			logger.Warn("Malformed v4 node in announce snapshot", "address", entry.Address, "err", err)
+			continue
+		}
+		valEnodeEntries = append(valEnodeEntries, &istanbul.AddressEntry{Address: entry.Address, Node: node, Version: entry.Version})
+	}
+	if err := sb.valEnodeTable.UpsertVersionAndEnode(valEnodeEntries); err != nil {
+		logger.Warn("Error in upserting val enode table entries", "err", err)
+		return err
+	}
+	logger.Debug("Bootstrapped announce state from snapshot", "versionCertificates", len(versionCertificateEntries), "valEnodes", len(valEnodeEntries))
+
+	return nil
+}
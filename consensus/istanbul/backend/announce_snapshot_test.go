@@ -0,0 +1,158 @@
+package backend
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/consensus/consensustest"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	vet "github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/enodes"
+	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/celo-org/celo-blockchain/p2p"
+	"github.com/celo-org/celo-blockchain/p2p/enode"
+	"github.com/celo-org/celo-blockchain/rlp"
+)
+
+func TestAnnounceSnapshotBootstrap(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(3, true)
+	_, server, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer server.StopAnnouncing()
+	// The server's node key differs from its validator signer key, as in real deployments
+	serverNodeKey, _ := crypto.GenerateKey()
+	server.SetP2PServer(consensustest.NewMockP2PServer(&serverNodeKey.PublicKey))
+	_, client, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer client.StopAnnouncing()
+
+	signFn := func(data []byte) ([]byte, error) {
+		return crypto.Sign(crypto.Keccak256(data), nodeKeys[2])
+	}
+	remoteAddress := crypto.PubkeyToAddress(nodeKeys[2].PublicKey)
+	remoteNode := enode.NewV4(&nodeKeys[2].PublicKey, net.ParseIP("127.0.0.1"), 30303, 30303)
+	remoteVersion := getTimestamp()
+
+	// The server knows the remote validator's version certificate and enode
+	vc := &versionCertificate{Version: remoteVersion}
+	if err := vc.Sign(signFn); err != nil {
+		t.Fatalf("Error in signing version certificate.  Error: %v", err)
+	}
+	if err := vc.RecoverPublicKeyAndAddress(); err != nil {
+		t.Fatalf("Error in recovering version certificate address.  Error: %v", err)
+	}
+	if _, err := server.versionCertificateTable.Upsert([]*vet.VersionCertificateEntry{vc.Entry()}); err != nil {
+		t.Fatalf("Error in upserting version certificate.  Error: %v", err)
+	}
+	if err := server.valEnodeTable.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: remoteAddress, Node: remoteNode, Version: remoteVersion}}); err != nil {
+		t.Fatalf("Error in upserting val enode entry.  Error: %v", err)
+	}
+
+	// Snapshots are only requested from trusted peers that support them
+	if err := client.RequestAnnounceSnapshot(newVersionedMockPeerWithPurpose(istanbul.Celo67, p2p.ValidatorPurpose)); err != errUntrustedAnnounceSnapshotPeer {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errUntrustedAnnounceSnapshotPeer, err)
+	}
+	if err := client.RequestAnnounceSnapshot(newVersionedMockPeerWithPurpose(istanbul.Celo66, p2p.ExplicitTrustedPurpose)); err != errAnnounceSnapshotNotSupported {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errAnnounceSnapshotNotSupported, err)
+	}
+
+	// The client requests the snapshot from the server
+	serverPeer := newVersionedMockPeerWithKey(istanbul.Celo67, p2p.ExplicitTrustedPurpose, serverNodeKey)
+	if err := client.RequestAnnounceSnapshot(serverPeer); err != nil {
+		t.Fatalf("Error in requesting announce snapshot.  Error: %v", err)
+	}
+	request := serverPeer.waitForSend(t)
+
+	// The server responds to the request
+	clientPeer := newVersionedMockPeerWithPurpose(istanbul.Celo67, p2p.ExplicitTrustedPurpose)
+	if _, err := server.HandleMsg(client.Address(), makeMsg(istanbul.AnnounceSnapshotRequestMsg, request), clientPeer); err != nil {
+		t.Fatalf("Error in handling announce snapshot request.  Error: %v", err)
+	}
+	response := clientPeer.waitForSend(t)
+
+	// A snapshot from an untrusted peer is rejected
+	untrustedPeer := newVersionedMockPeerWithPurpose(istanbul.Celo67, p2p.ValidatorPurpose)
+	payload, err := decompressAnnouncePayload(untrustedPeer, istanbul.AnnounceSnapshotMsg, response)
+	if err != nil {
+		t.Fatalf("Error in decompressing announce snapshot.  Error: %v", err)
+	}
+	if err := client.handleAnnounceSnapshotMsg(untrustedPeer, payload); err != errUntrustedAnnounceSnapshotPeer {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errUntrustedAnnounceSnapshotPeer, err)
+	}
+	if _, err := client.versionCertificateTable.Get(remoteAddress); err == nil {
+		t.Errorf("Version certificate from an untrusted snapshot was saved")
+	}
+
+	// The client bootstraps its announce state from the server's snapshot
+	if _, err := client.HandleMsg(server.Address(), makeMsg(istanbul.AnnounceSnapshotMsg, response), serverPeer); err != nil {
+		t.Fatalf("Error in handling announce snapshot.  Error: %v", err)
+	}
+	var node *enode.Node
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(100 * time.Millisecond) {
+		if node, err = client.valEnodeTable.GetNodeFromAddress(remoteAddress); err == nil && node != nil {
+			break
+		}
+	}
+	if node == nil || node.URLv4() != remoteNode.URLv4() {
+		t.Errorf("Incorrect bootstrapped enode.  Want: %v, Have: %v", remoteNode, node)
+	}
+	if version, err := client.versionCertificateTable.GetVersion(remoteAddress); err != nil || version != remoteVersion {
+		t.Errorf("Incorrect bootstrapped version certificate.  Want: %d, Have: %d, err: %v", remoteVersion, version, err)
+	}
+
+	// A snapshot relayed by another trusted peer than the signer's node is rejected
+	otherPeer := newVersionedMockPeerWithPurpose(istanbul.Celo67, p2p.ExplicitTrustedPurpose)
+	if err := client.handleAnnounceSnapshotMsg(otherPeer, payload); err != errAnnounceSnapshotSignerMismatch {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errAnnounceSnapshotSignerMismatch, err)
+	}
+}
+
+func TestAnnounceSnapshotRejectsUnverifiedEntries(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(3, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	remoteAddress := crypto.PubkeyToAddress(nodeKeys[2].PublicKey)
+	remoteNode := enode.NewV4(&nodeKeys[2].PublicKey, net.ParseIP("127.0.0.1"), 30303, 30303)
+
+	// A snapshot signed by a trusted peer's validator, with an enode url that isn't covered by a version certificate
+	snapshotPeer := newVersionedMockPeerWithPurpose(istanbul.Celo67, p2p.ExplicitTrustedPurpose)
+	snapshotBytes, err := rlp.EncodeToBytes(&announceSnapshot{
+		ValEnodeEntries: []*announceSnapshotEntry{
+			{Address: remoteAddress, EnodeURL: remoteNode.URLv4(), Version: getTimestamp()},
+			{Address: engine.Address(), EnodeURL: snapshotPeer.Node().URLv4(), Version: getTimestamp()},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error in encoding announce snapshot.  Error: %v", err)
+	}
+	msg := &istanbul.Message{Code: istanbul.AnnounceSnapshotMsg, Address: engine.Address(), Msg: snapshotBytes}
+	if err := msg.Sign(engine.Sign); err != nil {
+		t.Fatalf("Error in signing announce snapshot.  Error: %v", err)
+	}
+	payload, _ := msg.Payload()
+
+	// A snapshot sent by another trusted peer than its signer's node is rejected
+	otherPeer := newVersionedMockPeerWithPurpose(istanbul.Celo67, p2p.ExplicitTrustedPurpose)
+	if err := engine.handleAnnounceSnapshotMsg(otherPeer, payload); err != errAnnounceSnapshotSignerMismatch {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errAnnounceSnapshotSignerMismatch, err)
+	}
+
+	if err := engine.handleAnnounceSnapshotMsg(snapshotPeer, payload); err != nil {
+		t.Fatalf("Error in handling announce snapshot.  Error: %v", err)
+	}
+	if _, err := engine.valEnodeTable.GetNodeFromAddress(remoteAddress); err == nil {
+		t.Errorf("Enode url without a verified version certificate was saved")
+	}
+}
+
+func TestProxyIgnoresAnnounceSnapshotMsgs(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, proxy, _ := newBlockChainWithKeys(true, crypto.PubkeyToAddress(nodeKeys[1].PublicKey), false, genesisCfg, nodeKeys[0])
+
+	peer := newVersionedMockPeerWithPurpose(istanbul.Celo67, p2p.ExplicitTrustedPurpose)
+	for _, code := range []uint64{istanbul.AnnounceSnapshotRequestMsg, istanbul.AnnounceSnapshotMsg} {
+		if handled, err := proxy.HandleMsg(common.Address{}, makeMsg(code, []byte{}), peer); !handled || err != nil {
+			t.Errorf("Announce snapshot message should be handled by a proxy.  Code: %d, Have: %v, err: %v", code, handled, err)
+		}
+	}
+}
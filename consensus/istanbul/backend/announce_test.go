@@ -1,11 +1,35 @@
 package backend
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"net"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/celo-org/celo-blockchain/accounts"
 	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/common/mclock"
+	"github.com/celo-org/celo-blockchain/consensus"
+	"github.com/celo-org/celo-blockchain/consensus/consensustest"
 	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	enodesdb "github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/db"
+	vet "github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/enodes"
+	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/celo-blockchain/crypto"
+	blscrypto "github.com/celo-org/celo-blockchain/crypto/bls"
+	"github.com/celo-org/celo-blockchain/crypto/ecies"
+	"github.com/celo-org/celo-blockchain/log"
+	"github.com/celo-org/celo-blockchain/metrics"
+	"github.com/celo-org/celo-blockchain/p2p"
+	"github.com/celo-org/celo-blockchain/p2p/enode"
 	"github.com/celo-org/celo-blockchain/rlp"
 )
 
@@ -251,3 +275,3433 @@ func TestSetAndShareUpdatedAnnounceVersion(t *testing.T) {
 
 	engine.StopAnnouncing()
 }
+
+// startupPeersBroadcaster is a broadcaster that finds no peers for its first noPeerCalls calls,
+// like at startup before any peer is connected, and peers afterwards
+type startupPeersBroadcaster struct {
+	peersBroadcaster
+	mu          sync.Mutex
+	noPeerCalls int
+}
+
+func (b *startupPeersBroadcaster) FindPeers(targets map[enode.ID]bool, purpose p2p.PurposeFlag) map[enode.ID]consensus.Peer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.noPeerCalls > 0 {
+		b.noPeerCalls--
+		return make(map[enode.ID]consensus.Peer)
+	}
+	return b.peersBroadcaster.FindPeers(targets, purpose)
+}
+
+func TestRetryGossipAnnounceMsg(t *testing.T) {
+	defer func(backoff time.Duration) { announceGossipRetryBackoff = backoff }(announceGossipRetryBackoff)
+	announceGossipRetryBackoff = time.Millisecond
+
+	// Stop the announce thread, so that only the gossips of the test find peers and fail
+	engine := newBackend()
+	engine.StopAnnouncing()
+	stopped := waitForGossipRetries(t, engine, 0)
+	engine.announceGossipFailuresCounter = metrics.NewCounterForced()
+
+	// A gossip without peers is retried in the background until a peer is found
+	peer := newVersionedMockPeer(istanbul.Celo66)
+	engine.SetBroadcaster(&startupPeersBroadcaster{
+		peersBroadcaster: peersBroadcaster{peers: map[enode.ID]consensus.Peer{peer.Node().ID(): peer}},
+		noPeerCalls:      announceGossipMaxAttempts - 1,
+	})
+	outcomes := make(chan error, 1)
+	done := func(err error) { outcomes <- err }
+	engine.gossipAnnounceMsg([]byte("payload1"), istanbul.QueryEnodeMsg, done)
+	peer.waitForSend(t)
+	if err := <-outcomes; err != nil {
+		t.Errorf("error mismatch.  Want: nil, Have: %v", err)
+	}
+	if count := engine.announceGossipFailuresCounter.Count(); count != int64(announceGossipMaxAttempts-1) {
+		t.Errorf("Incorrect gossip failures count.  Want: %d, Have: %d", announceGossipMaxAttempts-1, count)
+	}
+
+	// A gossip that never finds peers gives up after the max number of attempts
+	waitForGossipRetries(t, engine, stopped+1)
+	engine.announceGossipFailuresCounter.Clear()
+	engine.SetBroadcaster(&startupPeersBroadcaster{noPeerCalls: math.MaxInt32})
+	engine.gossipAnnounceMsg([]byte("payload2"), istanbul.QueryEnodeMsg, done)
+	waitForGossipRetries(t, engine, stopped+2)
+	if err := <-outcomes; err != errNoPeersToGossip {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errNoPeersToGossip, err)
+	}
+	if count := engine.announceGossipFailuresCounter.Count(); count != int64(announceGossipMaxAttempts) {
+		t.Errorf("Incorrect gossip failures count.  Want: %d, Have: %d", announceGossipMaxAttempts, count)
+	}
+}
+
+func TestRegossipCooldownRecordedOnGossip(t *testing.T) {
+	defer func(backoff time.Duration) { announceGossipRetryBackoff = backoff }(announceGossipRetryBackoff)
+	announceGossipRetryBackoff = time.Millisecond
+
+	engine := newBackend()
+	engine.StopAnnouncing()
+	stopped := waitForGossipRetries(t, engine, 0)
+
+	sourceAddress := common.HexToAddress("0x1")
+	msg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Address: sourceAddress}
+	versionCertificate := func(version uint) *vet.VersionCertificateEntry {
+		vc, err := engine.generateVersionCertificate(version)
+		if err != nil {
+			t.Fatalf("Error in generating version certificate.  Error: %v", err)
+		}
+		return vc.Entry()
+	}
+	// Ahead of the version used by the announce thread for this node's own certificate
+	version := getTimestamp() + 10000
+	hasGossipTimes := func() (queryEnode, versionCertificates bool) {
+		engine.lastQueryEnodeGossipedMu.RLock()
+		defer engine.lastQueryEnodeGossipedMu.RUnlock()
+		engine.lastVersionCertificatesGossipedMu.RLock()
+		defer engine.lastVersionCertificatesGossipedMu.RUnlock()
+		_, queryEnode = engine.lastQueryEnodeGossiped[sourceAddress]
+		_, versionCertificates = engine.lastVersionCertificatesGossiped[engine.Address()]
+		return queryEnode, versionCertificates
+	}
+
+	// Without peers, the gossips fail and the cooldowns don't start
+	if err := engine.regossipQueryEnode(msg, 1, []byte("payload1")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if err := engine.upsertAndGossipVersionCertificateEntries([]*vet.VersionCertificateEntry{versionCertificate(version)}, false); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+	waitForGossipRetries(t, engine, stopped+2)
+	if queryEnode, versionCertificates := hasGossipTimes(); queryEnode || versionCertificates {
+		t.Errorf("Cooldowns started without gossiping.  Query enode: %v, version certificates: %v", queryEnode, versionCertificates)
+	}
+
+	// Once gossiped, they do
+	connectDiscardingPeer(engine)
+	if err := engine.regossipQueryEnode(msg, 2, []byte("payload2")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if err := engine.upsertAndGossipVersionCertificateEntries([]*vet.VersionCertificateEntry{versionCertificate(version + 1)}, false); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+	if queryEnode, versionCertificates := hasGossipTimes(); !queryEnode || !versionCertificates {
+		t.Errorf("Cooldowns not started after gossiping.  Query enode: %v, version certificates: %v", queryEnode, versionCertificates)
+	}
+}
+
+func TestRegossipQueryEnodeCooldownReservedWhileRetrying(t *testing.T) {
+	defer func(backoff time.Duration) { announceGossipRetryBackoff = backoff }(announceGossipRetryBackoff)
+	announceGossipRetryBackoff = 20 * time.Millisecond
+
+	engine := newBackend()
+	engine.StopAnnouncing()
+	stopped := waitForGossipRetries(t, engine, 0)
+
+	var reasons []string
+	engine.regossipQueryEnodeHook = func(address common.Address, regossiped bool, reason string) {
+		reasons = append(reasons, reason)
+	}
+	msg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Address: common.HexToAddress("0x1")}
+
+	// Without peers, the first gossip is retried, and another message from the same source
+	// address isn't gossiped alongside it
+	for i, payload := range []string{"payload1", "payload2"} {
+		if err := engine.regossipQueryEnode(msg, uint(i), []byte(payload)); err != nil {
+			t.Errorf("error mismatch: have %v, want nil", err)
+		}
+	}
+	if want := []string{"", "cooldown"}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("Incorrect regossip decisions while retrying.  Want: %v, Have: %v", want, reasons)
+	}
+
+	// Once the retries fail, the reservation is rolled back
+	waitForGossipRetries(t, engine, stopped+1)
+	reasons = nil
+	if err := engine.regossipQueryEnode(msg, 2, []byte("payload3")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if want := []string{""}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("Incorrect regossip decisions after the retries failed.  Want: %v, Have: %v", want, reasons)
+	}
+	waitForGossipRetries(t, engine, stopped+2)
+}
+
+// connectDiscardingPeer connects the engine to a peer that drops the messages sent to it, so that its gossips succeed
+func connectDiscardingPeer(engine *Backend) {
+	key, _ := crypto.GenerateKey()
+	peer := consensustest.NewMockPeer(enode.NewV4(&key.PublicKey, nil, 0, 0), p2p.AnyPurpose)
+	engine.SetBroadcaster(&peersBroadcaster{peers: map[enode.ID]consensus.Peer{peer.Node().ID(): peer}})
+}
+
+// waitForGossipRetries waits until at least minStopped retryGossipAnnounceMsg goroutines of the
+// engine stopped, and none is running.  It returns the number of stopped goroutines.
+func waitForGossipRetries(t *testing.T, engine *Backend, minStopped uint64) uint64 {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var status AnnounceGoroutineStatus
+		for _, goroutine := range engine.AnnounceGoroutines().Goroutines {
+			if goroutine.Name == "retryGossipAnnounceMsg" {
+				status = *goroutine
+			}
+		}
+		if status.Stopped >= minStopped && status.Running == 0 {
+			return status.Stopped
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the gossip retries.  Status: %+v", status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSeedValEnodeTable(t *testing.T) {
+	numValidators := 3
+	genesisCfg, nodeKeys := getGenesisAndKeys(numValidators, true)
+
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	_, engine2, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[2])
+	defer engine0.StopAnnouncing()
+	defer engine1.StopAnnouncing()
+	defer engine2.StopAnnouncing()
+
+	nonValKey, _ := crypto.GenerateKey()
+	nonValAddress := crypto.PubkeyToAddress(nonValKey.PublicKey)
+	nonValNode := enode.NewV4(&nonValKey.PublicKey, net.ParseIP("10.0.0.3"), 30303, 30303)
+
+	seedEntries := []*istanbul.AddressEntry{
+		{Address: engine1.Address(), Node: engine1.SelfNode()},
+		// Enode without an IP address
+		{Address: engine2.Address(), Node: enode.NewV4(&nodeKeys[2].PublicKey, nil, 0, 0)},
+		// Not within the validator conn set
+		{Address: nonValAddress, Node: nonValNode},
+	}
+
+	if err := engine0.SeedValEnodeTable(seedEntries); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+
+	entryMap, err := engine0.GetValEnodeTableEntries([]common.Address{engine1.Address(), engine2.Address(), nonValAddress})
+	if err != nil {
+		t.Errorf("Error in retrieving val enode table entries.  Error: %v", err)
+	}
+
+	if entry := entryMap[engine1.Address()]; entry == nil || entry.Node == nil || entry.Node.URLv4() != engine1.SelfNode().URLv4() {
+		t.Errorf("Incorrect val enode table entry for engine1.  Want: %v, Have: %v", engine1.SelfNode(), entry)
+	}
+
+	if entry := entryMap[engine2.Address()]; entry != nil && entry.Node != nil {
+		t.Errorf("Seeded entry with invalid enode for engine2.  Have: %v", entry)
+	}
+
+	if entry := entryMap[nonValAddress]; entry != nil {
+		t.Errorf("Seeded entry not within the validator conn set.  Have: %v", entry)
+	}
+}
+
+func TestAnnounceJSONLogs(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+	engine.config.AnnounceJSONLogs = true
+
+	destAddress := common.HexToAddress("0x1")
+	qeData := &queryEnodeData{
+		EncryptedEnodeURLs: []*encryptedEnodeURL{{DestAddress: destAddress, EncryptedEnodeURL: []byte{1, 2, 3}}},
+		Version:            4,
+		Timestamp:          5,
+	}
+
+	var decodedQEData queryEnodeDataLogEntry
+	if err := json.Unmarshal([]byte(engine.queryEnodeDataLogValue(qeData)), &decodedQEData); err != nil {
+		t.Fatalf("Invalid JSON for queryEnodeData.  Error: %v", err)
+	}
+	if decodedQEData.Version != 4 || decodedQEData.Timestamp != 5 || len(decodedQEData.EncryptedEnodeURLs) != 1 ||
+		decodedQEData.EncryptedEnodeURLs[0].DestAddress != destAddress || decodedQEData.EncryptedEnodeURLs[0].EncryptedEnodeURLLength != 3 {
+		t.Errorf("Incorrect JSON for queryEnodeData.  Have: %v", decodedQEData)
+	}
+
+	var decodedVCs []versionCertificateLogEntry
+	vcEntries := []*vet.VersionCertificateEntry{{Address: destAddress, Version: 6}}
+	if err := json.Unmarshal([]byte(engine.versionCertificatesLogValue(vcEntries)), &decodedVCs); err != nil {
+		t.Fatalf("Invalid JSON for version certificates.  Error: %v", err)
+	}
+	if len(decodedVCs) != 1 || decodedVCs[0].Address != destAddress || decodedVCs[0].Version != 6 {
+		t.Errorf("Incorrect JSON for version certificates.  Have: %v", decodedVCs)
+	}
+
+	// The String() representation is used when the option is off
+	engine.config.AnnounceJSONLogs = false
+	if have, want := engine.queryEnodeDataLogValue(qeData), qeData.String(); have != want {
+		t.Errorf("Incorrect log value for queryEnodeData.  Want: %s, Have: %s", want, have)
+	}
+}
+
+func TestSelectEnodeURL(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+
+	internalValAddress := common.HexToAddress("0x1")
+	externalValAddress := common.HexToAddress("0x2")
+	engine.config.AnnounceInternalEnodeURLValidators = []common.Address{internalValAddress}
+
+	key, _ := generatePrivateKey()
+	internalNode := enode.NewV4(&key.PublicKey, net.ParseIP("10.0.0.1"), 30303, 30303)
+	externalNode := enode.NewV4(&key.PublicKey, net.ParseIP("1.2.3.4"), 30303, 30303)
+
+	testCases := []struct {
+		valAddress   common.Address
+		internalNode *enode.Node
+		want         *enode.Node
+	}{
+		{internalValAddress, internalNode, internalNode},
+		{externalValAddress, internalNode, externalNode},
+		// Fall back to the external node if there is no internal node
+		{internalValAddress, nil, externalNode},
+	}
+
+	for i, tc := range testCases {
+		selector := engine.enodeURLSelectorForValidator(tc.valAddress)
+		if have := engine.selectEnodeURL(selector, tc.internalNode, externalNode); have != tc.want {
+			t.Errorf("test %d: Incorrect enode URL.  Want: %v, Have: %v", i, tc.want, have)
+		}
+	}
+}
+
+func TestSplitByEnodeURLSelector(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+
+	internalValAddress := common.HexToAddress("0x1")
+	externalValAddress := common.HexToAddress("0x2")
+	engine.config.AnnounceInternalEnodeURLValidators = []common.Address{internalValAddress}
+
+	external, internal := engine.splitByEnodeURLSelector([]common.Address{internalValAddress, externalValAddress})
+	if len(external) != 1 || external[0] != externalValAddress {
+		t.Errorf("Incorrect external destinations.  Want: %v, Have: %v", []common.Address{externalValAddress}, external)
+	}
+	if len(internal) != 1 || internal[0] != internalValAddress {
+		t.Errorf("Incorrect internal destinations.  Want: %v, Have: %v", []common.Address{internalValAddress}, internal)
+	}
+
+	// The external destinations stay non-nil, so the certificate isn't sent to all validators
+	external, internal = engine.splitByEnodeURLSelector([]common.Address{internalValAddress})
+	if external == nil || len(external) != 0 || len(internal) != 1 {
+		t.Errorf("Incorrect split.  External: %v, Internal: %v", external, internal)
+	}
+
+	// The internal certificate is signed over the internal enode URL
+	key, _ := generatePrivateKey()
+	internalNode := enode.NewV4(&key.PublicKey, net.ParseIP("10.0.0.1"), 30303, 30303)
+	msg, err := engine.generateEnodeCertificateMsg(internalNode, 1, "internalEnodeCertificate:")
+	if err != nil {
+		t.Fatalf("Error in generating the enode certificate.  Error: %v", err)
+	}
+	var enodeCertificate istanbul.EnodeCertificate
+	if err := istanbul.DecodeAnnounceData(msg.Msg, &enodeCertificate); err != nil {
+		t.Fatalf("Error in decoding the enode certificate.  Error: %v", err)
+	}
+	if enodeCertificate.EnodeURL != internalNode.URLv4() {
+		t.Errorf("Incorrect enode URL.  Want: %s, Have: %s", internalNode.URLv4(), enodeCertificate.EnodeURL)
+	}
+}
+
+func TestAdvertiseAddress(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+
+	if _, err := resolveAdvertiseIP("not-an-ip-or-interface"); err == nil {
+		t.Errorf("Resolving an invalid advertise address should fail")
+	}
+	advertiseIP, err := resolveAdvertiseIP("10.1.2.3")
+	if err != nil {
+		t.Fatalf("Error in resolving the advertise address.  Error: %v", err)
+	}
+	engine.advertiseIP = advertiseIP
+
+	selfNode := engine.SelfNode()
+	enodeCertMsgs, _, err := engine.generateEnodeCertificateMsgs(1)
+	if err != nil {
+		t.Fatalf("Error in generating enode certificate messages.  Error: %v", err)
+	}
+	enodeCertMsg, ok := enodeCertMsgs[selfNode.ID()]
+	if !ok {
+		t.Fatalf("No enode certificate generated for the self enode ID")
+	}
+	var enodeCertificate istanbul.EnodeCertificate
+	if err := rlp.DecodeBytes(enodeCertMsg.Msg.Msg, &enodeCertificate); err != nil {
+		t.Fatalf("Error in decoding the enode certificate.  Error: %v", err)
+	}
+	want := enode.NewV4(selfNode.Pubkey(), advertiseIP, selfNode.TCP(), selfNode.UDP()).URLv4()
+	if enodeCertificate.EnodeURL != want {
+		t.Errorf("Incorrect enode certificate URL.  Want: %v, Have: %v", want, enodeCertificate.EnodeURL)
+	}
+
+	// Query enode messages advertise the same enode
+	valAddress := common.HexToAddress("0x1")
+	assignments, err := engine.getValProxyAssignments([]common.Address{valAddress})
+	if err != nil {
+		t.Fatalf("Error in getting the val proxy assignments.  Error: %v", err)
+	}
+	if have := assignments[valAddress].URLv4(); have != want {
+		t.Errorf("Incorrect query enode URL.  Want: %v, Have: %v", want, have)
+	}
+}
+
+func TestRegossipQueryEnodeCooldown(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+	connectDiscardingPeer(engine)
+
+	type regossipDecision struct {
+		address    common.Address
+		regossiped bool
+		reason     string
+	}
+	var decisions []regossipDecision
+	engine.regossipQueryEnodeHook = func(address common.Address, regossiped bool, reason string) {
+		decisions = append(decisions, regossipDecision{address, regossiped, reason})
+	}
+
+	sourceAddress := common.HexToAddress("0x1")
+	msg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Address: sourceAddress}
+
+	// The first message from the source address should be regossiped
+	if err := engine.regossipQueryEnode(msg, 1, []byte("payload1")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	// A second message within the cooldown period should be skipped
+	if err := engine.regossipQueryEnode(msg, 2, []byte("payload2")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+
+	want := []regossipDecision{{sourceAddress, true, ""}, {sourceAddress, false, "cooldown"}}
+	if !reflect.DeepEqual(decisions, want) {
+		t.Errorf("Incorrect regossip decisions.  Want: %v, Have: %v", want, decisions)
+	}
+}
+
+func TestRegossipQueryEnodeCooldownIgnoresWallClockSteps(t *testing.T) {
+	engine := newBackend()
+	engine.StopAnnouncing()
+
+	clock := &mclock.Simulated{}
+	engine.announceClock = clock
+
+	var reasons []string
+	engine.regossipQueryEnodeHook = func(address common.Address, regossiped bool, reason string) {
+		reasons = append(reasons, reason)
+	}
+
+	sourceAddress := common.HexToAddress("0x1")
+	msg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Address: sourceAddress}
+
+	// The wall clock was stepped forward past the cooldown since the last regossip,
+	// but no monotonic time has elapsed, so the message should still be skipped
+	engine.lastQueryEnodeGossiped[sourceAddress] = gossipTime{mono: clock.Now(), wall: time.Now().Add(-2 * queryEnodeGossipCooldownDuration)}
+	if err := engine.regossipQueryEnode(msg, 1, []byte("payload1")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if have := engine.cooldownStatus(clock.Now())[sourceAddress].QueryEnode; have != queryEnodeGossipCooldownDuration {
+		t.Errorf("Incorrect remaining cooldown.  Want: %v, Have: %v", queryEnodeGossipCooldownDuration, have)
+	}
+
+	// Once the cooldown has elapsed on the monotonic clock the message should be regossiped
+	clock.Run(queryEnodeGossipCooldownDuration)
+	if err := engine.regossipQueryEnode(msg, 2, []byte("payload2")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+
+	want := []string{"cooldown", ""}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Errorf("Incorrect regossip reasons.  Want: %v, Have: %v", want, reasons)
+	}
+}
+
+func TestSuppressSelfRegossip(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+	connectDiscardingPeer(engine)
+
+	var reasons []string
+	engine.regossipQueryEnodeHook = func(address common.Address, regossiped bool, reason string) {
+		reasons = append(reasons, reason)
+	}
+	selfMsg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Address: engine.Address()}
+	selfVersionCertificate := func(version uint) *vet.VersionCertificateEntry {
+		vc, err := engine.generateVersionCertificate(version)
+		if err != nil {
+			t.Fatalf("Error in generating version certificate.  Error: %v", err)
+		}
+		return vc.Entry()
+	}
+	version := getTimestamp()
+
+	// By default, this node's own messages are regossiped
+	if err := engine.regossipQueryEnode(selfMsg, 1, []byte("payload1")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if err := engine.upsertAndGossipVersionCertificateEntries([]*vet.VersionCertificateEntry{selfVersionCertificate(version)}, true); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+	if _, ok := engine.lastVersionCertificatesGossiped[engine.Address()]; !ok {
+		t.Errorf("Own version certificate should be regossiped by default")
+	}
+
+	// With the option set, they aren't
+	engine.config.AnnounceSuppressSelfRegossip = true
+	delete(engine.lastVersionCertificatesGossiped, engine.Address())
+	if err := engine.regossipQueryEnode(selfMsg, 2, []byte("payload2")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if err := engine.upsertAndGossipVersionCertificateEntries([]*vet.VersionCertificateEntry{selfVersionCertificate(version + 1)}, true); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+	if _, ok := engine.lastVersionCertificatesGossiped[engine.Address()]; ok {
+		t.Errorf("Received own version certificate should not be regossiped with the option set")
+	}
+
+	// A freshly generated own version certificate is still gossiped
+	if err := engine.upsertAndGossipVersionCertificateEntries([]*vet.VersionCertificateEntry{selfVersionCertificate(version + 2)}, false); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+	if _, ok := engine.lastVersionCertificatesGossiped[engine.Address()]; !ok {
+		t.Errorf("Freshly generated own version certificate should be gossiped with the option set")
+	}
+
+	// Messages from other addresses are still regossiped
+	if err := engine.regossipQueryEnode(&istanbul.Message{Code: istanbul.QueryEnodeMsg, Address: common.HexToAddress("0x1")}, 3, []byte("payload3")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+
+	if want := []string{"", "self", ""}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("Incorrect regossip reasons.  Want: %v, Have: %v", want, reasons)
+	}
+}
+
+func TestSuppressSelfRegossipSharesUpdatedAnnounceVersion(t *testing.T) {
+	engine := newBackend()
+	// Keep the announce thread from gossiping on its own
+	engine.StopAnnouncing()
+	engine.config.AnnounceSuppressSelfRegossip = true
+
+	peer := newVersionedMockPeer(istanbul.Celo66)
+	engine.SetBroadcaster(&peersBroadcaster{peers: map[enode.ID]consensus.Peer{peer.Node().ID(): peer}})
+	engine.SetValidatorConnSetProvider(fixedValidatorConnSetProvider{engine.Address(): true})
+
+	version := engine.GetAnnounceVersion() + 1
+	if err := engine.setAndShareUpdatedAnnounceVersion(version); err != nil {
+		t.Fatalf("Error in setting and sharing the announce version.  Error: %v", err)
+	}
+
+	// This node's new version certificate is gossiped even though the option is set
+	for {
+		var msg istanbul.Message
+		if err := msg.FromPayload(peer.waitForSend(t), nil); err != nil {
+			t.Fatalf("Error in decoding message.  Error: %v", err)
+		}
+		if msg.Code != istanbul.VersionCertificatesMsg {
+			continue
+		}
+		var versionCertificates []*versionCertificate
+		if err := istanbul.DecodeAnnounceData(msg.Msg, (*versionCertificateList)(&versionCertificates)); err != nil {
+			t.Fatalf("Error in decoding version certificates.  Error: %v", err)
+		}
+		if len(versionCertificates) != 1 || versionCertificates[0].Version != version {
+			t.Fatalf("Incorrect gossiped version certificates.  Want version: %d, Have: %v", version, versionCertificates)
+		}
+		return
+	}
+}
+
+// gossipTimeAgo returns the gossip time of a regossip that happened d ago
+func gossipTimeAgo(engine *Backend, d time.Duration) gossipTime {
+	return gossipTime{mono: engine.announceClock.Now().Add(-d), wall: time.Now().Add(-d)}
+}
+
+func TestDecryptEnodeURLMaxLength(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(1, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	numDecryptCalls := 0
+	decryptFn := engine.decryptFn
+	engine.decryptFn = func(account accounts.Account, c, s1, s2 []byte) ([]byte, error) {
+		numDecryptCalls++
+		return decryptFn(account, c, s1, s2)
+	}
+
+	encrypt := func(plaintext []byte) []byte {
+		ciphertext, err := ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(&nodeKeys[0].PublicKey), plaintext, nil, nil)
+		if err != nil {
+			t.Fatalf("Error in encrypting.  Error: %v", err)
+		}
+		return ciphertext
+	}
+
+	// A valid enode URL should be decrypted and parsed
+	selfNode := engine.SelfNode()
+	node, err := engine.decryptEnodeURL(encrypt([]byte(selfNode.URLv4())))
+	if err != nil || node.URLv4() != selfNode.URLv4() {
+		t.Errorf("Incorrect decrypted enode.  Want: %v, Have: %v, err: %v", selfNode, node, err)
+	}
+
+	// An oversized ciphertext should be rejected before being decrypted
+	numDecryptCalls = 0
+	oversizedCiphertext := make([]byte, engine.config.AnnounceMaxEncryptedEnodeURLLength+1)
+	if _, err := engine.decryptEnodeURL(oversizedCiphertext); err != errEncryptedEnodeURLTooLong {
+		t.Errorf("error mismatch: have %v, want %v", err, errEncryptedEnodeURLTooLong)
+	}
+	if numDecryptCalls != 0 {
+		t.Errorf("Oversized ciphertext should not be decrypted")
+	}
+
+	// An oversized plaintext that fits within the ciphertext limit should be rejected before being parsed
+	oversizedPlaintext := make([]byte, engine.config.AnnounceMaxEnodeURLLength+1)
+	if _, err := engine.decryptEnodeURL(encrypt(oversizedPlaintext)); err != errEnodeURLTooLong {
+		t.Errorf("error mismatch: have %v, want %v", err, errEnodeURLTooLong)
+	}
+}
+
+func TestHandleQueryEnodeMalformedEnodeURL(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	engine0.announceMalformedEnodeURLCounter = metrics.NewCounterForced()
+
+	// A query enode message from engine1 whose enode URL decrypts successfully but can't be parsed
+	malformedQueryEnodePayload := func(timestamp uint) []byte {
+		encryptedURL, err := ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(&nodeKeys[0].PublicKey), []byte("enode://not-a-valid-enode"), nil, nil)
+		if err != nil {
+			t.Fatalf("Error in encrypting.  Error: %v", err)
+		}
+		qeBytes, err := rlp.EncodeToBytes(&queryEnodeData{
+			EncryptedEnodeURLs: []*encryptedEnodeURL{{DestAddress: engine0.Address(), EncryptedEnodeURL: encryptedURL}},
+			Version:            getTimestamp(),
+			Timestamp:          timestamp,
+		})
+		if err != nil {
+			t.Fatalf("Error in encoding query enode data.  Error: %v", err)
+		}
+		msg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Address: engine1.Address(), Msg: qeBytes}
+		if err := msg.Sign(engine1.Sign); err != nil {
+			t.Fatalf("Error in signing query enode message.  Error: %v", err)
+		}
+		payload, _ := msg.Payload()
+		return payload
+	}
+
+	// A malformed enode URL is tolerated until it's repeated too many times in a row
+	peer := newVersionedMockPeer(istanbul.Celo67)
+	for i := 1; i <= maxConsecutiveMalformedEnodeURLs; i++ {
+		err := engine0.handleQueryEnodeMsg(engine1.Address(), peer, malformedQueryEnodePayload(getTimestamp()+uint(i)))
+		if i < maxConsecutiveMalformedEnodeURLs && err != nil {
+			t.Errorf("error mismatch for malformed enode url %d.  Want: nil, Have: %v", i, err)
+		} else if i == maxConsecutiveMalformedEnodeURLs && !errors.Is(err, errMalformedDecryptedEnodeURL) {
+			t.Errorf("error mismatch for malformed enode url %d.  Want: %v, Have: %v", i, errMalformedDecryptedEnodeURL, err)
+		}
+	}
+
+	if count := engine0.announceMalformedEnodeURLCounter.Count(); count != int64(maxConsecutiveMalformedEnodeURLs) {
+		t.Errorf("Incorrect malformed enode url count.  Want: %d, Have: %d", maxConsecutiveMalformedEnodeURLs, count)
+	}
+}
+
+func TestHandleQueryEnodeMultipleSelfTargetedEntries(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	numDecryptCalls := 0
+	engine0.signFnMu.Lock()
+	decryptFn := engine0.decryptFn
+	engine0.decryptFn = func(account accounts.Account, c, s1, s2 []byte) ([]byte, error) {
+		numDecryptCalls++
+		return decryptFn(account, c, s1, s2)
+	}
+	engine0.signFnMu.Unlock()
+
+	var regossipDecisionAddresses []common.Address
+	engine0.regossipQueryEnodeHook = func(address common.Address, regossiped bool, reason string) {
+		regossipDecisionAddresses = append(regossipDecisionAddresses, address)
+	}
+
+	// A query enode message from engine1 with two entries for engine0
+	query := &enodeQuery{recipientAddress: engine0.Address(), recipientPublicKey: &nodeKeys[0].PublicKey, enodeURL: engine1.SelfNode().URLv4()}
+	encEnodeURLs, err := engine1.generateEncryptedEnodeURLs([]*enodeQuery{query, query})
+	if err != nil {
+		t.Fatalf("Error in generating encrypted enode urls.  Error: %v", err)
+	}
+	qeBytes, err := rlp.EncodeToBytes(&queryEnodeData{EncryptedEnodeURLs: encEnodeURLs, Version: getTimestamp(), Timestamp: getTimestamp()})
+	if err != nil {
+		t.Fatalf("Error in encoding query enode data.  Error: %v", err)
+	}
+	msg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Address: engine1.Address(), Msg: qeBytes}
+	if err := msg.Sign(engine1.Sign); err != nil {
+		t.Fatalf("Error in signing query enode message.  Error: %v", err)
+	}
+	payload, _ := msg.Payload()
+
+	// The message is rejected as malformed, so none of its entries is decrypted and it isn't regossiped
+	if err := engine0.handleQueryEnodeMsg(engine1.Address(), newVersionedMockPeer(istanbul.Celo67), payload); err != nil {
+		t.Errorf("error mismatch.  Want: nil, Have: %v", err)
+	}
+	if numDecryptCalls != 0 {
+		t.Errorf("Entries of a message with multiple entries for this node were decrypted.  Have: %d calls", numDecryptCalls)
+	}
+	if len(regossipDecisionAddresses) != 0 {
+		t.Errorf("Message with multiple entries for this node was regossiped.  Have: %v", regossipDecisionAddresses)
+	}
+}
+
+func TestAnnouncingStateEvents(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(1, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+
+	ch := make(chan istanbul.AnnouncingStateEvent, 10)
+	sub := engine.SubscribeAnnouncingState(ch)
+	defer sub.Unsubscribe()
+
+	waitForEvent := func() istanbul.AnnouncingStateEvent {
+		select {
+		case ev := <-ch:
+			return ev
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for announcing state event")
+			return istanbul.AnnouncingStateEvent{}
+		}
+	}
+
+	// The validator is in the conn set and validating, so it should start announcing
+	started := waitForEvent()
+	if !started.Announcing {
+		t.Errorf("Expected a started announcing event.  Have: %v", started)
+	}
+
+	// Once it stops validating, it should stop announcing
+	if err := engine.StopValidating(); err != nil {
+		t.Fatalf("Error in stopping validating.  Error: %v", err)
+	}
+	stopped := waitForEvent()
+	if stopped.Announcing || stopped.Reason != "not validating" {
+		t.Errorf("Expected a stopped announcing event because of not validating.  Have: %v", stopped)
+	}
+	if stopped.Seq != started.Seq+1 {
+		t.Errorf("Incorrect event sequence number.  Want: %d, Have: %d", started.Seq+1, stopped.Seq)
+	}
+
+	engine.StopAnnouncing()
+}
+
+func TestAnnouncingStateEventsDropOnOverflow(t *testing.T) {
+	engine := newBackend()
+	engine.StopAnnouncing()
+	engine.announcingStateEventsDroppedCounter = metrics.NewCounterForced()
+
+	// A subscriber that never reads blocks the delivery of the events
+	ch := make(chan istanbul.AnnouncingStateEvent)
+	sub := engine.SubscribeAnnouncingState(ch)
+	defer sub.Unsubscribe()
+
+	// Posting never blocks, the events that don't fit in the queue are dropped
+	posted := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*announcingStateEventBufferSize; i++ {
+			engine.postAnnouncingStateEvent(true, "test")
+		}
+		close(posted)
+	}()
+	select {
+	case <-posted:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Posting announcing state events blocked")
+	}
+	if count := engine.announcingStateEventsDroppedCounter.Count(); count < announcingStateEventBufferSize-1 {
+		t.Errorf("Incorrect number of dropped events.  Want: >= %d, Have: %d", announcingStateEventBufferSize-1, count)
+	}
+
+	// The queued events are still delivered, in order
+	var events []istanbul.AnnouncingStateEvent
+	for len(events) < 2 {
+		select {
+		case ev := <-ch:
+			events = append(events, ev)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for announcing state event")
+		}
+	}
+	if events[1].Seq != events[0].Seq+1 {
+		t.Errorf("Incorrect event sequence number.  Want: %d, Have: %d", events[0].Seq+1, events[1].Seq)
+	}
+}
+
+func TestPauseAndResumeAnnounce(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(1, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	ch := make(chan istanbul.AnnouncingStateEvent, 10)
+	sub := engine.SubscribeAnnouncingState(ch)
+	defer sub.Unsubscribe()
+
+	waitForEvent := func() istanbul.AnnouncingStateEvent {
+		select {
+		case ev := <-ch:
+			return ev
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for announcing state event")
+			return istanbul.AnnouncingStateEvent{}
+		}
+	}
+
+	if ev := waitForEvent(); !ev.Announcing {
+		t.Fatalf("Expected a started announcing event.  Have: %v", ev)
+	}
+
+	engine.PauseAnnounce()
+	if ev := waitForEvent(); ev.Announcing || ev.Reason != "announcing paused" {
+		t.Errorf("Expected a stopped announcing event because of the pause.  Have: %v", ev)
+	}
+	if !engine.IsAnnouncePaused() {
+		t.Errorf("Expected announcing to be paused")
+	}
+
+	// The announce version isn't updated, and so not gossiped, while paused
+	pausedVersion := engine.GetAnnounceVersion()
+	time.Sleep(1100 * time.Millisecond)
+	engine.UpdateAnnounceVersion()
+	time.Sleep(500 * time.Millisecond)
+	if version := engine.GetAnnounceVersion(); version != pausedVersion {
+		t.Errorf("Announce version updated while paused.  Want: %v, Have: %v", pausedVersion, version)
+	}
+
+	// Resuming starts announcing right away, with a new announce version
+	engine.ResumeAnnounce()
+	if ev := waitForEvent(); !ev.Announcing {
+		t.Errorf("Expected a started announcing event after resuming.  Have: %v", ev)
+	}
+	// The event is posted before the announce version is updated
+	deadline := time.Now().Add(5 * time.Second)
+	for engine.GetAnnounceVersion() <= pausedVersion && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if version := engine.GetAnnounceVersion(); version <= pausedVersion {
+		t.Errorf("Announce version not updated after resuming.  Paused version: %v, Have: %v", pausedVersion, version)
+	}
+}
+
+func TestPausedAnnounceDoesNotShareVersionCertificates(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(1, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+
+	ch := make(chan istanbul.AnnouncingStateEvent, 10)
+	sub := engine.SubscribeAnnouncingState(ch)
+	defer sub.Unsubscribe()
+
+	// Wait for this node's own version certificate to be in the table
+	select {
+	case <-ch:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for announcing state event")
+	}
+	engine.StopAnnouncing()
+
+	// Restart the announce thread paused, with the version certificates shared every second
+	engine.config.AnnounceShareVersionCertificatesPeriod = 1
+	peer := newVersionedMockPeer(istanbul.Celo66)
+	engine.SetBroadcaster(&peersBroadcaster{peers: map[enode.ID]consensus.Peer{peer.Node().ID(): peer}})
+	engine.PauseAnnounce()
+	if err := engine.StartAnnouncing(); err != nil {
+		t.Fatalf("Error in starting announcing.  Error: %v", err)
+	}
+	defer engine.StopAnnouncing()
+
+	select {
+	case data := <-peer.sentCh:
+		t.Errorf("Message sent while announcing is paused: %x", data)
+	case <-time.After(2500 * time.Millisecond):
+	}
+}
+
+func TestEnodeCertificateRequest(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(1, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	// A peer that doesn't support enode certificate requests can't be sent one
+	if err := engine.RequestEnodeCertificate(newVersionedMockPeer(istanbul.Celo66)); err != errEnodeCertificateRequestNotSupported {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errEnodeCertificateRequestNotSupported, err)
+	}
+	requester := newVersionedMockPeer(istanbul.Celo67)
+	if err := engine.RequestEnodeCertificate(requester); err != nil {
+		t.Errorf("error mismatch.  Want: nil, Have: %v", err)
+	}
+	if data := requester.waitForSend(t); len(data) != 0 {
+		t.Errorf("Unexpected enode certificate request payload: %x", data)
+	}
+
+	// The request is not answered when this node has no enode certificate yet
+	engine.enodeCertificateMsgMapMu.Lock()
+	engine.enodeCertificateMsgMap = nil
+	engine.enodeCertificateMsgMapMu.Unlock()
+	if err := engine.handleEnodeCertificateRequestMsg(requester); err != errNodeMissingEnodeCertificate {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errNodeMissingEnodeCertificate, err)
+	}
+	select {
+	case <-requester.sentCh:
+		t.Errorf("Enode certificate sent without having one")
+	default:
+	}
+
+	// The request is answered with this node's enode certificate
+	announceVersion := engine.GetAnnounceVersion() + 1
+	if err := engine.setAndShareUpdatedAnnounceVersion(announceVersion); err != nil {
+		t.Fatalf("error mismatch.  Want: nil, Have: %v", err)
+	}
+	if err := engine.handleEnodeCertificateRequestMsg(requester); err != nil {
+		t.Fatalf("error mismatch.  Want: nil, Have: %v", err)
+	}
+	payload, err := decompressAnnouncePayload(requester, istanbul.EnodeCertificateMsg, requester.waitForSend(t))
+	if err != nil {
+		t.Fatalf("Error in decompressing enode certificate payload.  Error: %v", err)
+	}
+
+	var msg istanbul.Message
+	if err := msg.FromPayload(payload, istanbul.GetSignatureAddress); err != nil {
+		t.Fatalf("Error in decoding enode certificate message.  Error: %v", err)
+	}
+	var enodeCertificate istanbul.EnodeCertificate
+	if err := rlp.DecodeBytes(msg.Msg, &enodeCertificate); err != nil {
+		t.Fatalf("Error in decoding enode certificate.  Error: %v", err)
+	}
+	if enodeCertificate.EnodeURL != engine.SelfNode().URLv4() {
+		t.Errorf("Incorrect enodeURL in the enode certificate.  Want: %s, Have: %s", engine.SelfNode().URLv4(), enodeCertificate.EnodeURL)
+	}
+	if enodeCertificate.Version != announceVersion {
+		t.Errorf("Incorrect version in the enode certificate.  Want: %d, Have: %d", announceVersion, enodeCertificate.Version)
+	}
+
+	// The requests beyond the peer's burst aren't answered, while other peers are still answered
+	if err := engine.handleEnodeCertificateRequestMsg(requester); err != nil {
+		t.Fatalf("error mismatch.  Want: nil, Have: %v", err)
+	}
+	requester.waitForSend(t)
+	if err := engine.handleEnodeCertificateRequestMsg(requester); err != errEnodeCertificateRequestRateLimited {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errEnodeCertificateRequestRateLimited, err)
+	}
+	otherRequester := newVersionedMockPeer(istanbul.Celo67)
+	if err := engine.handleEnodeCertificateRequestMsg(otherRequester); err != nil {
+		t.Errorf("error mismatch.  Want: nil, Have: %v", err)
+	}
+	otherRequester.waitForSend(t)
+}
+
+func TestQueryEnodeDataNodeTagRLPEncoding(t *testing.T) {
+	encryptedEnodeURLs := []*encryptedEnodeURL{{DestAddress: common.HexToAddress("0x1"), EncryptedEnodeURL: []byte{1, 2, 3}}}
+	// The node tag isn't encoded, so that older versions can decode the message
+	legacyVal, _ := rlp.EncodeToBytes([]interface{}{encryptedEnodeURLs, uint(4), uint(5)})
+	rawVal, err := rlp.EncodeToBytes(&queryEnodeData{EncryptedEnodeURLs: encryptedEnodeURLs, Version: 4, Timestamp: 5, NodeTag: "validator-1"})
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if !reflect.DeepEqual(legacyVal, rawVal) {
+		t.Errorf("Encoding with node tag mismatch.  Want: %x, Have: %x", legacyVal, rawVal)
+	}
+
+	// Node tags encoded by other versions are still decoded, and sanitized
+	taggedVal, _ := rlp.EncodeToBytes([]interface{}{encryptedEnodeURLs, uint(4), uint(5), "validator\n-1"})
+	var result queryEnodeData
+	if err := rlp.DecodeBytes(taggedVal, &result); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if want := (&queryEnodeData{EncryptedEnodeURLs: encryptedEnodeURLs, Version: 4, Timestamp: 5, NodeTag: "validator-1"}); !reflect.DeepEqual(want, &result) {
+		t.Errorf("RLP decoding mismatch.  Want: %v, Have: %v", want, &result)
+	}
+}
+
+func TestPruneAnnounceDataStructures(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	inConnSetAddress := crypto.PubkeyToAddress(nodeKeys[1].PublicKey)
+	staleKey, _ := crypto.GenerateKey()
+	staleAddress := crypto.PubkeyToAddress(staleKey.PublicKey)
+	recentKey, _ := crypto.GenerateKey()
+	recentAddress := crypto.PubkeyToAddress(recentKey.PublicKey)
+
+	expired := gossipTimeAgo(engine, 2*queryEnodeGossipCooldownDuration)
+	engine.lastQueryEnodeGossipedMu.Lock()
+	engine.lastVersionCertificatesGossipedMu.Lock()
+	for _, gossipTimes := range []map[common.Address]gossipTime{engine.lastQueryEnodeGossiped, engine.lastVersionCertificatesGossiped} {
+		gossipTimes[inConnSetAddress] = expired
+		gossipTimes[staleAddress] = expired
+		gossipTimes[recentAddress] = engine.newGossipTime()
+	}
+	engine.lastVersionCertificatesGossipedMu.Unlock()
+	engine.lastQueryEnodeGossipedMu.Unlock()
+
+	if err := engine.valEnodeTable.UpsertHighestKnownVersion([]*istanbul.AddressEntry{
+		{Address: inConnSetAddress, PublicKey: &nodeKeys[1].PublicKey, HighestKnownVersion: 1},
+		{Address: staleAddress, PublicKey: &staleKey.PublicKey, HighestKnownVersion: 1},
+	}); err != nil {
+		t.Fatalf("Error in upserting val enode entries.  Error: %v", err)
+	}
+	if _, err := engine.versionCertificateTable.Upsert([]*vet.VersionCertificateEntry{
+		{Address: inConnSetAddress, PublicKey: &nodeKeys[1].PublicKey, Version: getTimestamp(), Signature: []byte("foo")},
+		{Address: staleAddress, PublicKey: &staleKey.PublicKey, Version: getTimestamp(), Signature: []byte("bar")},
+	}); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+
+	if err := engine.pruneAnnounceDataStructures(); err != nil {
+		t.Fatalf("Error in pruning announce data structures.  Error: %v", err)
+	}
+
+	// All four data structures agree on which addresses are kept
+	engine.lastQueryEnodeGossipedMu.RLock()
+	engine.lastVersionCertificatesGossipedMu.RLock()
+	for name, gossipTimes := range map[string]map[common.Address]gossipTime{"lastQueryEnodeGossiped": engine.lastQueryEnodeGossiped, "lastVersionCertificatesGossiped": engine.lastVersionCertificatesGossiped} {
+		if _, ok := gossipTimes[inConnSetAddress]; !ok {
+			t.Errorf("%s: entry in the validator conn set was pruned", name)
+		}
+		if _, ok := gossipTimes[staleAddress]; ok {
+			t.Errorf("%s: entry outside of the validator conn set was not pruned", name)
+		}
+		if _, ok := gossipTimes[recentAddress]; !ok {
+			t.Errorf("%s: entry within its gossip cooldown was pruned", name)
+		}
+	}
+	engine.lastVersionCertificatesGossipedMu.RUnlock()
+	engine.lastQueryEnodeGossipedMu.RUnlock()
+
+	if _, err := engine.valEnodeTable.GetHighestKnownVersionFromAddress(inConnSetAddress); err != nil {
+		t.Errorf("valEnodeTable: entry in the validator conn set was pruned.  Error: %v", err)
+	}
+	if _, err := engine.valEnodeTable.GetHighestKnownVersionFromAddress(staleAddress); err == nil {
+		t.Errorf("valEnodeTable: entry outside of the validator conn set was not pruned")
+	}
+	if _, err := engine.versionCertificateTable.Get(inConnSetAddress); err != nil {
+		t.Errorf("versionCertificateTable: entry in the validator conn set was pruned.  Error: %v", err)
+	}
+	if _, err := engine.versionCertificateTable.Get(staleAddress); err == nil {
+		t.Errorf("versionCertificateTable: entry outside of the validator conn set was not pruned")
+	}
+}
+
+func TestPruneVersionCertificatesByAge(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+	engine.config.AnnounceVersionCertificateMaxAge = 60
+
+	key, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	engine.SetValidatorConnSetProvider(fixedValidatorConnSetProvider{engine.Address(): true, address: true})
+	if _, err := engine.versionCertificateTable.Upsert([]*vet.VersionCertificateEntry{{Address: address, PublicKey: &key.PublicKey, Version: getTimestamp() - 3600, Signature: []byte("foo")}}); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+	isPruned := func() bool {
+		if err := engine.pruneAnnounceDataStructures(); err != nil {
+			t.Fatalf("Error in pruning announce data structures.  Error: %v", err)
+		}
+		_, err := engine.versionCertificateTable.Get(address)
+		return err == enodesdb.ErrNotFound
+	}
+
+	// Epoch+counter versions aren't timestamps, so versions aren't pruned by age
+	engine.config.AnnounceVersionMode = istanbul.EpochCounterVersion
+	if isPruned() {
+		t.Errorf("Version certificate pruned by age in epoch+counter version mode")
+	}
+
+	engine.config.AnnounceVersionMode = istanbul.TimestampVersion
+	if !isPruned() {
+		t.Errorf("Expired version certificate not pruned")
+	}
+}
+
+func TestPruneCallback(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+
+	var summaries []*PruneSummary
+	var summariesMu sync.Mutex
+	engine.SetPruneCallback(func(summary *PruneSummary) {
+		summariesMu.Lock()
+		defer summariesMu.Unlock()
+		summaries = append(summaries, summary)
+	})
+	getSummaries := func() []*PruneSummary {
+		summariesMu.Lock()
+		defer summariesMu.Unlock()
+		return append([]*PruneSummary(nil), summaries...)
+	}
+
+	keptKey, _ := crypto.GenerateKey()
+	keptAddress := crypto.PubkeyToAddress(keptKey.PublicKey)
+	removedKey, _ := crypto.GenerateKey()
+	removedAddress := crypto.PubkeyToAddress(removedKey.PublicKey)
+	engine.SetValidatorConnSetProvider(fixedValidatorConnSetProvider{engine.Address(): true, keptAddress: true, removedAddress: true})
+
+	expired := gossipTimeAgo(engine, 2*queryEnodeGossipCooldownDuration)
+	engine.lastQueryEnodeGossipedMu.Lock()
+	engine.lastVersionCertificatesGossipedMu.Lock()
+	for _, gossipTimes := range []map[common.Address]gossipTime{engine.lastQueryEnodeGossiped, engine.lastVersionCertificatesGossiped} {
+		gossipTimes[keptAddress] = expired
+		gossipTimes[removedAddress] = expired
+	}
+	engine.lastVersionCertificatesGossipedMu.Unlock()
+	engine.lastQueryEnodeGossipedMu.Unlock()
+
+	if err := engine.valEnodeTable.UpsertHighestKnownVersion([]*istanbul.AddressEntry{
+		{Address: keptAddress, PublicKey: &keptKey.PublicKey, HighestKnownVersion: 1},
+		{Address: removedAddress, PublicKey: &removedKey.PublicKey, HighestKnownVersion: 1},
+	}); err != nil {
+		t.Fatalf("Error in upserting val enode entries.  Error: %v", err)
+	}
+	if _, err := engine.versionCertificateTable.Upsert([]*vet.VersionCertificateEntry{
+		{Address: keptAddress, PublicKey: &keptKey.PublicKey, Version: getTimestamp(), Signature: []byte("foo")},
+		{Address: removedAddress, PublicKey: &removedKey.PublicKey, Version: getTimestamp(), Signature: []byte("bar")},
+	}); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+
+	// Nothing is removed while both validators are in the conn set, so there is no notification
+	if err := engine.pruneAnnounceDataStructures(); err != nil {
+		t.Fatalf("Error in pruning announce data structures.  Error: %v", err)
+	}
+	if have := getSummaries(); len(have) != 0 {
+		t.Fatalf("Incorrect number of prune notifications.  Want: 0, Have: %d", len(have))
+	}
+
+	// After the conn set shrinks, the removed validator is reported for each data structure
+	engine.SetValidatorConnSetProvider(fixedValidatorConnSetProvider{engine.Address(): true, keptAddress: true})
+	if err := engine.pruneAnnounceDataStructures(); err != nil {
+		t.Fatalf("Error in pruning announce data structures.  Error: %v", err)
+	}
+	have := getSummaries()
+	if len(have) != 1 {
+		t.Fatalf("Incorrect number of prune notifications.  Want: 1, Have: %d", len(have))
+	}
+	want := &PruneSummary{
+		LastQueryEnodeGossiped:          []common.Address{removedAddress},
+		LastVersionCertificatesGossiped: []common.Address{removedAddress},
+		ValEnodeTable:                   []common.Address{removedAddress},
+		VersionCertificateTable:         []common.Address{removedAddress},
+	}
+	if !reflect.DeepEqual(have[0], want) {
+		t.Errorf("Incorrect prune summary.  Want: %v, Have: %v", want, have[0])
+	}
+	if numRemoved := have[0].NumRemoved(); numRemoved != 4 {
+		t.Errorf("Incorrect number of removed entries.  Want: 4, Have: %d", numRemoved)
+	}
+}
+
+func TestHandleVersionCertificatesMsgRejectsSelf(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	newVersionCertificate := func(key *ecdsa.PrivateKey, version uint) *versionCertificate {
+		vc := &versionCertificate{Version: version}
+		if err := vc.Sign(func(data []byte) ([]byte, error) { return crypto.Sign(crypto.Keccak256(data), key) }); err != nil {
+			t.Fatalf("Error in signing version certificate.  Error: %v", err)
+		}
+		return vc
+	}
+
+	// A version certificate with this node's address that it never generated, alongside one of another validator
+	remoteAddress := crypto.PubkeyToAddress(nodeKeys[1].PublicKey)
+	selfVersion := engine.GetAnnounceVersion() + 100
+	remoteVersion := getTimestamp()
+	certsBytes, err := rlp.EncodeToBytes([]*versionCertificate{
+		newVersionCertificate(nodeKeys[0], selfVersion),
+		newVersionCertificate(nodeKeys[1], remoteVersion),
+	})
+	if err != nil {
+		t.Fatalf("Error in encoding version certificates.  Error: %v", err)
+	}
+	msg := &istanbul.Message{Code: istanbul.VersionCertificatesMsg, Address: remoteAddress, Msg: certsBytes}
+	if err := msg.Sign(engine.Sign); err != nil {
+		t.Fatalf("Error in signing version certificates message.  Error: %v", err)
+	}
+	payload, _ := msg.Payload()
+
+	if err := engine.handleVersionCertificatesMsg(remoteAddress, newVersionedMockPeer(istanbul.Celo67), payload); err != nil {
+		t.Fatalf("Error in handling version certificates message.  Error: %v", err)
+	}
+
+	if version, err := engine.versionCertificateTable.GetVersion(engine.Address()); err == nil && version == selfVersion {
+		t.Errorf("Version certificate with this node's address was saved")
+	}
+	if version, err := engine.versionCertificateTable.GetVersion(remoteAddress); err != nil || version != remoteVersion {
+		t.Errorf("Incorrect version certificate version.  Want: %d, Have: %d, err: %v", remoteVersion, version, err)
+	}
+
+	// Replicas share the address of their primary, and store its version certificates
+	if err := engine.StopValidating(); err != nil {
+		t.Fatalf("Error in stopping validating.  Error: %v", err)
+	}
+	primaryVersion := selfVersion + 1
+	certsBytes, err = rlp.EncodeToBytes([]*versionCertificate{newVersionCertificate(nodeKeys[0], primaryVersion)})
+	if err != nil {
+		t.Fatalf("Error in encoding version certificates.  Error: %v", err)
+	}
+	msg = &istanbul.Message{Code: istanbul.VersionCertificatesMsg, Address: remoteAddress, Msg: certsBytes}
+	if err := msg.Sign(engine.Sign); err != nil {
+		t.Fatalf("Error in signing version certificates message.  Error: %v", err)
+	}
+	payload, _ = msg.Payload()
+	if err := engine.handleVersionCertificatesMsg(remoteAddress, newVersionedMockPeer(istanbul.Celo67), payload); err != nil {
+		t.Fatalf("Error in handling version certificates message.  Error: %v", err)
+	}
+	if version, err := engine.versionCertificateTable.GetVersion(engine.Address()); err != nil || version != primaryVersion {
+		t.Errorf("Incorrect version of the primary's version certificate on the replica.  Want: %d, Have: %d, err: %v", primaryVersion, version, err)
+	}
+}
+
+// peersBroadcaster is a broadcaster whose FindPeers returns the targets that are in peers
+type peersBroadcaster struct {
+	consensustest.MockBroadcaster
+	peers map[enode.ID]consensus.Peer
+}
+
+func (b *peersBroadcaster) FindPeers(targets map[enode.ID]bool, purpose p2p.PurposeFlag) map[enode.ID]consensus.Peer {
+	found := make(map[enode.ID]consensus.Peer)
+	for id, peer := range b.peers {
+		if targets == nil || targets[id] {
+			found[id] = peer
+		}
+	}
+	return found
+}
+
+func TestShouldUpsertQueryEnodeOrigin(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+
+	peerKey, _ := crypto.GenerateKey()
+	peerAddress := crypto.PubkeyToAddress(peerKey.PublicKey)
+	peerNode := enode.NewV4(&peerKey.PublicKey, net.ParseIP("127.0.0.1"), 30303, 30303)
+	otherKey, _ := crypto.GenerateKey()
+	otherAddress := crypto.PubkeyToAddress(otherKey.PublicKey)
+	otherNode := enode.NewV4(&otherKey.PublicKey, net.ParseIP("127.0.0.2"), 30303, 30303)
+
+	engine.SetBroadcaster(&peersBroadcaster{peers: map[enode.ID]consensus.Peer{peerNode.ID(): newVersionedMockPeer(istanbul.Celo67)}})
+
+	testCases := []struct {
+		name      string
+		policy    istanbul.AnswerPolicy
+		allowlist []common.Address
+		address   common.Address
+		node      *enode.Node
+		want      bool
+	}{
+		{"AlwaysUpsert peer", istanbul.AlwaysUpsert, nil, peerAddress, peerNode, true},
+		{"AlwaysUpsert non-peer", istanbul.AlwaysUpsert, nil, otherAddress, otherNode, true},
+		{"OnlyExistingPeers peer", istanbul.OnlyExistingPeers, nil, peerAddress, peerNode, true},
+		{"OnlyExistingPeers non-peer", istanbul.OnlyExistingPeers, nil, otherAddress, otherNode, false},
+		{"Allowlist allowed", istanbul.Allowlist, []common.Address{otherAddress}, otherAddress, otherNode, true},
+		{"Allowlist not allowed", istanbul.Allowlist, []common.Address{otherAddress}, peerAddress, peerNode, false},
+		{"Allowlist empty", istanbul.Allowlist, nil, otherAddress, otherNode, false},
+	}
+	for _, tc := range testCases {
+		engine.config.AnnounceAnswerPolicy = tc.policy
+		engine.config.AnnounceAnswerAllowlist = tc.allowlist
+		if have := engine.shouldUpsertQueryEnodeOrigin(tc.address, tc.node); have != tc.want {
+			t.Errorf("%s: incorrect answer policy decision.  Want: %v, Have: %v", tc.name, tc.want, have)
+		}
+	}
+
+	// The origin isn't upserted into the val enode table when the policy rejects it
+	engine.config.AnnounceAnswerPolicy = istanbul.Allowlist
+	engine.config.AnnounceAnswerAllowlist = nil
+	if err := engine.answerQueryEnodeMsg(otherAddress, otherNode, getTimestamp()); err != nil && err != errNodeMissingEnodeCertificate {
+		t.Fatalf("Error in answering query enode message.  Error: %v", err)
+	}
+	if node, err := engine.valEnodeTable.GetNodeFromAddress(otherAddress); err == nil && node != nil {
+		t.Errorf("Query enode origin rejected by the answer policy was upserted")
+	}
+}
+
+func TestDetectVersionRegressions(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	engine.announceVersionRegressionsCounter = metrics.NewCounterForced()
+
+	var warningsMu sync.Mutex
+	var warnings []string
+	handler := log.Root().GetHandler()
+	log.Root().SetHandler(log.FuncHandler(func(r *log.Record) error {
+		if r.Lvl == log.LvlWarn {
+			warningsMu.Lock()
+			warnings = append(warnings, r.Msg)
+			warningsMu.Unlock()
+		}
+		return nil
+	}))
+	defer log.Root().SetHandler(handler)
+
+	newEntry := func(version uint) *vet.VersionCertificateEntry {
+		vc := &versionCertificate{Version: version}
+		if err := vc.Sign(func(data []byte) ([]byte, error) { return crypto.Sign(crypto.Keccak256(data), nodeKeys[1]) }); err != nil {
+			t.Fatalf("Error in signing version certificate.  Error: %v", err)
+		}
+		if err := vc.RecoverPublicKeyAndAddress(); err != nil {
+			t.Fatalf("Error in recovering version certificate address.  Error: %v", err)
+		}
+		return vc.Entry()
+	}
+
+	version := getTimestamp()
+	for _, entry := range []*vet.VersionCertificateEntry{newEntry(version), newEntry(version), newEntry(version - 1)} {
+		if err := engine.upsertAndGossipVersionCertificateEntries([]*vet.VersionCertificateEntry{entry}, true); err != nil {
+			t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+		}
+	}
+
+	// Only the strictly lower version is a regression
+	if count := engine.announceVersionRegressionsCounter.Count(); count != 1 {
+		t.Errorf("Incorrect version regression count.  Want: 1, Have: %d", count)
+	}
+	// Regressions are expected while a version propagates, so they aren't warned about
+	warningsMu.Lock()
+	defer warningsMu.Unlock()
+	for _, warning := range warnings {
+		if warning == "Received a version certificate with a lower version than the stored one" {
+			t.Errorf("Version regressions should only be logged at debug level")
+		}
+	}
+	if storedVersion, err := engine.versionCertificateTable.GetVersion(crypto.PubkeyToAddress(nodeKeys[1].PublicKey)); err != nil || storedVersion != version {
+		t.Errorf("Incorrect stored version.  Want: %d, Have: %d, err: %v", version, storedVersion, err)
+	}
+}
+
+func TestPlaintextEnodeURLs(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	selfNode := engine0.SelfNode()
+	queries := []*enodeQuery{{recipientAddress: engine1.Address(), recipientPublicKey: &nodeKeys[1].PublicKey, enodeURL: selfNode.URLv4()}}
+
+	// Encrypted enode urls keep the original encoding
+	encrypted, err := engine0.generateEncryptedEnodeURLs(queries)
+	if err != nil {
+		t.Fatalf("Error in generating encrypted enode urls.  Error: %v", err)
+	}
+	encryptedBytes, _ := rlp.EncodeToBytes(encrypted[0])
+	legacyBytes, _ := rlp.EncodeToBytes([]interface{}{encrypted[0].DestAddress, encrypted[0].EncryptedEnodeURL})
+	if encrypted[0].Plaintext || !reflect.DeepEqual(encryptedBytes, legacyBytes) {
+		t.Errorf("Encrypted enode url encoding changed")
+	}
+
+	engine0.config.AnnounceInsecurePlaintextEnodeURLs = true
+	plaintext, err := engine0.generateEncryptedEnodeURLs(queries)
+	if err != nil {
+		t.Fatalf("Error in generating plaintext enode urls.  Error: %v", err)
+	}
+
+	// Round trip the plaintext enode url through a query enode message's encoding
+	qeBytes, err := rlp.EncodeToBytes(&queryEnodeData{EncryptedEnodeURLs: plaintext, Version: 1, Timestamp: 1})
+	if err != nil {
+		t.Fatalf("Error in encoding query enode data.  Error: %v", err)
+	}
+	var qeData queryEnodeData
+	if err := rlp.DecodeBytes(qeBytes, &qeData); err != nil {
+		t.Fatalf("Error in decoding query enode data.  Error: %v", err)
+	}
+	received := qeData.EncryptedEnodeURLs[0]
+	if !received.Plaintext || received.DestAddress != engine1.Address() || string(received.EncryptedEnodeURL) != selfNode.URLv4() {
+		t.Errorf("Incorrect decoded plaintext enode url.  Want: %v, Have: %v", plaintext[0], received)
+	}
+
+	// Plaintext enode urls are only accepted when enabled
+	if _, err := engine1.parsePlaintextEnodeURL(received.EncryptedEnodeURL); err != errPlaintextEnodeURLNotAllowed {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errPlaintextEnodeURLNotAllowed, err)
+	}
+	engine1.config.AnnounceInsecurePlaintextEnodeURLs = true
+	node, err := engine1.parsePlaintextEnodeURL(received.EncryptedEnodeURL)
+	if err != nil || node.URLv4() != selfNode.URLv4() {
+		t.Errorf("Incorrect plaintext enode.  Want: %v, Have: %v, err: %v", selfNode, node, err)
+	}
+}
+
+// flakyProxyPeer is a proxy peer whose sends of a given enode certificate payload fail
+// a given number of times before succeeding.  All other messages are sent successfully.
+type flakyProxyPeer struct {
+	*consensustest.MockPeer
+	payload     []byte
+	mu          sync.Mutex
+	numFailures int
+	numAttempts int
+	delivered   chan struct{}
+}
+
+func (p *flakyProxyPeer) Send(msgCode uint64, data interface{}) error {
+	if msgCode != istanbul.EnodeCertificateMsg {
+		return nil
+	}
+	payload, err := decompressAnnouncePayload(p, msgCode, data.([]byte))
+	if err != nil || !bytes.Equal(payload, p.payload) {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.numAttempts++
+	if p.numAttempts <= p.numFailures {
+		return errors.New("flaky proxy peer")
+	}
+	select {
+	case p.delivered <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func TestRetrySendEnodeCertsToProxies(t *testing.T) {
+	defer func(backoff, maxBackoff time.Duration) {
+		proxyEnodeCertsRetryBackoff, proxyEnodeCertsMaxRetryBackoff = backoff, maxBackoff
+	}(proxyEnodeCertsRetryBackoff, proxyEnodeCertsMaxRetryBackoff)
+	proxyEnodeCertsRetryBackoff, proxyEnodeCertsMaxRetryBackoff = time.Millisecond, 4*time.Millisecond
+
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, true, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+	engine.announceGossipFailuresCounter = metrics.NewCounterForced()
+
+	proxyKey, _ := crypto.GenerateKey()
+	proxyNode := enode.NewV4(&proxyKey.PublicKey, net.ParseIP("127.0.0.1"), 30303, 30303)
+
+	enodeCert := &istanbul.EnodeCertMsg{Msg: &istanbul.Message{Code: istanbul.EnodeCertificateMsg, Msg: []byte("enode certificate"), Address: engine.Address()}}
+	payload, err := enodeCert.Msg.Payload()
+	if err != nil {
+		t.Fatalf("Error in getting the enode certificate payload: %v", err)
+	}
+	peer := &flakyProxyPeer{
+		MockPeer:    consensustest.NewMockPeer(proxyNode, p2p.ProxyPurpose),
+		payload:     payload,
+		numFailures: 3,
+		delivered:   make(chan struct{}, 1),
+	}
+
+	pv := engine.GetProxiedValidatorEngine()
+	if err := pv.AddProxy(proxyNode, proxyNode); err != nil {
+		t.Fatalf("Error in adding the proxy: %v", err)
+	}
+	if err := pv.RegisterProxyPeer(peer); err != nil {
+		t.Fatalf("Error in registering the proxy peer: %v", err)
+	}
+
+	// Wait for the asynchronous registration of the proxy peer
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		proxies, _, err := pv.GetProxiesAndValAssignments()
+		if err == nil && len(proxies) == 1 && proxies[0].IsPeered() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Proxy peer was not registered.  proxies: %v, err: %v", proxies, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	engine.sendEnodeCertsToProxies(map[enode.ID]*istanbul.EnodeCertMsg{proxyNode.ID(): enodeCert})
+
+	// The enode certificate is eventually delivered to the failing proxy, without a new announce version
+	select {
+	case <-peer.delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Enode certificate was not delivered to the proxy")
+	}
+
+	peer.mu.Lock()
+	if want := peer.numFailures + 1; peer.numAttempts != want {
+		t.Errorf("Incorrect number of send attempts.  Want: %d, Have: %d", want, peer.numAttempts)
+	}
+	peer.mu.Unlock()
+	if count := engine.announceGossipFailuresCounter.Count(); count < int64(peer.numFailures) {
+		t.Errorf("Incorrect failure count.  Want: >= %d, Have: %d", peer.numFailures, count)
+	}
+	engine.proxyEnodeCertsRetryMu.Lock()
+	defer engine.proxyEnodeCertsRetryMu.Unlock()
+	if engine.proxyEnodeCertsRetryCancel != nil {
+		t.Errorf("Retry is still pending after delivery")
+	}
+}
+
+// lockedPeersBroadcaster is a peersBroadcaster whose peers can be connected while it's in use
+type lockedPeersBroadcaster struct {
+	peersBroadcaster
+	mu sync.Mutex
+}
+
+func (b *lockedPeersBroadcaster) FindPeers(targets map[enode.ID]bool, purpose p2p.PurposeFlag) map[enode.ID]consensus.Peer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.peersBroadcaster.FindPeers(targets, purpose)
+}
+
+func (b *lockedPeersBroadcaster) connect(peer consensus.Peer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.peers[peer.Node().ID()] = peer
+}
+
+// waitForEnodeCertificate waits until the peer is sent an enode certificate message, skipping any other message
+func waitForEnodeCertificate(t *testing.T, peer *versionedMockPeer) {
+	for {
+		var msg istanbul.Message
+		if err := msg.FromPayload(peer.waitForSend(t), nil); err != nil {
+			t.Fatalf("Error in decoding message.  Error: %v", err)
+		}
+		if msg.Code == istanbul.EnodeCertificateMsg {
+			return
+		}
+	}
+}
+
+func TestRetryEnodeCertsToUnreachableValidators(t *testing.T) {
+	defer func(backoff, maxBackoff time.Duration) {
+		enodeCertsRetryBackoff, enodeCertsMaxRetryBackoff = backoff, maxBackoff
+	}(enodeCertsRetryBackoff, enodeCertsMaxRetryBackoff)
+	enodeCertsRetryBackoff, enodeCertsMaxRetryBackoff = time.Millisecond, 4*time.Millisecond
+
+	genesisCfg, nodeKeys := getGenesisAndKeys(1, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	reachablePeer := newVersionedMockPeer(istanbul.Celo66)
+	unreachablePeer := newVersionedMockPeer(istanbul.Celo66)
+	broadcaster := &lockedPeersBroadcaster{peersBroadcaster: peersBroadcaster{peers: map[enode.ID]consensus.Peer{reachablePeer.Node().ID(): reachablePeer}}}
+	engine.SetBroadcaster(broadcaster)
+
+	provider := fixedValidatorConnSetProvider{engine.Address(): true}
+	var entries []*istanbul.AddressEntry
+	for _, peer := range []*versionedMockPeer{reachablePeer, unreachablePeer} {
+		address := crypto.PubkeyToAddress(*peer.Node().Pubkey())
+		provider[address] = true
+		entries = append(entries, &istanbul.AddressEntry{Address: address, Node: peer.Node(), Version: 1})
+	}
+	engine.SetValidatorConnSetProvider(provider)
+	if err := engine.valEnodeTable.UpsertVersionAndEnode(entries); err != nil {
+		t.Fatalf("Error in upserting val enode table entries.  Error: %v", err)
+	}
+
+	if err := engine.setAndShareUpdatedAnnounceVersion(engine.GetAnnounceVersion() + 1); err != nil {
+		t.Fatalf("Error in setting and sharing the announce version.  Error: %v", err)
+	}
+	waitForEnodeCertificate(t, reachablePeer)
+
+	// The unreachable validator is sent the enode certificate once it connects
+	broadcaster.connect(unreachablePeer)
+	waitForEnodeCertificate(t, unreachablePeer)
+
+	// Once every recipient has been reached, the retry stops
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		engine.enodeCertsRetryMu.Lock()
+		pending := engine.enodeCertsRetryCancel != nil
+		engine.enodeCertsRetryMu.Unlock()
+		if !pending {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Retry is still pending after delivery")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEnodeURLEncryptionECIESSharedInfo(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	encrypt := func() []byte {
+		encEnodeURLs, err := engine1.generateEncryptedEnodeURLs([]*enodeQuery{{recipientAddress: engine0.Address(), recipientPublicKey: &nodeKeys[0].PublicKey, enodeURL: engine1.SelfNode().URLv4()}})
+		if err != nil {
+			t.Fatalf("Error in generating encrypted enode urls.  Error: %v", err)
+		}
+		return encEnodeURLs[0].EncryptedEnodeURL
+	}
+
+	// The round trip succeeds with the same non-default shared info on both ends
+	for _, engine := range []*Backend{engine0, engine1} {
+		engine.config.AnnounceECIESKDFSharedInfo = "kdf-shared-info"
+		engine.config.AnnounceECIESMACSharedInfo = "mac-shared-info"
+	}
+	node, err := engine0.decryptEnodeURL(encrypt())
+	if err != nil {
+		t.Fatalf("error mismatch.  Want: nil, Have: %v", err)
+	}
+	if node.URLv4() != engine1.SelfNode().URLv4() {
+		t.Errorf("Incorrect decrypted enode url.  Want: %v, Have: %v", engine1.SelfNode().URLv4(), node.URLv4())
+	}
+
+	// Mismatched shared info fails the MAC check
+	engine1.config.AnnounceECIESKDFSharedInfo = ""
+	if _, err := engine0.decryptEnodeURL(encrypt()); err != errECIESParamsMismatch {
+		t.Errorf("error mismatch for a kdf shared info mismatch.  Want: %v, Have: %v", errECIESParamsMismatch, err)
+	}
+	engine1.config.AnnounceECIESKDFSharedInfo = "kdf-shared-info"
+	engine1.config.AnnounceECIESMACSharedInfo = "other-mac-shared-info"
+	if _, err := engine0.decryptEnodeURL(encrypt()); err != errECIESParamsMismatch {
+		t.Errorf("error mismatch for a mac shared info mismatch.  Want: %v, Have: %v", errECIESParamsMismatch, err)
+	}
+
+	// Overlong shared info is rejected when starting to announce
+	engine0.config.AnnounceECIESMACSharedInfo = string(make([]byte, maxECIESSharedInfoLength+1))
+	if err := validateECIESSharedInfo(engine0.config, engine0.logger); err != errECIESSharedInfoTooLong {
+		t.Errorf("error mismatch for an overlong shared info.  Want: %v, Have: %v", errECIESSharedInfoTooLong, err)
+	}
+}
+
+func TestHandleQueryEnodeECIESParamsMismatch(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	// engine1 encrypts with different shared info than engine0 decrypts with
+	engine1.config.AnnounceECIESKDFSharedInfo = "kdf-shared-info"
+
+	var regossipDecisionAddresses []common.Address
+	engine0.regossipQueryEnodeHook = func(address common.Address, regossiped bool, reason string) {
+		regossipDecisionAddresses = append(regossipDecisionAddresses, address)
+	}
+
+	encEnodeURLs, err := engine1.generateEncryptedEnodeURLs([]*enodeQuery{{recipientAddress: engine0.Address(), recipientPublicKey: &nodeKeys[0].PublicKey, enodeURL: engine1.SelfNode().URLv4()}})
+	if err != nil {
+		t.Fatalf("Error in generating encrypted enode urls.  Error: %v", err)
+	}
+	qeBytes, err := rlp.EncodeToBytes(&queryEnodeData{EncryptedEnodeURLs: encEnodeURLs, Version: getTimestamp(), Timestamp: getTimestamp()})
+	if err != nil {
+		t.Fatalf("Error in encoding query enode data.  Error: %v", err)
+	}
+	msg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Address: engine1.Address(), Msg: qeBytes}
+	if err := msg.Sign(engine1.Sign); err != nil {
+		t.Fatalf("Error in signing query enode message.  Error: %v", err)
+	}
+	payload, _ := msg.Payload()
+
+	// The relaying peer isn't dropped for the mismatch, and the message still goes through regossiping
+	if err := engine0.handleQueryEnodeMsg(engine1.Address(), newVersionedMockPeer(istanbul.Celo67), payload); err != nil {
+		t.Errorf("error mismatch.  Want: nil, Have: %v", err)
+	}
+	if want := []common.Address{engine1.Address()}; !reflect.DeepEqual(regossipDecisionAddresses, want) {
+		t.Errorf("Incorrect regossip decisions.  Want: %v, Have: %v", want, regossipDecisionAddresses)
+	}
+}
+
+func TestEncryptedEnodeURLCache(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	encrypt := func(enodeURL string) []byte {
+		encEnodeURLs, err := engine1.generateEncryptedEnodeURLs([]*enodeQuery{{recipientAddress: engine0.Address(), recipientPublicKey: &nodeKeys[0].PublicKey, enodeURL: enodeURL}})
+		if err != nil {
+			t.Fatalf("Error in generating encrypted enode urls.  Error: %v", err)
+		}
+		return encEnodeURLs[0].EncryptedEnodeURL
+	}
+	checkDecrypted := func(encEnodeURL []byte, want string) {
+		node, err := engine0.decryptEnodeURL(encEnodeURL)
+		if err != nil {
+			t.Fatalf("Error in decrypting enode url.  Error: %v", err)
+		}
+		if node.URLv4() != want {
+			t.Errorf("Incorrect decrypted enode url.  Want: %v, Have: %v", want, node.URLv4())
+		}
+	}
+
+	enodeURL := engine1.SelfNode().URLv4()
+
+	// Without the cache, every encryption is randomized
+	if bytes.Equal(encrypt(enodeURL), encrypt(enodeURL)) {
+		t.Errorf("Enode url encryptions should differ without the cache")
+	}
+
+	// With the cache, the ciphertext of an unchanged enode url is reused
+	engine1.config.AnnounceCacheEncryptedEnodeURLs = true
+	cached := encrypt(enodeURL)
+	if !bytes.Equal(cached, encrypt(enodeURL)) {
+		t.Errorf("Enode url encryptions should be reused with the cache")
+	}
+	checkDecrypted(cached, enodeURL)
+
+	// A changed enode url is encrypted again
+	changedEnodeURL := enode.NewV4(&nodeKeys[1].PublicKey, net.ParseIP("10.0.0.1"), 30303, 30303).URLv4()
+	changed := encrypt(changedEnodeURL)
+	if bytes.Equal(cached, changed) {
+		t.Errorf("A changed enode url should be encrypted again")
+	}
+	checkDecrypted(changed, changedEnodeURL)
+
+	// So is an enode url with changed ecies shared info
+	engine0.config.AnnounceECIESKDFSharedInfo = "kdf-shared-info"
+	engine1.config.AnnounceECIESKDFSharedInfo = "kdf-shared-info"
+	checkDecrypted(encrypt(enodeURL), enodeURL)
+}
+
+func TestCachedSignatures(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+	engine.config.AnnounceCacheSignatures = true
+
+	numSignCalls := 0
+	engine.signFnMu.Lock()
+	signFn := engine.signFn
+	engine.signFn = func(account accounts.Account, mimeType string, data []byte) ([]byte, error) {
+		numSignCalls++
+		return signFn(account, mimeType, data)
+	}
+	engine.signFnMu.Unlock()
+
+	// Repeated enode certificates with identical content are signed only once
+	selfID := engine.SelfNode().ID()
+	var signatures [][]byte
+	for i := 0; i < 3; i++ {
+		enodeCertMsgs, _, err := engine.generateEnodeCertificateMsgs(1)
+		if err != nil {
+			t.Fatalf("Error in generating enode certificate messages.  Error: %v", err)
+		}
+		signatures = append(signatures, enodeCertMsgs[selfID].Msg.Signature)
+	}
+	if numSignCalls != 1 {
+		t.Errorf("Incorrect number of signer calls.  Want: 1, Have: %d", numSignCalls)
+	}
+	if !bytes.Equal(signatures[0], signatures[2]) {
+		t.Errorf("Cached enode certificate signature should be reused")
+	}
+
+	// A changed version invalidates the cached signature
+	enodeCertMsgs, _, err := engine.generateEnodeCertificateMsgs(2)
+	if err != nil {
+		t.Fatalf("Error in generating enode certificate messages.  Error: %v", err)
+	}
+	if numSignCalls != 2 {
+		t.Errorf("Incorrect number of signer calls.  Want: 2, Have: %d", numSignCalls)
+	}
+	payload, err := enodeCertMsgs[selfID].Msg.Payload()
+	if err != nil {
+		t.Fatalf("Error in encoding enode certificate message.  Error: %v", err)
+	}
+	if err := new(istanbul.Message).FromPayload(payload, istanbul.GetSignatureAddress); err != nil {
+		t.Errorf("Invalid enode certificate signature.  Error: %v", err)
+	}
+
+	// Repeated version certificates for an unchanged version are signed only once
+	numSignCalls = 0
+	for i := 0; i < 3; i++ {
+		vc, err := engine.generateVersionCertificate(1)
+		if err != nil {
+			t.Fatalf("Error in generating version certificate.  Error: %v", err)
+		}
+		if err := vc.RecoverPublicKeyAndAddress(); err != nil || vc.Address != engine.Address() {
+			t.Errorf("Invalid version certificate signature.  Address: %v, err: %v", vc.Address, err)
+		}
+	}
+	if numSignCalls != 1 {
+		t.Errorf("Incorrect number of signer calls.  Want: 1, Have: %d", numSignCalls)
+	}
+	if _, err := engine.generateVersionCertificate(2); err != nil {
+		t.Fatalf("Error in generating version certificate.  Error: %v", err)
+	}
+	if numSignCalls != 2 {
+		t.Errorf("Incorrect number of signer calls.  Want: 2, Have: %d", numSignCalls)
+	}
+
+	// A new signer doesn't reuse the signatures of the previous one
+	newKey, _ := crypto.GenerateKey()
+	newAddress := crypto.PubkeyToAddress(newKey.PublicKey)
+	engine.Authorize(newAddress, newAddress, &newKey.PublicKey, DecryptFn(newKey), SignFn(newKey), SignBLSFn(newKey), SignHashFn(newKey))
+	vc, err := engine.generateVersionCertificate(2)
+	if err != nil {
+		t.Fatalf("Error in generating version certificate.  Error: %v", err)
+	}
+	if err := vc.RecoverPublicKeyAndAddress(); err != nil || vc.Address != newAddress {
+		t.Errorf("Version certificate not signed by the new signer.  Want: %v, Have: %v, err: %v", newAddress, vc.Address, err)
+	}
+}
+
+func BenchmarkGenerateEncryptedEnodeURLs(b *testing.B) {
+	b.Run("Uncached", func(b *testing.B) { benchmarkGenerateEncryptedEnodeURLs(b, false, 0) })
+	b.Run("UncachedBufferedRand", func(b *testing.B) { benchmarkGenerateEncryptedEnodeURLs(b, false, 4096) })
+	b.Run("Cached", func(b *testing.B) { benchmarkGenerateEncryptedEnodeURLs(b, true, 0) })
+}
+
+func benchmarkGenerateEncryptedEnodeURLs(b *testing.B, cached bool, randBufferSize int) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+	engine.config.AnnounceCacheEncryptedEnodeURLs = cached
+	engine.encryptionRand = newEncryptionRand(randBufferSize)
+
+	// A round of queries for 100 validators, with an unchanged enode url
+	selfKey, _ := crypto.GenerateKey()
+	enodeURL := enode.NewV4(&selfKey.PublicKey, net.ParseIP("10.0.0.1"), 30303, 30303).URLv4()
+	queries := make([]*enodeQuery, 100)
+	for i := range queries {
+		key, _ := crypto.GenerateKey()
+		queries[i] = &enodeQuery{recipientAddress: crypto.PubkeyToAddress(key.PublicKey), recipientPublicKey: &key.PublicKey, enodeURL: enodeURL}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := engine.generateEncryptedEnodeURLs(queries); err != nil {
+			b.Fatalf("Error in generating encrypted enode urls.  Error: %v", err)
+		}
+	}
+}
+
+func TestBufferedEncryptionRandCiphertextsDistinct(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+	engine.encryptionRand = newEncryptionRand(4096)
+
+	key, _ := crypto.GenerateKey()
+	enodeURL := enode.NewV4(&key.PublicKey, net.ParseIP("10.0.0.1"), 30303, 30303).URLv4()
+
+	// Concurrent encryptions of the same enode url for the same recipient must not share randomness
+	const numEncryptions = 200
+	var wg sync.WaitGroup
+	ciphertexts := make([][]byte, numEncryptions)
+	for i := range ciphertexts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			encEnodeURL, err := engine.encryptEnodeURL(engine.logger, &key.PublicKey, enodeURL)
+			if err != nil {
+				t.Errorf("Error in encrypting enode url.  Error: %v", err)
+				return
+			}
+			ciphertexts[i] = encEnodeURL
+		}(i)
+	}
+	wg.Wait()
+
+	// The ephemeral public key prefixes the ciphertext, followed by the IV
+	ephemeralKeys := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, ciphertext := range ciphertexts {
+		if seen[string(ciphertext)] {
+			t.Fatalf("Ciphertext was produced twice")
+		}
+		seen[string(ciphertext)] = true
+		ephemeralKey := string(ciphertext[:65])
+		if ephemeralKeys[ephemeralKey] {
+			t.Fatalf("Ephemeral key was reused")
+		}
+		ephemeralKeys[ephemeralKey] = true
+
+		decrypted, err := ecies.ImportECDSA(key).Decrypt(ciphertext, nil, nil)
+		if err != nil {
+			t.Fatalf("Error in decrypting enode url.  Error: %v", err)
+		}
+		if string(decrypted) != enodeURL {
+			t.Errorf("Incorrect decrypted enode url.  Want: %v, Have: %v", enodeURL, string(decrypted))
+		}
+	}
+}
+
+func TestVerifyQueryEnodePayload(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	queryEnodePayload := func(encEnodeURLs []*encryptedEnodeURL) []byte {
+		qeBytes, err := rlp.EncodeToBytes(&queryEnodeData{EncryptedEnodeURLs: encEnodeURLs, Version: getTimestamp(), Timestamp: getTimestamp()})
+		if err != nil {
+			t.Fatalf("Error in encoding query enode data.  Error: %v", err)
+		}
+		msg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Address: engine1.Address(), Msg: qeBytes}
+		if err := msg.Sign(engine1.Sign); err != nil {
+			t.Fatalf("Error in signing query enode message.  Error: %v", err)
+		}
+		payload, _ := msg.Payload()
+		return payload
+	}
+
+	encEnodeURLs, err := engine1.generateEncryptedEnodeURLs([]*enodeQuery{{recipientAddress: engine0.Address(), recipientPublicKey: &nodeKeys[0].PublicKey, enodeURL: engine1.SelfNode().URLv4()}})
+	if err != nil {
+		t.Fatalf("Error in generating encrypted enode urls.  Error: %v", err)
+	}
+	oversized := &encryptedEnodeURL{DestAddress: engine0.Address(), EncryptedEnodeURL: make([]byte, engine0.config.AnnounceMaxEncryptedEnodeURLLength+1)}
+
+	testCases := []struct {
+		name    string
+		payload []byte
+		wantErr error
+	}{
+		{"valid", queryEnodePayload(encEnodeURLs), nil},
+		{"oversized", queryEnodePayload([]*encryptedEnodeURL{oversized}), errEncryptedEnodeURLTooLong},
+		{"duplicate entries", queryEnodePayload([]*encryptedEnodeURL{encEnodeURLs[0], encEnodeURLs[0]}), errInvalidQueryEnodeMsg},
+	}
+	for _, tc := range testCases {
+		sender, err := engine0.VerifyQueryEnodePayload(tc.payload)
+		if err != tc.wantErr {
+			t.Errorf("%s: error mismatch.  Want: %v, Have: %v", tc.name, tc.wantErr, err)
+		}
+		if sender != engine1.Address() {
+			t.Errorf("%s: incorrect sender.  Want: %v, Have: %v", tc.name, engine1.Address(), sender)
+		}
+		// Verifying a payload doesn't process it
+		if engine0.checkIfMessageProcessedBySelf(tc.payload) {
+			t.Errorf("%s: verified payload was marked as processed", tc.name)
+		}
+	}
+
+	if _, err := engine0.VerifyQueryEnodePayload([]byte("not a payload")); err == nil {
+		t.Errorf("Malformed payload was verified")
+	}
+}
+
+func TestVerifyEnodeCertificatePayload(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+
+	enodeCertificatePayload := func(enodeURL string, address common.Address) []byte {
+		enodeCertificateBytes, err := rlp.EncodeToBytes(&istanbul.EnodeCertificate{EnodeURL: enodeURL, Version: 1})
+		if err != nil {
+			t.Fatalf("Error in encoding enode certificate.  Error: %v", err)
+		}
+		msg := &istanbul.Message{Code: istanbul.EnodeCertificateMsg, Address: address, Msg: enodeCertificateBytes}
+		if err := msg.Sign(engine.Sign); err != nil {
+			t.Fatalf("Error in signing enode certificate message.  Error: %v", err)
+		}
+		payload, _ := msg.Payload()
+		return payload
+	}
+
+	enodeURL := engine.SelfNode().URLv4()
+	address, enodeCertificate, err := engine.VerifyEnodeCertificatePayload(enodeCertificatePayload(enodeURL, engine.Address()))
+	if err != nil {
+		t.Fatalf("Error in verifying a valid enode certificate.  Error: %v", err)
+	}
+	if address != engine.Address() {
+		t.Errorf("Incorrect signer.  Want: %v, Have: %v", engine.Address(), address)
+	}
+	if enodeCertificate.EnodeURL != enodeURL || enodeCertificate.Version != 1 {
+		t.Errorf("Incorrect enode certificate.  Want: {%s 1}, Have: %v", enodeURL, enodeCertificate)
+	}
+
+	if _, _, err := engine.VerifyEnodeCertificatePayload(enodeCertificatePayload("enode://malformed", engine.Address())); !errors.Is(err, errMalformedEnodeCertificateURL) {
+		t.Errorf("error mismatch for a malformed enode url.  Want: %v, Have: %v", errMalformedEnodeCertificateURL, err)
+	}
+
+	// The message claims to be from another address than its signer
+	if _, _, err := engine.VerifyEnodeCertificatePayload(enodeCertificatePayload(enodeURL, common.HexToAddress("0x1"))); err != istanbul.ErrInvalidSigner {
+		t.Errorf("error mismatch for a bad signature.  Want: %v, Have: %v", istanbul.ErrInvalidSigner, err)
+	}
+
+	// Verifying a payload doesn't process it
+	if size := engine.valEnodeTable.Size(); size != 0 {
+		t.Errorf("Verifying enode certificates changed the val enode table.  Want size: 0, Have: %d", size)
+	}
+}
+
+func TestQueryEnodeMaxQueriesPerRound(t *testing.T) {
+	b := newBackend()
+	defer b.StopAnnouncing()
+	b.config.AnnounceMaxQueriesPerRound = 2
+
+	var entries []*istanbul.AddressEntry
+	for i := 0; i < 3; i++ {
+		key, _ := crypto.GenerateKey()
+		entries = append(entries, &istanbul.AddressEntry{Address: crypto.PubkeyToAddress(key.PublicKey), PublicKey: &key.PublicKey, HighestKnownVersion: 1})
+	}
+	if err := b.valEnodeTable.UpsertHighestKnownVersion(entries); err != nil {
+		t.Fatalf("Error in upserting val enode entries.  Error: %v", err)
+	}
+
+	firstRound, err := b.getQueryEnodeValEnodeEntries(true)
+	if err != nil {
+		t.Fatalf("Error in retrieving entries for queryEnode request.  Error: %v", err)
+	}
+	if len(firstRound) != 2 {
+		t.Fatalf("Incorrect number of queried validators in the first round.  Want: 2, Have: %d", len(firstRound))
+	}
+	if err := b.valEnodeTable.UpdateQueryEnodeStats(firstRound); err != nil {
+		t.Fatalf("Error in updating query enode stats.  Error: %v", err)
+	}
+
+	// The deferred validator is queried in the next round
+	secondRound, err := b.getQueryEnodeValEnodeEntries(true)
+	if err != nil {
+		t.Fatalf("Error in retrieving entries for queryEnode request.  Error: %v", err)
+	}
+	if len(secondRound) != 1 {
+		t.Fatalf("Incorrect number of queried validators in the second round.  Want: 1, Have: %d", len(secondRound))
+	}
+	for _, entry := range firstRound {
+		if entry.Address == secondRound[0].Address {
+			t.Errorf("Validator %v was queried in both rounds", entry.Address)
+		}
+	}
+
+	// Without backoff, validators with fewer query attempts are prioritized
+	thirdRound, err := b.getQueryEnodeValEnodeEntries(false)
+	if err != nil {
+		t.Fatalf("Error in retrieving entries for queryEnode request.  Error: %v", err)
+	}
+	if len(thirdRound) != 2 || thirdRound[0].Address != secondRound[0].Address {
+		t.Errorf("Validator with the fewest query attempts was not prioritized.  Want: %v first, Have: %v", secondRound[0].Address, thirdRound)
+	}
+}
+
+func TestAnnounceSignatureScheme(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	signedPayload := func(engine *Backend) []byte {
+		msg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Msg: []byte("query enode data"), Address: engine.Address()}
+		if err := engine.signQueryEnodeMsg(msg); err != nil {
+			t.Fatalf("Error in signing message.  Error: %v", err)
+		}
+		payload, err := msg.Payload()
+		if err != nil {
+			t.Fatalf("Error in getting message payload.  Error: %v", err)
+		}
+		return payload
+	}
+	verify := func(payload []byte) (common.Address, error) {
+		var msg istanbul.Message
+		err := msg.FromPayload(payload, engine1.queryEnodeSignatureAddressFn())
+		return msg.Address, err
+	}
+
+	// ECDSA is the default
+	if address, err := verify(signedPayload(engine0)); err != nil || address != engine0.Address() {
+		t.Errorf("Error in verifying ECDSA signed message.  Address: %v, Error: %v", address, err)
+	}
+
+	// Both schemes are verified regardless of the configured one
+	engine0.config.AnnounceSignatureScheme = istanbul.BLSScheme
+	payload := signedPayload(engine0)
+	if address, err := verify(payload); err != nil || address != engine0.Address() {
+		t.Errorf("Error in verifying BLS signed message.  Address: %v, Error: %v", address, err)
+	}
+	engine1.config.AnnounceSignatureScheme = istanbul.BLSScheme
+	if address, err := verify(payload); err != nil || address != engine0.Address() {
+		t.Errorf("Error in verifying BLS signed message.  Address: %v, Error: %v", address, err)
+	}
+	engine0.config.AnnounceSignatureScheme = istanbul.ECDSAScheme
+	if address, err := verify(signedPayload(engine0)); err != nil || address != engine0.Address() {
+		t.Errorf("Error in verifying ECDSA signed message with the BLS scheme set.  Address: %v, Error: %v", address, err)
+	}
+
+	// The signature doesn't verify for another address
+	var msg istanbul.Message
+	if err := rlp.DecodeBytes(payload, &msg); err != nil {
+		t.Fatalf("Error in decoding message.  Error: %v", err)
+	}
+	msg.Address = engine1.Address()
+	forgedPayload, _ := msg.Payload()
+	if _, err := verify(forgedPayload); err == nil {
+		t.Errorf("BLS signature was verified for another validator's address")
+	}
+
+	// Nor for an address without a known BLS key
+	msg.Address = common.HexToAddress("0x1234")
+	forgedPayload, _ = msg.Payload()
+	if _, err := verify(forgedPayload); err != errUnknownBLSPublicKey {
+		t.Errorf("Incorrect error for an unknown BLS public key.  Want: %v, Have: %v", errUnknownBLSPublicKey, err)
+	}
+}
+
+func TestAnnounceBLSSignatureOfRegisteredValidator(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+	engine.config.AnnounceSignatureScheme = istanbul.BLSScheme
+
+	// A registered validator that isn't elected
+	registeredKey, _ := crypto.GenerateKey()
+	_, registered, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, registeredKey)
+	defer registered.StopAnnouncing()
+	registered.config.AnnounceSignatureScheme = istanbul.BLSScheme
+	blsPrivateKey, _ := blscrypto.ECDSAToBLS(registeredKey)
+	blsPublicKey, _ := blscrypto.PrivateToPublic(blsPrivateKey)
+	lookups := 0
+	engine.registeredBLSPublicKeyFn = func(header *types.Header, address common.Address) (blscrypto.SerializedPublicKey, error) {
+		lookups++
+		if address != registered.Address() {
+			return blscrypto.SerializedPublicKey{}, errors.New("not registered")
+		}
+		return blsPublicKey, nil
+	}
+
+	msg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Msg: []byte("query enode data"), Address: registered.Address()}
+	if err := registered.signQueryEnodeMsg(msg); err != nil {
+		t.Fatalf("Error in signing message.  Error: %v", err)
+	}
+	payload, err := msg.Payload()
+	if err != nil {
+		t.Fatalf("Error in getting message payload.  Error: %v", err)
+	}
+	verify := func() (common.Address, error) {
+		var msg istanbul.Message
+		err := msg.FromPayload(payload, engine.queryEnodeSignatureAddressFn())
+		return msg.Address, err
+	}
+
+	// Its key isn't looked up while it's not in the validator conn set
+	provider := fixedValidatorConnSetProvider{engine.Address(): true}
+	engine.SetValidatorConnSetProvider(provider)
+	if _, err := verify(); err != errUnknownBLSPublicKey {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errUnknownBLSPublicKey, err)
+	}
+
+	if lookups != 0 {
+		t.Errorf("BLS public key was looked up for an address outside of the validator conn set")
+	}
+
+	// Once it is, its messages are verified with its registered key, which is looked up once per epoch
+	provider[registered.Address()] = true
+	engine.SetValidatorConnSetProvider(provider)
+	for i := 0; i < 2; i++ {
+		if address, err := verify(); err != nil || address != registered.Address() {
+			t.Errorf("Error in verifying BLS signed message of a registered validator.  Address: %v, Error: %v", address, err)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("Incorrect number of BLS public key lookups.  Want: 1, Have: %d", lookups)
+	}
+
+	// A node outside of the validator conn set only regossips messages, so it doesn't verify
+	// them while their address is on the regossip cooldown
+	engine.lastQueryEnodeGossipedMu.Lock()
+	engine.lastQueryEnodeGossiped[registered.Address()] = engine.newGossipTime()
+	engine.lastQueryEnodeGossipedMu.Unlock()
+	if address, err := verify(); err != nil || address != registered.Address() {
+		t.Errorf("Error in verifying BLS signed message on the regossip cooldown.  Address: %v, Error: %v", address, err)
+	}
+	delete(provider, engine.Address())
+	engine.SetValidatorConnSetProvider(provider)
+	if _, err := verify(); err != errQueryEnodeRegossipOnCooldown {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errQueryEnodeRegossipOnCooldown, err)
+	}
+}
+
+func TestQueryEnodeGossipSkippedWhenNotQuerying(t *testing.T) {
+	skipped := make(chan struct{}, 1)
+	handler := log.Root().GetHandler()
+	log.Root().SetHandler(log.FuncHandler(func(r *log.Record) error {
+		if r.Lvl == log.LvlDebug && r.Msg == "Skipping query enode gossip, this node should not query" {
+			select {
+			case skipped <- struct{}{}:
+			default:
+			}
+		}
+		return nil
+	}))
+	defer log.Root().SetHandler(handler)
+
+	b := newBackend()
+	defer b.StopAnnouncing()
+
+	// The announce thread hasn't checked if it should query yet, as if this node left the conn set,
+	// so a requested query enode gossip is skipped
+	b.startGossipQueryEnodeTask()
+	select {
+	case <-skipped:
+	case <-time.After(2 * time.Second):
+		t.Errorf("Skipped query enode gossip was not logged")
+	}
+}
+
+func TestQueryEnodeTimestampBounds(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	encEnodeURLs, err := engine1.generateEncryptedEnodeURLs([]*enodeQuery{{recipientAddress: engine0.Address(), recipientPublicKey: &nodeKeys[0].PublicKey, enodeURL: engine1.SelfNode().URLv4()}})
+	if err != nil {
+		t.Fatalf("Error in generating encrypted enode urls.  Error: %v", err)
+	}
+	queryEnodePayload := func(timestamp uint) []byte {
+		qeBytes, err := rlp.EncodeToBytes(&queryEnodeData{EncryptedEnodeURLs: encEnodeURLs, Version: getTimestamp(), Timestamp: timestamp})
+		if err != nil {
+			t.Fatalf("Error in encoding query enode data.  Error: %v", err)
+		}
+		msg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Address: engine1.Address(), Msg: qeBytes}
+		if err := msg.Sign(engine1.Sign); err != nil {
+			t.Fatalf("Error in signing query enode message.  Error: %v", err)
+		}
+		payload, _ := msg.Payload()
+		return payload
+	}
+
+	// The bounds are disabled by default
+	engine0.config.AnnounceMaxTimestampSkew = 300
+	engine0.config.AnnounceQueryEnodeMaxAge = 3600
+	maxSkew := uint(engine0.config.AnnounceMaxTimestampSkew)
+	maxAge := uint(engine0.config.AnnounceQueryEnodeMaxAge)
+	testCases := []struct {
+		name      string
+		timestamp uint
+		wantErr   error
+	}{
+		{"current", getTimestamp(), nil},
+		{"slightly ahead", getTimestamp() + maxSkew/2, nil},
+		{"slightly old", getTimestamp() - maxAge/2, nil},
+		{"too far in the future", getTimestamp() + 2*maxSkew, errInvalidQueryEnodeMsg},
+		{"too old", getTimestamp() - 2*maxAge, errInvalidQueryEnodeMsg},
+	}
+	for _, tc := range testCases {
+		if _, err := engine0.VerifyQueryEnodePayload(queryEnodePayload(tc.timestamp)); err != tc.wantErr {
+			t.Errorf("%s: error mismatch.  Want: %v, Have: %v", tc.name, tc.wantErr, err)
+		}
+	}
+
+	// The bounds can be disabled
+	engine0.config.AnnounceMaxTimestampSkew = 0
+	engine0.config.AnnounceQueryEnodeMaxAge = 0
+	for _, timestamp := range []uint{getTimestamp() + 2*maxSkew, getTimestamp() - 2*maxAge} {
+		if _, err := engine0.VerifyQueryEnodePayload(queryEnodePayload(timestamp)); err != nil {
+			t.Errorf("Error in verifying query enode message with disabled timestamp bounds.  Timestamp: %d, Error: %v", timestamp, err)
+		}
+	}
+}
+
+func TestSetAnnounceVersion(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(1, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	// Versions too far ahead of the current time are rejected
+	engine.config.AnnounceMaxVersionSkew = 300
+	maxSkew := uint(engine.config.AnnounceMaxVersionSkew)
+	if err := engine.SetAnnounceVersion(getTimestamp() + 2*maxSkew); err != errAnnounceVersionTooFarAhead {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errAnnounceVersionTooFarAhead, err)
+	}
+
+	// Ahead of the periodic updates, which use the current timestamp
+	version := getTimestamp() + maxSkew/2
+	if err := engine.SetAnnounceVersion(version); err != nil {
+		t.Fatalf("Error in setting the announce version.  Error: %v", err)
+	}
+	if have := engine.GetAnnounceVersion(); have != version {
+		t.Errorf("Incorrect announce version.  Want: %d, Have: %d", version, have)
+	}
+	enodeCertMsg := engine.RetrieveEnodeCertificateMsgMap()[engine.SelfNode().ID()]
+	if enodeCertMsg == nil {
+		t.Fatalf("Enode certificate was not generated for the announce version")
+	}
+	var enodeCertificate istanbul.EnodeCertificate
+	if err := rlp.DecodeBytes(enodeCertMsg.Msg.Msg, &enodeCertificate); err != nil || enodeCertificate.Version != version {
+		t.Errorf("Incorrect enode certificate version.  Want: %d, Have: %d, err: %v", version, enodeCertificate.Version, err)
+	}
+
+	// Regressions are rejected
+	for _, regressed := range []uint{version, version - 1} {
+		if err := engine.SetAnnounceVersion(regressed); err != errAnnounceVersionNotNewer {
+			t.Errorf("error mismatch for version %d.  Want: %v, Have: %v", regressed, errAnnounceVersionNotNewer, err)
+		}
+	}
+	if have := engine.GetAnnounceVersion(); have != version {
+		t.Errorf("Announce version changed after a rejected regression.  Want: %d, Have: %d", version, have)
+	}
+}
+
+func TestVersionCertificateRegossipAccounting(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(3, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	engine.announceVersionCertificatesRegossipedCounter = metrics.NewCounterForced()
+	engine.announceVersionCertificatesSkippedCooldownCounter = metrics.NewCounterForced()
+	engine.announceVersionCertificatesSelfCounter = metrics.NewCounterForced()
+
+	newEntry := func(key *ecdsa.PrivateKey, version uint) *vet.VersionCertificateEntry {
+		vc := &versionCertificate{Version: version}
+		if err := vc.Sign(func(data []byte) ([]byte, error) { return crypto.Sign(crypto.Keccak256(data), key) }); err != nil {
+			t.Fatalf("Error in signing version certificate.  Error: %v", err)
+		}
+		if err := vc.RecoverPublicKeyAndAddress(); err != nil {
+			t.Fatalf("Error in recovering version certificate address.  Error: %v", err)
+		}
+		return vc.Entry()
+	}
+
+	// Ahead of the version used by the announce thread for this node's own certificate
+	version := getTimestamp() + 10000
+	selfEntry := newEntry(nodeKeys[0], version)
+	cooldownEntry := newEntry(nodeKeys[1], version)
+	freshEntry := newEntry(nodeKeys[2], version)
+
+	// The last gossip for the cooldown entry is old enough for it to be skipped
+	engine.lastVersionCertificatesGossipedMu.Lock()
+	engine.lastVersionCertificatesGossiped[cooldownEntry.Address] = gossipTimeAgo(engine, 2*versionCertificateGossipCooldownDuration)
+	engine.lastVersionCertificatesGossipedMu.Unlock()
+
+	if err := engine.upsertAndGossipVersionCertificateEntries([]*vet.VersionCertificateEntry{selfEntry, cooldownEntry, freshEntry}, true); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+
+	if count := engine.announceVersionCertificatesRegossipedCounter.Count(); count != 1 {
+		t.Errorf("Incorrect regossiped count.  Want: 1, Have: %d", count)
+	}
+	if count := engine.announceVersionCertificatesSkippedCooldownCounter.Count(); count != 1 {
+		t.Errorf("Incorrect skipped cooldown count.  Want: 1, Have: %d", count)
+	}
+	if count := engine.announceVersionCertificatesSelfCounter.Count(); count != 1 {
+		t.Errorf("Incorrect self count.  Want: 1, Have: %d", count)
+	}
+}
+
+func TestShareChangedVersionCertificates(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(3, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	newEntry := func(key *ecdsa.PrivateKey, version uint) *vet.VersionCertificateEntry {
+		vc := &versionCertificate{Version: version}
+		if err := vc.Sign(func(data []byte) ([]byte, error) { return crypto.Sign(crypto.Keccak256(data), key) }); err != nil {
+			t.Fatalf("Error in signing version certificate.  Error: %v", err)
+		}
+		if err := vc.RecoverPublicKeyAndAddress(); err != nil {
+			t.Fatalf("Error in recovering version certificate address.  Error: %v", err)
+		}
+		return vc.Entry()
+	}
+	addressesOf := func(versionCertificates []*versionCertificate) map[common.Address]uint {
+		addresses := make(map[common.Address]uint)
+		for _, vc := range versionCertificates {
+			addresses[vc.Address] = vc.Version
+		}
+		return addresses
+	}
+
+	version := getTimestamp()
+	if err := engine.upsertAndGossipVersionCertificateEntries([]*vet.VersionCertificateEntry{newEntry(nodeKeys[1], version), newEntry(nodeKeys[2], version)}, true); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+
+	// A full share includes every entry of the table
+	fullShare, err := engine.getVersionCertificatesToShare(true)
+	if err != nil {
+		t.Fatalf("Error in getting the full share.  Error: %v", err)
+	}
+	allEntries, err := engine.versionCertificateTable.GetAll()
+	if err != nil {
+		t.Fatalf("Error in getting all version certificate entries.  Error: %v", err)
+	}
+	if len(fullShare) != len(allEntries) {
+		t.Errorf("Incorrect number of version certificates in the full share.  Want: %d, Have: %d", len(allEntries), len(fullShare))
+	}
+
+	// A delta share only includes the entries that changed since the previous share
+	changedAddress := crypto.PubkeyToAddress(nodeKeys[2].PublicKey)
+	if err := engine.upsertAndGossipVersionCertificateEntries([]*vet.VersionCertificateEntry{newEntry(nodeKeys[1], version), newEntry(nodeKeys[2], version+1)}, true); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+	deltaShare, err := engine.getVersionCertificatesToShare(false)
+	if err != nil {
+		t.Fatalf("Error in getting the delta share.  Error: %v", err)
+	}
+	if want, have := map[common.Address]uint{changedAddress: version + 1}, addressesOf(deltaShare); !reflect.DeepEqual(want, have) {
+		t.Errorf("Incorrect delta share.  Want: %v, Have: %v", want, have)
+	}
+
+	// Nothing changed since the delta share
+	if deltaShare, err = engine.getVersionCertificatesToShare(false); err != nil || len(deltaShare) != 0 {
+		t.Errorf("Incorrect delta share without changes.  Want: [], Have: %v, err: %v", addressesOf(deltaShare), err)
+	}
+}
+
+func TestEnodeCertificateAllowlist(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	if err := engine0.SetAnnounceVersion(getTimestamp() + 100); err != nil {
+		t.Fatalf("Error in setting the announce version.  Error: %v", err)
+	}
+	enodeCertMsg := engine0.RetrieveEnodeCertificateMsgMap()[engine0.SelfNode().ID()]
+	if enodeCertMsg == nil {
+		t.Fatalf("No enode certificate generated for engine0")
+	}
+	enodeCertMsgPayload, err := enodeCertMsg.Msg.Payload()
+	if err != nil {
+		t.Fatalf("Error in encoding the enode certificate message.  Error: %v", err)
+	}
+
+	// A sender that isn't allowlisted is rejected even though it's in the validator conn set
+	engine1.config.AnnounceEnodeCertificateAllowlist = []common.Address{engine1.Address()}
+	if err := engine1.handleEnodeCertificateMsg(nil, enodeCertMsgPayload); err != errEnodeCertificateNotAllowlisted {
+		t.Errorf("error mismatch for a disallowed address.  Want: %v, Have: %v", errEnodeCertificateNotAllowlisted, err)
+	}
+	if entries, err := engine1.GetValEnodeTableEntries([]common.Address{engine0.Address()}); err != nil || (entries[engine0.Address()] != nil && entries[engine0.Address()].Node != nil) {
+		t.Errorf("Val enode table entry upserted for a disallowed address.  Have: %v, err: %v", entries[engine0.Address()], err)
+	}
+
+	// An allowlisted sender is accepted
+	engine1.config.AnnounceEnodeCertificateAllowlist = []common.Address{engine0.Address()}
+	if err := engine1.handleEnodeCertificateMsg(nil, enodeCertMsgPayload); err != nil {
+		t.Errorf("Error in handling an enode certificate message from an allowed address.  Error: %v", err)
+	}
+	entries, err := engine1.GetValEnodeTableEntries([]common.Address{engine0.Address()})
+	if err != nil || entries[engine0.Address()] == nil {
+		t.Fatalf("Missing val enode table entry for an allowed address.  err: %v", err)
+	}
+	if have, want := entries[engine0.Address()].Version, engine0.GetAnnounceVersion(); have != want {
+		t.Errorf("Incorrect val enode table entry version.  Want: %d, Have: %d", want, have)
+	}
+}
+
+func TestEnodeCertificateMaxAge(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	maxAge := engine1.config.AnnounceEnodeCertificateMaxAge
+	enodeCertMsgPayload := func(version uint) []byte {
+		enodeCertMsgs, _, err := engine0.generateEnodeCertificateMsgs(version)
+		if err != nil {
+			t.Fatalf("Error in generating enode certificate messages.  Error: %v", err)
+		}
+		payload, err := enodeCertMsgs[engine0.SelfNode().ID()].Msg.Payload()
+		if err != nil {
+			t.Fatalf("Error in encoding the enode certificate message.  Error: %v", err)
+		}
+		return payload
+	}
+
+	// A certificate older than the maximum age is rejected
+	expiredVersion := getTimestamp() - uint(maxAge) - 60
+	if err := engine1.handleEnodeCertificateMsg(nil, enodeCertMsgPayload(expiredVersion)); err != errEnodeCertificateExpired {
+		t.Errorf("error mismatch for an expired certificate.  Want: %v, Have: %v", errEnodeCertificateExpired, err)
+	}
+	if entries, err := engine1.GetValEnodeTableEntries([]common.Address{engine0.Address()}); err != nil || (entries[engine0.Address()] != nil && entries[engine0.Address()].Node != nil) {
+		t.Errorf("Val enode table entry upserted for an expired certificate.  Have: %v, err: %v", entries[engine0.Address()], err)
+	}
+
+	// The same certificate is accepted with the check disabled
+	engine1.config.AnnounceEnodeCertificateMaxAge = 0
+	if err := engine1.handleEnodeCertificateMsg(nil, enodeCertMsgPayload(expiredVersion)); err != nil {
+		t.Errorf("Error in handling an enode certificate message without a maximum age.  Error: %v", err)
+	}
+
+	// A slightly old certificate is accepted
+	engine1.config.AnnounceEnodeCertificateMaxAge = maxAge
+	slightlyOldVersion := getTimestamp() - 600
+	if err := engine1.handleEnodeCertificateMsg(nil, enodeCertMsgPayload(slightlyOldVersion)); err != nil {
+		t.Errorf("Error in handling a slightly old enode certificate message.  Error: %v", err)
+	}
+	entries, err := engine1.GetValEnodeTableEntries([]common.Address{engine0.Address()})
+	if err != nil || entries[engine0.Address()] == nil {
+		t.Fatalf("Missing val enode table entry for a slightly old certificate.  err: %v", err)
+	}
+	if have := entries[engine0.Address()].Version; have != slightlyOldVersion {
+		t.Errorf("Incorrect val enode table entry version.  Want: %d, Have: %d", slightlyOldVersion, have)
+	}
+}
+
+// malleateSignature returns the malleated counterpart (r, N-s) of a signature, which recovers the same signer
+func malleateSignature(sig []byte) []byte {
+	malleated := common.CopyBytes(sig)
+	s := new(big.Int).Sub(crypto.S256().Params().N, new(big.Int).SetBytes(sig[32:64]))
+	copy(malleated[32:64], common.LeftPadBytes(s.Bytes(), 32))
+	malleated[64] ^= 1
+	return malleated
+}
+
+func TestRejectMalleatedAnnounceSignatures(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	// An enode certificate message with a malleated signature is rejected
+	enodeCertMsgs, _, err := engine0.generateEnodeCertificateMsgs(getTimestamp())
+	if err != nil {
+		t.Fatalf("Error in generating enode certificate messages.  Error: %v", err)
+	}
+	msg := enodeCertMsgs[engine0.SelfNode().ID()].Msg.Copy()
+	msg.Signature = malleateSignature(msg.Signature)
+	if signer, err := istanbul.GetSignatureAddress(mustPayloadNoSig(t, msg), msg.Signature); err != nil || signer != engine0.Address() {
+		t.Fatalf("The malleated signature should recover the same signer.  Want: %v, Have: %v, err: %v", engine0.Address(), signer, err)
+	}
+	payload, err := msg.Payload()
+	if err != nil {
+		t.Fatalf("Error in encoding the enode certificate message.  Error: %v", err)
+	}
+	if err := engine1.handleEnodeCertificateMsg(nil, payload); err != istanbul.ErrNonCanonicalSignature {
+		t.Errorf("error mismatch for a malleated enode certificate.  Want: %v, Have: %v", istanbul.ErrNonCanonicalSignature, err)
+	}
+
+	// So is the enode certificate of a validator handshake with a malleated signature
+	if _, err := engine1.verifyValidatorHandshakeMessage(mustPayloadNoSig(t, msg), msg.Signature); err != istanbul.ErrNonCanonicalSignature {
+		t.Errorf("error mismatch for a malleated validator handshake.  Want: %v, Have: %v", istanbul.ErrNonCanonicalSignature, err)
+	}
+
+	// So is a version certificate with a malleated signature
+	vc, err := engine0.generateVersionCertificate(getTimestamp())
+	if err != nil {
+		t.Fatalf("Error in generating version certificate.  Error: %v", err)
+	}
+	vc.Signature = malleateSignature(vc.Signature)
+	if err := vc.RecoverPublicKeyAndAddress(); err != istanbul.ErrNonCanonicalSignature {
+		t.Errorf("error mismatch for a malleated version certificate.  Want: %v, Have: %v", istanbul.ErrNonCanonicalSignature, err)
+	}
+}
+
+// mustPayloadNoSig returns the payload of the message that is signed
+func mustPayloadNoSig(t *testing.T, msg *istanbul.Message) []byte {
+	payload, err := msg.PayloadNoSig()
+	if err != nil {
+		t.Fatalf("Error in encoding the message without signature.  Error: %v", err)
+	}
+	return payload
+}
+
+// recordingHistogram is a histogram that records its updates regardless of whether metrics are enabled
+type recordingHistogram struct {
+	metrics.NilHistogram
+	values []int64
+}
+
+func (h *recordingHistogram) Update(v int64) { h.values = append(h.values, v) }
+
+// recordingTimer is a timer that records its updates regardless of whether metrics are enabled
+type recordingTimer struct {
+	metrics.NilTimer
+	durations []time.Duration
+}
+
+func (t *recordingTimer) Update(d time.Duration)      { t.durations = append(t.durations, d) }
+func (t *recordingTimer) UpdateSince(start time.Time) { t.Update(time.Since(start)) }
+
+func TestEnodeURLEncryptionTiming(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(3, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+
+	timer := &recordingTimer{}
+	engine0.announceEnodeURLEncryptionTimer = timer
+	histogram := &recordingHistogram{}
+	engine0.announceEncryptionRoundHistogram = histogram
+
+	enodeURL := engine0.SelfNode().URLv4()
+	queries := []*enodeQuery{
+		{recipientAddress: crypto.PubkeyToAddress(nodeKeys[1].PublicKey), recipientPublicKey: &nodeKeys[1].PublicKey, enodeURL: enodeURL},
+		{recipientAddress: crypto.PubkeyToAddress(nodeKeys[2].PublicKey), recipientPublicKey: &nodeKeys[2].PublicKey, enodeURL: enodeURL},
+	}
+	if _, err := engine0.generateEncryptedEnodeURLs(queries); err != nil {
+		t.Fatalf("Error in generating encrypted enode urls.  Error: %v", err)
+	}
+	// Rounds without destinations aren't recorded
+	if _, err := engine0.generateEncryptedEnodeURLs(nil); err != nil {
+		t.Fatalf("Error in generating encrypted enode urls.  Error: %v", err)
+	}
+
+	// Every destination's encryption is timed
+	if len(timer.durations) != len(queries) {
+		t.Fatalf("Incorrect number of timed encryptions.  Want: %d, Have: %d", len(queries), len(timer.durations))
+	}
+	var total time.Duration
+	for _, d := range timer.durations {
+		if d <= 0 {
+			t.Errorf("Incorrect encryption duration.  Want: > 0, Have: %v", d)
+		}
+		total += d
+	}
+
+	// And the round's total encryption time is at least their sum
+	if len(histogram.values) != 1 {
+		t.Fatalf("Incorrect number of recorded rounds.  Want: 1, Have: %d", len(histogram.values))
+	}
+	if have := time.Duration(histogram.values[0]); have < total {
+		t.Errorf("Incorrect round encryption time.  Want: >= %v, Have: %v", total, have)
+	}
+}
+
+func TestEncryptedEnodeURLsHistogram(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(3, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+
+	histogram := &recordingHistogram{}
+	engine0.announceEncryptedEnodeURLsHistogram = histogram
+
+	enodeURL := engine0.SelfNode().URLv4()
+	queries := []*enodeQuery{
+		{recipientAddress: crypto.PubkeyToAddress(nodeKeys[1].PublicKey), recipientPublicKey: &nodeKeys[1].PublicKey, enodeURL: enodeURL},
+		{recipientAddress: crypto.PubkeyToAddress(nodeKeys[2].PublicKey), recipientPublicKey: &nodeKeys[2].PublicKey, enodeURL: enodeURL},
+	}
+	if _, err := engine0.generateQueryEnodeMsg(getTimestamp(), queries); err != nil {
+		t.Fatalf("Error in generating a query enode message.  Error: %v", err)
+	}
+	// Messages without any queries aren't generated, and not recorded
+	if _, err := engine0.generateQueryEnodeMsg(getTimestamp(), nil); err != nil {
+		t.Fatalf("Error in generating an empty query enode message.  Error: %v", err)
+	}
+
+	if want := []int64{2}; !reflect.DeepEqual(histogram.values, want) {
+		t.Errorf("Incorrect recorded encrypted enode url counts.  Want: %v, Have: %v", want, histogram.values)
+	}
+}
+
+func TestEpochVersionEncoding(t *testing.T) {
+	// Versions are ordered by epoch, and by counter within an epoch
+	ordered := []uint{
+		encodeEpochVersion(0, 0),
+		encodeEpochVersion(0, 1),
+		encodeEpochVersion(0, math.MaxUint32),
+		encodeEpochVersion(1, 0),
+		encodeEpochVersion(1, 1),
+		encodeEpochVersion(2, 0),
+	}
+	for i := 1; i < len(ordered); i++ {
+		if ordered[i-1] >= ordered[i] {
+			t.Errorf("Incorrect version ordering at index %d.  Have: %d >= %d", i, ordered[i-1], ordered[i])
+		}
+	}
+
+	// Versions are ahead of timestamp versions, so that switching modes keeps versions increasing
+	if version := encodeEpochVersion(1, 0); version <= getTimestamp() {
+		t.Errorf("Epoch version is not ahead of the current timestamp.  Have: %d", version)
+	}
+
+	for _, test := range []struct {
+		epoch   uint64
+		counter uint32
+	}{{0, 0}, {0, 7}, {1, 0}, {12345, math.MaxUint32}} {
+		epoch, counter := decodeEpochVersion(encodeEpochVersion(test.epoch, test.counter))
+		if epoch != test.epoch || counter != test.counter {
+			t.Errorf("Incorrect decoded version.  Want: (%d, %d), Have: (%d, %d)", test.epoch, test.counter, epoch, counter)
+		}
+	}
+}
+
+func TestNextAnnounceVersionEpochCounter(t *testing.T) {
+	engine := newBackend()
+	// Stop the announce thread so that it doesn't update the announce version concurrently
+	engine.StopAnnouncing()
+	engine.config.AnnounceVersionMode = istanbul.EpochCounterVersion
+
+	epoch := istanbul.GetEpochNumber(engine.currentBlock().Number().Uint64(), engine.config.Epoch)
+	// The version 0 is never set, so the counter of the genesis epoch starts at 1
+	firstCounter := uint32(0)
+	if epoch == 0 {
+		firstCounter = 1
+	}
+	for counter := firstCounter; counter < firstCounter+3; counter++ {
+		version := engine.nextAnnounceVersion()
+		if want := encodeEpochVersion(epoch, counter); version != want {
+			t.Errorf("Incorrect next announce version.  Want: %d, Have: %d", want, version)
+		}
+		if err := engine.SetAnnounceVersion(version); err != nil {
+			t.Fatalf("Error in setting the announce version.  Error: %v", err)
+		}
+	}
+
+	// Versions of a later epoch are rejected
+	if err := engine.SetAnnounceVersion(encodeEpochVersion(epoch+1, 0)); err != errAnnounceVersionTooFarAhead {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errAnnounceVersionTooFarAhead, err)
+	}
+}
+
+func TestNextAnnounceVersionEpochCounterAfterRestart(t *testing.T) {
+	engine := newBackend()
+	// Stop the announce thread so that it doesn't update the announce version concurrently
+	engine.StopAnnouncing()
+	engine.config.AnnounceVersionMode = istanbul.EpochCounterVersion
+
+	// A restarted node has no announce version in memory, but its own version certificate
+	// from before the restart is still in the version certificate table
+	epoch := istanbul.GetEpochNumber(engine.currentBlock().Number().Uint64(), engine.config.Epoch)
+	persisted := encodeEpochVersion(epoch, 5)
+	vc, err := engine.generateVersionCertificate(persisted)
+	if err != nil {
+		t.Fatalf("Error in generating version certificate.  Error: %v", err)
+	}
+	if _, err := engine.versionCertificateTable.Upsert([]*vet.VersionCertificateEntry{vc.Entry()}); err != nil {
+		t.Fatalf("Error in upserting version certificate.  Error: %v", err)
+	}
+	if engine.GetAnnounceVersion() >= persisted {
+		t.Fatalf("Announce version is not older than the persisted version.  announceVersion: %d", engine.GetAnnounceVersion())
+	}
+
+	if version, want := engine.nextAnnounceVersion(), encodeEpochVersion(epoch, 6); version != want {
+		t.Errorf("Incorrect next announce version.  Want: %d, Have: %d", want, version)
+	}
+}
+
+func TestNextAnnounceVersionEpochCounterAfterReplicaPromotion(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	// Stop the announce thread so that it doesn't update the announce version concurrently
+	engine.StopAnnouncing()
+	engine.config.AnnounceVersionMode = istanbul.EpochCounterVersion
+	if err := engine.StopValidating(); err != nil {
+		t.Fatalf("Error in stopping validating.  Error: %v", err)
+	}
+
+	// A replica receives the version certificate of its primary, which shares its address
+	epoch := istanbul.GetEpochNumber(engine.currentBlock().Number().Uint64(), engine.config.Epoch)
+	primaryVersion := encodeEpochVersion(epoch, 5)
+	vc := &versionCertificate{Version: primaryVersion}
+	if err := vc.Sign(func(data []byte) ([]byte, error) { return crypto.Sign(crypto.Keccak256(data), nodeKeys[0]) }); err != nil {
+		t.Fatalf("Error in signing version certificate.  Error: %v", err)
+	}
+	certsBytes, err := rlp.EncodeToBytes([]*versionCertificate{vc})
+	if err != nil {
+		t.Fatalf("Error in encoding version certificates.  Error: %v", err)
+	}
+	remoteAddress := crypto.PubkeyToAddress(nodeKeys[1].PublicKey)
+	msg := &istanbul.Message{Code: istanbul.VersionCertificatesMsg, Address: remoteAddress, Msg: certsBytes}
+	if err := msg.Sign(engine.Sign); err != nil {
+		t.Fatalf("Error in signing version certificates message.  Error: %v", err)
+	}
+	payload, _ := msg.Payload()
+	if err := engine.handleVersionCertificatesMsg(remoteAddress, newVersionedMockPeer(istanbul.Celo67), payload); err != nil {
+		t.Fatalf("Error in handling version certificates message.  Error: %v", err)
+	}
+	if engine.GetAnnounceVersion() >= primaryVersion {
+		t.Fatalf("Announce version is not older than the primary's version.  announceVersion: %d", engine.GetAnnounceVersion())
+	}
+
+	// Once promoted, its first version is newer than the primary's, even without the stored certificate
+	if version, want := engine.nextAnnounceVersion(), encodeEpochVersion(epoch, 6); version != want {
+		t.Errorf("Incorrect next announce version.  Want: %d, Have: %d", want, version)
+	}
+	if err := engine.versionCertificateTable.Remove(engine.Address()); err != nil {
+		t.Fatalf("Error in removing version certificate.  Error: %v", err)
+	}
+	if version, want := engine.nextAnnounceVersion(), encodeEpochVersion(epoch, 6); version != want {
+		t.Errorf("Incorrect next announce version without the stored certificate.  Want: %d, Have: %d", want, version)
+	}
+}
+
+func TestPruneVersionCertificates(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(3, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	newEntry := func(key *ecdsa.PrivateKey, version uint) *vet.VersionCertificateEntry {
+		vc := &versionCertificate{Version: version}
+		if err := vc.Sign(func(data []byte) ([]byte, error) { return crypto.Sign(crypto.Keccak256(data), key) }); err != nil {
+			t.Fatalf("Error in signing version certificate.  Error: %v", err)
+		}
+		if err := vc.RecoverPublicKeyAndAddress(); err != nil {
+			t.Fatalf("Error in recovering version certificate address.  Error: %v", err)
+		}
+		return vc.Entry()
+	}
+
+	oldAddress := crypto.PubkeyToAddress(nodeKeys[1].PublicKey)
+	recentAddress := crypto.PubkeyToAddress(nodeKeys[2].PublicKey)
+	now := getTimestamp()
+	if err := engine.upsertAndGossipVersionCertificateEntries([]*vet.VersionCertificateEntry{newEntry(nodeKeys[1], now-3600), newEntry(nodeKeys[2], now)}, true); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+	valEnodeEntriesBefore, err := engine.valEnodeTable.GetValEnodes(nil)
+	if err != nil {
+		t.Fatalf("Error in getting val enode table entries.  Error: %v", err)
+	}
+
+	// Versions aren't timestamps in epoch+counter version mode, so nothing is pruned
+	engine.config.AnnounceVersionMode = istanbul.EpochCounterVersion
+	if _, err := engine.PruneVersionCertificates(10 * time.Minute); err != errPruneByAgeUnsupported {
+		t.Errorf("error mismatch in epoch+counter version mode.  Want: %v, Have: %v", errPruneByAgeUnsupported, err)
+	}
+	if _, err := engine.versionCertificateTable.Get(oldAddress); err != nil {
+		t.Errorf("Old version certificate was pruned in epoch+counter version mode.  err: %v", err)
+	}
+	engine.config.AnnounceVersionMode = istanbul.TimestampVersion
+
+	numPruned, err := engine.PruneVersionCertificates(10 * time.Minute)
+	if err != nil {
+		t.Fatalf("Error in pruning version certificates.  Error: %v", err)
+	}
+	if numPruned != 1 {
+		t.Errorf("Incorrect number of pruned version certificates.  Want: 1, Have: %d", numPruned)
+	}
+	if _, err := engine.versionCertificateTable.Get(oldAddress); err != enodesdb.ErrNotFound {
+		t.Errorf("Old version certificate was not pruned.  err: %v", err)
+	}
+	if _, err := engine.versionCertificateTable.Get(recentAddress); err != nil {
+		t.Errorf("Recent version certificate was pruned.  err: %v", err)
+	}
+
+	// The val enode table is unaffected
+	valEnodeEntriesAfter, err := engine.valEnodeTable.GetValEnodes(nil)
+	if err != nil {
+		t.Fatalf("Error in getting val enode table entries.  Error: %v", err)
+	}
+	if len(valEnodeEntriesBefore) != len(valEnodeEntriesAfter) {
+		t.Errorf("Incorrect number of val enode table entries.  Want: %d, Have: %d", len(valEnodeEntriesBefore), len(valEnodeEntriesAfter))
+	}
+	for address, before := range valEnodeEntriesBefore {
+		if after := valEnodeEntriesAfter[address]; after == nil || after.HighestKnownVersion != before.HighestKnownVersion {
+			t.Errorf("Val enode table entry changed.  Before: %v, After: %v", before, after)
+		}
+	}
+}
+
+func TestHandleQueryEnodeDecryptionKeyUnavailable(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	// The account of engine0 is locked
+	engine0.signFnMu.Lock()
+	engine0.decryptFn = func(accounts.Account, []byte, []byte, []byte) ([]byte, error) {
+		return nil, accounts.NewAuthNeededError("password or unlock")
+	}
+	engine0.signFnMu.Unlock()
+
+	var regossipDecisionAddresses []common.Address
+	engine0.regossipQueryEnodeHook = func(address common.Address, regossiped bool, reason string) {
+		regossipDecisionAddresses = append(regossipDecisionAddresses, address)
+	}
+
+	encEnodeURLs, err := engine1.generateEncryptedEnodeURLs([]*enodeQuery{{recipientAddress: engine0.Address(), recipientPublicKey: &nodeKeys[0].PublicKey, enodeURL: engine1.SelfNode().URLv4()}})
+	if err != nil {
+		t.Fatalf("Error in generating encrypted enode urls.  Error: %v", err)
+	}
+	qeBytes, err := rlp.EncodeToBytes(&queryEnodeData{EncryptedEnodeURLs: encEnodeURLs, Version: getTimestamp(), Timestamp: getTimestamp()})
+	if err != nil {
+		t.Fatalf("Error in encoding query enode data.  Error: %v", err)
+	}
+	msg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Address: engine1.Address(), Msg: qeBytes}
+	if err := msg.Sign(engine1.Sign); err != nil {
+		t.Fatalf("Error in signing query enode message.  Error: %v", err)
+	}
+	payload, _ := msg.Payload()
+
+	// The sender isn't penalized for the locked account, and the message still goes through regossiping
+	if err := engine0.handleQueryEnodeMsg(engine1.Address(), newVersionedMockPeer(istanbul.Celo67), payload); err != nil {
+		t.Errorf("error mismatch.  Want: nil, Have: %v", err)
+	}
+	if want := []common.Address{engine1.Address()}; !reflect.DeepEqual(regossipDecisionAddresses, want) {
+		t.Errorf("Incorrect regossip decisions.  Want: %v, Have: %v", want, regossipDecisionAddresses)
+	}
+
+	// An unknown account is unavailable as well
+	engine0.signFnMu.Lock()
+	engine0.decryptFn = func(accounts.Account, []byte, []byte, []byte) ([]byte, error) {
+		return nil, accounts.ErrUnknownAccount
+	}
+	engine0.signFnMu.Unlock()
+	if _, err := engine0.decryptEnodeURL(encEnodeURLs[0].EncryptedEnodeURL); !errors.Is(err, errDecryptionKeyUnavailable) {
+		t.Errorf("error mismatch for an unknown account.  Want: %v, Have: %v", errDecryptionKeyUnavailable, err)
+	}
+}
+
+func TestOwnVersionCertificate(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(1, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	version := getTimestamp() + 100
+	if err := engine.SetAnnounceVersion(version); err != nil {
+		t.Fatalf("Error in setting announce version.  Error: %v", err)
+	}
+
+	vc, err := engine.OwnVersionCertificate()
+	if err != nil {
+		t.Fatalf("error mismatch.  Want: nil, Have: %v", err)
+	}
+	if vc.Version != version || vc.Address != engine.Address() {
+		t.Errorf("Unexpected own version certificate.  Want: version %v of %v, Have: version %v of %v", version, engine.Address(), vc.Version, vc.Address)
+	}
+	// The signature is this node's
+	recovered := &versionCertificate{Version: vc.Version, Signature: vc.Signature}
+	if err := recovered.RecoverPublicKeyAndAddress(); err != nil || recovered.Address != engine.Address() {
+		t.Errorf("Unexpected signer.  Want: %v, Have: %v (err: %v)", engine.Address(), recovered.Address, err)
+	}
+
+	// The RPC encodes the public key
+	info := vc.Info()
+	if want := crypto.FromECDSAPub(&nodeKeys[0].PublicKey); !bytes.Equal(info.PublicKey, want) {
+		t.Errorf("Unexpected public key.  Want: %x, Have: %x", want, []byte(info.PublicKey))
+	}
+
+	// The last generated version certificate is returned if the table has no entry for this node
+	if err := engine.versionCertificateTable.Remove(engine.Address()); err != nil {
+		t.Fatalf("Error in removing version certificate.  Error: %v", err)
+	}
+	if vc, err := engine.OwnVersionCertificate(); err != nil || vc.Version != version {
+		t.Errorf("Unexpected last generated version certificate.  Want: version %v, Have: %v (err: %v)", version, vc, err)
+	}
+}
+
+func TestVersionHistory(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	depth := 3
+	engine.config.AnnounceVersionHistoryDepth = uint64(depth)
+
+	newEntry := func(version uint) *vet.VersionCertificateEntry {
+		vc := &versionCertificate{Version: version}
+		if err := vc.Sign(func(data []byte) ([]byte, error) { return crypto.Sign(crypto.Keccak256(data), nodeKeys[1]) }); err != nil {
+			t.Fatalf("Error in signing version certificate.  Error: %v", err)
+		}
+		if err := vc.RecoverPublicKeyAndAddress(); err != nil {
+			t.Fatalf("Error in recovering version certificate address.  Error: %v", err)
+		}
+		return vc.Entry()
+	}
+
+	start := time.Now()
+	version := getTimestamp()
+	for i := uint(0); i < 5; i++ {
+		if err := engine.upsertAndGossipVersionCertificateEntries([]*vet.VersionCertificateEntry{newEntry(version + i)}, true); err != nil {
+			t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+		}
+	}
+	// Versions that aren't newer than the stored one aren't recorded
+	if err := engine.upsertAndGossipVersionCertificateEntries([]*vet.VersionCertificateEntry{newEntry(version)}, true); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+
+	history := engine.GetVersionHistory(crypto.PubkeyToAddress(nodeKeys[1].PublicKey))
+	var versions []uint
+	for _, entry := range history {
+		versions = append(versions, entry.Version)
+		if entry.ReceivedAt.Before(start) {
+			t.Errorf("Incorrect receipt time of version %d.  Have: %v, started at: %v", entry.Version, entry.ReceivedAt, start)
+		}
+	}
+	if want := []uint{version + 2, version + 3, version + 4}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("Incorrect version history.  Want: %v, Have: %v", want, versions)
+	}
+}
+
+// newQueryEnodePayload returns the payload of a query enode message from sender that claims
+// to be from address, with an encrypted enode url intended for recipient.
+func newQueryEnodePayload(sender, recipient *Backend, recipientKey *ecdsa.PrivateKey, address common.Address, version uint) ([]byte, error) {
+	encEnodeURLs, err := sender.generateEncryptedEnodeURLs([]*enodeQuery{{recipientAddress: recipient.Address(), recipientPublicKey: &recipientKey.PublicKey, enodeURL: sender.SelfNode().URLv4()}})
+	if err != nil {
+		return nil, err
+	}
+	qeBytes, err := rlp.EncodeToBytes(&queryEnodeData{EncryptedEnodeURLs: encEnodeURLs, Version: version, Timestamp: getTimestamp()})
+	if err != nil {
+		return nil, err
+	}
+	msg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Address: address, Msg: qeBytes}
+	if err := msg.Sign(sender.Sign); err != nil {
+		return nil, err
+	}
+	return msg.Payload()
+}
+
+func TestLightweightQueryEnodeRegossip(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(3, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	var regossipDecisionAddresses []common.Address
+	engine0.regossipQueryEnodeHook = func(address common.Address, regossiped bool, reason string) {
+		regossipDecisionAddresses = append(regossipDecisionAddresses, address)
+	}
+
+	// engine1 signs a message claiming to be from the third validator
+	claimedAddress := crypto.PubkeyToAddress(nodeKeys[2].PublicKey)
+	payload, err := newQueryEnodePayload(engine1, engine0, nodeKeys[0], claimedAddress, getTimestamp())
+	if err != nil {
+		t.Fatalf("Error in generating query enode payload.  Error: %v", err)
+	}
+
+	// Validators always verify the signature, even with the option set
+	engine0.config.AnnounceLightweightQueryEnodeRegossip = true
+	if err := engine0.handleQueryEnodeMsg(engine1.Address(), nil, payload); err != istanbul.ErrInvalidSigner {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", istanbul.ErrInvalidSigner, err)
+	}
+
+	// A node that isn't a validator regossips messages without decoding their content, but still
+	// verifies their signer
+	engine0.config.Validator = false
+	payload, err = newQueryEnodePayload(engine1, engine0, nodeKeys[0], claimedAddress, getTimestamp()+1)
+	if err != nil {
+		t.Fatalf("Error in generating query enode payload.  Error: %v", err)
+	}
+	if err := engine0.handleQueryEnodeMsg(engine1.Address(), nil, payload); err != istanbul.ErrInvalidSigner {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", istanbul.ErrInvalidSigner, err)
+	}
+	if len(regossipDecisionAddresses) != 0 {
+		t.Errorf("Forged message was regossiped.  Regossip decisions: %v", regossipDecisionAddresses)
+	}
+
+	// The signer must be within the validator connection set
+	outsiderKey, _ := crypto.GenerateKey()
+	outsiderAddress := crypto.PubkeyToAddress(outsiderKey.PublicKey)
+	msg := &istanbul.Message{Code: istanbul.QueryEnodeMsg, Address: outsiderAddress, Msg: []byte("query enode data")}
+	if err := msg.Sign(func(data []byte) ([]byte, error) { return crypto.Sign(crypto.Keccak256(data), outsiderKey) }); err != nil {
+		t.Fatalf("Error in signing message.  Error: %v", err)
+	}
+	payload, _ = msg.Payload()
+	if err := engine0.handleQueryEnodeMsg(engine1.Address(), nil, payload); err != errUnauthorizedAnnounceMessage {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errUnauthorizedAnnounceMessage, err)
+	}
+
+	// A genuine message is regossiped
+	_, engine2, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[2])
+	defer engine2.StopAnnouncing()
+	payload, err = newQueryEnodePayload(engine2, engine0, nodeKeys[0], claimedAddress, getTimestamp()+2)
+	if err != nil {
+		t.Fatalf("Error in generating query enode payload.  Error: %v", err)
+	}
+	if err := engine0.handleQueryEnodeMsg(engine2.Address(), nil, payload); err != nil {
+		t.Errorf("error mismatch.  Want: nil, Have: %v", err)
+	}
+	if want := []common.Address{claimedAddress}; !reflect.DeepEqual(regossipDecisionAddresses, want) {
+		t.Errorf("Genuine message wasn't regossiped.  Want: %v, Have: %v", want, regossipDecisionAddresses)
+	}
+
+	// Each delivering peer is limited to twice the size of the validator connection set per cooldown period
+	var reasons []string
+	engine0.regossipQueryEnodeHook = func(address common.Address, regossiped bool, reason string) {
+		reasons = append(reasons, reason)
+	}
+	peer := newVersionedMockPeer(istanbul.Celo67)
+	for i := 0; i < 7; i++ {
+		payload, err := newQueryEnodePayload(engine1, engine0, nodeKeys[0], engine1.Address(), getTimestamp()+3+uint(i))
+		if err != nil {
+			t.Fatalf("Error in generating query enode payload.  Error: %v", err)
+		}
+		if err := engine0.handleQueryEnodeMsg(engine1.Address(), peer, payload); err != nil {
+			t.Errorf("error mismatch.  Want: nil, Have: %v", err)
+		}
+	}
+	if want := []string{"", "", "", "", "", "", "rate limit"}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("Incorrect regossip reasons.  Want: %v, Have: %v", want, reasons)
+	}
+	// Other peers have their own budget
+	reasons = nil
+	payload, err = newQueryEnodePayload(engine1, engine0, nodeKeys[0], engine1.Address(), getTimestamp()+10)
+	if err != nil {
+		t.Fatalf("Error in generating query enode payload.  Error: %v", err)
+	}
+	otherKey, _ := crypto.GenerateKey()
+	otherPeer := consensustest.NewMockPeer(enode.NewV4(&otherKey.PublicKey, nil, 0, 0), p2p.AnyPurpose)
+	if err := engine0.handleQueryEnodeMsg(engine1.Address(), otherPeer, payload); err != nil {
+		t.Errorf("error mismatch.  Want: nil, Have: %v", err)
+	}
+	if want := []string{""}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("Incorrect regossip reasons for another peer.  Want: %v, Have: %v", want, reasons)
+	}
+
+	// Messages that aren't query enode messages are still rejected
+	msg = &istanbul.Message{Code: istanbul.VersionCertificatesMsg, Address: engine1.Address(), Msg: []byte{}}
+	if err := msg.Sign(engine1.Sign); err != nil {
+		t.Fatalf("Error in signing message.  Error: %v", err)
+	}
+	payload, _ = msg.Payload()
+	if err := engine0.handleQueryEnodeMsg(engine1.Address(), nil, payload); err != errInvalidQueryEnodeMsg {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errInvalidQueryEnodeMsg, err)
+	}
+}
+
+func BenchmarkHandleQueryEnodeMsg(b *testing.B) {
+	b.Run("Full", func(b *testing.B) { benchmarkHandleQueryEnodeMsg(b, false, false) })
+	b.Run("Lightweight", func(b *testing.B) { benchmarkHandleQueryEnodeMsg(b, true, false) })
+	b.Run("FullForgedAddress", func(b *testing.B) { benchmarkHandleQueryEnodeMsg(b, false, true) })
+	b.Run("LightweightForgedAddress", func(b *testing.B) { benchmarkHandleQueryEnodeMsg(b, true, true) })
+}
+
+// benchmarkHandleQueryEnodeMsg benchmarks handling query enode messages from engine1, which claim
+// to be from the third validator if forged.  Forged messages are rejected on both paths.
+func benchmarkHandleQueryEnodeMsg(b *testing.B, lightweight, forged bool) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(3, true)
+	_, engine0, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine0.StopAnnouncing()
+	_, engine1, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	defer engine1.StopAnnouncing()
+
+	// engine0 acts as a relay that isn't a validator
+	engine0.config.Validator = false
+	engine0.config.AnnounceLightweightQueryEnodeRegossip = lightweight
+
+	address, wantErr := engine1.Address(), error(nil)
+	if forged {
+		address = crypto.PubkeyToAddress(nodeKeys[2].PublicKey)
+		wantErr = istanbul.ErrInvalidSigner
+	}
+
+	// Every message must be distinct, so that it isn't dropped as already processed
+	payloads := make([][]byte, b.N)
+	for i := range payloads {
+		payload, err := newQueryEnodePayload(engine1, engine0, nodeKeys[0], address, getTimestamp()+uint(i))
+		if err != nil {
+			b.Fatalf("Error in generating query enode payload.  Error: %v", err)
+		}
+		payloads[i] = payload
+	}
+
+	b.ResetTimer()
+	for _, payload := range payloads {
+		if err := engine0.handleQueryEnodeMsg(engine1.Address(), nil, payload); err != wantErr {
+			b.Fatalf("error mismatch.  Want: %v, Have: %v", wantErr, err)
+		}
+	}
+}
+
+func TestFilteredVersionCertificateTable(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+
+	// The signatures are fake, so the version certificates are told apart by them
+	var entries []*vet.VersionCertificateEntry
+	signatureAddresses := make(map[string]common.Address)
+	for i := 0; i < 3; i++ {
+		key, _ := crypto.GenerateKey()
+		address := crypto.PubkeyToAddress(key.PublicKey)
+		signature := []byte{byte(i)}
+		entries = append(entries, &vet.VersionCertificateEntry{Address: address, PublicKey: &key.PublicKey, Version: 1, Signature: signature})
+		signatureAddresses[string(signature)] = address
+	}
+	if _, err := engine.versionCertificateTable.Upsert(entries); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+
+	sentAddresses := func(peer *versionedMockPeer) []common.Address {
+		if err := engine.sendVersionCertificateTable(peer); err != nil {
+			t.Fatalf("Error in sending version certificate table.  Error: %v", err)
+		}
+		var msg istanbul.Message
+		if err := msg.FromPayload(peer.waitForSend(t), nil); err != nil {
+			t.Fatalf("Error in decoding version certificates message.  Error: %v", err)
+		}
+		var versionCertificates []*versionCertificate
+		if err := rlp.DecodeBytes(msg.Msg, &versionCertificates); err != nil {
+			t.Fatalf("Error in decoding version certificates.  Error: %v", err)
+		}
+		addresses := make([]common.Address, len(versionCertificates))
+		for i, vc := range versionCertificates {
+			addresses[i] = signatureAddresses[string(vc.Signature)]
+		}
+		return addresses
+	}
+
+	// Without a filter, the full table is sent
+	if sent := sentAddresses(newVersionedMockPeer(istanbul.Celo66)); len(sent) != 3 {
+		t.Errorf("Incorrect number of sent version certificates.  Want: 3, Have: %d", len(sent))
+	}
+
+	// Only the requested version certificates are sent to a filtered peer, and unknown addresses are skipped
+	filteredPeer := newVersionedMockPeer(istanbul.Celo66)
+	unknownAddress := common.HexToAddress("0x1")
+	api := &API{istanbul: engine}
+	if _, err := api.SetVersionCertificateTableRequest(filteredPeer.Node().URLv4(), []common.Address{entries[2].Address, unknownAddress, entries[0].Address}); err != nil {
+		t.Fatalf("Error in setting version certificate table request.  Error: %v", err)
+	}
+	if want, have := []common.Address{entries[2].Address, entries[0].Address}, sentAddresses(filteredPeer); !reflect.DeepEqual(want, have) {
+		t.Errorf("Incorrect sent version certificates.  Want: %v, Have: %v", want, have)
+	}
+
+	// Peers without a request still get the full table
+	if sent := sentAddresses(newVersionedMockPeer(istanbul.Celo66)); len(sent) != 3 {
+		t.Errorf("Incorrect number of sent version certificates.  Want: 3, Have: %d", len(sent))
+	}
+
+	// So does the filtered peer once its request is cleared
+	if _, err := api.SetVersionCertificateTableRequest(filteredPeer.Node().URLv4(), nil); err != nil {
+		t.Fatalf("Error in clearing version certificate table request.  Error: %v", err)
+	}
+	if sent := sentAddresses(filteredPeer); len(sent) != 3 {
+		t.Errorf("Incorrect number of sent version certificates.  Want: 3, Have: %d", len(sent))
+	}
+
+	// Invalid enode urls are rejected
+	if _, err := api.SetVersionCertificateTableRequest("invalid", nil); err == nil {
+		t.Errorf("Setting a version certificate table request for an invalid enode url should fail")
+	}
+}
+
+func TestConcurrentSetAnnounceVersion(t *testing.T) {
+	genesisCfg, nodeKeys := getGenesisAndKeys(2, true)
+	_, engine, _ := newBlockChainWithKeys(false, common.Address{}, false, genesisCfg, nodeKeys[0])
+	defer engine.StopAnnouncing()
+
+	const numUpdates = 20
+	baseVersion := getTimestamp() + 100
+	var wg sync.WaitGroup
+	for i := 1; i <= numUpdates; i++ {
+		wg.Add(1)
+		go func(version uint) {
+			defer wg.Done()
+			if err := engine.SetAnnounceVersion(version); err != nil && err != errAnnounceVersionNotNewer {
+				t.Errorf("Error in setting announce version %d.  Error: %v", version, err)
+			}
+		}(baseVersion + uint(i))
+	}
+	wg.Wait()
+
+	// Whatever the order of the updates, the newest version wins everywhere
+	wantVersion := baseVersion + numUpdates
+	if version := engine.GetAnnounceVersion(); version != wantVersion {
+		t.Errorf("Incorrect announce version.  Want: %d, Have: %d", wantVersion, version)
+	}
+	engine.enodeCertificateMsgMapMu.RLock()
+	enodeCertificateVersion := engine.enodeCertificateMsgVersion
+	engine.enodeCertificateMsgMapMu.RUnlock()
+	if enodeCertificateVersion != wantVersion {
+		t.Errorf("Incorrect enode certificate version.  Want: %d, Have: %d", wantVersion, enodeCertificateVersion)
+	}
+	vc, err := engine.OwnVersionCertificate()
+	if err != nil {
+		t.Fatalf("Error in getting own version certificate.  Error: %v", err)
+	}
+	if vc.Version != wantVersion {
+		t.Errorf("Incorrect version certificate version.  Want: %d, Have: %d", wantVersion, vc.Version)
+	}
+	if mismatch, err := engine.verifyAnnounceVersionConsistency(); err != nil || mismatch != nil {
+		t.Errorf("Inconsistent announce version.  Have: %+v, err: %v", mismatch, err)
+	}
+}
+
+// recordingP2PServer is a p2p server that records the purposes that nodes are added as peers with
+type recordingP2PServer struct {
+	*consensustest.MockP2PServer
+
+	mu    sync.Mutex
+	added map[enode.ID]p2p.PurposeFlag
+}
+
+func (s *recordingP2PServer) AddPeer(node *enode.Node, purpose p2p.PurposeFlag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.added[node.ID()] |= purpose
+}
+
+func TestReconcilePeers(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+
+	peeredPeer := newVersionedMockPeer(istanbul.Celo66)
+	disconnectedPeer := newVersionedMockPeer(istanbul.Celo66)
+	untrackedPeer := newVersionedMockPeer(istanbul.Celo66)
+	engine.SetBroadcaster(&peersBroadcaster{peers: map[enode.ID]consensus.Peer{
+		peeredPeer.Node().ID():    peeredPeer,
+		untrackedPeer.Node().ID(): untrackedPeer,
+	}})
+
+	provider := fixedValidatorConnSetProvider{engine.Address(): true}
+	var entries []*istanbul.AddressEntry
+	for _, peer := range []*versionedMockPeer{peeredPeer, disconnectedPeer} {
+		address := crypto.PubkeyToAddress(*peer.Node().Pubkey())
+		provider[address] = true
+		entries = append(entries, &istanbul.AddressEntry{Address: address, Node: peer.Node(), Version: 1})
+	}
+	engine.SetValidatorConnSetProvider(provider)
+	if err := engine.valEnodeTable.UpsertVersionAndEnode(entries); err != nil {
+		t.Fatalf("Error in upserting val enode table entries.  Error: %v", err)
+	}
+
+	// Only record the peers added by the reconciliation
+	server := &recordingP2PServer{MockP2PServer: consensustest.NewMockP2PServer(nil), added: make(map[enode.ID]p2p.PurposeFlag)}
+	engine.SetP2PServer(server)
+
+	reconciliation, err := engine.ReconcilePeers()
+	if err != nil {
+		t.Fatalf("Error in reconciling peers.  Error: %v", err)
+	}
+	disconnectedAddress := crypto.PubkeyToAddress(*disconnectedPeer.Node().Pubkey())
+	if want := []string{disconnectedAddress.Hex()}; !reflect.DeepEqual(reconciliation.Disconnected, want) {
+		t.Errorf("Incorrect disconnected validators.  Want: %v, Have: %v", want, reconciliation.Disconnected)
+	}
+	if want := []string{untrackedPeer.Node().ID().String()}; !reflect.DeepEqual(reconciliation.UntrackedPeers, want) {
+		t.Errorf("Incorrect untracked peers.  Want: %v, Have: %v", want, reconciliation.UntrackedPeers)
+	}
+
+	server.mu.Lock()
+	want := map[enode.ID]p2p.PurposeFlag{disconnectedPeer.Node().ID(): p2p.ValidatorPurpose}
+	if !reflect.DeepEqual(server.added, want) {
+		t.Errorf("Incorrect peers added for reconnection.  Want: %v, Have: %v", want, server.added)
+	}
+	server.mu.Unlock()
+
+	// Nothing is re-added nor reported as such when this node isn't in the validator conn set
+	delete(provider, engine.Address())
+	engine.SetValidatorConnSetProvider(provider)
+	server.mu.Lock()
+	server.added = make(map[enode.ID]p2p.PurposeFlag)
+	server.mu.Unlock()
+	if reconciliation, err = engine.ReconcilePeers(); err != nil {
+		t.Fatalf("Error in reconciling peers.  Error: %v", err)
+	}
+	if len(reconciliation.Disconnected) != 0 {
+		t.Errorf("Incorrect disconnected validators.  Want: [], Have: %v", reconciliation.Disconnected)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.added) != 0 {
+		t.Errorf("Incorrect peers added for reconnection.  Want: none, Have: %v", server.added)
+	}
+}
+
+// versionCertificateVectors are golden encodings of version certificates, for validating other
+// implementations of the announce protocol.  They are signed with versionCertificateVectorKey.
+var versionCertificateVectors = []struct {
+	version       uint
+	payloadToSign string // rlp([]interface{}{versionCertificateSalt, version})
+	signature     string // crypto.Sign(keccak256(payloadToSign), key): r || s || v
+	rlp           string // rlp([]interface{}{version, signature})
+}{
+	{
+		version:       1,
+		payloadToSign: "d49276657273696f6e436572746966696361746501",
+		signature:     "41df8b2f593be36a170ee947ba77d8bb806f97020ebffebc60f6633a18511ee64528d7b174c63ed6c5f5eedbbaa59a0fd2ce124b7a75583f888c7f9c6f5bead501",
+		rlp:           "f84401b84141df8b2f593be36a170ee947ba77d8bb806f97020ebffebc60f6633a18511ee64528d7b174c63ed6c5f5eedbbaa59a0fd2ce124b7a75583f888c7f9c6f5bead501",
+	},
+	{
+		version:       1600000000,
+		payloadToSign: "d89276657273696f6e4365727469666963617465845f5e1000",
+		signature:     "0b19da20d98481f7943cb2376a3001d8c21aaf798d8c6718495dbf99efd118987e7e3f5bfd8bf8f827107dc2911fcd88861461bb7d75f80f996465b51e41e95f00",
+		rlp:           "f848845f5e1000b8410b19da20d98481f7943cb2376a3001d8c21aaf798d8c6718495dbf99efd118987e7e3f5bfd8bf8f827107dc2911fcd88861461bb7d75f80f996465b51e41e95f00",
+	},
+}
+
+const (
+	versionCertificateVectorKey     = "289c2857d4598e37fb9647507e47a309d6133539bf21a8b9cb6df88fd5232032"
+	versionCertificateVectorAddress = "0x970E8128AB834E8EAC17Ab8E3812F010678CF791"
+)
+
+func TestVersionCertificateVectors(t *testing.T) {
+	key, err := crypto.HexToECDSA(versionCertificateVectorKey)
+	if err != nil {
+		t.Fatalf("Error in decoding the private key.  Error: %v", err)
+	}
+	address := common.HexToAddress(versionCertificateVectorAddress)
+
+	for _, vector := range versionCertificateVectors {
+		encoding, err := EncodeVersionCertificate(key, vector.version)
+		if err != nil {
+			t.Fatalf("Error in encoding version certificate.  Version: %d, Error: %v", vector.version, err)
+		}
+		if want := common.Hex2Bytes(vector.payloadToSign); !bytes.Equal(encoding.PayloadToSign, want) {
+			t.Errorf("Incorrect payload to sign.  Version: %d, Want: %x, Have: %x", vector.version, want, encoding.PayloadToSign)
+		}
+		if want := common.Hex2Bytes(vector.signature); !bytes.Equal(encoding.Signature, want) {
+			t.Errorf("Incorrect signature.  Version: %d, Want: %x, Have: %x", vector.version, want, encoding.Signature)
+		}
+		if want := common.Hex2Bytes(vector.rlp); !bytes.Equal(encoding.RLP, want) {
+			t.Errorf("Incorrect RLP.  Version: %d, Want: %x, Have: %x", vector.version, want, encoding.RLP)
+		}
+
+		// The salt is signed along with the version, but isn't sent
+		var signedContent struct {
+			Salt    []byte
+			Version uint
+		}
+		if err := rlp.DecodeBytes(common.Hex2Bytes(vector.payloadToSign), &signedContent); err != nil {
+			t.Fatalf("Error in decoding payload to sign.  Version: %d, Error: %v", vector.version, err)
+		}
+		if !bytes.Equal(signedContent.Salt, versionCertificateSalt) || signedContent.Version != vector.version {
+			t.Errorf("Incorrect signed content.  Want: %s %d, Have: %s %d", versionCertificateSalt, vector.version, signedContent.Salt, signedContent.Version)
+		}
+
+		var vc versionCertificate
+		if err := rlp.DecodeBytes(common.Hex2Bytes(vector.rlp), &vc); err != nil {
+			t.Fatalf("Error in decoding version certificate.  Version: %d, Error: %v", vector.version, err)
+		}
+		if err := vc.RecoverPublicKeyAndAddress(); err != nil {
+			t.Fatalf("Error in recovering address.  Version: %d, Error: %v", vector.version, err)
+		}
+		if vc.Address != address || vc.Version != vector.version {
+			t.Errorf("Incorrect decoded version certificate.  Want: %s %d, Have: %s %d", address.Hex(), vector.version, vc.Address.Hex(), vc.Version)
+		}
+	}
+}
+
+func TestQueryEnodeAnswerDedup(t *testing.T) {
+	engine := newBackend()
+	// Keep the announce thread from sending enode certificates to the origin
+	engine.StopAnnouncing()
+
+	provider := fixedValidatorConnSetProvider{engine.Address(): true}
+	engine.SetValidatorConnSetProvider(provider)
+	if err := engine.setAndShareUpdatedAnnounceVersion(engine.GetAnnounceVersion() + 1); err != nil {
+		t.Fatalf("Error in setting the announce version.  Error: %v", err)
+	}
+
+	originPeer := newVersionedMockPeer(istanbul.Celo66)
+	originAddress := crypto.PubkeyToAddress(*originPeer.Node().Pubkey())
+	provider[originAddress] = true
+	if err := engine.valEnodeTable.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: originAddress, Node: originPeer.Node(), Version: 1}}); err != nil {
+		t.Fatalf("Error in upserting val enode table entry.  Error: %v", err)
+	}
+
+	// An answer that isn't sent to any peer isn't recorded
+	engine.SetBroadcaster(&peersBroadcaster{peers: map[enode.ID]consensus.Peer{}})
+	if err := engine.answerQueryEnodeMsg(originAddress, originPeer.Node(), 2); err != nil {
+		t.Fatalf("Error in answering query enode message.  Error: %v", err)
+	}
+	engine.lastQueryEnodeAnswersMu.Lock()
+	_, recorded := engine.lastQueryEnodeAnswers[originAddress]
+	engine.lastQueryEnodeAnswersMu.Unlock()
+	if recorded {
+		t.Errorf("Answer was recorded without being sent")
+	}
+	engine.SetBroadcaster(&peersBroadcaster{peers: map[enode.ID]consensus.Peer{originPeer.Node().ID(): originPeer}})
+
+	assertNoSend := func() {
+		select {
+		case <-originPeer.sentCh:
+			t.Fatalf("Enode certificate was sent again within the dedup window")
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+
+	// Two rapid queries of the same version are answered once
+	for i := 0; i < 2; i++ {
+		if err := engine.answerQueryEnodeMsg(originAddress, originPeer.Node(), 2); err != nil {
+			t.Fatalf("Error in answering query enode message.  Error: %v", err)
+		}
+	}
+	originPeer.waitForSend(t)
+	assertNoSend()
+
+	// A query of a new version is answered again
+	if err := engine.answerQueryEnodeMsg(originAddress, originPeer.Node(), 3); err != nil {
+		t.Fatalf("Error in answering query enode message.  Error: %v", err)
+	}
+	originPeer.waitForSend(t)
+
+	// As is one after the dedup window
+	engine.lastQueryEnodeAnswersMu.Lock()
+	engine.lastQueryEnodeAnswers[originAddress].answered = engine.announceClock.Now().Add(-queryEnodeAnswerDedupWindow)
+	engine.lastQueryEnodeAnswersMu.Unlock()
+	if err := engine.answerQueryEnodeMsg(originAddress, originPeer.Node(), 3); err != nil {
+		t.Fatalf("Error in answering query enode message.  Error: %v", err)
+	}
+	originPeer.waitForSend(t)
+	assertNoSend()
+}
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/celo-org/celo-blockchain/common"
 	"github.com/celo-org/celo-blockchain/consensus"
@@ -165,10 +166,116 @@ func (api *API) GetValEnodeTable() (map[string]*vet.ValEnodeEntryInfo, error) {
 	return api.istanbul.valEnodeTable.ValEnodeTableInfo()
 }
 
+// GetValEnodeTableFingerprint retrieves a hash of the logical contents of the Validator Enode Table,
+// which is equal on nodes whose tables agree
+func (api *API) GetValEnodeTableFingerprint() (common.Hash, error) {
+	return api.istanbul.ValEnodeTableFingerprint()
+}
+
 func (api *API) GetVersionCertificateTableInfo() (map[string]*vet.VersionCertificateEntryInfo, error) {
 	return api.istanbul.versionCertificateTable.Info()
 }
 
+// GetKnownEnodeURLs retrieves the enode URLs of all validators in the val enode table with a known enode,
+// in a format suitable for static-nodes.json
+func (api *API) GetKnownEnodeURLs() []string {
+	return api.istanbul.KnownEnodeURLs()
+}
+
+// SetAnnounceVersion sets this node's announce version and shares the updated announce
+// data structures.  The version must be newer than the current one, and can't be too far
+// ahead of the current time or epoch.
+func (api *API) SetAnnounceVersion(version uint) (bool, error) {
+	if err := api.istanbul.SetAnnounceVersion(version); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PruneVersionCertificates removes the version certificates that are older than olderThanSeconds
+// from the version certificate table, leaving the val enode table as is.  Returns the number of
+// removed version certificates.  Not supported in epoch+counter version mode.
+func (api *API) PruneVersionCertificates(olderThanSeconds uint64) (int, error) {
+	return api.istanbul.PruneVersionCertificates(time.Duration(olderThanSeconds) * time.Second)
+}
+
+// SetVersionCertificateTableRequest limits the version certificates sent to the peer with
+// enode url when it registers to those of addresses.  An empty list sends the full table again.
+func (api *API) SetVersionCertificateTableRequest(url string, addresses []common.Address) (bool, error) {
+	node, err := enode.ParseV4(url)
+	if err != nil {
+		return false, fmt.Errorf("invalid enode: %v", err)
+	}
+	api.istanbul.SetVersionCertificateTableRequest(node, addresses)
+	return true, nil
+}
+
+// GetOwnVersionCertificate retrieves the version certificate that this node currently advertises
+func (api *API) GetOwnVersionCertificate() (*VersionCertificateInfo, error) {
+	vc, err := api.istanbul.OwnVersionCertificate()
+	if err != nil {
+		return nil, err
+	}
+	return vc.Info(), nil
+}
+
+// GetVersionHistory retrieves the most recent versions of the version certificates received for
+// the validator with the given address, oldest first
+func (api *API) GetVersionHistory(address common.Address) []VersionHistoryEntry {
+	return api.istanbul.GetVersionHistory(address)
+}
+
+// GetEnodeConflicts retrieves the enode URLs that were announced for more than one validator address
+func (api *API) GetEnodeConflicts() []*vet.EnodeConflict {
+	return api.istanbul.valEnodeTable.EnodeConflicts()
+}
+
+// PauseAnnounce stops this node from announcing and querying until ResumeAnnounce is called.
+// Received announce messages are still handled while paused.
+func (api *API) PauseAnnounce() bool {
+	api.istanbul.PauseAnnounce()
+	return true
+}
+
+// ResumeAnnounce resumes announcing and querying after PauseAnnounce
+func (api *API) ResumeAnnounce() bool {
+	api.istanbul.ResumeAnnounce()
+	return true
+}
+
+// GetPartitionDiagnostics retrieves the validators in the validator conn set that this node
+// hasn't received a version certificate from recently
+func (api *API) GetPartitionDiagnostics() ([]*SilentValidator, error) {
+	return api.istanbul.PartitionDiagnostics()
+}
+
+// GetAnnounceGoroutines retrieves the start and stop accounting of the announce goroutines, and the
+// scheduled tasks of the announce thread
+func (api *API) GetAnnounceGoroutines() *AnnounceGoroutinesStatus {
+	return api.istanbul.AnnounceGoroutines()
+}
+
+// GetCooldownStatus retrieves the time remaining in the regossip cooldowns of every tracked source address
+func (api *API) GetCooldownStatus() map[common.Address]*CooldownStatus {
+	return api.istanbul.CooldownStatus()
+}
+
+// GetAnnounceReport retrieves a report of the state of the announce protocol
+func (api *API) GetAnnounceReport() (*AnnounceReport, error) {
+	return api.istanbul.GenerateAnnounceReport()
+}
+
+// VerifyConsistency reports the inconsistencies between the val enode table and the version certificate table
+func (api *API) VerifyConsistency() (*ConsistencyReport, error) {
+	return api.istanbul.VerifyConsistency()
+}
+
+// ReconcilePeers re-initiates the connections to the validators in the val enode table that
+// aren't peered, and reports the discrepancies between the table and the validator peers
+func (api *API) ReconcilePeers() (*PeerReconciliation, error) {
+	return api.istanbul.ReconcilePeers()
+}
+
 // GetCurrentRoundState retrieves the current IBFT RoundState
 func (api *API) GetCurrentRoundState() (*core.RoundStateSummary, error) {
 	if !api.istanbul.coreStarted {
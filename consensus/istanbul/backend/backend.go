@@ -20,7 +20,10 @@ import (
 	"crypto/ecdsa"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
+	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -28,11 +31,14 @@ import (
 
 	"github.com/celo-org/celo-blockchain/accounts"
 	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/common/mclock"
 	"github.com/celo-org/celo-blockchain/consensus"
 	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	enodesdb "github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/db"
 	"github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/enodes"
 	"github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/replica"
 	istanbulCore "github.com/celo-org/celo-blockchain/consensus/istanbul/core"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul/kvstore"
 	"github.com/celo-org/celo-blockchain/consensus/istanbul/proxy"
 	"github.com/celo-org/celo-blockchain/consensus/istanbul/validator"
 	"github.com/celo-org/celo-blockchain/contract_comm/election"
@@ -62,10 +68,28 @@ var (
 
 	// errNoBlockHeader is returned when the requested block header could not be found.
 	errNoBlockHeader = errors.New("failed to retrieve block header")
+
+	// errEmptyValidatorConnSet is returned when the validator conn set is unexpectedly empty.
+	// There are always elected validators, so an empty set is the result of e.g. a state read
+	// error, and must not be used for pruning or validating announce messages.
+	errEmptyValidatorConnSet = errors.New("validator conn set is empty")
 )
 
+// EnodeStores are the key-value stores of the validator enode and version certificate tables.
+// A nil store opens the leveldb database at the table's configured path.
+type EnodeStores struct {
+	ValidatorEnodes     kvstore.KVStore
+	VersionCertificates kvstore.KVStore
+}
+
 // New creates an Ethereum backend for Istanbul core engine.
 func New(config *istanbul.Config, db ethdb.Database) consensus.Istanbul {
+	return NewWithEnodeStores(config, db, EnodeStores{})
+}
+
+// NewWithEnodeStores is like New, but backs the validator enode and version certificate
+// tables with the given stores instead of leveldb.
+func NewWithEnodeStores(config *istanbul.Config, db ethdb.Database, stores EnodeStores) consensus.Istanbul {
 	// Allocate the snapshot caches and create the engine
 	logger := log.New()
 	recentSnapshots, err := lru.NewARC(inmemorySnapshots)
@@ -80,37 +104,96 @@ func New(config *istanbul.Config, db ethdb.Database) consensus.Istanbul {
 	if err != nil {
 		logger.Crit("Failed to create known messages cache", "err", err)
 	}
+	announcePeerRateLimiters, err := lru.NewARC(inmemoryPeerRateLimiters)
+	if err != nil {
+		logger.Crit("Failed to create announce peer rate limiters cache", "err", err)
+	}
+	lightweightRegossipLimiters, err := lru.NewARC(inmemoryRegossipLimiters)
+	if err != nil {
+		logger.Crit("Failed to create lightweight regossip rate limiters cache", "err", err)
+	}
+	enodeCertRequestLimiters, err := lru.NewARC(inmemoryCertRequestLimiters)
+	if err != nil {
+		logger.Crit("Failed to create enode certificate request rate limiters cache", "err", err)
+	}
+	announcePeerMetrics, err := lru.NewWithEvict(inmemoryAnnouncePeerMetrics, unregisterAnnouncePeerCounters)
+	if err != nil {
+		logger.Crit("Failed to create announce peer metrics cache", "err", err)
+	}
+	encryptedEnodeURLs, err := lru.NewARC(inmemoryEncryptedEnodeURLs)
+	if err != nil {
+		logger.Crit("Failed to create encrypted enode urls cache", "err", err)
+	}
+	announceBLSPublicKeys, err := lru.NewARC(inmemoryBLSPublicKeys)
+	if err != nil {
+		logger.Crit("Failed to create announce BLS public keys cache", "err", err)
+	}
 	backend := &Backend{
-		config:                             config,
-		istanbulEventMux:                   new(event.TypeMux),
-		logger:                             logger,
-		db:                                 db,
-		commitCh:                           make(chan *types.Block, 1),
-		recentSnapshots:                    recentSnapshots,
-		coreStarted:                        false,
-		announceRunning:                    false,
-		peerRecentMessages:                 peerRecentMessages,
-		selfRecentMessages:                 selfRecentMessages,
-		announceThreadWg:                   new(sync.WaitGroup),
-		generateAndGossipQueryEnodeCh:      make(chan struct{}, 1),
-		updateAnnounceVersionCh:            make(chan struct{}, 1),
-		lastQueryEnodeGossiped:             make(map[common.Address]time.Time),
-		lastVersionCertificatesGossiped:    make(map[common.Address]time.Time),
-		updatingCachedValidatorConnSetCond: sync.NewCond(&sync.Mutex{}),
-		finalizationTimer:                  metrics.NewRegisteredTimer("consensus/istanbul/backend/finalize", nil),
-		rewardDistributionTimer:            metrics.NewRegisteredTimer("consensus/istanbul/backend/rewards", nil),
-		blocksElectedMeter:                 metrics.NewRegisteredMeter("consensus/istanbul/blocks/elected", nil),
-		blocksElectedAndSignedMeter:        metrics.NewRegisteredMeter("consensus/istanbul/blocks/signedbyus", nil),
-		blocksElectedButNotSignedMeter:     metrics.NewRegisteredMeter("consensus/istanbul/blocks/missedbyus", nil),
-		blocksElectedAndProposedMeter:      metrics.NewRegisteredMeter("consensus/istanbul/blocks/proposedbyus", nil),
-		blocksTotalSigsGauge:               metrics.NewRegisteredGauge("consensus/istanbul/blocks/totalsigs", nil),
-		blocksValSetSizeGauge:              metrics.NewRegisteredGauge("consensus/istanbul/blocks/validators", nil),
-		blocksTotalMissedRoundsMeter:       metrics.NewRegisteredMeter("consensus/istanbul/blocks/missedrounds", nil),
-		blocksMissedRoundsAsProposerMeter:  metrics.NewRegisteredMeter("consensus/istanbul/blocks/missedroundsasproposer", nil),
-		blocksElectedButNotSignedGauge:     metrics.NewRegisteredGauge("consensus/istanbul/blocks/missedbyusinarow", nil),
-		blocksDowntimeEventMeter:           metrics.NewRegisteredMeter("consensus/istanbul/blocks/downtimeevent", nil),
-		blocksFinalizedTransactionsGauge:   metrics.NewRegisteredGauge("consensus/istanbul/blocks/transactions", nil),
-		blocksFinalizedGasUsedGauge:        metrics.NewRegisteredGauge("consensus/istanbul/blocks/gasused", nil),
+		config:                                            config,
+		istanbulEventMux:                                  new(event.TypeMux),
+		logger:                                            logger,
+		db:                                                db,
+		commitCh:                                          make(chan *types.Block, 1),
+		recentSnapshots:                                   recentSnapshots,
+		coreStarted:                                       false,
+		announceRunning:                                   false,
+		peerRecentMessages:                                peerRecentMessages,
+		selfRecentMessages:                                selfRecentMessages,
+		announcePeerRateLimiters:                          announcePeerRateLimiters,
+		lightweightRegossipLimiters:                       lightweightRegossipLimiters,
+		enodeCertRequestLimiters:                          enodeCertRequestLimiters,
+		announcePeerMetrics:                               announcePeerMetrics,
+		encryptedEnodeURLs:                                encryptedEnodeURLs,
+		announceBLSPublicKeys:                             announceBLSPublicKeys,
+		encryptionRand:                                    newEncryptionRand(config.AnnounceEncryptionRandBufferSize),
+		newAnnouncePeerCounter:                            func(name string) metrics.Counter { return metrics.GetOrRegisterCounter(name, nil) },
+		announceThreadWg:                                  new(sync.WaitGroup),
+		announceGoroutines:                                newAnnounceGoroutineRegistry(),
+		generateAndGossipQueryEnodeCh:                     make(chan struct{}, 1),
+		updateAnnounceVersionCh:                           make(chan struct{}, 1),
+		announcePausedToggledCh:                           make(chan struct{}, 1),
+		announceClock:                                     mclock.System{},
+		reachabilityDialFn:                                net.DialTimeout,
+		reachabilityProbeSem:                              make(chan struct{}, maxConcurrentReachabilityProbes),
+		announcingStateEventCh:                            make(chan istanbul.AnnouncingStateEvent, announcingStateEventBufferSize),
+		announcingStateQuit:                               make(chan struct{}),
+		announceWarnings:                                  newWarningAggregator(mclock.System{}, time.Duration(config.AnnounceWarningAggregationWindow)*time.Second),
+		lastQueryEnodeGossiped:                            make(map[common.Address]gossipTime),
+		lastVersionCertificatesGossiped:                   make(map[common.Address]gossipTime),
+		versionCertificateTableRequests:                   make(map[enode.ID][]common.Address),
+		changedVersionCertificates:                        make(map[common.Address]struct{}),
+		versionHistory:                                    make(map[common.Address][]VersionHistoryEntry),
+		malformedEnodeURLCounts:                           make(map[common.Address]int),
+		lastQueryEnodeAnswers:                             make(map[common.Address]*queryEnodeAnswer),
+		cachedSignatures:                                  make(map[string]*cachedSignature),
+		updatingCachedValidatorConnSetCond:                sync.NewCond(&sync.Mutex{}),
+		finalizationTimer:                                 metrics.NewRegisteredTimer("consensus/istanbul/backend/finalize", nil),
+		rewardDistributionTimer:                           metrics.NewRegisteredTimer("consensus/istanbul/backend/rewards", nil),
+		blocksElectedMeter:                                metrics.NewRegisteredMeter("consensus/istanbul/blocks/elected", nil),
+		blocksElectedAndSignedMeter:                       metrics.NewRegisteredMeter("consensus/istanbul/blocks/signedbyus", nil),
+		blocksElectedButNotSignedMeter:                    metrics.NewRegisteredMeter("consensus/istanbul/blocks/missedbyus", nil),
+		blocksElectedAndProposedMeter:                     metrics.NewRegisteredMeter("consensus/istanbul/blocks/proposedbyus", nil),
+		blocksTotalSigsGauge:                              metrics.NewRegisteredGauge("consensus/istanbul/blocks/totalsigs", nil),
+		blocksValSetSizeGauge:                             metrics.NewRegisteredGauge("consensus/istanbul/blocks/validators", nil),
+		blocksTotalMissedRoundsMeter:                      metrics.NewRegisteredMeter("consensus/istanbul/blocks/missedrounds", nil),
+		blocksMissedRoundsAsProposerMeter:                 metrics.NewRegisteredMeter("consensus/istanbul/blocks/missedroundsasproposer", nil),
+		blocksElectedButNotSignedGauge:                    metrics.NewRegisteredGauge("consensus/istanbul/blocks/missedbyusinarow", nil),
+		blocksDowntimeEventMeter:                          metrics.NewRegisteredMeter("consensus/istanbul/blocks/downtimeevent", nil),
+		blocksFinalizedTransactionsGauge:                  metrics.NewRegisteredGauge("consensus/istanbul/blocks/transactions", nil),
+		blocksFinalizedGasUsedGauge:                       metrics.NewRegisteredGauge("consensus/istanbul/blocks/gasused", nil),
+		announceGossipFailuresCounter:                     metrics.NewRegisteredCounter("consensus/istanbul/announce/gossipfailures", nil),
+		announceRateLimitedMeter:                          metrics.NewRegisteredMeter("consensus/istanbul/announce/ratelimited", nil),
+		announceOversizedCounter:                          metrics.NewRegisteredCounter("consensus/istanbul/announce/oversized", nil),
+		announceMalformedEnodeURLCounter:                  metrics.NewRegisteredCounter("consensus/istanbul/announce/malformedenodeurls", nil),
+		announceVersionRegressionsCounter:                 metrics.NewRegisteredCounter("consensus/istanbul/announce/versionregressions", nil),
+		announceVersionCertificatesRegossipedCounter:      metrics.NewRegisteredCounter("consensus/istanbul/announce/versioncertificates/regossiped", nil),
+		announceVersionCertificatesSkippedCooldownCounter: metrics.NewRegisteredCounter("consensus/istanbul/announce/versioncertificates/skippedcooldown", nil),
+		announceVersionCertificatesSelfCounter:            metrics.NewRegisteredCounter("consensus/istanbul/announce/versioncertificates/self", nil),
+		announceEncryptedEnodeURLsHistogram:               metrics.NewRegisteredHistogram("consensus/istanbul/announce/queryenode/encryptedenodeurls", nil, metrics.NewExpDecaySample(1028, 0.015)),
+		announceSilentValidatorsGauge:                     metrics.NewRegisteredGauge("consensus/istanbul/announce/partition/silentvalidators", nil),
+		announceEnodeURLEncryptionTimer:                   metrics.NewRegisteredTimer("consensus/istanbul/announce/queryenode/encryption", nil),
+		announceEncryptionRoundHistogram:                  metrics.NewRegisteredHistogram("consensus/istanbul/announce/queryenode/encryptionround", nil, metrics.NewExpDecaySample(1028, 0.015)),
+		announcingStateEventsDroppedCounter:               metrics.NewRegisteredCounter("consensus/istanbul/announce/announcingstate/dropped", nil),
 	}
 
 	backend.core = istanbulCore.New(backend, backend.config)
@@ -134,14 +217,47 @@ func New(config *istanbul.Config, db ethdb.Database) consensus.Istanbul {
 		backend.replicaState = nil
 	}
 
+	backend.validatorConnSetProvider = electionValidatorConnSetProvider{sb: backend}
+	backend.registeredBLSPublicKeyFn = backend.registeredBLSPublicKey
 	backend.vph = newVPH(backend)
-	valEnodeTable, err := enodes.OpenValidatorEnodeDB(config.ValidatorEnodeDBPath, backend.vph)
+	enodeDBOptions := &enodesdb.Options{
+		BlockCacheCapacity:          config.EnodeDBBlockCacheCapacity,
+		BloomFilterBits:             config.EnodeDBBloomFilterBits,
+		CompactionDeletionThreshold: config.EnodeDBCompactionDeletionThreshold,
+		MemoryFallback:              config.EnodeDBMemoryFallback,
+		OpenRetryAttempts:           config.EnodeDBOpenRetryAttempts,
+		OpenRetryInterval:           time.Duration(config.EnodeDBOpenRetryInterval) * time.Millisecond,
+		CorruptionRotationThreshold: config.EnodeDBCorruptionRotationThreshold,
+		CorruptionRotationWindow:    time.Duration(config.EnodeDBCorruptionRotationWindow) * time.Second,
+	}
+	if config.AnnounceAdvertiseAddress != "" {
+		if backend.advertiseIP, err = resolveAdvertiseIP(config.AnnounceAdvertiseAddress); err != nil {
+			logger.Crit("Invalid announce advertise address", "err", err, "address", config.AnnounceAdvertiseAddress)
+		}
+	}
+	valEnodeDBLayout := enodes.AddressLayout
+	if len(config.ValidatorEnodeDBIndexLayout) > 0 {
+		if valEnodeDBLayout, err = enodes.IndexLayout(config.ValidatorEnodeDBIndexLayout); err != nil {
+			logger.Crit("Invalid ValidatorEnodeDB index layout", "err", err)
+		}
+	}
+	var valEnodeTable *enodes.ValidatorEnodeDB
+	if stores.ValidatorEnodes != nil {
+		valEnodeTable, err = enodes.NewValidatorEnodeDBWithStore(stores.ValidatorEnodes, backend.vph, enodeDBOptions, valEnodeDBLayout)
+	} else {
+		valEnodeTable, err = enodes.OpenValidatorEnodeDBWithLayout(config.ValidatorEnodeDBPath, backend.vph, enodeDBOptions, valEnodeDBLayout)
+	}
 	if err != nil {
 		logger.Crit("Can't open ValidatorEnodeDB", "err", err, "dbpath", config.ValidatorEnodeDBPath)
 	}
 	backend.valEnodeTable = valEnodeTable
 
-	versionCertificateTable, err := enodes.OpenVersionCertificateDB(config.VersionCertificateDBPath)
+	var versionCertificateTable *enodes.VersionCertificateDB
+	if stores.VersionCertificates != nil {
+		versionCertificateTable, err = enodes.NewVersionCertificateDBWithStore(stores.VersionCertificates, enodeDBOptions)
+	} else {
+		versionCertificateTable, err = enodes.OpenVersionCertificateDBWithOptions(config.VersionCertificateDBPath, enodeDBOptions)
+	}
 	if err != nil {
 		logger.Crit("Can't open VersionCertificateDB", "err", err, "dbpath", config.VersionCertificateDBPath)
 	}
@@ -160,6 +276,8 @@ func New(config *istanbul.Config, db ethdb.Database) consensus.Istanbul {
 		}
 	}
 
+	go backend.announcingStateEventLoop()
+
 	return backend
 }
 
@@ -209,15 +327,100 @@ type Backend struct {
 	peerRecentMessages *lru.ARCCache // the cache of peer's recent messages
 	selfRecentMessages *lru.ARCCache // the cache of self recent messages
 
-	lastQueryEnodeGossiped   map[common.Address]time.Time
+	announcePeerRateLimiters   *lru.ARCCache // the cache of each peer's outbound announce rate limiter
+	announcePeerRateLimitersMu sync.Mutex
+
+	lightweightRegossipLimiters   *lru.ARCCache // the cache of each peer's lightweight query enode regossip rate limiter
+	lightweightRegossipLimitersMu sync.Mutex
+
+	enodeCertRequestLimiters   *lru.ARCCache // the cache of each peer's enode certificate request rate limiter
+	enodeCertRequestLimitersMu sync.Mutex
+
+	// The cache of each peer's announce metrics, only used with config.AnnounceVerbosePeerMetrics
+	announcePeerMetrics   *lru.Cache
+	announcePeerMetricsMu sync.Mutex
+	// Creates the counters of the per peer announce metrics. Only intended to be replaced by tests.
+	newAnnouncePeerCounter func(name string) metrics.Counter
+
+	// The cache of enode urls encrypted for each recipient, only used with config.AnnounceCacheEncryptedEnodeURLs
+	encryptedEnodeURLs *lru.ARCCache
+
+	// The last signed payload and its signature for each announce signing purpose, only used with config.AnnounceCacheSignatures
+	cachedSignatures   map[string]*cachedSignature
+	cachedSignaturesMu sync.Mutex
+
+	// The source of randomness for encrypting enode urls, see config.AnnounceEncryptionRandBufferSize
+	encryptionRand io.Reader
+
+	// The IP advertised in this node's own enode URL instead of the p2p server's, see config.AnnounceAdvertiseAddress
+	advertiseIP net.IP
+
+	// The clock used by the announce thread's scheduler. Only intended to be replaced by tests.
+	announceClock mclock.Clock
+
+	// Coalesces the repetitions of the noisiest announce warnings, see config.AnnounceWarningAggregationWindow
+	announceWarnings *warningAggregator
+
+	// The dialer of reachability probes. Only intended to be replaced by tests.
+	reachabilityDialFn func(network, address string, timeout time.Duration) (net.Conn, error)
+
+	// Bounds the number of reachability probes in flight
+	reachabilityProbeSem chan struct{}
+
+	// Looks up the BLS public key of a registered validator. Only intended to be replaced by tests.
+	registeredBLSPublicKeyFn func(header *types.Header, address common.Address) (blscrypto.SerializedPublicKey, error)
+	// The cache of the BLS public keys of the validator conn set members, keyed by epoch and address
+	announceBLSPublicKeys *lru.ARCCache
+
+	lastQueryEnodeGossiped   map[common.Address]gossipTime
 	lastQueryEnodeGossipedMu sync.RWMutex
 
+	// Called after each decision of whether to regossip a query enode message, with the
+	// message's source address, whether it was regossiped, and the reason if it wasn't.
+	// Only intended to be set by tests.
+	regossipQueryEnodeHook func(address common.Address, regossiped bool, reason string)
+
+	// Notified of the entries removed by each prune of the announce data structures
+	pruneCallback   PruneCallback
+	pruneCallbackMu sync.RWMutex
+
 	valEnodeTable *enodes.ValidatorEnodeDB
 
 	versionCertificateTable           *enodes.VersionCertificateDB
-	lastVersionCertificatesGossiped   map[common.Address]time.Time
+	lastVersionCertificatesGossiped   map[common.Address]gossipTime
 	lastVersionCertificatesGossipedMu sync.RWMutex
 
+	// The addresses of the version certificates requested by peers, keyed by node ID,
+	// see SetVersionCertificateTableRequest
+	versionCertificateTableRequests   map[enode.ID][]common.Address
+	versionCertificateTableRequestsMu sync.RWMutex
+
+	// The addresses of the version certificates that changed since the previous share of the table
+	changedVersionCertificates   map[common.Address]struct{}
+	changedVersionCertificatesMu sync.Mutex
+
+	// The version certificate most recently generated by this node
+	lastVersionCertificate   *versionCertificate
+	lastVersionCertificateMu sync.RWMutex
+
+	// The most recent versions of the version certificates of each validator, oldest first.
+	// At most config.AnnounceVersionHistoryDepth versions are retained per validator.
+	versionHistory   map[common.Address][]VersionHistoryEntry
+	versionHistoryMu sync.Mutex
+
+	// The number of consecutive query enode messages from each validator whose enode URL
+	// decrypted successfully but couldn't be parsed
+	malformedEnodeURLCounts   map[common.Address]int
+	malformedEnodeURLCountsMu sync.Mutex
+
+	// The most recent answer to the query enode messages of each origin, to not answer the
+	// origin's retries with the same enode certificate
+	lastQueryEnodeAnswers   map[common.Address]*queryEnodeAnswer
+	lastQueryEnodeAnswersMu sync.Mutex
+
+	// The start and stop accounting of the announce thread and the goroutines it spawns
+	announceGoroutines *announceGoroutineRegistry
+
 	announceRunning               bool
 	announceMu                    sync.RWMutex
 	announceThreadWg              *sync.WaitGroup
@@ -226,7 +429,20 @@ type Backend struct {
 	announceVersionMu             sync.RWMutex
 	generateAndGossipQueryEnodeCh chan struct{}
 
+	// The highest version of a version certificate with this node's address received from peers,
+	// e.g. of the primary while this node is a replica.  Guarded by announceVersionMu.
+	highestSeenOwnVersion uint
+
 	updateAnnounceVersionCh chan struct{}
+	// Serializes announce version updates, so that the certificates of different versions
+	// aren't generated and shared interleaved
+	announceVersionUpdateMu sync.Mutex
+
+	// While paused, this node doesn't announce or query, but still handles announce messages
+	announcePaused   bool
+	announcePausedMu sync.RWMutex
+	// Notifies the announce thread that announcing was paused or resumed
+	announcePausedToggledCh chan struct{}
 
 	// The enode certificate message map contains the most recently generated
 	// enode certificates for each external node ID (e.g. will have one entry per proxy
@@ -242,6 +458,15 @@ type Backend struct {
 	delegateSignFeed  event.Feed
 	delegateSignScope event.SubscriptionScope
 
+	announcingStateFeed  event.Feed
+	announcingStateScope event.SubscriptionScope
+
+	// Queue of the announcing state events pending delivery to the subscribers, and the sequence
+	// number of the last posted event (accessed atomically)
+	announcingStateEventCh chan istanbul.AnnouncingStateEvent
+	announcingStateSeq     uint64
+	announcingStateQuit    chan struct{}
+
 	// Metric timer used to record block finalization times.
 	finalizationTimer metrics.Timer
 	// Metric timer used to record epoch reward distribution times.
@@ -276,6 +501,45 @@ type Backend struct {
 	// Gauge counting the gas used in the last block
 	blocksFinalizedGasUsedGauge metrics.Gauge
 
+	// Counter for the number of failed attempts to gossip an announce message
+	announceGossipFailuresCounter metrics.Counter
+
+	// Meter for announce messages dropped because of a peer's outbound rate limit
+	announceRateLimitedMeter metrics.Meter
+
+	// Counter for announce messages dropped because they exceed the burst of the peers' outbound rate limit
+	announceOversizedCounter metrics.Counter
+
+	// Counter for decrypted enode URLs in query enode messages that couldn't be parsed
+	announceMalformedEnodeURLCounter metrics.Counter
+
+	// Counter for received version certificates with a lower version than the stored one
+	announceVersionRegressionsCounter metrics.Counter
+
+	// Counters for the regossip decisions of new version certificate entries: other validators'
+	// entries that were regossiped, those that were skipped by the gossip cooldown check, and
+	// this node's own entries, which are always regossiped
+	announceVersionCertificatesRegossipedCounter      metrics.Counter
+	announceVersionCertificatesSkippedCooldownCounter metrics.Counter
+	announceVersionCertificatesSelfCounter            metrics.Counter
+
+	// Histogram of the number of encrypted enode URLs in the query enode messages generated by this node.
+	// Used to tune the validator conn set size and the query enode message size limits against real data.
+	announceEncryptedEnodeURLsHistogram metrics.Histogram
+
+	// Timer of the encryption of the enode URL for a single destination of a query enode message, and
+	// histogram of the total encryption time (in nanoseconds) of a query enode message's destinations.
+	// They quantify the encryption cost on the announce thread.
+	announceEnodeURLEncryptionTimer  metrics.Timer
+	announceEncryptionRoundHistogram metrics.Histogram
+
+	// Counter for the announcing state events dropped because the subscribers fell behind
+	announcingStateEventsDroppedCounter metrics.Counter
+
+	// Gauge for the number of validators in the validator conn set that no version certificate was
+	// received from within config.AnnouncePartitionWindow, as of the latest partition diagnostics
+	announceSilentValidatorsGauge metrics.Gauge
+
 	// Cache for the return values of the method RetrieveValidatorConnSet
 	cachedValidatorConnSet         map[common.Address]bool
 	cachedValidatorConnSetBlockNum uint64
@@ -288,6 +552,10 @@ type Backend struct {
 	updatingCachedValidatorConnSetErr  error
 	updatingCachedValidatorConnSetCond *sync.Cond
 
+	// Computes the validator conn set on a cache miss
+	validatorConnSetProvider   ValidatorConnSetProvider
+	validatorConnSetProviderMu sync.RWMutex
+
 	// Handler to manage and maintain validator peer connections
 	vph *validatorPeerHandler
 
@@ -299,6 +567,15 @@ type Backend struct {
 	proxiedValidatorEngineRunning bool
 	proxiedValidatorEngineMu      sync.RWMutex
 
+	// Closed to cancel the pending retry of sending enode certificates to the proxies, if there is one
+	proxyEnodeCertsRetryCancel chan struct{}
+	proxyEnodeCertsRetryMu     sync.Mutex
+
+	// Closed to cancel the pending retry of sending enode certificates to unreachable
+	// validator conn set members, if there is one
+	enodeCertsRetryCancel chan struct{}
+	enodeCertsRetryMu     sync.Mutex
+
 	// RandomSeed (and it's mutex) used to generate the random beacon randomness
 	randomSeed   []byte
 	randomSeedMu sync.Mutex
@@ -366,9 +643,14 @@ func (sb *Backend) SendDelegateSignMsgToProxiedValidator(msg []byte) error {
 
 // Authorize implements istanbul.Backend.Authorize
 func (sb *Backend) Authorize(ecdsaAddress, blsAddress common.Address, publicKey *ecdsa.PublicKey, decryptFn istanbul.DecryptFn, signFn istanbul.SignerFn, signBLSFn istanbul.BLSSignerFn, signHashFn istanbul.HashSignerFn) {
-	sb.signFnMu.Lock()
-	defer sb.signFnMu.Unlock()
+	// Signatures of the previous signer must not be reused, so the signature cache is reset once
+	// the signer is swapped.  cachedSignaturesMu is held throughout, so that no signature of the
+	// previous signer can be cached in between.  It's locked before the signer fields, like
+	// cached signing does.
+	sb.cachedSignaturesMu.Lock()
+	defer sb.cachedSignaturesMu.Unlock()
 
+	sb.signFnMu.Lock()
 	sb.address = ecdsaAddress
 	sb.blsAddress = blsAddress
 	sb.publicKey = publicKey
@@ -377,6 +659,9 @@ func (sb *Backend) Authorize(ecdsaAddress, blsAddress common.Address, publicKey
 	sb.signBLSFn = signBLSFn
 	sb.signHashFn = signHashFn
 	sb.core.SetAddress(ecdsaAddress)
+	sb.signFnMu.Unlock()
+
+	sb.cachedSignatures = make(map[string]*cachedSignature)
 }
 
 // Address implements istanbul.Backend.Address
@@ -392,6 +677,8 @@ func (sb *Backend) SelfNode() *enode.Node {
 // Close the backend
 func (sb *Backend) Close() error {
 	sb.delegateSignScope.Close()
+	close(sb.announcingStateQuit)
+	sb.announcingStateScope.Close()
 	var errs []error
 	if err := sb.valEnodeTable.Close(); err != nil {
 		errs = append(errs, err)
@@ -804,6 +1091,35 @@ func (sb *Backend) RefreshValPeers() error {
 	return nil
 }
 
+// KnownEnodeURLs returns the deduplicated and sorted enode URLs of all val enode table entries
+// with a known node, e.g. for exporting them to another node's static-nodes.json
+func (sb *Backend) KnownEnodeURLs() []string {
+	entries, err := sb.valEnodeTable.GetValEnodesWithFilter(func(entry *istanbul.AddressEntry) bool {
+		return entry.Node != nil
+	})
+	if err != nil {
+		sb.logger.Warn("Error in retrieving the val enode table entries", "func", "KnownEnodeURLs", "err", err)
+		return nil
+	}
+
+	enodeURLSet := make(map[string]bool)
+	for _, entry := range entries {
+		enodeURLSet[entry.Node.URLv4()] = true
+	}
+	enodeURLs := make([]string, 0, len(enodeURLSet))
+	for enodeURL := range enodeURLSet {
+		enodeURLs = append(enodeURLs, enodeURL)
+	}
+	sort.Strings(enodeURLs)
+	return enodeURLs
+}
+
+// ValEnodeTableFingerprint returns a hash of the logical contents of the val enode table, so that
+// it can be quickly checked whether the tables of two nodes agree
+func (sb *Backend) ValEnodeTableFingerprint() (common.Hash, error) {
+	return sb.valEnodeTable.Fingerprint()
+}
+
 func (sb *Backend) ValidatorAddress() common.Address {
 	if sb.IsProxy() {
 		return sb.config.ProxiedValidatorAddress
@@ -814,7 +1130,8 @@ func (sb *Backend) ValidatorAddress() common.Address {
 // RetrieveValidatorConnSet returns the cached validator conn set if the cache
 // is younger than 20 blocks, younger than 1 minute, or if an epoch transition didn't occur since the last
 // cached entry. In the event of a cache miss, this may block for a
-// couple seconds while retrieving the uncached set.
+// couple seconds while retrieving the uncached set.  If the retrieved set is empty, the previously
+// cached set is returned, and errEmptyValidatorConnSet only if there is none.
 func (sb *Backend) RetrieveValidatorConnSet() (map[common.Address]bool, error) {
 	var valConnSetToReturn map[common.Address]bool = nil
 
@@ -847,7 +1164,8 @@ func (sb *Backend) RetrieveValidatorConnSet() (map[common.Address]bool, error) {
 	if valConnSetToReturn == nil {
 		sb.cachedValidatorConnSetMu.RUnlock()
 
-		if err := sb.updateCachedValidatorConnSet(); err != nil {
+		// An empty set isn't cached, so the previously cached set, if any, is returned instead
+		if err := sb.updateCachedValidatorConnSet(); err != nil && err != errEmptyValidatorConnSet {
 			return nil, err
 		}
 
@@ -855,11 +1173,15 @@ func (sb *Backend) RetrieveValidatorConnSet() (map[common.Address]bool, error) {
 		valConnSetToReturn = sb.cachedValidatorConnSet
 	}
 
+	defer sb.cachedValidatorConnSetMu.RUnlock()
+	if len(valConnSetToReturn) == 0 {
+		return nil, errEmptyValidatorConnSet
+	}
+
 	valConnSetCopy := make(map[common.Address]bool)
 	for address, inSet := range valConnSetToReturn {
 		valConnSetCopy[address] = inSet
 	}
-	defer sb.cachedValidatorConnSetMu.RUnlock()
 	return valConnSetCopy, nil
 }
 
@@ -908,6 +1230,16 @@ func (sb *Backend) updateCachedValidatorConnSet() (err error) {
 	if err != nil {
 		return err
 	}
+	// Keep the previously cached set rather than caching an empty one, so that RetrieveValidatorConnSet
+	// falls back to it.  errEmptyValidatorConnSet is returned regardless.
+	if len(validatorConnSet) == 0 {
+		if previous := sb.retrieveCachedValidatorConnSet(); len(previous) > 0 {
+			sb.announceWarnings.Warn(logger, "Retrieved an empty validator conn set, falling back to the previously cached one", "blockNum", blockNum, "err", errEmptyValidatorConnSet)
+		} else {
+			logger.Warn("Retrieved an empty validator conn set, and there is no previously cached one", "blockNum", blockNum)
+		}
+		return errEmptyValidatorConnSet
+	}
 	sb.cachedValidatorConnSetMu.Lock()
 	sb.cachedValidatorConnSet = validatorConnSet
 	sb.cachedValidatorConnSetBlockNum = blockNum
@@ -916,15 +1248,60 @@ func (sb *Backend) updateCachedValidatorConnSet() (err error) {
 	return nil
 }
 
+// ValidatorConnSetProvider computes the validator conn set, i.e. the validators that this node
+// maintains connections to and exchanges announce messages with.  By default the set is derived
+// from the on-chain election, but tests and alternative governance models can provide their own.
+type ValidatorConnSetProvider interface {
+	// ValidatorConnSet returns the validator conn set as of the given block
+	ValidatorConnSet(block *types.Block) (map[common.Address]bool, error)
+}
+
+// SetValidatorConnSetProvider replaces the provider of the validator conn set.  The cached
+// validator conn set is discarded, so that the next retrieval uses the new provider.
+func (sb *Backend) SetValidatorConnSetProvider(provider ValidatorConnSetProvider) {
+	sb.validatorConnSetProviderMu.Lock()
+	sb.validatorConnSetProvider = provider
+	sb.validatorConnSetProviderMu.Unlock()
+
+	sb.cachedValidatorConnSetMu.Lock()
+	sb.cachedValidatorConnSet = nil
+	sb.cachedValidatorConnSetMu.Unlock()
+}
+
 func (sb *Backend) retrieveUncachedValidatorConnSet() (map[common.Address]bool, uint64, time.Time, error) {
 	logger := sb.logger.New("func", "retrieveUncachedValidatorConnSet")
+
+	sb.validatorConnSetProviderMu.RLock()
+	provider := sb.validatorConnSetProvider
+	sb.validatorConnSetProviderMu.RUnlock()
+
+	currentBlock := sb.currentBlock()
+	validatorsSet, err := provider.ValidatorConnSet(currentBlock)
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+
+	connSetTS := time.Now()
+
+	logger.Trace("Returning validator conn set", "validatorsSet", validatorsSet)
+	return validatorsSet, currentBlock.Number().Uint64(), connSetTS, nil
+}
+
+// electionValidatorConnSetProvider is the default ValidatorConnSetProvider.  Its validator conn
+// set is the elected validators plus AnnounceAdditionalValidatorsToGossip more registered ones.
+type electionValidatorConnSetProvider struct {
+	sb *Backend
+}
+
+func (p electionValidatorConnSetProvider) ValidatorConnSet(currentBlock *types.Block) (map[common.Address]bool, error) {
+	sb := p.sb
+	logger := sb.logger.New("func", "electionValidatorConnSetProvider.ValidatorConnSet")
 	// Retrieve the validator conn set from the election smart contract
 	validatorsSet := make(map[common.Address]bool)
 
-	currentBlock := sb.currentBlock()
 	currentState, err := sb.stateAt(currentBlock.Hash())
 	if err != nil {
-		return nil, 0, time.Time{}, err
+		return nil, err
 	}
 	electNValidators, err := election.ElectNValidatorSigners(currentBlock.Header(), currentState, sb.config.AnnounceAdditionalValidatorsToGossip)
 
@@ -946,10 +1323,7 @@ func (sb *Backend) retrieveUncachedValidatorConnSet() (map[common.Address]bool,
 		validatorsSet[val.Address()] = true
 	}
 
-	connSetTS := time.Now()
-
-	logger.Trace("Returning validator conn set", "validatorsSet", validatorsSet)
-	return validatorsSet, currentBlock.Number().Uint64(), connSetTS, nil
+	return validatorsSet, nil
 }
 
 func (sb *Backend) AddProxy(node, externalNode *enode.Node) error {
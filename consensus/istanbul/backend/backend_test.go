@@ -19,13 +19,20 @@ package backend
 import (
 	"fmt"
 	"math/big"
+	"net"
+	"reflect"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/celo-org/celo-blockchain/common"
 	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	vet "github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/enodes"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul/kvstore"
+	"github.com/celo-org/celo-blockchain/core/rawdb"
 	"github.com/celo-org/celo-blockchain/core/types"
 	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/celo-org/celo-blockchain/p2p/enode"
 )
 
 func TestSign(t *testing.T) {
@@ -185,3 +192,186 @@ func TestGetProposer(t *testing.T) {
 		t.Errorf("proposer mismatch: have %v, want %v, currentblock: %v", actual.Hex(), expected.Hex(), chain.CurrentBlock().Number())
 	}
 }
+
+func TestEmptyValidatorConnSet(t *testing.T) {
+	b := newBackend()
+	defer b.StopAnnouncing()
+
+	if _, err := b.RetrieveValidatorConnSet(); err != nil {
+		t.Fatalf("Error in retrieving validator conn set.  Error: %v", err)
+	}
+
+	// An entry that pruning against an empty conn set would remove
+	key, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	node := enode.NewV4(&key.PublicKey, net.ParseIP("127.0.0.1"), 30303, 30303)
+	if err := b.valEnodeTable.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: address, Node: node, Version: 1}}); err != nil {
+		t.Fatalf("Error in upserting val enode entry.  Error: %v", err)
+	}
+
+	// Simulate an empty set being cached for the current block
+	b.cachedValidatorConnSetMu.Lock()
+	b.cachedValidatorConnSet = make(map[common.Address]bool)
+	b.cachedValidatorConnSetBlockNum = b.currentBlock().Number().Uint64()
+	b.cachedValidatorConnSetTS = time.Now()
+	b.cachedValidatorConnSetMu.Unlock()
+
+	if _, err := b.RetrieveValidatorConnSet(); err != errEmptyValidatorConnSet {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errEmptyValidatorConnSet, err)
+	}
+	if err := b.pruneAnnounceDataStructures(); err != errEmptyValidatorConnSet {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", errEmptyValidatorConnSet, err)
+	}
+	if n, err := b.valEnodeTable.GetNodeFromAddress(address); err != nil || n == nil {
+		t.Errorf("Val enode entry was pruned against an empty validator conn set, err: %v", err)
+	}
+
+	// Once a non-empty set was cached, retrieving an empty one falls back to it
+	b.SetValidatorConnSetProvider(fixedValidatorConnSetProvider{address: true})
+	if _, err := b.RetrieveValidatorConnSet(); err != nil {
+		t.Fatalf("Error in retrieving validator conn set.  Error: %v", err)
+	}
+	b.validatorConnSetProviderMu.Lock()
+	b.validatorConnSetProvider = fixedValidatorConnSetProvider{}
+	b.validatorConnSetProviderMu.Unlock()
+	b.cachedValidatorConnSetMu.Lock()
+	b.cachedValidatorConnSetTS = time.Now().Add(-2 * time.Minute)
+	b.cachedValidatorConnSetMu.Unlock()
+	if validatorConnSet, err := b.RetrieveValidatorConnSet(); err != nil || !reflect.DeepEqual(validatorConnSet, map[common.Address]bool{address: true}) {
+		t.Errorf("Incorrect validator conn set after retrieving an empty one.  Want: %v, Have: %v, err: %v", map[common.Address]bool{address: true}, validatorConnSet, err)
+	}
+}
+
+// fixedValidatorConnSetProvider is a ValidatorConnSetProvider with a fixed validator conn set
+type fixedValidatorConnSetProvider map[common.Address]bool
+
+func (p fixedValidatorConnSetProvider) ValidatorConnSet(block *types.Block) (map[common.Address]bool, error) {
+	validatorConnSet := make(map[common.Address]bool, len(p))
+	for address := range p {
+		validatorConnSet[address] = true
+	}
+	return validatorConnSet, nil
+}
+
+func TestValidatorConnSetProvider(t *testing.T) {
+	b := newBackend()
+	defer b.StopAnnouncing()
+	nonValKey, _ := crypto.GenerateKey()
+	nonValAddress := crypto.PubkeyToAddress(nonValKey.PublicKey)
+	b.Authorize(nonValAddress, nonValAddress, &nonValKey.PublicKey, DecryptFn(nonValKey), SignFn(nonValKey), SignBLSFn(nonValKey), SignHashFn(nonValKey))
+
+	// The backend's random address isn't in the on-chain validator conn set
+	if shouldParticipate, err := b.shouldParticipateInAnnounce(); err != nil || shouldParticipate {
+		t.Fatalf("Should not participate in announce.  Have: %v, err: %v", shouldParticipate, err)
+	}
+
+	key, _ := crypto.GenerateKey()
+	remoteAddress := crypto.PubkeyToAddress(key.PublicKey)
+	provider := fixedValidatorConnSetProvider{b.Address(): true, remoteAddress: true}
+	b.SetValidatorConnSetProvider(provider)
+
+	// The provided set replaces the cached one right away
+	validatorConnSet, err := b.RetrieveValidatorConnSet()
+	if err != nil {
+		t.Fatalf("Error in retrieving validator conn set.  Error: %v", err)
+	}
+	if want := map[common.Address]bool(provider); !reflect.DeepEqual(validatorConnSet, want) {
+		t.Errorf("Incorrect validator conn set.  Want: %v, Have: %v", want, validatorConnSet)
+	}
+	if shouldParticipate, err := b.shouldParticipateInAnnounce(); err != nil || !shouldParticipate {
+		t.Errorf("Should participate in announce.  Have: %v, err: %v", shouldParticipate, err)
+	}
+
+	// Announce data of validators outside of the provided set is pruned
+	otherKey, _ := crypto.GenerateKey()
+	otherAddress := crypto.PubkeyToAddress(otherKey.PublicKey)
+	if err := b.valEnodeTable.UpsertVersionAndEnode([]*istanbul.AddressEntry{
+		{Address: remoteAddress, Node: enode.NewV4(&key.PublicKey, net.ParseIP("127.0.0.1"), 30303, 30303), Version: 1},
+		{Address: otherAddress, Node: enode.NewV4(&otherKey.PublicKey, net.ParseIP("127.0.0.1"), 30304, 30304), Version: 1},
+	}); err != nil {
+		t.Fatalf("Error in upserting val enode entries.  Error: %v", err)
+	}
+	if err := b.pruneAnnounceDataStructures(); err != nil {
+		t.Fatalf("Error in pruning announce data structures.  Error: %v", err)
+	}
+	if n, err := b.valEnodeTable.GetNodeFromAddress(remoteAddress); err != nil || n == nil {
+		t.Errorf("Val enode entry in the provided set was pruned, err: %v", err)
+	}
+	if _, err := b.valEnodeTable.GetNodeFromAddress(otherAddress); err == nil {
+		t.Errorf("Val enode entry outside of the provided set wasn't pruned")
+	}
+}
+
+func TestKnownEnodeURLs(t *testing.T) {
+	b := newBackend()
+	defer b.StopAnnouncing()
+
+	var entries []*istanbul.AddressEntry
+	var want []string
+	for i := 0; i < 3; i++ {
+		key, _ := crypto.GenerateKey()
+		node := enode.NewV4(&key.PublicKey, net.ParseIP("127.0.0.1"), 30303+i, 30303+i)
+		entries = append(entries, &istanbul.AddressEntry{Address: crypto.PubkeyToAddress(key.PublicKey), Node: node, Version: 1})
+		want = append(want, node.URLv4())
+	}
+	// An entry without a known node is skipped
+	key, _ := crypto.GenerateKey()
+	if err := b.valEnodeTable.UpsertHighestKnownVersion([]*istanbul.AddressEntry{{Address: crypto.PubkeyToAddress(key.PublicKey), PublicKey: &key.PublicKey, HighestKnownVersion: 1}}); err != nil {
+		t.Fatalf("Error in upserting val enode entry.  Error: %v", err)
+	}
+	if err := b.valEnodeTable.UpsertVersionAndEnode(entries); err != nil {
+		t.Fatalf("Error in upserting val enode entries.  Error: %v", err)
+	}
+	sort.Strings(want)
+
+	if enodeURLs := b.KnownEnodeURLs(); !reflect.DeepEqual(enodeURLs, want) {
+		t.Errorf("Incorrect known enode URLs.  Want: %v, Have: %v", want, enodeURLs)
+	}
+}
+
+func TestValEnodeTableFingerprint(t *testing.T) {
+	b := newBackend()
+	defer b.StopAnnouncing()
+
+	before, err := b.ValEnodeTableFingerprint()
+	if err != nil {
+		t.Fatalf("Error in computing the val enode table fingerprint.  Error: %v", err)
+	}
+	key, _ := crypto.GenerateKey()
+	node := enode.NewV4(&key.PublicKey, net.ParseIP("127.0.0.1"), 30303, 30303)
+	if err := b.valEnodeTable.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: crypto.PubkeyToAddress(key.PublicKey), Node: node, Version: 1}}); err != nil {
+		t.Fatalf("Error in upserting val enode entry.  Error: %v", err)
+	}
+	after, err := b.ValEnodeTableFingerprint()
+	if err != nil {
+		t.Fatalf("Error in computing the val enode table fingerprint.  Error: %v", err)
+	}
+	if before == after {
+		t.Errorf("Val enode table fingerprint didn't change after upserting an entry.  Fingerprint: %v", after)
+	}
+}
+
+func TestNewWithEnodeStores(t *testing.T) {
+	stores := EnodeStores{ValidatorEnodes: kvstore.NewMemoryStore(), VersionCertificates: kvstore.NewMemoryStore()}
+	config := *istanbul.DefaultConfig
+	b := NewWithEnodeStores(&config, rawdb.NewMemoryDatabase(), stores).(*Backend)
+
+	key, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	node := enode.NewV4(&key.PublicKey, net.ParseIP("127.0.0.1"), 30303, 30303)
+	if err := b.valEnodeTable.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: address, Node: node, Version: 1}}); err != nil {
+		t.Fatalf("Error in upserting val enode entries.  Error: %v", err)
+	}
+	if _, err := b.versionCertificateTable.Upsert([]*vet.VersionCertificateEntry{{Address: address, PublicKey: &key.PublicKey, Version: 1, Signature: []byte{1}}}); err != nil {
+		t.Fatalf("Error in upserting version certificate entries.  Error: %v", err)
+	}
+
+	// The tables are written to the given stores
+	for name, store := range map[string]kvstore.KVStore{"validator enodes": stores.ValidatorEnodes, "version certificates": stores.VersionCertificates} {
+		iter := store.NewIterator(nil)
+		if !iter.Next() {
+			t.Errorf("The %s store is empty", name)
+		}
+		iter.Release()
+	}
+}
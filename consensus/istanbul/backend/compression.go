@@ -0,0 +1,74 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"errors"
+
+	"github.com/celo-org/celo-blockchain/consensus"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	"github.com/golang/snappy"
+)
+
+const (
+	// Prefix of compressed announce payloads.  Uncompressed payloads are RLP encoded
+	// istanbul messages, which never start with this byte.
+	compressedAnnouncePayloadPrefix = 0x00
+
+	// Maximum size of a decompressed announce payload
+	maxDecompressedAnnouncePayloadSize = 10 * 1024 * 1024
+)
+
+var (
+	errDecompressedAnnouncePayloadTooLarge = errors.New("decompressed announce payload is too large")
+)
+
+// isCompressibleAnnounceMsg returns whether messages with the given code may be
+// compressed when sent to a peer that supports it.
+func isCompressibleAnnounceMsg(ethMsgCode uint64) bool {
+	return ethMsgCode == istanbul.EnodeCertificateMsg || ethMsgCode == istanbul.VersionCertificatesMsg || ethMsgCode == istanbul.AnnounceSnapshotMsg
+}
+
+// compressAnnouncePayload will snappy compress the payload of enode certificate, version
+// certificates and announce snapshot messages if the peer supports it, and otherwise
+// returns the payload unchanged.
+func compressAnnouncePayload(peer consensus.Peer, ethMsgCode uint64, payload []byte) []byte {
+	if !isCompressibleAnnounceMsg(ethMsgCode) || !istanbul.SupportsAnnounceCompression(peer.Version()) {
+		return payload
+	}
+	return append([]byte{compressedAnnouncePayloadPrefix}, snappy.Encode(nil, payload)...)
+}
+
+// decompressAnnouncePayload will decompress a payload that was compressed with
+// compressAnnouncePayload.  Uncompressed payloads are returned unchanged.
+func decompressAnnouncePayload(peer consensus.Peer, ethMsgCode uint64, data []byte) ([]byte, error) {
+	if !isCompressibleAnnounceMsg(ethMsgCode) || !istanbul.SupportsAnnounceCompression(peer.Version()) {
+		return data, nil
+	}
+	if len(data) == 0 || data[0] != compressedAnnouncePayloadPrefix {
+		return data, nil
+	}
+
+	decodedLen, err := snappy.DecodedLen(data[1:])
+	if err != nil {
+		return nil, err
+	}
+	if decodedLen > maxDecompressedAnnouncePayloadSize {
+		return nil, errDecompressedAnnouncePayloadTooLarge
+	}
+	return snappy.Decode(nil, data[1:])
+}
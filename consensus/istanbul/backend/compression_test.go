@@ -0,0 +1,111 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/consensus/consensustest"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/celo-org/celo-blockchain/p2p"
+	"github.com/celo-org/celo-blockchain/p2p/enode"
+)
+
+// versionedMockPeer is a mock peer with a configurable protocol version and purpose that records sent payloads
+type versionedMockPeer struct {
+	*consensustest.MockPeer
+	version int
+	purpose p2p.PurposeFlag
+	sentCh  chan []byte
+}
+
+func newVersionedMockPeer(version int) *versionedMockPeer {
+	return newVersionedMockPeerWithPurpose(version, p2p.AnyPurpose)
+}
+
+func newVersionedMockPeerWithPurpose(version int, purpose p2p.PurposeFlag) *versionedMockPeer {
+	key, _ := crypto.GenerateKey()
+	return newVersionedMockPeerWithKey(version, purpose, key)
+}
+
+func newVersionedMockPeerWithKey(version int, purpose p2p.PurposeFlag, key *ecdsa.PrivateKey) *versionedMockPeer {
+	node := enode.NewV4(&key.PublicKey, nil, 0, 0)
+	return &versionedMockPeer{
+		MockPeer: consensustest.NewMockPeer(node, purpose),
+		version:  version,
+		purpose:  purpose,
+		sentCh:   make(chan []byte, 1),
+	}
+}
+
+func (p *versionedMockPeer) Version() int {
+	return p.version
+}
+
+func (p *versionedMockPeer) PurposeIsSet(purpose p2p.PurposeFlag) bool {
+	return p.purpose.IsSet(purpose)
+}
+
+func (p *versionedMockPeer) Send(msgCode uint64, data interface{}) error {
+	p.sentCh <- data.([]byte)
+	return nil
+}
+
+func (p *versionedMockPeer) waitForSend(t *testing.T) []byte {
+	select {
+	case data := <-p.sentCh:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for message to be sent")
+		return nil
+	}
+}
+
+func TestAnnouncePayloadCompression(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+
+	vCert, err := engine.generateVersionCertificate(1)
+	if err != nil {
+		t.Fatalf("Error in generating version certificate.  Error: %v", err)
+	}
+	payload, err := engine.encodeVersionCertificatesMsg([]*versionCertificate{vCert, vCert, vCert})
+	if err != nil {
+		t.Fatalf("Error in encoding version certificates msg.  Error: %v", err)
+	}
+
+	// Negotiated: a peer supporting compression should receive a compressed payload
+	newPeer := newVersionedMockPeer(istanbul.Celo67)
+	engine.Unicast(newPeer, payload, istanbul.VersionCertificatesMsg)
+	sent := newPeer.waitForSend(t)
+	if len(sent) == 0 || sent[0] != compressedAnnouncePayloadPrefix {
+		t.Errorf("Payload sent to a peer supporting compression was not compressed")
+	}
+	decompressed, err := decompressAnnouncePayload(newPeer, istanbul.VersionCertificatesMsg, sent)
+	if err != nil {
+		t.Fatalf("Error in decompressing payload.  Error: %v", err)
+	}
+	if !bytes.Equal(decompressed, payload) {
+		t.Errorf("Decompressed payload mismatch.  Want: %x, Have: %x", payload, decompressed)
+	}
+
+	// An uncompressed payload from a peer supporting compression is accepted as is
+	if data, err := decompressAnnouncePayload(newPeer, istanbul.VersionCertificatesMsg, payload); err != nil || !bytes.Equal(data, payload) {
+		t.Errorf("Uncompressed payload mismatch.  Want: %x, Have: %x, err: %v", payload, data, err)
+	}
+
+	// Messages other than enode certificates and version certificates are never compressed
+	engine.Unicast(newPeer, payload, istanbul.ConsensusMsg)
+	if sent := newPeer.waitForSend(t); !bytes.Equal(sent, payload) {
+		t.Errorf("Consensus payload mismatch.  Want: %x, Have: %x", payload, sent)
+	}
+
+	// Fallback: a peer not supporting compression should receive the payload as is
+	oldPeer := newVersionedMockPeer(istanbul.Celo66)
+	engine.Unicast(oldPeer, payload, istanbul.VersionCertificatesMsg)
+	if sent := oldPeer.waitForSend(t); !bytes.Equal(sent, payload) {
+		t.Errorf("Payload sent to a peer not supporting compression mismatch.  Want: %x, Have: %x", payload, sent)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"bufio"
+	"crypto/rand"
+	"io"
+	"sync"
+)
+
+// bufferedRandReader is a source of randomness for ECIES encryption that reads from crypto/rand
+// in chunks of its buffer size, instead of making a syscall for every ephemeral key and IV.  This
+// matters when encrypting enode urls for many recipients in one query enode round.
+//
+// Security: every byte still comes from the OS CSPRNG and is handed out exactly once, under a
+// lock, so each ciphertext's ephemeral key and IV are as fresh and independent of each other as
+// with crypto/rand directly.  Ephemeral keys are deliberately never reused across recipients,
+// since that would link the ciphertexts of a round and make a compromise of one ephemeral key
+// expose every enode url encrypted with it.  The only difference is that up to a buffer of unused
+// randomness sits in process memory, which holds the private keys anyway.
+type bufferedRandReader struct {
+	mu     sync.Mutex
+	reader *bufio.Reader
+}
+
+func newBufferedRandReader(size int) *bufferedRandReader {
+	return &bufferedRandReader{reader: bufio.NewReaderSize(rand.Reader, size)}
+}
+
+func (r *bufferedRandReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reader.Read(p)
+}
+
+// newEncryptionRand returns the source of randomness for ECIES encryption.  A bufferSize of 0
+// reads from crypto/rand directly.
+func newEncryptionRand(bufferSize int) io.Reader {
+	if bufferSize <= 0 {
+		return rand.Reader
+	}
+	return newBufferedRandReader(bufferSize)
+}
@@ -43,10 +43,16 @@ import (
 )
 
 const (
-	inmemorySnapshots             = 128 // Number of recent vote snapshots to keep in memory
-	inmemoryPeers                 = 40
-	inmemoryMessages              = 1024
-	mobileAllowedClockSkew uint64 = 5
+	inmemorySnapshots                  = 128 // Number of recent vote snapshots to keep in memory
+	inmemoryPeers                      = 40
+	inmemoryMessages                   = 1024
+	inmemoryPeerRateLimiters           = 1024 // Number of peers' outbound announce rate limiters to keep in memory
+	inmemoryRegossipLimiters           = 1024 // Number of peers' lightweight query enode regossip rate limiters to keep in memory
+	inmemoryCertRequestLimiters        = 1024 // Number of peers' enode certificate request rate limiters to keep in memory
+	inmemoryAnnouncePeerMetrics        = 1024 // Number of peers' announce metrics to keep in memory
+	inmemoryEncryptedEnodeURLs         = 1024 // Number of encrypted enode urls to keep in memory
+	inmemoryBLSPublicKeys              = 1024 // Number of validators' BLS public keys used to verify announce messages to keep in memory
+	mobileAllowedClockSkew      uint64 = 5
 )
 
 var (
@@ -726,6 +732,9 @@ func (sb *Backend) StartAnnouncing() error {
 	if sb.announceRunning {
 		return istanbul.ErrStartedAnnounce
 	}
+	if err := validateECIESSharedInfo(sb.config, sb.logger); err != nil {
+		return err
+	}
 
 	go sb.announceThread()
 
@@ -28,7 +28,6 @@ import (
 	"github.com/celo-org/celo-blockchain/event"
 	"github.com/celo-org/celo-blockchain/p2p"
 	"github.com/celo-org/celo-blockchain/p2p/enode"
-	"github.com/celo-org/celo-blockchain/rlp"
 )
 
 var (
@@ -55,6 +54,13 @@ func (sb *Backend) HandleMsg(addr common.Address, msg p2p.Msg, peer consensus.Pe
 		return true, errDecodeFailed
 	}
 
+	data, err := decompressAnnouncePayload(peer, msg.Code, data)
+	if err != nil {
+		logger.Error("Failed to decompress message payload", "err", err, "from", addr)
+		return true, errDecodeFailed
+	}
+	sb.tracePayload(logger, "received", msg.Code, data, "from", addr)
+
 	if sb.IsProxy() {
 		switch msg.Code {
 		// TODO(Joshua): Decide to pull out specific proxy handlers
@@ -84,6 +90,15 @@ func (sb *Backend) HandleMsg(addr common.Address, msg p2p.Msg, peer consensus.Pe
 		case istanbul.VersionCertificatesMsg:
 			go sb.handleVersionCertificatesMsg(addr, peer, data)
 			return true, nil
+		case istanbul.EnodeCertificateRequestMsg:
+			go sb.handleEnodeCertificateRequestMsg(peer)
+			return true, nil
+		case istanbul.AnnounceSnapshotRequestMsg:
+			fallthrough
+		case istanbul.AnnounceSnapshotMsg:
+			// Announce snapshots are only exchanged between validators and their trusted peers
+			logger.Trace("Ignoring announce snapshot message as proxy", "ethMsgCode", msg.Code)
+			return true, nil
 		case istanbul.ValidatorHandshakeMsg:
 			logger.Warn("Received unexpected Istanbul validator handshake message")
 			return true, nil
@@ -119,6 +134,15 @@ func (sb *Backend) HandleMsg(addr common.Address, msg p2p.Msg, peer consensus.Pe
 		case istanbul.VersionCertificatesMsg:
 			go sb.handleVersionCertificatesMsg(addr, peer, data)
 			return true, nil
+		case istanbul.EnodeCertificateRequestMsg:
+			go sb.handleEnodeCertificateRequestMsg(peer)
+			return true, nil
+		case istanbul.AnnounceSnapshotRequestMsg:
+			go sb.handleAnnounceSnapshotRequestMsg(peer)
+			return true, nil
+		case istanbul.AnnounceSnapshotMsg:
+			go sb.handleAnnounceSnapshotMsg(peer, data)
+			return true, nil
 		case istanbul.ValidatorHandshakeMsg:
 			logger.Warn("Received unexpected Istanbul validator handshake message")
 			return true, nil
@@ -152,6 +176,15 @@ func (sb *Backend) HandleMsg(addr common.Address, msg p2p.Msg, peer consensus.Pe
 		case istanbul.VersionCertificatesMsg:
 			go sb.handleVersionCertificatesMsg(addr, peer, data)
 			return true, nil
+		case istanbul.EnodeCertificateRequestMsg:
+			go sb.handleEnodeCertificateRequestMsg(peer)
+			return true, nil
+		case istanbul.AnnounceSnapshotRequestMsg:
+			go sb.handleAnnounceSnapshotRequestMsg(peer)
+			return true, nil
+		case istanbul.AnnounceSnapshotMsg:
+			go sb.handleAnnounceSnapshotMsg(peer, data)
+			return true, nil
 		case istanbul.ValidatorHandshakeMsg:
 			logger.Warn("Received unexpected Istanbul validator handshake message")
 			return true, nil
@@ -488,7 +521,7 @@ func (sb *Backend) readValidatorHandshakeMessage(peer consensus.Peer) (bool, err
 	}
 
 	var enodeCertificate istanbul.EnodeCertificate
-	err = rlp.DecodeBytes(msg.Msg, &enodeCertificate)
+	err = istanbul.DecodeAnnounceData(msg.Msg, &enodeCertificate)
 	if err != nil {
 		return false, err
 	}
@@ -562,5 +595,5 @@ func (sb *Backend) verifyValidatorHandshakeMessage(data []byte, sig []byte) (com
 	if len(sig) == 0 {
 		return common.ZeroAddress, nil
 	}
-	return istanbul.GetSignatureAddress(data, sig)
+	return istanbul.GetCanonicalSignatureAddress(data, sig)
 }
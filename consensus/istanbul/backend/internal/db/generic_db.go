@@ -19,62 +19,144 @@ package db
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
 	lvlerrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/filter"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
-	"github.com/syndtr/goleveldb/leveldb/util"
 
 	"github.com/celo-org/celo-blockchain/log"
 )
 
 const (
 	dbVersionKey = "version" // Version of the database to flush if changes
+
+	defaultOpenRetryInterval        = 100 * time.Millisecond
+	defaultCorruptionRotationWindow = 24 * time.Hour
+
+	// The suffix of the file next to a persistent db that records the times it was recovered from corruption
+	recoveriesFileSuffix = ".recoveries"
 )
 
-// GenericDB manages a levelDB database
+// openRetrySleep waits between the attempts to open a persistent db, and is replaced in tests
+var openRetrySleep = time.Sleep
+
+// openDBFile opens a persistent db, and is replaced in tests to simulate corruption
+var openDBFile = leveldb.OpenFile
+
+// ErrVersionMismatch is returned when opening a db read-only whose version differs from the expected one
+var ErrVersionMismatch = errors.New("db version mismatch")
+
+// GenericDB manages a database backed by a KVStore, by default levelDB
 type GenericDB struct {
-	db           *leveldb.DB
-	writeOptions *opt.WriteOptions
+	store  KVStore
+	logger log.Logger
+
+	compactionMu                sync.Mutex
+	compactionDeletionThreshold int // 0 disables compaction
+	numDeletionsSinceCompaction int
+	numCompactions              int
+	compacting                  bool           // Whether a background compaction is running
+	compactionWg                sync.WaitGroup // Waits for the background compaction on Close
 }
 
 type GenericEntry interface{}
 
+// Options configures the read performance and compaction of a db.
+// A block cache saves disk reads of recently read blocks, at the cost of up to
+// BlockCacheCapacity bytes of memory.  A bloom filter saves disk reads of missing
+// keys, at the cost of BloomFilterBits bits of memory and disk space per key.
+// The block cache and bloom filter only apply to a persistent db.
+type Options struct {
+	BlockCacheCapacity int // The capacity (in bytes) of the block cache. 0 uses the leveldb default of 8 MiB
+	BloomFilterBits    int // The number of bits per key of the bloom filter. 0 disables the bloom filter
+	// The number of deleted keys after which the db is compacted, so that deleted entries don't bloat it. 0 disables compaction
+	CompactionDeletionThreshold int
+	// If a persistent db can't be opened, use an in-memory db instead of returning an error.
+	// The node can then still operate, but the db contents are lost on restart.
+	MemoryFallback bool
+	// The number of times opening a persistent db is retried before giving up, e.g. while the lock
+	// of a process that just exited lingers. 0 disables retries
+	OpenRetryAttempts int
+	// The delay before the first retry of opening a persistent db, which doubles after each retry. 0 uses a default of 100ms
+	OpenRetryInterval time.Duration
+	// The number of recoveries of a corrupted persistent db within CorruptionRotationWindow after which the next
+	// corrupted db directory is moved aside and a fresh db is started, so that a failing disk doesn't go unnoticed
+	// behind repeated recoveries. 0 disables rotation
+	CorruptionRotationThreshold int
+	// The window in which recoveries count towards CorruptionRotationThreshold. 0 uses a default of a day
+	CorruptionRotationWindow time.Duration
+}
+
 // New will open a new db at the given file path with the given version.
 // If the path is empty, the db will be created in memory.
 // If there is a version mismatch in the existing db, the contents are flushed.
 func New(dbVersion int64, path string, logger log.Logger, writeOptions *opt.WriteOptions) (*GenericDB, error) {
-	db, err := NewDB(dbVersion, path, logger)
+	return NewWithOptions(dbVersion, path, logger, writeOptions, nil)
+}
+
+// NewWithOptions is like New, but applies the given options.
+func NewWithOptions(dbVersion int64, path string, logger log.Logger, writeOptions *opt.WriteOptions, options *Options) (*GenericDB, error) {
+	db, err := NewDB(dbVersion, path, logger, options)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithStore(NewLevelDBStore(db, writeOptions), logger, options), nil
+}
+
+// NewWithStore returns a db backed by the given store.  The store is used as is, i.e. it's
+// not versioned, and only the compaction deletion threshold of the options applies.
+func NewWithStore(store KVStore, logger log.Logger, options *Options) *GenericDB {
+	gdb := &GenericDB{
+		store:  store,
+		logger: logger,
+	}
+	if options != nil {
+		gdb.compactionDeletionThreshold = options.CompactionDeletionThreshold
+	}
+	return gdb
+}
+
+// NewReadOnly opens an existing persistent db at the given file path without modifying it,
+// e.g. for the forensic analysis of a node's on-disk state. Writes to the db fail.
+// Unlike New, a version mismatch returns ErrVersionMismatch instead of flushing the contents.
+func NewReadOnly(dbVersion int64, path string, logger log.Logger) (*GenericDB, error) {
+	db, err := NewReadOnlyDB(dbVersion, path)
 	if err != nil {
 		return nil, err
 	}
-	return &GenericDB{
-		db:           db,
-		writeOptions: writeOptions,
-	}, nil
+	return NewWithStore(NewLevelDBStore(db, nil), logger, nil), nil
 }
 
-// Close flushes and closes the database files.
+// Close waits for a running compaction, and then flushes and closes the database files.
 func (gdb *GenericDB) Close() error {
-	return gdb.db.Close()
+	gdb.compactionWg.Wait()
+	return gdb.store.Close()
 }
 
 // Upsert iterates through each provided entry and determines if the entry is
 // new. If there is an existing entry in the db, `onUpdatedEntry` is called.
 // If there is no existing entry, `onNewEntry` is called. Db content modifications are left to those functions
-// by providing a leveldb Batch that is written after all entries are processed.
+// by providing a Batch that is written after all entries are processed.
 func (gdb *GenericDB) Upsert(
 	entries []GenericEntry,
 	getExistingEntry func(entry GenericEntry) (GenericEntry, error),
-	onUpdatedEntry func(batch *leveldb.Batch, existingEntry GenericEntry, newEntry GenericEntry) error,
-	onNewEntry func(batch *leveldb.Batch, entry GenericEntry) error,
+	onUpdatedEntry func(batch Batch, existingEntry GenericEntry, newEntry GenericEntry) error,
+	onNewEntry func(batch Batch, entry GenericEntry) error,
 ) error {
-	batch := new(leveldb.Batch)
+	batch := gdb.NewBatch()
 	for _, entry := range entries {
 		existingEntry, err := getExistingEntry(entry)
-		isNew := err == leveldb.ErrNotFound
+		isNew := err == ErrNotFound
 		if !isNew && err != nil {
 			return err
 		}
@@ -100,19 +182,84 @@ func (gdb *GenericDB) Upsert(
 
 // Get gets the bytes at a given key in the db
 func (gdb *GenericDB) Get(key []byte) ([]byte, error) {
-	return gdb.db.Get(key, nil)
+	return gdb.store.Get(key)
+}
+
+// NewBatch returns a batch of the db's store, to be written with Write
+func (gdb *GenericDB) NewBatch() Batch {
+	return &countingBatch{Batch: gdb.store.NewBatch()}
+}
+
+// Write writes a Batch returned by NewBatch to modify the db.
+// The db is compacted in the background once the number of keys deleted since the
+// last compaction reaches the compaction deletion threshold.
+func (gdb *GenericDB) Write(batch Batch) error {
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if counted, ok := batch.(*countingBatch); ok && gdb.compactionDeletionThreshold > 0 {
+		gdb.recordDeletions(counted.numDeletions)
+	}
+	return nil
+}
+
+// recordDeletions adds to the number of keys deleted since the last compaction,
+// and starts a background compaction of the entire db if the threshold is reached
+// and no compaction is running.
+func (gdb *GenericDB) recordDeletions(numDeletions int) {
+	store, ok := gdb.store.(compacter)
+	if numDeletions == 0 || !ok {
+		return
+	}
+	gdb.compactionMu.Lock()
+	defer gdb.compactionMu.Unlock()
+
+	gdb.numDeletionsSinceCompaction += numDeletions
+	if gdb.numDeletionsSinceCompaction < gdb.compactionDeletionThreshold || gdb.compacting {
+		return
+	}
+	gdb.logger.Debug("Compacting db", "numDeletions", gdb.numDeletionsSinceCompaction, "threshold", gdb.compactionDeletionThreshold)
+	numCompactedDeletions := gdb.numDeletionsSinceCompaction
+	gdb.numDeletionsSinceCompaction = 0
+	gdb.compacting = true
+	gdb.compactionWg.Add(1)
+	go gdb.compact(store, numCompactedDeletions)
+}
+
+// compact compacts the entire db.  A failed compaction is only logged, since the
+// writes that triggered it were already committed, and its deletions count
+// towards the next compaction.
+func (gdb *GenericDB) compact(store compacter, numDeletions int) {
+	defer gdb.compactionWg.Done()
+	err := store.Compact()
+
+	gdb.compactionMu.Lock()
+	defer gdb.compactionMu.Unlock()
+	gdb.compacting = false
+	if err != nil {
+		gdb.logger.Warn("Error in compacting db", "numDeletions", numDeletions, "err", err)
+		gdb.numDeletionsSinceCompaction += numDeletions
+		return
+	}
+	gdb.numCompactions++
 }
 
-// Write writes a Batch to modify the db
-func (gdb *GenericDB) Write(batch *leveldb.Batch) error {
-	return gdb.db.Write(batch, gdb.writeOptions)
+// countingBatch is a batch of a store that counts its deletes
+type countingBatch struct {
+	Batch
+	numDeletions int
+}
+
+func (b *countingBatch) Delete(key []byte) {
+	b.Batch.Delete(key)
+	b.numDeletions++
 }
 
 // Iterate will iterate through each entry in the db whose key has the prefix
 // keyPrefix, and call `onEntry` with the bytes of the key (without the prefix)
 // and the bytes of the value
 func (gdb *GenericDB) Iterate(keyPrefix []byte, onEntry func([]byte, []byte) error) error {
-	iter := gdb.db.NewIterator(util.BytesPrefix(keyPrefix), nil)
+	iter := gdb.store.NewIterator(keyPrefix)
 	defer iter.Release()
 
 	for iter.Next() {
@@ -127,11 +274,18 @@ func (gdb *GenericDB) Iterate(keyPrefix []byte, onEntry func([]byte, []byte) err
 
 // newDB creates/opens a leveldb persistent database at the given path.
 // If no path is given, an in-memory, temporary database is constructed.
-func NewDB(dbVersion int64, path string, logger log.Logger) (*leveldb.DB, error) {
+// If the persistent database can't be opened and the memory fallback option is
+// set, an in-memory database is constructed instead.
+func NewDB(dbVersion int64, path string, logger log.Logger, options *Options) (*leveldb.DB, error) {
 	if path == "" {
 		return NewMemoryDB()
 	}
-	return NewPersistentDB(dbVersion, path, logger)
+	db, err := NewPersistentDB(dbVersion, path, logger, options)
+	if err != nil && options != nil && options.MemoryFallback {
+		logger.Error("Can't open persistent db, falling back to an in-memory db. Its contents will NOT be persisted", "path", path, "err", err)
+		return NewMemoryDB()
+	}
+	return db, err
 }
 
 // newMemoryDB creates a new in-memory node database without a persistent backend.
@@ -145,11 +299,11 @@ func NewMemoryDB() (*leveldb.DB, error) {
 
 // newPersistentNodeDB creates/opens a leveldb backed persistent database,
 // also flushing its contents in case of a version mismatch.
-func NewPersistentDB(dbVersion int64, path string, logger log.Logger) (*leveldb.DB, error) {
-	opts := &opt.Options{OpenFilesCacheCapacity: 5}
-	db, err := leveldb.OpenFile(path, opts)
+func NewPersistentDB(dbVersion int64, path string, logger log.Logger, options *Options) (*leveldb.DB, error) {
+	opts := leveldbOptions(options)
+	db, err := openFileWithRetry(path, opts, logger, options)
 	if _, iscorrupted := err.(*lvlerrors.ErrCorrupted); iscorrupted {
-		db, err = leveldb.RecoverFile(path, nil)
+		db, err = recoverOrRotate(path, opts, logger, options, err)
 	}
 	if err != nil {
 		return nil, err
@@ -176,8 +330,141 @@ func NewPersistentDB(dbVersion int64, path string, logger log.Logger) (*leveldb.
 			if err = os.RemoveAll(path); err != nil {
 				return nil, err
 			}
-			return NewPersistentDB(dbVersion, path, logger)
+			return NewPersistentDB(dbVersion, path, logger, options)
 		}
 	}
 	return db, nil
 }
+
+// openFileWithRetry opens a leveldb persistent database, retrying with an exponential backoff
+// as many times as the options allow.  A corrupted db isn't retried, since it needs to be recovered.
+func openFileWithRetry(path string, opts *opt.Options, logger log.Logger, options *Options) (*leveldb.DB, error) {
+	attempts, interval := 0, defaultOpenRetryInterval
+	if options != nil {
+		attempts = options.OpenRetryAttempts
+		if options.OpenRetryInterval > 0 {
+			interval = options.OpenRetryInterval
+		}
+	}
+
+	db, err := openDBFile(path, opts)
+	for retry := 1; err != nil && retry <= attempts; retry++ {
+		if _, iscorrupted := err.(*lvlerrors.ErrCorrupted); iscorrupted {
+			break
+		}
+		logger.Warn("Failed to open db, retrying", "path", path, "retry", retry, "maxRetries", attempts, "delay", interval, "err", err)
+		openRetrySleep(interval)
+		interval *= 2
+		db, err = openDBFile(path, opts)
+	}
+	return db, err
+}
+
+// recoverOrRotate recovers a corrupted persistent db.  If corruption rotation is enabled and the db
+// was already recovered CorruptionRotationThreshold times within the rotation window, the corrupted db
+// directory is moved aside to a timestamped path instead, and a fresh db is started.
+func recoverOrRotate(path string, opts *opt.Options, logger log.Logger, options *Options, corruptionErr error) (*leveldb.DB, error) {
+	if options == nil || options.CorruptionRotationThreshold <= 0 {
+		return leveldb.RecoverFile(path, opts)
+	}
+	window := options.CorruptionRotationWindow
+	if window <= 0 {
+		window = defaultCorruptionRotationWindow
+	}
+
+	now := time.Now()
+	recoveriesPath := path + recoveriesFileSuffix
+	recoveries, err := readRecoveries(recoveriesPath, now.Add(-window))
+	if err != nil {
+		logger.Warn("Failed to read the db recovery history", "path", recoveriesPath, "err", err)
+	}
+
+	if len(recoveries) >= options.CorruptionRotationThreshold {
+		rotatedPath := fmt.Sprintf("%s.corrupted-%s", path, now.UTC().Format("20060102T150405Z"))
+		logger.Error("DB keeps getting corrupted, the disk may be failing. Moving it aside and starting a fresh db", "path", path, "rotatedPath", rotatedPath, "recoveries", len(recoveries), "window", window, "err", corruptionErr)
+		if err := os.Rename(path, rotatedPath); err != nil {
+			return nil, err
+		}
+		if err := os.Remove(recoveriesPath); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to remove the db recovery history", "path", recoveriesPath, "err", err)
+		}
+		return leveldb.OpenFile(path, opts)
+	}
+
+	logger.Warn("Recovering corrupted db", "path", path, "recoveries", len(recoveries), "window", window, "err", corruptionErr)
+	db, err := leveldb.RecoverFile(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeRecoveries(recoveriesPath, append(recoveries, now)); err != nil {
+		logger.Warn("Failed to write the db recovery history", "path", recoveriesPath, "err", err)
+	}
+	return db, nil
+}
+
+// readRecoveries reads the times of the recoveries of a db that happened after since.
+// A missing recovery history has no recoveries.
+func readRecoveries(recoveriesPath string, since time.Time) ([]time.Time, error) {
+	blob, err := ioutil.ReadFile(recoveriesPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var recoveries []time.Time
+	for _, line := range strings.Fields(string(blob)) {
+		nanos, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return recoveries, err
+		}
+		if recovery := time.Unix(0, nanos); recovery.After(since) {
+			recoveries = append(recoveries, recovery)
+		}
+	}
+	return recoveries, nil
+}
+
+// writeRecoveries writes the times of the recoveries of a db, one unix nanosecond timestamp per line
+func writeRecoveries(recoveriesPath string, recoveries []time.Time) error {
+	var sb strings.Builder
+	for _, recovery := range recoveries {
+		sb.WriteString(strconv.FormatInt(recovery.UnixNano(), 10))
+		sb.WriteByte('\n')
+	}
+	return ioutil.WriteFile(recoveriesPath, []byte(sb.String()), 0644)
+}
+
+// NewReadOnlyDB opens an existing leveldb persistent database read-only.
+// Neither a corrupted db nor a version mismatch is repaired, an error is returned instead.
+func NewReadOnlyDB(dbVersion int64, path string) (*leveldb.DB, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{OpenFilesCacheCapacity: 5, ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return nil, err
+	}
+	currentVer := make([]byte, binary.MaxVarintLen64)
+	currentVer = currentVer[:binary.PutVarint(currentVer, dbVersion)]
+
+	blob, err := db.Get([]byte(dbVersionKey), nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		db.Close()
+		return nil, err
+	}
+	if !bytes.Equal(blob, currentVer) {
+		db.Close()
+		oldVersion, _ := binary.Varint(blob)
+		return nil, fmt.Errorf("%w: have %d, want %d", ErrVersionMismatch, oldVersion, dbVersion)
+	}
+	return db, nil
+}
+
+// leveldbOptions returns the leveldb options of a persistent db
+func leveldbOptions(options *Options) *opt.Options {
+	opts := &opt.Options{OpenFilesCacheCapacity: 5}
+	if options != nil {
+		opts.BlockCacheCapacity = options.BlockCacheCapacity
+		if options.BloomFilterBits > 0 {
+			opts.Filter = filter.NewBloomFilter(options.BloomFilterBits)
+		}
+	}
+	return opts
+}
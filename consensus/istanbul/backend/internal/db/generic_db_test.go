@@ -1,10 +1,19 @@
 package db
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/celo-org/celo-blockchain/log"
 	"github.com/syndtr/goleveldb/leveldb"
+	lvlerrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
 type mockEntry struct{}
@@ -59,15 +68,15 @@ func upsertEntry(gdb *GenericDB, existingEntry *mockEntry, newEntry *mockEntry)
 
 	getExistingEntry := func(_ GenericEntry) (GenericEntry, error) {
 		if existingEntry == nil {
-			return nil, leveldb.ErrNotFound
+			return nil, ErrNotFound
 		}
 		return existingEntry, nil
 	}
-	onExistingEntry := func(_ *leveldb.Batch, _ GenericEntry, _ GenericEntry) error {
+	onExistingEntry := func(_ Batch, _ GenericEntry, _ GenericEntry) error {
 		onExistingEntryCalled = true
 		return nil
 	}
-	onNewEntry := func(_ *leveldb.Batch, _ GenericEntry) error {
+	onNewEntry := func(_ Batch, _ GenericEntry) error {
 		onNewEntryCalled = true
 		return nil
 	}
@@ -80,3 +89,337 @@ func upsertEntry(gdb *GenericDB, existingEntry *mockEntry, newEntry *mockEntry)
 	)
 	return onExistingEntryCalled, onNewEntryCalled, err
 }
+
+func TestPersistentDBOptions(t *testing.T) {
+	options := &Options{BlockCacheCapacity: 2 * opt.MiB, BloomFilterBits: 10}
+
+	opts := leveldbOptions(options)
+	if opts.BlockCacheCapacity != options.BlockCacheCapacity {
+		t.Errorf("Unexpected block cache capacity. Expected %d, got %d", options.BlockCacheCapacity, opts.BlockCacheCapacity)
+	}
+	if opts.Filter == nil || opts.Filter.Name() != "leveldb.BuiltinBloomFilter" {
+		t.Errorf("Expected a bloom filter, got %v", opts.Filter)
+	}
+	if opts := leveldbOptions(nil); opts.BlockCacheCapacity != 0 || opts.Filter != nil {
+		t.Errorf("Unexpected default options %v", opts)
+	}
+
+	dir, err := ioutil.TempDir("", "generic-db-test")
+	if err != nil {
+		t.Fatal("Failed to create temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	gdb, err := NewWithOptions(int64(0), dir, log.New(), nil, options)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer gdb.Close()
+
+	batch := gdb.NewBatch()
+	batch.Put([]byte("key"), []byte("value"))
+	if err := gdb.Write(batch); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if value, err := gdb.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("Unexpected value. Expected %q, got %q (err: %v)", "value", value, err)
+	}
+	if _, err := gdb.Get([]byte("missing")); err != ErrNotFound {
+		t.Errorf("Unexpected error for missing key. Expected %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestCompactionAfterDeletions(t *testing.T) {
+	gdb, err := NewWithOptions(int64(0), "", log.New(), nil, &Options{CompactionDeletionThreshold: 100})
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer gdb.Close()
+
+	// Simulate many prunes of a few entries each, as when the validator conn set churns
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%d", i)) }
+	for i := 0; i < 250; i++ {
+		batch := gdb.NewBatch()
+		batch.Put(key(i), []byte("value"))
+		if err := gdb.Write(batch); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	for i := 0; i < 250; i += 10 {
+		batch := gdb.NewBatch()
+		for j := i; j < i+10; j++ {
+			batch.Delete(key(j))
+		}
+		if err := gdb.Write(batch); err != nil {
+			t.Fatalf("Failed to prune: %v", err)
+		}
+		// Compactions run in the background
+		gdb.compactionWg.Wait()
+	}
+
+	if gdb.numCompactions != 2 {
+		t.Errorf("Unexpected number of compactions. Expected %d, got %d", 2, gdb.numCompactions)
+	}
+	if gdb.numDeletionsSinceCompaction != 50 {
+		t.Errorf("Unexpected number of deletions since compaction. Expected %d, got %d", 50, gdb.numDeletionsSinceCompaction)
+	}
+	if _, err := gdb.Get(key(0)); err != ErrNotFound {
+		t.Errorf("Unexpected error for deleted key. Expected %v, got %v", ErrNotFound, err)
+	}
+
+	// Compaction is disabled without a threshold
+	gdb, err = New(int64(0), "", log.New(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer gdb.Close()
+	for i := 0; i < 250; i++ {
+		batch := gdb.NewBatch()
+		batch.Delete(key(i))
+		if err := gdb.Write(batch); err != nil {
+			t.Fatalf("Failed to prune: %v", err)
+		}
+	}
+	if gdb.numCompactions != 0 {
+		t.Errorf("Unexpected number of compactions. Expected %d, got %d", 0, gdb.numCompactions)
+	}
+}
+
+// failingCompactionStore is a store whose compactions fail
+type failingCompactionStore struct {
+	KVStore
+}
+
+func (s *failingCompactionStore) Compact() error {
+	return errors.New("compaction failed")
+}
+
+func TestFailedCompaction(t *testing.T) {
+	db, err := NewDB(int64(0), "", log.New(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	gdb := NewWithStore(&failingCompactionStore{NewLevelDBStore(db, nil)}, log.New(), &Options{CompactionDeletionThreshold: 10})
+	defer gdb.Close()
+
+	// The write that triggers a failing compaction still succeeds
+	batch := gdb.NewBatch()
+	for i := 0; i < 10; i++ {
+		batch.Delete([]byte(fmt.Sprintf("key%d", i)))
+	}
+	if err := gdb.Write(batch); err != nil {
+		t.Fatalf("Unexpected error for write that triggered a failing compaction: %v", err)
+	}
+	gdb.compactionWg.Wait()
+
+	// The deletions count towards the next compaction
+	if gdb.numCompactions != 0 {
+		t.Errorf("Unexpected number of compactions. Expected %d, got %d", 0, gdb.numCompactions)
+	}
+	if gdb.numDeletionsSinceCompaction != 10 {
+		t.Errorf("Unexpected number of deletions since compaction. Expected %d, got %d", 10, gdb.numDeletionsSinceCompaction)
+	}
+}
+
+func TestReadOnlyVersionMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generic-db-test")
+	if err != nil {
+		t.Fatal("Failed to create temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	gdb, err := New(int64(0), dir, log.New(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	batch := gdb.NewBatch()
+	batch.Put([]byte("key"), []byte("value"))
+	if err := gdb.Write(batch); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	gdb.Close()
+
+	// A version mismatch is an error instead of flushing the contents
+	if _, err := NewReadOnly(int64(1), dir, log.New()); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Unexpected error. Expected %v, got %v", ErrVersionMismatch, err)
+	}
+
+	gdb, err = NewReadOnly(int64(0), dir, log.New())
+	if err != nil {
+		t.Fatalf("Failed to open DB read-only: %v", err)
+	}
+	defer gdb.Close()
+	if value, err := gdb.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("Unexpected value. Expected %q, got %q (err: %v)", "value", value, err)
+	}
+	batch = gdb.NewBatch()
+	batch.Put([]byte("key"), []byte("value"))
+	if err := gdb.Write(batch); err == nil {
+		t.Error("Writing to a read-only DB should fail")
+	}
+}
+
+func TestMemoryFallback(t *testing.T) {
+	// A regular file in place of the db directory can't be opened
+	file, err := ioutil.TempFile("", "generic-db-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if _, err := NewWithOptions(int64(0), file.Name(), log.New(), nil, &Options{}); err == nil {
+		t.Fatalf("Opened a db without the memory fallback")
+	}
+
+	gdb, err := NewWithOptions(int64(0), file.Name(), log.New(), nil, &Options{MemoryFallback: true})
+	if err != nil {
+		t.Fatalf("Failed to open a db with the memory fallback: %v", err)
+	}
+	defer gdb.Close()
+
+	batch := gdb.NewBatch()
+	batch.Put([]byte("key"), []byte("value"))
+	if err := gdb.Write(batch); err != nil {
+		t.Fatalf("Failed to write to the fallback db: %v", err)
+	}
+	if value, err := gdb.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("Incorrect value in the fallback db.  Want: value, Have: %s, err: %v", value, err)
+	}
+}
+
+func TestOpenRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generic-db-test")
+	if err != nil {
+		t.Fatal("Failed to create temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	// The lock of the db lingers until the first retry
+	lockingDB, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatalf("Failed to open the locking DB: %v", err)
+	}
+	var delays []time.Duration
+	defer func(sleep func(time.Duration)) { openRetrySleep = sleep }(openRetrySleep)
+	openRetrySleep = func(d time.Duration) {
+		delays = append(delays, d)
+		lockingDB.Close()
+	}
+
+	if _, err := NewWithOptions(int64(0), dir, log.New(), nil, &Options{}); err == nil {
+		t.Fatalf("Opened a locked DB without retries")
+	}
+	if len(delays) != 0 {
+		t.Errorf("Retried without retries.  Delays: %v", delays)
+	}
+
+	gdb, err := NewWithOptions(int64(0), dir, log.New(), nil, &Options{OpenRetryAttempts: 3, OpenRetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to open the DB after the lock cleared: %v", err)
+	}
+	gdb.Close()
+	if want := []time.Duration{10 * time.Millisecond}; !reflect.DeepEqual(delays, want) {
+		t.Errorf("Incorrect retry delays.  Want: %v, Have: %v", want, delays)
+	}
+
+	// The delay doubles after each retry, up to the number of attempts
+	lockingDB, err = leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatalf("Failed to open the locking DB: %v", err)
+	}
+	defer lockingDB.Close()
+	delays = nil
+	openRetrySleep = func(d time.Duration) { delays = append(delays, d) }
+	if _, err := NewWithOptions(int64(0), dir, log.New(), nil, &Options{OpenRetryAttempts: 3}); err == nil {
+		t.Fatalf("Opened a locked DB")
+	}
+	if want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}; !reflect.DeepEqual(delays, want) {
+		t.Errorf("Incorrect retry delays.  Want: %v, Have: %v", want, delays)
+	}
+}
+
+func TestCorruptionRotation(t *testing.T) {
+	parent, err := ioutil.TempDir("", "generic-db-test")
+	if err != nil {
+		t.Fatal("Failed to create temp dir")
+	}
+	defer os.RemoveAll(parent)
+	dir := filepath.Join(parent, "db")
+
+	gdb, err := NewWithOptions(int64(0), dir, log.New(), nil, &Options{})
+	if err != nil {
+		t.Fatalf("Failed to create the DB: %v", err)
+	}
+	batch := gdb.NewBatch()
+	batch.Put([]byte("key"), []byte("value"))
+	if err := gdb.Write(batch); err != nil {
+		t.Fatalf("Failed to write to the DB: %v", err)
+	}
+	gdb.Close()
+
+	// Every open of the db finds it corrupted
+	defer func(open func(string, *opt.Options) (*leveldb.DB, error)) { openDBFile = open }(openDBFile)
+	openDBFile = func(string, *opt.Options) (*leveldb.DB, error) {
+		return nil, &lvlerrors.ErrCorrupted{Err: errors.New("simulated corruption")}
+	}
+	options := &Options{CorruptionRotationThreshold: 2, CorruptionRotationWindow: time.Hour}
+
+	// The db is recovered up to the threshold
+	for i := 0; i < 2; i++ {
+		gdb, err := NewWithOptions(int64(0), dir, log.New(), nil, options)
+		if err != nil {
+			t.Fatalf("Failed to recover the DB: %v", err)
+		}
+		if value, err := gdb.Get([]byte("key")); err != nil || string(value) != "value" {
+			t.Errorf("Incorrect value in the recovered DB.  Want: value, Have: %s, err: %v", value, err)
+		}
+		gdb.Close()
+	}
+
+	// Then it's moved aside and a fresh db is started
+	gdb, err = NewWithOptions(int64(0), dir, log.New(), nil, options)
+	if err != nil {
+		t.Fatalf("Failed to rotate the DB: %v", err)
+	}
+	if _, err := gdb.Get([]byte("key")); err != ErrNotFound {
+		t.Errorf("Rotated DB isn't empty.  Want: %v, Have: %v", ErrNotFound, err)
+	}
+	gdb.Close()
+	rotated, err := filepath.Glob(dir + ".corrupted-*")
+	if err != nil || len(rotated) != 1 {
+		t.Fatalf("Incorrect rotated DB directories.  Want: 1, Have: %v, err: %v", rotated, err)
+	}
+	if _, err := os.Stat(dir + recoveriesFileSuffix); !os.IsNotExist(err) {
+		t.Errorf("Recovery history not reset after the rotation.  err: %v", err)
+	}
+	rotatedDB, err := leveldb.OpenFile(rotated[0], nil)
+	if err != nil {
+		t.Fatalf("Failed to open the rotated DB: %v", err)
+	}
+	defer rotatedDB.Close()
+	if value, err := rotatedDB.Get([]byte("key"), nil); err != nil || string(value) != "value" {
+		t.Errorf("Incorrect value in the rotated DB.  Want: value, Have: %s, err: %v", value, err)
+	}
+}
+
+func TestRecoveriesOutsideWindow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generic-db-test")
+	if err != nil {
+		t.Fatal("Failed to create temp dir")
+	}
+	defer os.RemoveAll(dir)
+	recoveriesPath := filepath.Join(dir, "db"+recoveriesFileSuffix)
+
+	now := time.Now()
+	if err := writeRecoveries(recoveriesPath, []time.Time{now.Add(-2 * time.Hour), now.Add(-time.Minute)}); err != nil {
+		t.Fatalf("Failed to write the recovery history: %v", err)
+	}
+	recoveries, err := readRecoveries(recoveriesPath, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to read the recovery history: %v", err)
+	}
+	if len(recoveries) != 1 || !recoveries[0].Equal(now.Add(-time.Minute)) {
+		t.Errorf("Incorrect recoveries within the window.  Want: %v, Have: %v", now.Add(-time.Minute), recoveries)
+	}
+}
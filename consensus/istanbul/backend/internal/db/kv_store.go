@@ -0,0 +1,99 @@
+// Copyright 2017 The Celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"github.com/celo-org/celo-blockchain/consensus/istanbul/kvstore"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// The store types are defined in the public kvstore package, so that embedders can implement them
+type (
+	KVStore  = kvstore.KVStore
+	Batch    = kvstore.Batch
+	Iterator = kvstore.Iterator
+)
+
+// ErrNotFound is returned by a KVStore for a missing key
+var ErrNotFound = kvstore.ErrNotFound
+
+// compacter is implemented by the stores that can reclaim the space of deleted keys
+type compacter interface {
+	Compact() error
+}
+
+// levelDBStore is the leveldb implementation of KVStore
+type levelDBStore struct {
+	db           *leveldb.DB
+	writeOptions *opt.WriteOptions
+}
+
+// NewLevelDBStore returns a KVStore backed by the given leveldb database
+func NewLevelDBStore(db *leveldb.DB, writeOptions *opt.WriteOptions) KVStore {
+	return &levelDBStore{db: db, writeOptions: writeOptions}
+}
+
+func (s *levelDBStore) Get(key []byte) ([]byte, error) {
+	value, err := s.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (s *levelDBStore) Put(key, value []byte) error {
+	return s.db.Put(key, value, s.writeOptions)
+}
+
+func (s *levelDBStore) Delete(key []byte) error {
+	return s.db.Delete(key, s.writeOptions)
+}
+
+func (s *levelDBStore) NewIterator(prefix []byte) Iterator {
+	return s.db.NewIterator(util.BytesPrefix(prefix), nil)
+}
+
+func (s *levelDBStore) NewBatch() Batch {
+	return &levelDBBatch{store: s}
+}
+
+func (s *levelDBStore) Close() error {
+	return s.db.Close()
+}
+
+// Compact compacts the entire db
+func (s *levelDBStore) Compact() error {
+	return s.db.CompactRange(util.Range{})
+}
+
+// levelDBBatch is the leveldb implementation of Batch
+type levelDBBatch struct {
+	store *levelDBStore
+	batch leveldb.Batch
+}
+
+func (b *levelDBBatch) Put(key, value []byte) { b.batch.Put(key, value) }
+
+func (b *levelDBBatch) Delete(key []byte) { b.batch.Delete(key) }
+
+func (b *levelDBBatch) Len() int { return b.batch.Len() }
+
+func (b *levelDBBatch) Write() error {
+	return b.store.db.Write(&b.batch, b.store.writeOptions)
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 The Celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package enodes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/db"
+)
+
+const (
+	dbIndexPrefix   = "index:" // Identifier to prefix index keyed entries with
+	dbLayoutKey     = "layout" // The layout that the entries were written with
+	addressLayoutID = "address"
+)
+
+var (
+	// ErrLayoutMismatch is returned when opening a validator enode database with a different
+	// layout than the one it was written with.  Entries aren't migrated between layouts, the
+	// database has to be deleted instead, after which the entries are re-learned from the network.
+	ErrLayoutMismatch = errors.New("validator enode db layout mismatch")
+
+	errAddressNotInLayout = errors.New("address can't be stored with the validator enode db layout")
+
+	errInvalidIndexKey = errors.New("invalid validator index key")
+
+	errStopIteration = errors.New("stop iteration")
+)
+
+// Layout determines how the entries of a validator enode database are keyed.
+// A database keeps the layout it was created with, see ErrLayoutMismatch.
+type Layout interface {
+	// entryKey returns the key of the address's entry, or false if the layout can't store it
+	entryKey(address common.Address) ([]byte, bool)
+	// entryPrefix returns the prefix of the keys of all entries
+	entryPrefix() []byte
+	// addressFromKey returns the address of an entry from its key without the prefix
+	addressFromKey(key []byte) (common.Address, error)
+	// id identifies the layout within the database
+	id() []byte
+}
+
+// AddressLayout keys the entries by validator address.  It can store any address, and is the
+// layout of databases created before layouts were selectable.
+var AddressLayout Layout = addressLayout{}
+
+type addressLayout struct{}
+
+func (addressLayout) entryKey(address common.Address) ([]byte, bool) {
+	return addressKey(address), true
+}
+
+func (addressLayout) entryPrefix() []byte { return []byte(dbAddressPrefix) }
+
+func (addressLayout) addressFromKey(key []byte) (common.Address, error) {
+	return common.BytesToAddress(key), nil
+}
+
+func (addressLayout) id() []byte { return []byte(addressLayoutID) }
+
+// indexLayout keys the entries by the index of the validator within a fixed validator set,
+// which makes the keys less than a third of the size of address keys
+type indexLayout struct {
+	validators []common.Address
+	indices    map[common.Address]uint16
+}
+
+// IndexLayout returns a layout that keys the entries by the index of the validator within
+// validators, for networks with a small fixed validator set.  Only the entries of these
+// validators are stored, the upserts of others are skipped.  The validators are part of the
+// layout, so a database can't be reopened with a changed validator set.
+func IndexLayout(validators []common.Address) (Layout, error) {
+	if len(validators) > math.MaxUint16+1 {
+		return nil, fmt.Errorf("too many validators for the index layout: %d", len(validators))
+	}
+	indices := make(map[common.Address]uint16, len(validators))
+	for i, validator := range validators {
+		if _, ok := indices[validator]; ok {
+			return nil, fmt.Errorf("duplicate validator in the index layout: %s", validator.Hex())
+		}
+		indices[validator] = uint16(i)
+	}
+	return &indexLayout{validators: append([]common.Address(nil), validators...), indices: indices}, nil
+}
+
+func (l *indexLayout) entryKey(address common.Address) ([]byte, bool) {
+	index, ok := l.indices[address]
+	if !ok {
+		return nil, false
+	}
+	key := make([]byte, len(dbIndexPrefix)+2)
+	copy(key, dbIndexPrefix)
+	binary.BigEndian.PutUint16(key[len(dbIndexPrefix):], index)
+	return key, true
+}
+
+func (l *indexLayout) entryPrefix() []byte { return []byte(dbIndexPrefix) }
+
+func (l *indexLayout) addressFromKey(key []byte) (common.Address, error) {
+	if len(key) != 2 {
+		return common.ZeroAddress, errInvalidIndexKey
+	}
+	index := binary.BigEndian.Uint16(key)
+	if int(index) >= len(l.validators) {
+		return common.ZeroAddress, errInvalidIndexKey
+	}
+	return l.validators[index], nil
+}
+
+func (l *indexLayout) id() []byte {
+	id := []byte(dbIndexPrefix)
+	for _, validator := range l.validators {
+		id = append(id, validator.Bytes()...)
+	}
+	return id
+}
+
+// checkLayout verifies that the database was written with the given layout, and records the
+// layout in a new database unless it's read-only
+func checkLayout(gdb *db.GenericDB, layout Layout, readOnly bool) error {
+	id, err := gdb.Get([]byte(dbLayoutKey))
+	if err == nil {
+		if !bytes.Equal(id, layout.id()) {
+			return ErrLayoutMismatch
+		}
+		return nil
+	} else if err != db.ErrNotFound {
+		return err
+	}
+
+	// A database without a recorded layout uses the address layout, if it has any entries
+	if !bytes.Equal(layout.id(), AddressLayout.id()) {
+		hasAddressEntries := false
+		err := gdb.Iterate(AddressLayout.entryPrefix(), func([]byte, []byte) error {
+			hasAddressEntries = true
+			return errStopIteration
+		})
+		if err != nil && err != errStopIteration {
+			return err
+		}
+		if hasAddressEntries {
+			return ErrLayoutMismatch
+		}
+	}
+
+	if readOnly {
+		return nil
+	}
+	batch := gdb.NewBatch()
+	batch.Put([]byte(dbLayoutKey), layout.id())
+	return gdb.Write(batch)
+}
@@ -17,12 +17,15 @@
 package enodes
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 
 	"github.com/celo-org/celo-blockchain/common"
@@ -31,6 +34,7 @@ import (
 	"github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/db"
 	"github.com/celo-org/celo-blockchain/crypto"
 	"github.com/celo-org/celo-blockchain/log"
+	"github.com/celo-org/celo-blockchain/metrics"
 	"github.com/celo-org/celo-blockchain/p2p/enode"
 	"github.com/celo-org/celo-blockchain/rlp"
 )
@@ -66,28 +70,124 @@ func addressEntryFromGenericEntry(entry db.GenericEntry) (*istanbul.AddressEntry
 // ValidatorEnodeDB represents a Map that can be accessed either
 // by address or enode
 type ValidatorEnodeDB struct {
-	gdb     *db.GenericDB
-	lock    sync.RWMutex
-	handler ValidatorEnodeHandler
-	logger  log.Logger
+	gdb        *db.GenericDB
+	layout     Layout
+	lock       sync.RWMutex
+	handler    ValidatorEnodeHandler
+	logger     log.Logger
+	numEntries int64 // The number of address entries in the db.  Accessed atomically
+	sizeGauge  metrics.Gauge
+
+	// The latest conflict for each enode that was upserted for more than one address
+	enodeConflicts   map[enode.ID]*EnodeConflict
+	enodeConflictsMu sync.Mutex
+}
+
+// EnodeConflict describes an enode that was upserted for more than one validator address,
+// which is either a misconfiguration or an attempt to impersonate a validator.
+// Only the address with the higher version (or the lower address, for equal versions) keeps the enode.
+type EnodeConflict struct {
+	EnodeURL   string           `json:"enodeURL"`
+	Addresses  []common.Address `json:"addresses"` // The address that kept the enode, and the one that didn't
+	DetectedAt time.Time        `json:"detectedAt"`
 }
 
 // OpenValidatorEnodeDB opens a validator enode database for storing and retrieving infos about validator
 // enodes. If no path is given an in-memory, temporary database is constructed.
 func OpenValidatorEnodeDB(path string, handler ValidatorEnodeHandler) (*ValidatorEnodeDB, error) {
+	return OpenValidatorEnodeDBWithOptions(path, handler, nil)
+}
+
+// OpenValidatorEnodeDBWithOptions is like OpenValidatorEnodeDB, but configures a persistent
+// database with the given leveldb options.
+func OpenValidatorEnodeDBWithOptions(path string, handler ValidatorEnodeHandler, options *db.Options) (*ValidatorEnodeDB, error) {
+	return OpenValidatorEnodeDBWithLayout(path, handler, options, AddressLayout)
+}
+
+// OpenValidatorEnodeDBWithLayout is like OpenValidatorEnodeDBWithOptions, but keys the entries
+// with the given layout.  Returns ErrLayoutMismatch if the persistent database was written with
+// a different layout.
+func OpenValidatorEnodeDBWithLayout(path string, handler ValidatorEnodeHandler, options *db.Options, layout Layout) (*ValidatorEnodeDB, error) {
 	logger := log.New("db", "ValidatorEnodeDB")
 
-	gdb, err := db.New(int64(valEnodeDBVersion), path, logger, &opt.WriteOptions{NoWriteMerge: true})
+	gdb, err := db.NewWithOptions(int64(valEnodeDBVersion), path, logger, &opt.WriteOptions{NoWriteMerge: true}, options)
 	if err != nil {
 		logger.Error("Error creating db", "err", err)
 		return nil, err
 	}
+	vet, err := newValidatorEnodeDB(gdb, handler, logger, layout, false)
+	if err != nil {
+		gdb.Close()
+		return nil, err
+	}
+	return vet, nil
+}
+
+// OpenValidatorEnodeDBReadOnly opens an existing persistent validator enode database without
+// modifying it. Upserts and removals fail, and a version mismatch is returned as an error
+// instead of flushing the database.  Only databases with the address layout can be opened.
+func OpenValidatorEnodeDBReadOnly(path string) (*ValidatorEnodeDB, error) {
+	logger := log.New("db", "ValidatorEnodeDB")
+
+	gdb, err := db.NewReadOnly(int64(valEnodeDBVersion), path, logger)
+	if err != nil {
+		logger.Error("Error opening db read-only", "err", err)
+		return nil, err
+	}
+	vet, err := newValidatorEnodeDB(gdb, nil, logger, AddressLayout, true)
+	if err != nil {
+		gdb.Close()
+		return nil, err
+	}
+	return vet, nil
+}
+
+// NewValidatorEnodeDBWithStore returns a validator enode database backed by the given store,
+// instead of leveldb, that keys the entries with the given layout.  The store isn't versioned,
+// so it must be flushed by the caller if the entry format changes.
+func NewValidatorEnodeDBWithStore(store db.KVStore, handler ValidatorEnodeHandler, options *db.Options, layout Layout) (*ValidatorEnodeDB, error) {
+	logger := log.New("db", "ValidatorEnodeDB")
+	return newValidatorEnodeDB(db.NewWithStore(store, logger, options), handler, logger, layout, false)
+}
+
+func newValidatorEnodeDB(gdb *db.GenericDB, handler ValidatorEnodeHandler, logger log.Logger, layout Layout, readOnly bool) (*ValidatorEnodeDB, error) {
+	if err := checkLayout(gdb, layout, readOnly); err != nil {
+		logger.Error("Error checking db layout", "err", err)
+		return nil, err
+	}
+
+	vet := &ValidatorEnodeDB{
+		gdb:       gdb,
+		layout:    layout,
+		handler:   handler,
+		logger:    logger,
+		sizeGauge: metrics.NewRegisteredGauge("consensus/istanbul/announce/valenodedb/size", nil),
+
+		enodeConflicts: make(map[enode.ID]*EnodeConflict),
+	}
+
+	// Count the existing entries once, the count is maintained incrementally from then on
+	var numEntries int64
+	if err := vet.iterateOverAddressEntries(func(common.Address, *istanbul.AddressEntry) error {
+		numEntries++
+		return nil
+	}); err != nil {
+		logger.Error("Error counting db entries", "err", err)
+		return nil, err
+	}
+	vet.addNumEntries(numEntries)
+
+	return vet, nil
+}
+
+// Size returns the number of entries in the table
+func (vet *ValidatorEnodeDB) Size() int64 {
+	return atomic.LoadInt64(&vet.numEntries)
+}
 
-	return &ValidatorEnodeDB{
-		gdb:     gdb,
-		handler: handler,
-		logger:  logger,
-	}, nil
+// addNumEntries adds delta to the number of entries in the table and updates the size gauge
+func (vet *ValidatorEnodeDB) addNumEntries(delta int64) {
+	vet.sizeGauge.Update(atomic.AddInt64(&vet.numEntries, delta))
 }
 
 // Close flushes and closes the database files.
@@ -194,13 +294,38 @@ func (vet *ValidatorEnodeDB) GetValEnodes(valAddresses []common.Address) (map[co
 	return entries, nil
 }
 
+// GetValEnodesWithFilter will return the entries in the valEnodeDB for which filter returns true.
+// The filter is applied while iterating over the db, so entries that are filtered out are never
+// collected.
+func (vet *ValidatorEnodeDB) GetValEnodesWithFilter(filter func(*istanbul.AddressEntry) bool) ([]*istanbul.AddressEntry, error) {
+	vet.lock.RLock()
+	defer vet.lock.RUnlock()
+	var entries []*istanbul.AddressEntry
+
+	err := vet.iterateOverAddressEntries(func(address common.Address, entry *istanbul.AddressEntry) error {
+		if filter(entry) {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+
+	if err != nil {
+		vet.logger.Error("ValidatorEnodeDB.GetValEnodesWithFilter error", "err", err)
+		return nil, err
+	}
+
+	return entries, nil
+}
+
 // UpsertHighestKnownVersion function will do the following
 // 1. Check if the updated HighestKnownVersion is higher than the existing HighestKnownVersion
-// 2. Update the fields HighestKnownVersion, NumQueryAttempsForHKVersion, and PublicKey
+// 2. Update the fields HighestKnownVersion and PublicKey
+// 3. If HighestKnownVersion increased, reset NumQueryAttemptsForHKVersion and LastQueryTimestamp
+//    so that the query backoff of the previous version doesn't delay querying the new one
 func (vet *ValidatorEnodeDB) UpsertHighestKnownVersion(valEnodeEntries []*istanbul.AddressEntry) error {
 	logger := vet.logger.New("func", "UpsertHighestKnownVersion")
 
-	onNewEntry := func(batch *leveldb.Batch, entry db.GenericEntry) error {
+	onNewEntry := func(batch db.Batch, entry db.GenericEntry) error {
 		addressEntry, err := addressEntryFromGenericEntry(entry)
 		if err != nil {
 			return err
@@ -209,14 +334,18 @@ func (vet *ValidatorEnodeDB) UpsertHighestKnownVersion(valEnodeEntries []*istanb
 		if err != nil {
 			return err
 		}
+		key, err := vet.entryKey(addressEntry.Address)
+		if err != nil {
+			return err
+		}
 		if addressEntry.Node != nil {
 			batch.Put(nodeIDKey(addressEntry.Node.ID()), addressEntry.Address.Bytes())
 		}
-		batch.Put(addressKey(addressEntry.Address), entryBytes)
+		batch.Put(key, entryBytes)
 		return nil
 	}
 
-	onUpdatedEntry := func(batch *leveldb.Batch, existingEntry db.GenericEntry, newEntry db.GenericEntry) error {
+	onUpdatedEntry := func(batch db.Batch, existingEntry db.GenericEntry, newEntry db.GenericEntry) error {
 		existingAddressEntry, err := addressEntryFromGenericEntry(existingEntry)
 		if err != nil {
 			return err
@@ -234,10 +363,16 @@ func (vet *ValidatorEnodeDB) UpsertHighestKnownVersion(valEnodeEntries []*istanb
 		// "Backfill" all other fields
 		newAddressEntry.Node = existingAddressEntry.Node
 		newAddressEntry.Version = existingAddressEntry.Version
-		newAddressEntry.LastQueryTimestamp = existingAddressEntry.LastQueryTimestamp
+		newAddressEntry.Reachability = existingAddressEntry.Reachability
 
-		// Set NumQueryAttemptsForHKVersion to 0
-		newAddressEntry.NumQueryAttemptsForHKVersion = 0
+		// Reset the query stats if HighestKnownVersion increased
+		if newAddressEntry.HighestKnownVersion > existingAddressEntry.HighestKnownVersion {
+			newAddressEntry.NumQueryAttemptsForHKVersion = 0
+			newAddressEntry.LastQueryTimestamp = nil
+		} else {
+			newAddressEntry.NumQueryAttemptsForHKVersion = existingAddressEntry.NumQueryAttemptsForHKVersion
+			newAddressEntry.LastQueryTimestamp = existingAddressEntry.LastQueryTimestamp
+		}
 
 		return onNewEntry(batch, newAddressEntry)
 	}
@@ -252,20 +387,60 @@ func (vet *ValidatorEnodeDB) UpsertHighestKnownVersion(valEnodeEntries []*istanb
 
 // UpsertVersionAndEnode will do the following
 // 1. Check if the updated Version higher than the existing Version
-// 2. Update Node, Version, HighestKnownVersion (if it's less than the new Version)
+// 2. Update Node, Version, HighestKnownVersion (if it's less than the new Version, resetting the query stats)
 // 3. If the Node has been updated, establish new validator peer
+// 4. If the Node is already associated with a different address, only keep it for the entry with the
+//    higher version and record the conflict
 func (vet *ValidatorEnodeDB) UpsertVersionAndEnode(valEnodeEntries []*istanbul.AddressEntry) error {
 	logger := vet.logger.New("func", "UpsertVersionAndEnode")
 
 	peersToRemove := make([]*enode.Node, 0, len(valEnodeEntries))
 	peersToAdd := make(map[common.Address]*enode.Node)
+	// The entries upserted with a node so far, as the batch isn't visible to reads of the db
+	batchNodeOwners := make(map[enode.ID]*istanbul.AddressEntry)
+
+	// claimNode resolves a conflict with another address that has the entry's node, and returns
+	// false if the entry lost the conflict and must be skipped
+	claimNode := func(batch db.Batch, addressEntry *istanbul.AddressEntry) (bool, error) {
+		if addressEntry.Node == nil {
+			return true, nil
+		}
+		owner, err := vet.getNodeOwner(batchNodeOwners, addressEntry.Node)
+		if err != nil {
+			return false, err
+		}
+		if owner != nil && owner.Address != addressEntry.Address {
+			kept, dropped := resolveEnodeConflict(owner, addressEntry)
+			vet.recordEnodeConflict(addressEntry.Node, kept.Address, dropped.Address)
+			logger.Warn("Enode URL is used by multiple validator addresses, only keeping the entry with the higher version", "enodeURL", addressEntry.Node.URLv4(), "kept", kept.Address, "keptVersion", kept.Version, "dropped", dropped.Address, "droppedVersion", dropped.Version)
+			if kept == owner {
+				return false, nil
+			}
+			// Remove the enode from the previous owner's entry.  The enode's peer is kept,
+			// as it's now associated with the new address.
+			ownerCopy := *owner
+			ownerCopy.Node = nil
+			ownerBytes, err := rlp.EncodeToBytes(&ownerCopy)
+			if err != nil {
+				return false, err
+			}
+			ownerKey, err := vet.entryKey(owner.Address)
+			if err != nil {
+				return false, err
+			}
+			batch.Put(ownerKey, ownerBytes)
+			delete(peersToAdd, owner.Address)
+		}
+		batchNodeOwners[addressEntry.Node.ID()] = addressEntry
+		return true, nil
+	}
 
-	onNewEntry := func(batch *leveldb.Batch, entry db.GenericEntry) error {
-		addressEntry, err := addressEntryFromGenericEntry(entry)
+	putEntry := func(batch db.Batch, addressEntry *istanbul.AddressEntry) error {
+		entryBytes, err := rlp.EncodeToBytes(addressEntry)
 		if err != nil {
 			return err
 		}
-		entryBytes, err := rlp.EncodeToBytes(addressEntry)
+		key, err := vet.entryKey(addressEntry.Address)
 		if err != nil {
 			return err
 		}
@@ -273,11 +448,22 @@ func (vet *ValidatorEnodeDB) UpsertVersionAndEnode(valEnodeEntries []*istanbul.A
 			batch.Put(nodeIDKey(addressEntry.Node.ID()), addressEntry.Address.Bytes())
 			peersToAdd[addressEntry.Address] = addressEntry.Node
 		}
-		batch.Put(addressKey(addressEntry.Address), entryBytes)
+		batch.Put(key, entryBytes)
 		return nil
 	}
 
-	onUpdatedEntry := func(batch *leveldb.Batch, existingEntry db.GenericEntry, newEntry db.GenericEntry) error {
+	onNewEntry := func(batch db.Batch, entry db.GenericEntry) error {
+		addressEntry, err := addressEntryFromGenericEntry(entry)
+		if err != nil {
+			return err
+		}
+		if ok, err := claimNode(batch, addressEntry); !ok {
+			return err
+		}
+		return putEntry(batch, addressEntry)
+	}
+
+	onUpdatedEntry := func(batch db.Batch, existingEntry db.GenericEntry, newEntry db.GenericEntry) error {
 		existingAddressEntry, err := addressEntryFromGenericEntry(existingEntry)
 		if err != nil {
 			return err
@@ -300,17 +486,25 @@ func (vet *ValidatorEnodeDB) UpsertVersionAndEnode(valEnodeEntries []*istanbul.A
 		if newAddressEntry.Version > existingAddressEntry.HighestKnownVersion {
 			newAddressEntry.HighestKnownVersion = newAddressEntry.Version
 			newAddressEntry.NumQueryAttemptsForHKVersion = 0
+			newAddressEntry.LastQueryTimestamp = nil
 		} else {
 			newAddressEntry.HighestKnownVersion = existingAddressEntry.HighestKnownVersion
 		}
 
+		if ok, err := claimNode(batch, newAddressEntry); !ok {
+			return err
+		}
+
 		enodeChanged := existingAddressEntry.Node != nil && newAddressEntry.Node != nil && existingAddressEntry.Node.String() != newAddressEntry.Node.String()
 		if enodeChanged {
 			batch.Delete(nodeIDKey(existingAddressEntry.Node.ID()))
 			peersToRemove = append(peersToRemove, existingAddressEntry.Node)
+		} else if existingAddressEntry.Node != nil {
+			// The reachability of a changed node is unknown
+			newAddressEntry.Reachability = existingAddressEntry.Reachability
 		}
 
-		return onNewEntry(batch, newAddressEntry)
+		return putEntry(batch, newAddressEntry)
 	}
 
 	if err := vet.upsert(valEnodeEntries, onNewEntry, onUpdatedEntry); err != nil {
@@ -335,7 +529,7 @@ func (vet *ValidatorEnodeDB) UpsertVersionAndEnode(valEnodeEntries []*istanbul.A
 func (vet *ValidatorEnodeDB) UpdateQueryEnodeStats(valEnodeEntries []*istanbul.AddressEntry) error {
 	logger := vet.logger.New("func", "UpdateEnodeQueryStats")
 
-	onNewEntry := func(batch *leveldb.Batch, entry db.GenericEntry) error {
+	onNewEntry := func(batch db.Batch, entry db.GenericEntry) error {
 		addressEntry, err := addressEntryFromGenericEntry(entry)
 		if err != nil {
 			return err
@@ -344,14 +538,18 @@ func (vet *ValidatorEnodeDB) UpdateQueryEnodeStats(valEnodeEntries []*istanbul.A
 		if err != nil {
 			return err
 		}
+		key, err := vet.entryKey(addressEntry.Address)
+		if err != nil {
+			return err
+		}
 		if addressEntry.Node != nil {
 			batch.Put(nodeIDKey(addressEntry.Node.ID()), addressEntry.Address.Bytes())
 		}
-		batch.Put(addressKey(addressEntry.Address), entryBytes)
+		batch.Put(key, entryBytes)
 		return nil
 	}
 
-	onUpdatedEntry := func(batch *leveldb.Batch, existingEntry db.GenericEntry, newEntry db.GenericEntry) error {
+	onUpdatedEntry := func(batch db.Batch, existingEntry db.GenericEntry, newEntry db.GenericEntry) error {
 		existingAddressEntry, err := addressEntryFromGenericEntry(existingEntry)
 		if err != nil {
 			return err
@@ -373,6 +571,7 @@ func (vet *ValidatorEnodeDB) UpdateQueryEnodeStats(valEnodeEntries []*istanbul.A
 		newAddressEntry.Node = existingAddressEntry.Node
 		newAddressEntry.Version = existingAddressEntry.Version
 		newAddressEntry.HighestKnownVersion = existingAddressEntry.HighestKnownVersion
+		newAddressEntry.Reachability = existingAddressEntry.Reachability
 
 		return onNewEntry(batch, newAddressEntry)
 	}
@@ -391,8 +590,8 @@ func (vet *ValidatorEnodeDB) UpdateQueryEnodeStats(valEnodeEntries []*istanbul.A
 //        and/or connect the corresponding validator connenctions.  The validator connections
 //        should be managed be a separate thread (see https://github.com/celo-org/celo-blockchain/issues/607)
 func (vet *ValidatorEnodeDB) upsert(valEnodeEntries []*istanbul.AddressEntry,
-	onNewEntry func(batch *leveldb.Batch, entry db.GenericEntry) error,
-	onUpdatedEntry func(batch *leveldb.Batch, existingEntry db.GenericEntry, newEntry db.GenericEntry) error) error {
+	onNewEntry func(batch db.Batch, entry db.GenericEntry) error,
+	onUpdatedEntry func(batch db.Batch, existingEntry db.GenericEntry, newEntry db.GenericEntry) error) error {
 	logger := vet.logger.New("func", "Upsert")
 	vet.lock.Lock()
 	defer vet.lock.Unlock()
@@ -405,15 +604,34 @@ func (vet *ValidatorEnodeDB) upsert(valEnodeEntries []*istanbul.AddressEntry,
 		return vet.getAddressEntry(addressEntry.Address)
 	}
 
-	entries := make([]db.GenericEntry, len(valEnodeEntries))
-	for i, valEnodeEntry := range valEnodeEntries {
-		entries[i] = db.GenericEntry(valEnodeEntry)
+	// Track the addresses that weren't in the table yet, so the size can be updated without a scan
+	insertedAddresses := make(map[common.Address]bool)
+	onInsertedEntry := func(batch db.Batch, entry db.GenericEntry) error {
+		if err := onNewEntry(batch, entry); err != nil {
+			return err
+		}
+		addressEntry, err := addressEntryFromGenericEntry(entry)
+		if err != nil {
+			return err
+		}
+		insertedAddresses[addressEntry.Address] = true
+		return nil
+	}
+
+	entries := make([]db.GenericEntry, 0, len(valEnodeEntries))
+	for _, valEnodeEntry := range valEnodeEntries {
+		if _, ok := vet.layout.entryKey(valEnodeEntry.Address); !ok {
+			logger.Trace("Skipping entry of an address that the layout can't store", "address", valEnodeEntry.Address)
+			continue
+		}
+		entries = append(entries, db.GenericEntry(valEnodeEntry))
 	}
 
-	if err := vet.gdb.Upsert(entries, getExistingEntry, onUpdatedEntry, onNewEntry); err != nil {
+	if err := vet.gdb.Upsert(entries, getExistingEntry, onUpdatedEntry, onInsertedEntry); err != nil {
 		logger.Warn("Error upserting entries", "err", err)
 		return err
 	}
+	vet.addNumEntries(int64(len(insertedAddresses)))
 
 	return nil
 }
@@ -422,29 +640,192 @@ func (vet *ValidatorEnodeDB) upsert(valEnodeEntries []*istanbul.AddressEntry,
 func (vet *ValidatorEnodeDB) RemoveEntry(address common.Address) error {
 	vet.lock.Lock()
 	defer vet.lock.Unlock()
-	batch := new(leveldb.Batch)
+	batch := vet.gdb.NewBatch()
 	err := vet.addDeleteToBatch(batch, address)
 	if err != nil {
 		return err
 	}
-	return vet.gdb.Write(batch)
+	if err := vet.gdb.Write(batch); err != nil {
+		return err
+	}
+	vet.addNumEntries(-1)
+	return nil
 }
 
-// PruneEntries will remove entries for all address not present in addressesToKeep
-func (vet *ValidatorEnodeDB) PruneEntries(addressesToKeep map[common.Address]bool) error {
+// PruneEntries will remove entries for all address not present in addressesToKeep, and
+// returns the addresses of the removed entries
+func (vet *ValidatorEnodeDB) PruneEntries(addressesToKeep map[common.Address]bool) ([]common.Address, error) {
 	vet.lock.Lock()
 	defer vet.lock.Unlock()
-	batch := new(leveldb.Batch)
+	batch := vet.gdb.NewBatch()
+	var removed []common.Address
 	err := vet.iterateOverAddressEntries(func(address common.Address, entry *istanbul.AddressEntry) error {
 		if !addressesToKeep[address] {
 			vet.logger.Trace("Deleting entry from valEnodeTable", "address", address)
+			removed = append(removed, address)
 			return vet.addDeleteToBatch(batch, address)
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	if err := vet.gdb.Write(batch); err != nil {
+		return nil, err
+	}
+	vet.addNumEntries(-int64(len(removed)))
+	return removed, nil
+}
+
+// ReplaceAll atomically replaces the contents of the table with the given entries, e.g. when
+// restoring a snapshot.  The new entries are written and the absent ones deleted in a single
+// batch, so concurrent readers see either the old or the new table, and the validator peers are
+// replaced with a single notification.  If an address occurs multiple times, the last entry is used.
+func (vet *ValidatorEnodeDB) ReplaceAll(valEnodeEntries []*istanbul.AddressEntry) error {
+	logger := vet.logger.New("func", "ReplaceAll")
+	vet.lock.Lock()
+	defer vet.lock.Unlock()
+
+	batch := vet.gdb.NewBatch()
+	var numExisting int64
+	err := vet.iterateOverAddressEntries(func(address common.Address, entry *istanbul.AddressEntry) error {
+		numExisting++
+		key, err := vet.entryKey(address)
+		if err != nil {
+			return err
+		}
+		batch.Delete(key)
+		if entry.Node != nil {
+			batch.Delete(nodeIDKey(entry.Node.ID()))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Deletes of keys that are rewritten are superseded by the later puts within the batch
+	newEntries := make(map[common.Address]*istanbul.AddressEntry)
+	for _, entry := range valEnodeEntries {
+		if _, ok := vet.layout.entryKey(entry.Address); !ok {
+			logger.Trace("Skipping entry of an address that the layout can't store", "address", entry.Address)
+			continue
+		}
+		newEntries[entry.Address] = entry
+	}
+	newNodes := make([]*enode.Node, 0, len(newEntries))
+	for address, entry := range newEntries {
+		entryBytes, err := rlp.EncodeToBytes(entry)
+		if err != nil {
+			return err
+		}
+		key, err := vet.entryKey(address)
+		if err != nil {
+			return err
+		}
+		batch.Put(key, entryBytes)
+		if entry.Node != nil {
+			batch.Put(nodeIDKey(entry.Node.ID()), address.Bytes())
+			newNodes = append(newNodes, entry.Node)
+		}
+	}
+
+	if err := vet.gdb.Write(batch); err != nil {
+		logger.Warn("Error replacing entries", "err", err)
+		return err
+	}
+	vet.addNumEntries(int64(len(newEntries)) - numExisting)
+
+	if vet.handler != nil {
+		vet.handler.ReplaceValidatorPeers(newNodes)
+	}
+	return nil
+}
+
+// getNodeOwner returns the entry of the address that node is currently associated with, if any.
+// Entries upserted earlier in the same batch, in batchNodeOwners, take precedence over the db.
+func (vet *ValidatorEnodeDB) getNodeOwner(batchNodeOwners map[enode.ID]*istanbul.AddressEntry, node *enode.Node) (*istanbul.AddressEntry, error) {
+	if owner, ok := batchNodeOwners[node.ID()]; ok {
+		return owner, nil
+	}
+	rawAddress, err := vet.gdb.Get(nodeIDKey(node.ID()))
+	if err == db.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	owner, err := vet.getAddressEntry(common.BytesToAddress(rawAddress))
+	if err == db.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	// The owner's entry may have moved on to a different node
+	if owner.Node == nil || owner.Node.ID() != node.ID() {
+		return nil, nil
+	}
+	return owner, nil
+}
+
+// resolveEnodeConflict deterministically decides which of two entries with the same node keeps
+// it: the one with the higher version, or the one with the lower address for equal versions
+func resolveEnodeConflict(a, b *istanbul.AddressEntry) (kept, dropped *istanbul.AddressEntry) {
+	if a.Version > b.Version || (a.Version == b.Version && bytes.Compare(a.Address.Bytes(), b.Address.Bytes()) < 0) {
+		return a, b
+	}
+	return b, a
+}
+
+func (vet *ValidatorEnodeDB) recordEnodeConflict(node *enode.Node, kept, dropped common.Address) {
+	vet.enodeConflictsMu.Lock()
+	defer vet.enodeConflictsMu.Unlock()
+	vet.enodeConflicts[node.ID()] = &EnodeConflict{
+		EnodeURL:   node.URLv4(),
+		Addresses:  []common.Address{kept, dropped},
+		DetectedAt: time.Now(),
+	}
+}
+
+// EnodeConflicts returns the latest conflict for each enode that was upserted for more than one address
+func (vet *ValidatorEnodeDB) EnodeConflicts() []*EnodeConflict {
+	vet.enodeConflictsMu.Lock()
+	defer vet.enodeConflictsMu.Unlock()
+	conflicts := make([]*EnodeConflict, 0, len(vet.enodeConflicts))
+	for _, conflict := range vet.enodeConflicts {
+		conflicts = append(conflicts, conflict)
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].EnodeURL < conflicts[j].EnodeURL })
+	return conflicts
+}
+
+// UpdateReachability sets the reachability of the entry with address `address`, if its
+// node is still `node`.  Otherwise the result is outdated, and the entry isn't modified.
+func (vet *ValidatorEnodeDB) UpdateReachability(address common.Address, node *enode.Node, reachability istanbul.Reachability) error {
+	vet.lock.Lock()
+	defer vet.lock.Unlock()
+
+	entry, err := vet.getAddressEntry(address)
+	if err == db.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if entry.Node == nil || entry.Node.String() != node.String() {
+		vet.logger.Trace("Ignoring reachability of an outdated node", "address", address, "node", node)
+		return nil
+	}
+
+	entry.Reachability = reachability
+	entryBytes, err := rlp.EncodeToBytes(entry)
 	if err != nil {
 		return err
 	}
+	key, err := vet.entryKey(address)
+	if err != nil {
+		return err
+	}
+	batch := vet.gdb.NewBatch()
+	batch.Put(key, entryBytes)
 	return vet.gdb.Write(batch)
 }
 
@@ -461,7 +842,7 @@ func (vet *ValidatorEnodeDB) RefreshValPeers(valConnSet map[common.Address]bool,
 			if entry != nil && entry.Node != nil {
 				if err == nil {
 					newNodes = append(newNodes, entry.Node)
-				} else if err != leveldb.ErrNotFound {
+				} else if err != db.ErrNotFound {
 					vet.logger.Error("Error reading valEnodeTable: GetEnodeURLFromAddress", "err", err)
 				}
 			}
@@ -474,13 +855,17 @@ func (vet *ValidatorEnodeDB) RefreshValPeers(valConnSet map[common.Address]bool,
 	}
 }
 
-func (vet *ValidatorEnodeDB) addDeleteToBatch(batch *leveldb.Batch, address common.Address) error {
+func (vet *ValidatorEnodeDB) addDeleteToBatch(batch db.Batch, address common.Address) error {
 	entry, err := vet.getAddressEntry(address)
 	if err != nil {
 		return err
 	}
+	key, err := vet.entryKey(address)
+	if err != nil {
+		return err
+	}
 
-	batch.Delete(addressKey(address))
+	batch.Delete(key)
 	if entry.Node != nil {
 		batch.Delete(nodeIDKey(entry.Node.ID()))
 		if vet.handler != nil {
@@ -490,9 +875,23 @@ func (vet *ValidatorEnodeDB) addDeleteToBatch(batch *leveldb.Batch, address comm
 	return nil
 }
 
+// entryKey returns the db key of the address's entry with the table's layout
+func (vet *ValidatorEnodeDB) entryKey(address common.Address) ([]byte, error) {
+	key, ok := vet.layout.entryKey(address)
+	if !ok {
+		return nil, errAddressNotInLayout
+	}
+	return key, nil
+}
+
 func (vet *ValidatorEnodeDB) getAddressEntry(address common.Address) (*istanbul.AddressEntry, error) {
 	var entry istanbul.AddressEntry
-	entryBytes, err := vet.gdb.Get(addressKey(address))
+	// An address that the layout can't store has no entry
+	key, ok := vet.layout.entryKey(address)
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	entryBytes, err := vet.gdb.Get(key)
 	if err != nil {
 		return nil, err
 	}
@@ -505,15 +904,18 @@ func (vet *ValidatorEnodeDB) getAddressEntry(address common.Address) (*istanbul.
 
 func (vet *ValidatorEnodeDB) iterateOverAddressEntries(onEntry func(common.Address, *istanbul.AddressEntry) error) error {
 	logger := vet.logger.New("func", "iterateOverAddressEntries")
-	// Only target address keys
-	keyPrefix := []byte(dbAddressPrefix)
+	// Only target entry keys
+	keyPrefix := vet.layout.entryPrefix()
 
 	onDBEntry := func(key []byte, value []byte) error {
 		var entry istanbul.AddressEntry
 		if err := rlp.DecodeBytes(value, &entry); err != nil {
 			return err
 		}
-		address := common.BytesToAddress(key)
+		address, err := vet.layout.addressFromKey(key)
+		if err != nil {
+			return err
+		}
 		if err := onEntry(address, &entry); err != nil {
 			return err
 		}
@@ -569,3 +971,32 @@ func (vet *ValidatorEnodeDB) ValEnodeTableInfo() (map[string]*ValEnodeEntryInfo,
 
 	return valEnodeTableInfo, err
 }
+
+// Fingerprint returns a hash of the logical contents of the table, so that it can be quickly checked
+// whether the tables of two nodes agree.  It covers the address, versions and enode ID of each entry
+// in address order, but not the query stats and reachability, which are local to each node.
+func (vet *ValidatorEnodeDB) Fingerprint() (common.Hash, error) {
+	entries, err := vet.GetValEnodesWithFilter(func(*istanbul.AddressEntry) bool { return true })
+	if err != nil {
+		return common.Hash{}, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Address[:], entries[j].Address[:]) < 0
+	})
+
+	var buf bytes.Buffer
+	var version [8]byte
+	for _, entry := range entries {
+		buf.Write(entry.Address[:])
+		binary.BigEndian.PutUint64(version[:], uint64(entry.Version))
+		buf.Write(version[:])
+		binary.BigEndian.PutUint64(version[:], uint64(entry.HighestKnownVersion))
+		buf.Write(version[:])
+		var nodeID enode.ID
+		if entry.Node != nil {
+			nodeID = entry.Node.ID()
+		}
+		buf.Write(nodeID[:])
+	}
+	return crypto.Keccak256Hash(buf.Bytes()), nil
+}
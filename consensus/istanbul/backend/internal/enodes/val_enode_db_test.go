@@ -1,13 +1,18 @@
 package enodes
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/celo-org/celo-blockchain/common"
 	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul/kvstore"
+	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/celo-org/celo-blockchain/metrics"
 	"github.com/celo-org/celo-blockchain/p2p/enode"
 	"github.com/celo-org/celo-blockchain/rlp"
-	"github.com/syndtr/goleveldb/leveldb"
 )
 
 var (
@@ -17,8 +22,19 @@ var (
 	enodeURLB = "enode://38b219b54ed49cf7d802e8add586fc75b531ed2c31e43b5da71c35982b2e6f5c56fa9cfbe39606fe71fbee2566b94c2874e950b1ec88323103c835246e3d0023@127.0.0.1:37303"
 	nodeA, _  = enode.ParseV4(enodeURLA)
 	nodeB, _  = enode.ParseV4(enodeURLB)
+	addressC  = common.HexToAddress("0x0000000000000000000000000000000000000C0C")
 )
 
+// forEachLayout runs a test of the table operations with each layout, which must behave identically
+func forEachLayout(t *testing.T, test func(t *testing.T, layout Layout)) {
+	indexLayout, err := IndexLayout([]common.Address{addressA, addressB, addressC})
+	if err != nil {
+		t.Fatalf("Failed to create index layout: %v", err)
+	}
+	t.Run("AddressLayout", func(t *testing.T) { test(t, AddressLayout) })
+	t.Run("IndexLayout", func(t *testing.T) { test(t, indexLayout) })
+}
+
 type mockListener struct{}
 
 func (ml *mockListener) AddValidatorPeer(node *enode.Node, address common.Address) {}
@@ -27,7 +43,11 @@ func (ml *mockListener) ReplaceValidatorPeers(nodeNodes []*enode.Node)
 func (ml *mockListener) ClearValidatorPeers()                                      {}
 
 func TestSimpleCase(t *testing.T) {
-	vet, err := OpenValidatorEnodeDB("", &mockListener{})
+	forEachLayout(t, testSimpleCase)
+}
+
+func testSimpleCase(t *testing.T, layout Layout) {
+	vet, err := OpenValidatorEnodeDBWithLayout("", &mockListener{}, nil, layout)
 	if err != nil {
 		t.Fatal("Failed to open DB")
 	}
@@ -57,7 +77,11 @@ func TestSimpleCase(t *testing.T) {
 }
 
 func TestDeleteEntry(t *testing.T) {
-	vet, err := OpenValidatorEnodeDB("", &mockListener{})
+	forEachLayout(t, testDeleteEntry)
+}
+
+func testDeleteEntry(t *testing.T, layout Layout) {
+	vet, err := OpenValidatorEnodeDBWithLayout("", &mockListener{}, nil, layout)
 	if err != nil {
 		t.Fatal("Failed to open DB")
 	}
@@ -75,7 +99,7 @@ func TestDeleteEntry(t *testing.T) {
 	}
 
 	if _, err := vet.GetNodeFromAddress(addressA); err != nil {
-		if err != leveldb.ErrNotFound {
+		if err != kvstore.ErrNotFound {
 			t.Fatalf("Can't get, different error: %v", err)
 		}
 	} else {
@@ -85,7 +109,11 @@ func TestDeleteEntry(t *testing.T) {
 }
 
 func TestPruneEntries(t *testing.T) {
-	vet, err := OpenValidatorEnodeDB("", &mockListener{})
+	forEachLayout(t, testPruneEntries)
+}
+
+func testPruneEntries(t *testing.T, layout Layout) {
+	vet, err := OpenValidatorEnodeDBWithLayout("", &mockListener{}, nil, layout)
 	if err != nil {
 		t.Fatal("Failed to open DB")
 	}
@@ -113,6 +141,208 @@ func TestPruneEntries(t *testing.T) {
 
 }
 
+func TestQueryStatsResetOnHighestKnownVersionIncrease(t *testing.T) {
+	forEachLayout(t, testQueryStatsResetOnHighestKnownVersionIncrease)
+}
+
+func testQueryStatsResetOnHighestKnownVersionIncrease(t *testing.T, layout Layout) {
+	vet, err := OpenValidatorEnodeDBWithLayout("", &mockListener{}, nil, layout)
+	if err != nil {
+		t.Fatal("Failed to open DB")
+	}
+
+	queryStats := func() (uint, time.Time) {
+		entry, err := vet.getAddressEntry(addressA)
+		if err != nil {
+			t.Fatalf("Failed to get entry: %v", err)
+		}
+		// A reset timestamp is decoded as the zero time
+		var lastQuery time.Time
+		if entry.LastQueryTimestamp != nil {
+			lastQuery = *entry.LastQueryTimestamp
+		}
+		return entry.NumQueryAttemptsForHKVersion, lastQuery
+	}
+	query := func(times int) {
+		for i := 0; i < times; i++ {
+			entry, err := vet.getAddressEntry(addressA)
+			if err != nil {
+				t.Fatalf("Failed to get entry: %v", err)
+			}
+			if err := vet.UpdateQueryEnodeStats([]*istanbul.AddressEntry{entry}); err != nil {
+				t.Fatalf("Failed to update query stats: %v", err)
+			}
+		}
+	}
+
+	if err := vet.UpsertHighestKnownVersion([]*istanbul.AddressEntry{{Address: addressA, HighestKnownVersion: 1}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	query(3)
+	if attempts, lastQuery := queryStats(); attempts != 3 || lastQuery.IsZero() {
+		t.Fatalf("Unexpected query stats.  Expected 3 attempts with a timestamp, got %d attempts with timestamp %v", attempts, lastQuery)
+	}
+
+	// The same version doesn't reset the backoff
+	if err := vet.UpsertHighestKnownVersion([]*istanbul.AddressEntry{{Address: addressA, HighestKnownVersion: 1}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	if attempts, lastQuery := queryStats(); attempts != 3 || lastQuery.IsZero() {
+		t.Errorf("Unexpected query stats for the same version.  Expected 3 attempts with a timestamp, got %d attempts with timestamp %v", attempts, lastQuery)
+	}
+
+	// A newer version does
+	if err := vet.UpsertHighestKnownVersion([]*istanbul.AddressEntry{{Address: addressA, HighestKnownVersion: 2}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	if attempts, lastQuery := queryStats(); attempts != 0 || !lastQuery.IsZero() {
+		t.Errorf("Unexpected query stats for a newer version.  Expected 0 attempts without a timestamp, got %d attempts with timestamp %v", attempts, lastQuery)
+	}
+
+	// As does a newer version learned with its enode
+	query(2)
+	if err := vet.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: addressA, Node: nodeA, Version: 3}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	if attempts, lastQuery := queryStats(); attempts != 0 || !lastQuery.IsZero() {
+		t.Errorf("Unexpected query stats for a newer enode version.  Expected 0 attempts without a timestamp, got %d attempts with timestamp %v", attempts, lastQuery)
+	}
+}
+
+func TestDuplicateEnodeAcrossAddresses(t *testing.T) {
+	forEachLayout(t, testDuplicateEnodeAcrossAddresses)
+}
+
+func testDuplicateEnodeAcrossAddresses(t *testing.T, layout Layout) {
+	vet, err := OpenValidatorEnodeDBWithLayout("", &mockListener{}, nil, layout)
+	if err != nil {
+		t.Fatal("Failed to open DB")
+	}
+
+	nodeOf := func(address common.Address) *enode.Node {
+		node, err := vet.GetNodeFromAddress(address)
+		if err != nil {
+			t.Fatalf("Failed to get node: %v", err)
+		}
+		return node
+	}
+
+	if err := vet.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: addressA, Node: nodeA, Version: 1}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	if conflicts := vet.EnodeConflicts(); len(conflicts) != 0 {
+		t.Errorf("Unexpected conflicts without a duplicate enode: %v", conflicts)
+	}
+
+	// A higher version takes over the enode
+	if err := vet.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: addressB, Node: nodeA, Version: 2}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	if addr, err := vet.GetAddressFromNodeID(nodeA.ID()); err != nil || addr != addressB {
+		t.Errorf("Unexpected enode owner.  Want: %v, Have: %v (err: %v)", addressB, addr, err)
+	}
+	if node := nodeOf(addressA); node != nil {
+		t.Errorf("Unexpected node for the address that lost its enode: %v", node)
+	}
+	conflicts := vet.EnodeConflicts()
+	if len(conflicts) != 1 {
+		t.Fatalf("Unexpected number of conflicts.  Want: 1, Have: %d", len(conflicts))
+	}
+	if conflicts[0].EnodeURL != nodeA.URLv4() || conflicts[0].Addresses[0] != addressB || conflicts[0].Addresses[1] != addressA {
+		t.Errorf("Unexpected conflict: %v", conflicts[0])
+	}
+
+	// A lower version is ignored
+	if err := vet.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: addressA, Node: nodeA, Version: 1}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	if addr, err := vet.GetAddressFromNodeID(nodeA.ID()); err != nil || addr != addressB {
+		t.Errorf("Unexpected enode owner.  Want: %v, Have: %v (err: %v)", addressB, addr, err)
+	}
+	if node := nodeOf(addressA); node != nil {
+		t.Errorf("Unexpected node for the address with the lower version: %v", node)
+	}
+	if node := nodeOf(addressB); node == nil || node.ID() != nodeA.ID() {
+		t.Errorf("Unexpected node.  Want: %v, Have: %v", nodeA, node)
+	}
+}
+
+func TestValEnodeTableSizeGauge(t *testing.T) {
+	forEachLayout(t, testValEnodeTableSizeGauge)
+}
+
+func testValEnodeTableSizeGauge(t *testing.T, layout Layout) {
+	vet, err := OpenValidatorEnodeDBWithLayout("", &mockListener{}, nil, layout)
+	if err != nil {
+		t.Fatal("Failed to open DB")
+	}
+	vet.sizeGauge = &metrics.StandardGauge{}
+
+	checkSize := func(want int64) {
+		t.Helper()
+		if have := vet.Size(); have != want {
+			t.Errorf("Size mismatch.  Want: %d, Have: %d", want, have)
+		}
+		if have := vet.sizeGauge.Value(); have != want {
+			t.Errorf("Size gauge mismatch.  Want: %d, Have: %d", want, have)
+		}
+	}
+	checkSize(0)
+
+	err = vet.UpsertVersionAndEnode([]*istanbul.AddressEntry{
+		{Address: addressA, Node: nodeA, Version: 1},
+		{Address: addressB, Node: nodeB, Version: 1},
+	})
+	if err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	checkSize(2)
+
+	// Updating existing entries doesn't change the size
+	if err := vet.UpsertHighestKnownVersion([]*istanbul.AddressEntry{{Address: addressA, HighestKnownVersion: 5}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	checkSize(2)
+
+	if _, err := vet.PruneEntries(map[common.Address]bool{addressB: true}); err != nil {
+		t.Fatal("Failed to prune")
+	}
+	checkSize(1)
+
+	if err := vet.RemoveEntry(addressB); err != nil {
+		t.Fatal("Failed to delete")
+	}
+	checkSize(0)
+}
+
+func TestGetValEnodesWithFilter(t *testing.T) {
+	forEachLayout(t, testGetValEnodesWithFilter)
+}
+
+func testGetValEnodesWithFilter(t *testing.T, layout Layout) {
+	vet, err := OpenValidatorEnodeDBWithLayout("", &mockListener{}, nil, layout)
+	if err != nil {
+		t.Fatal("Failed to open DB")
+	}
+
+	batch := []*istanbul.AddressEntry{
+		{Address: addressA, Node: nodeA, Version: 1},
+		{Address: addressB, Node: nodeB, Version: 2},
+	}
+
+	vet.UpsertVersionAndEnode(batch)
+
+	entries, err := vet.GetValEnodesWithFilter(func(entry *istanbul.AddressEntry) bool {
+		return entry.Version > 1
+	})
+	if err != nil {
+		t.Fatalf("got %v", err)
+	}
+	if len(entries) != 1 || entries[0].Address != addressB {
+		t.Errorf("Incorrect filtered entries.  Want: [%s], Have: %v", addressB.Hex(), entries)
+	}
+}
+
 func TestRLPEntries(t *testing.T) {
 	original := istanbul.AddressEntry{Address: addressA, Node: nodeA, Version: 1}
 
@@ -135,7 +365,11 @@ func TestRLPEntries(t *testing.T) {
 }
 
 func TestTableToString(t *testing.T) {
-	vet, err := OpenValidatorEnodeDB("", &mockListener{})
+	forEachLayout(t, testTableToString)
+}
+
+func testTableToString(t *testing.T, layout Layout) {
+	vet, err := OpenValidatorEnodeDBWithLayout("", &mockListener{}, nil, layout)
 	if err != nil {
 		t.Fatal("Failed to open DB")
 	}
@@ -153,3 +387,378 @@ func TestTableToString(t *testing.T) {
 		t.Errorf("String() error: got: %s", vet.String())
 	}
 }
+
+func newBenchmarkValEnodeDB(b *testing.B, numEntries int) *ValidatorEnodeDB {
+	vet, err := OpenValidatorEnodeDB("", &mockListener{})
+	if err != nil {
+		b.Fatal("Failed to open DB")
+	}
+
+	batch := make([]*istanbul.AddressEntry, 0, numEntries)
+	for i := 0; i < numEntries; i++ {
+		key, _ := crypto.GenerateKey()
+		node := enode.NewV4(&key.PublicKey, nil, 0, 0)
+		// Only every 10th entry needs a query
+		version := uint(1)
+		if i%10 == 0 {
+			version = 0
+		}
+		batch = append(batch, &istanbul.AddressEntry{Address: crypto.PubkeyToAddress(key.PublicKey), Node: node, Version: version, HighestKnownVersion: 1})
+	}
+	if err := vet.UpsertVersionAndEnode(batch); err != nil {
+		b.Fatal("Failed to upsert")
+	}
+	return vet
+}
+
+func needsQuery(entry *istanbul.AddressEntry) bool {
+	return entry.Version != entry.HighestKnownVersion
+}
+
+func BenchmarkGetValEnodesFullMap(b *testing.B) {
+	vet := newBenchmarkValEnodeDB(b, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entries, _ := vet.GetValEnodes(nil)
+		var filtered []*istanbul.AddressEntry
+		for _, entry := range entries {
+			if needsQuery(entry) {
+				filtered = append(filtered, entry)
+			}
+		}
+	}
+}
+
+func BenchmarkGetValEnodesWithFilter(b *testing.B) {
+	vet := newBenchmarkValEnodeDB(b, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vet.GetValEnodesWithFilter(needsQuery)
+	}
+}
+
+func TestOpenValidatorEnodeDBReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "val-enode-db-test")
+	if err != nil {
+		t.Fatal("Failed to create temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	vet, err := OpenValidatorEnodeDB(dir, &mockListener{})
+	if err != nil {
+		t.Fatal("Failed to open DB")
+	}
+	if err := vet.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: addressA, Node: nodeA, Version: 1}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	vet.Close()
+
+	readOnlyVet, err := OpenValidatorEnodeDBReadOnly(dir)
+	if err != nil {
+		t.Fatalf("Failed to open DB read-only: %v", err)
+	}
+	if readOnlyVet.Size() != 1 {
+		t.Errorf("Unexpected size. Expected %d, got %d", 1, readOnlyVet.Size())
+	}
+	if node, err := readOnlyVet.GetNodeFromAddress(addressA); err != nil || node.String() != enodeURLA {
+		t.Errorf("Unexpected node. Expected %v, got %v (err: %v)", enodeURLA, node, err)
+	}
+	if err := readOnlyVet.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: addressB, Node: nodeB, Version: 1}}); err == nil {
+		t.Error("Upserting into a read-only DB should fail")
+	}
+	if err := readOnlyVet.RemoveEntry(addressA); err == nil {
+		t.Error("Removing from a read-only DB should fail")
+	}
+	readOnlyVet.Close()
+
+	// Nothing was written
+	vet, err = OpenValidatorEnodeDB(dir, &mockListener{})
+	if err != nil {
+		t.Fatal("Failed to open DB")
+	}
+	defer vet.Close()
+	if _, err := vet.GetNodeFromAddress(addressA); err != nil {
+		t.Errorf("Entry was removed from the read-only DB: %v", err)
+	}
+	if _, err := vet.GetNodeFromAddress(addressB); err != kvstore.ErrNotFound {
+		t.Errorf("Entry was upserted into the read-only DB: %v", err)
+	}
+
+	// A missing DB isn't created
+	if _, err := OpenValidatorEnodeDBReadOnly(dir + "-missing"); err == nil {
+		t.Error("Opening a missing DB read-only should fail")
+	}
+}
+
+func TestIndexLayoutSkipsOtherAddresses(t *testing.T) {
+	layout, err := IndexLayout([]common.Address{addressA})
+	if err != nil {
+		t.Fatalf("Failed to create index layout: %v", err)
+	}
+	vet, err := OpenValidatorEnodeDBWithLayout("", &mockListener{}, nil, layout)
+	if err != nil {
+		t.Fatal("Failed to open DB")
+	}
+
+	// The entries of validators outside of the layout are skipped, without failing the others
+	if err := vet.UpsertVersionAndEnode([]*istanbul.AddressEntry{
+		{Address: addressA, Node: nodeA, Version: 1},
+		{Address: addressB, Node: nodeB, Version: 1},
+	}); err != nil {
+		t.Fatalf("Failed to upsert: %v", err)
+	}
+	if vet.Size() != 1 {
+		t.Errorf("Unexpected size. Expected %d, got %d", 1, vet.Size())
+	}
+	if _, err := vet.GetNodeFromAddress(addressB); err != kvstore.ErrNotFound {
+		t.Errorf("Unexpected error for a validator outside of the layout. Expected %v, got %v", kvstore.ErrNotFound, err)
+	}
+	if _, err := vet.GetAddressFromNodeID(nodeB.ID()); err != kvstore.ErrNotFound {
+		t.Errorf("Unexpected error for the node of a validator outside of the layout. Expected %v, got %v", kvstore.ErrNotFound, err)
+	}
+
+	if _, err := IndexLayout([]common.Address{addressA, addressA}); err == nil {
+		t.Error("Creating an index layout with duplicate validators should fail")
+	}
+}
+
+func TestLayoutMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "val-enode-db-test")
+	if err != nil {
+		t.Fatal("Failed to create temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	indexLayout, err := IndexLayout([]common.Address{addressA, addressB})
+	if err != nil {
+		t.Fatalf("Failed to create index layout: %v", err)
+	}
+	changedIndexLayout, err := IndexLayout([]common.Address{addressB, addressA})
+	if err != nil {
+		t.Fatalf("Failed to create index layout: %v", err)
+	}
+
+	vet, err := OpenValidatorEnodeDBWithLayout(dir, &mockListener{}, nil, indexLayout)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	if err := vet.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: addressB, Node: nodeB, Version: 1}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	vet.Close()
+
+	// The entries aren't migrated to a different layout, or to a changed validator set
+	for name, layout := range map[string]Layout{"AddressLayout": AddressLayout, "changed IndexLayout": changedIndexLayout} {
+		if _, err := OpenValidatorEnodeDBWithLayout(dir, &mockListener{}, nil, layout); err != ErrLayoutMismatch {
+			t.Errorf("%s: unexpected error. Expected %v, got %v", name, ErrLayoutMismatch, err)
+		}
+	}
+	if _, err := OpenValidatorEnodeDBReadOnly(dir); err != ErrLayoutMismatch {
+		t.Errorf("Unexpected error opening read-only. Expected %v, got %v", ErrLayoutMismatch, err)
+	}
+
+	// The same layout keeps the entries
+	vet, err = OpenValidatorEnodeDBWithLayout(dir, &mockListener{}, nil, indexLayout)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	if node, err := vet.GetNodeFromAddress(addressB); err != nil || node.String() != enodeURLB {
+		t.Errorf("Unexpected node. Expected %v, got %v (err: %v)", enodeURLB, node, err)
+	}
+	vet.Close()
+
+	// A db without a recorded layout, from before layouts were selectable, has the address layout
+	legacyDir, err := ioutil.TempDir("", "val-enode-db-test")
+	if err != nil {
+		t.Fatal("Failed to create temp dir")
+	}
+	defer os.RemoveAll(legacyDir)
+	vet, err = OpenValidatorEnodeDB(legacyDir, &mockListener{})
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	if err := vet.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: addressA, Node: nodeA, Version: 1}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	batch := vet.gdb.NewBatch()
+	batch.Delete([]byte(dbLayoutKey))
+	if err := vet.gdb.Write(batch); err != nil {
+		t.Fatalf("Failed to delete layout: %v", err)
+	}
+	vet.Close()
+	if _, err := OpenValidatorEnodeDBWithLayout(legacyDir, &mockListener{}, nil, indexLayout); err != ErrLayoutMismatch {
+		t.Errorf("Unexpected error for a db without a recorded layout. Expected %v, got %v", ErrLayoutMismatch, err)
+	}
+	vet, err = OpenValidatorEnodeDB(legacyDir, &mockListener{})
+	if err != nil {
+		t.Fatalf("Failed to open a db without a recorded layout: %v", err)
+	}
+	vet.Close()
+}
+
+type replaceRecordingListener struct {
+	mockListener
+	replacedNodes [][]*enode.Node
+}
+
+func (rl *replaceRecordingListener) ReplaceValidatorPeers(newNodes []*enode.Node) {
+	rl.replacedNodes = append(rl.replacedNodes, newNodes)
+}
+
+func TestReplaceAll(t *testing.T) {
+	forEachLayout(t, testReplaceAll)
+}
+
+func testReplaceAll(t *testing.T, layout Layout) {
+	listener := &replaceRecordingListener{}
+	vet, err := OpenValidatorEnodeDBWithLayout("", listener, nil, layout)
+	if err != nil {
+		t.Fatal("Failed to open DB")
+	}
+
+	if err := vet.UpsertVersionAndEnode([]*istanbul.AddressEntry{
+		{Address: addressA, Node: nodeA, Version: 1},
+		{Address: addressB, Node: nodeB, Version: 5},
+	}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+
+	if err := vet.ReplaceAll([]*istanbul.AddressEntry{
+		{Address: addressB, Node: nodeB, Version: 3},
+		{Address: addressC, HighestKnownVersion: 2},
+	}); err != nil {
+		t.Fatalf("Failed to replace: %v", err)
+	}
+
+	// Absent entries are gone
+	if _, err := vet.GetNodeFromAddress(addressA); err != kvstore.ErrNotFound {
+		t.Errorf("Unexpected error for replaced entry. Expected %v, got %v", kvstore.ErrNotFound, err)
+	}
+	if _, err := vet.GetAddressFromNodeID(nodeA.ID()); err != kvstore.ErrNotFound {
+		t.Errorf("Unexpected error for replaced node ID. Expected %v, got %v", kvstore.ErrNotFound, err)
+	}
+
+	// Present entries are overwritten, even with a lower version
+	if version, err := vet.GetVersionFromAddress(addressB); err != nil || version != 3 {
+		t.Errorf("Unexpected version. Expected %d, got %d (err: %v)", 3, version, err)
+	}
+	if address, err := vet.GetAddressFromNodeID(nodeB.ID()); err != nil || address != addressB {
+		t.Errorf("Unexpected address. Expected %v, got %v (err: %v)", addressB, address, err)
+	}
+	if hkVersion, err := vet.GetHighestKnownVersionFromAddress(addressC); err != nil || hkVersion != 2 {
+		t.Errorf("Unexpected highest known version. Expected %d, got %d (err: %v)", 2, hkVersion, err)
+	}
+	if vet.Size() != 2 {
+		t.Errorf("Unexpected size. Expected %d, got %d", 2, vet.Size())
+	}
+
+	// The validator peers are replaced with a single notification
+	if len(listener.replacedNodes) != 1 {
+		t.Fatalf("Unexpected number of notifications. Expected %d, got %d", 1, len(listener.replacedNodes))
+	}
+	if nodes := listener.replacedNodes[0]; len(nodes) != 1 || nodes[0].ID() != nodeB.ID() {
+		t.Errorf("Unexpected replaced validator peers. Expected %v, got %v", []*enode.Node{nodeB}, nodes)
+	}
+}
+
+func TestValidatorEnodeDBWithStore(t *testing.T) {
+	store := kvstore.NewMemoryStore()
+	vet, err := NewValidatorEnodeDBWithStore(store, &mockListener{}, nil, AddressLayout)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+
+	if err := vet.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: addressA, Node: nodeA, Version: 1}, {Address: addressB, Node: nodeB, Version: 1}}); err != nil {
+		t.Fatalf("Failed to upsert: %v", err)
+	}
+	if addr, err := vet.GetAddressFromNodeID(nodeA.ID()); err != nil || addr != addressA {
+		t.Errorf("Invalid address saved. Expected %v, got %v (err: %v)", addressA, addr, err)
+	}
+	if node, err := vet.GetNodeFromAddress(addressB); err != nil || node.String() != enodeURLB {
+		t.Errorf("Invalid enode saved. Expected %v, got %v (err: %v)", enodeURLB, node, err)
+	}
+
+	if err := vet.RemoveEntry(addressA); err != nil {
+		t.Fatalf("Failed to remove: %v", err)
+	}
+	if _, err := vet.GetNodeFromAddress(addressA); err != kvstore.ErrNotFound {
+		t.Errorf("Unexpected error for removed entry. Expected %v, got %v", kvstore.ErrNotFound, err)
+	}
+	if vet.Size() != 1 {
+		t.Errorf("Unexpected size. Expected 1, got %d", vet.Size())
+	}
+
+	// The entries are in the store, and are found by a table reopened on it
+	reopened, err := NewValidatorEnodeDBWithStore(store, &mockListener{}, nil, AddressLayout)
+	if err != nil {
+		t.Fatalf("Failed to reopen DB: %v", err)
+	}
+	if reopened.Size() != 1 {
+		t.Errorf("Unexpected size of the reopened table. Expected 1, got %d", reopened.Size())
+	}
+	if node, err := reopened.GetNodeFromAddress(addressB); err != nil || node.String() != enodeURLB {
+		t.Errorf("Invalid enode in the reopened table. Expected %v, got %v (err: %v)", enodeURLB, node, err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	forEachLayout(t, testFingerprint)
+}
+
+func testFingerprint(t *testing.T, layout Layout) {
+	fingerprint := func(vet *ValidatorEnodeDB) common.Hash {
+		hash, err := vet.Fingerprint()
+		if err != nil {
+			t.Fatalf("Failed to compute fingerprint: %v", err)
+		}
+		return hash
+	}
+
+	vetA, err := OpenValidatorEnodeDBWithLayout("", &mockListener{}, nil, layout)
+	if err != nil {
+		t.Fatal("Failed to open DB")
+	}
+	vetB, err := OpenValidatorEnodeDBWithLayout("", &mockListener{}, nil, layout)
+	if err != nil {
+		t.Fatal("Failed to open DB")
+	}
+
+	// The same entries, upserted in a different order
+	if err := vetA.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: addressA, Node: nodeA, Version: 1}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	if err := vetA.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: addressB, Node: nodeB, Version: 2}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	if err := vetB.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: addressB, Node: nodeB, Version: 2}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	if err := vetB.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: addressA, Node: nodeA, Version: 1}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	if fingerprint(vetA) != fingerprint(vetB) {
+		t.Errorf("Tables with the same entries have different fingerprints.  A: %v, B: %v", fingerprint(vetA), fingerprint(vetB))
+	}
+
+	// Query stats don't change the fingerprint
+	entry, err := vetA.getAddressEntry(addressA)
+	if err != nil {
+		t.Fatalf("Failed to get entry: %v", err)
+	}
+	if err := vetA.UpdateQueryEnodeStats([]*istanbul.AddressEntry{entry}); err != nil {
+		t.Fatalf("Failed to update query stats: %v", err)
+	}
+	if fingerprint(vetA) != fingerprint(vetB) {
+		t.Errorf("Query stats changed the fingerprint.  A: %v, B: %v", fingerprint(vetA), fingerprint(vetB))
+	}
+
+	// A different version does
+	if err := vetB.UpsertVersionAndEnode([]*istanbul.AddressEntry{{Address: addressA, Node: nodeA, Version: 3}}); err != nil {
+		t.Fatal("Failed to upsert")
+	}
+	if fingerprint(vetA) == fingerprint(vetB) {
+		t.Errorf("Tables with different versions have the same fingerprint: %v", fingerprint(vetA))
+	}
+}
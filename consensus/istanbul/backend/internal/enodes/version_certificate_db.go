@@ -22,14 +22,17 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 
 	"github.com/celo-org/celo-blockchain/common"
 	"github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/db"
 	"github.com/celo-org/celo-blockchain/crypto"
 	"github.com/celo-org/celo-blockchain/log"
+	"github.com/celo-org/celo-blockchain/metrics"
 	"github.com/celo-org/celo-blockchain/rlp"
 )
 
@@ -39,8 +42,13 @@ const (
 
 // VersionCertificateDB stores
 type VersionCertificateDB struct {
-	gdb    *db.GenericDB
-	logger log.Logger
+	gdb        *db.GenericDB
+	logger     log.Logger
+	numEntries int64 // The number of entries in the db.  Accessed atomically
+	sizeGauge  metrics.Gauge
+	// Serializes the writes, so that the existence checks, the write and the update of
+	// numEntries of one write aren't interleaved with another write's
+	writeMu sync.Mutex
 }
 
 // VersionCertificateEntry is an entry in the VersionCertificateDB.
@@ -51,6 +59,8 @@ type VersionCertificateEntry struct {
 	PublicKey *ecdsa.PublicKey
 	Version   uint
 	Signature []byte
+	// When this node last received the entry's version, set on Upsert.  It's only stored locally.
+	LastUpdated time.Time
 }
 
 func versionCertificateEntryFromGenericEntry(entry db.GenericEntry) (*VersionCertificateEntry, error) {
@@ -64,7 +74,11 @@ func versionCertificateEntryFromGenericEntry(entry db.GenericEntry) (*VersionCer
 // EncodeRLP serializes VersionCertificateEntry into the Ethereum RLP format.
 func (entry *VersionCertificateEntry) EncodeRLP(w io.Writer) error {
 	encodedPublicKey := crypto.FromECDSAPub(entry.PublicKey)
-	return rlp.Encode(w, []interface{}{entry.Address, encodedPublicKey, entry.Version, entry.Signature})
+	var lastUpdated uint64
+	if !entry.LastUpdated.IsZero() {
+		lastUpdated = uint64(entry.LastUpdated.UnixNano())
+	}
+	return rlp.Encode(w, []interface{}{entry.Address, encodedPublicKey, entry.Version, entry.Signature, lastUpdated})
 }
 
 // DecodeRLP implements rlp.Decoder, and load the VersionCertificateEntry fields from a RLP stream.
@@ -74,6 +88,8 @@ func (entry *VersionCertificateEntry) DecodeRLP(s *rlp.Stream) error {
 		PublicKey []byte
 		Version   uint
 		Signature []byte
+		// Entries stored before LastUpdated was added don't have it
+		Optional []uint64 `rlp:"tail"`
 	}
 
 	if err := s.Decode(&content); err != nil {
@@ -84,29 +100,88 @@ func (entry *VersionCertificateEntry) DecodeRLP(s *rlp.Stream) error {
 		return err
 	}
 	entry.Address, entry.PublicKey, entry.Version, entry.Signature = content.Address, decodedPublicKey, content.Version, content.Signature
+	entry.LastUpdated = time.Time{}
+	if len(content.Optional) > 0 && content.Optional[0] != 0 {
+		entry.LastUpdated = time.Unix(0, int64(content.Optional[0]))
+	}
 	return nil
 }
 
 // String gives a string representation of VersionCertificateEntry
 func (entry *VersionCertificateEntry) String() string {
-	return fmt.Sprintf("{Address: %v, Version: %v, Signature: %v}", entry.Address, entry.Version, hex.EncodeToString(entry.Signature))
+	return fmt.Sprintf("{Address: %v, Version: %v, Signature: %v, LastUpdated: %v}", entry.Address, entry.Version, hex.EncodeToString(entry.Signature), entry.LastUpdated)
 }
 
 // OpenVersionCertificateDB opens a signed announce version database for storing
 // VersionCertificates. If no path is given an in-memory, temporary database is constructed.
 func OpenVersionCertificateDB(path string) (*VersionCertificateDB, error) {
+	return OpenVersionCertificateDBWithOptions(path, nil)
+}
+
+// OpenVersionCertificateDBWithOptions is like OpenVersionCertificateDB, but configures a
+// persistent database with the given leveldb options.
+func OpenVersionCertificateDBWithOptions(path string, options *db.Options) (*VersionCertificateDB, error) {
 	logger := log.New("db", "VersionCertificateDB")
 
-	gdb, err := db.New(int64(versionCertificateDBVersion), path, logger, &opt.WriteOptions{NoWriteMerge: true})
+	gdb, err := db.NewWithOptions(int64(versionCertificateDBVersion), path, logger, &opt.WriteOptions{NoWriteMerge: true}, options)
 	if err != nil {
 		logger.Error("Error creating db", "err", err)
 		return nil, err
 	}
+	return newVersionCertificateDB(gdb, logger)
+}
 
-	return &VersionCertificateDB{
-		gdb:    gdb,
-		logger: logger,
-	}, nil
+// OpenVersionCertificateDBReadOnly opens an existing persistent version certificate database
+// without modifying it. Upserts and removals fail, and a version mismatch is returned as an
+// error instead of flushing the database.
+func OpenVersionCertificateDBReadOnly(path string) (*VersionCertificateDB, error) {
+	logger := log.New("db", "VersionCertificateDB")
+
+	gdb, err := db.NewReadOnly(int64(versionCertificateDBVersion), path, logger)
+	if err != nil {
+		logger.Error("Error opening db read-only", "err", err)
+		return nil, err
+	}
+	return newVersionCertificateDB(gdb, logger)
+}
+
+// NewVersionCertificateDBWithStore returns a version certificate database backed by the given
+// store, instead of leveldb.  The store isn't versioned, so it must be flushed by the caller if
+// the entry format changes.
+func NewVersionCertificateDBWithStore(store db.KVStore, options *db.Options) (*VersionCertificateDB, error) {
+	logger := log.New("db", "VersionCertificateDB")
+	return newVersionCertificateDB(db.NewWithStore(store, logger, options), logger)
+}
+
+func newVersionCertificateDB(gdb *db.GenericDB, logger log.Logger) (*VersionCertificateDB, error) {
+	svdb := &VersionCertificateDB{
+		gdb:       gdb,
+		logger:    logger,
+		sizeGauge: metrics.NewRegisteredGauge("consensus/istanbul/announce/versioncertificatedb/size", nil),
+	}
+
+	// Count the existing entries once, the count is maintained incrementally from then on
+	var numEntries int64
+	if err := svdb.iterate(func(common.Address, *VersionCertificateEntry) error {
+		numEntries++
+		return nil
+	}); err != nil {
+		logger.Error("Error counting db entries", "err", err)
+		return nil, err
+	}
+	svdb.addNumEntries(numEntries)
+
+	return svdb, nil
+}
+
+// Size returns the number of entries in the db
+func (svdb *VersionCertificateDB) Size() int64 {
+	return atomic.LoadInt64(&svdb.numEntries)
+}
+
+// addNumEntries adds delta to the number of entries in the db and updates the size gauge
+func (svdb *VersionCertificateDB) addNumEntries(delta int64) {
+	svdb.sizeGauge.Update(atomic.AddInt64(&svdb.numEntries, delta))
 }
 
 // Close flushes and closes the database files.
@@ -132,11 +207,16 @@ func (svdb *VersionCertificateDB) String() string {
 }
 
 // Upsert inserts any new entries or entries with a Version higher than the
-// existing version. Returns any new or updated entries
+// existing version, and sets their LastUpdated time.  Entries with the same Version as the
+// existing one only update its LastUpdated time. Returns any new or updated entries
 func (svdb *VersionCertificateDB) Upsert(savEntries []*VersionCertificateEntry) ([]*VersionCertificateEntry, error) {
 	logger := svdb.logger.New("func", "Upsert")
+	svdb.writeMu.Lock()
+	defer svdb.writeMu.Unlock()
 
 	var newEntries []*VersionCertificateEntry
+	// Strip the monotonic clock reading, so that LastUpdated round trips through the db unchanged
+	now := time.Unix(0, time.Now().UnixNano())
 
 	getExistingEntry := func(entry db.GenericEntry) (db.GenericEntry, error) {
 		savEntry, err := versionCertificateEntryFromGenericEntry(entry)
@@ -146,11 +226,12 @@ func (svdb *VersionCertificateDB) Upsert(savEntries []*VersionCertificateEntry)
 		return svdb.Get(savEntry.Address)
 	}
 
-	onNewEntry := func(batch *leveldb.Batch, entry db.GenericEntry) error {
+	onNewEntry := func(batch db.Batch, entry db.GenericEntry) error {
 		savEntry, err := versionCertificateEntryFromGenericEntry(entry)
 		if err != nil {
 			return err
 		}
+		savEntry.LastUpdated = now
 		savEntryBytes, err := rlp.EncodeToBytes(savEntry)
 		if err != nil {
 			return err
@@ -162,7 +243,7 @@ func (svdb *VersionCertificateDB) Upsert(savEntries []*VersionCertificateEntry)
 		return nil
 	}
 
-	onUpdatedEntry := func(batch *leveldb.Batch, existingEntry db.GenericEntry, newEntry db.GenericEntry) error {
+	onUpdatedEntry := func(batch db.Batch, existingEntry db.GenericEntry, newEntry db.GenericEntry) error {
 		existingSav, err := versionCertificateEntryFromGenericEntry(existingEntry)
 		if err != nil {
 			return err
@@ -171,22 +252,48 @@ func (svdb *VersionCertificateDB) Upsert(savEntries []*VersionCertificateEntry)
 		if err != nil {
 			return err
 		}
-		if newSav.Version <= existingSav.Version {
+		if newSav.Version == existingSav.Version {
+			// Re-receipt of the existing version
+			refreshedSav := *existingSav
+			refreshedSav.LastUpdated = now
+			refreshedSavBytes, err := rlp.EncodeToBytes(&refreshedSav)
+			if err != nil {
+				return err
+			}
+			batch.Put(addressKey(refreshedSav.Address), refreshedSavBytes)
+			return nil
+		}
+		if newSav.Version < existingSav.Version {
 			logger.Trace("Skipping new entry whose version is not greater than the existing entry", "existing version", existingSav.Version, "new version", newSav.Version)
 			return nil
 		}
 		return onNewEntry(batch, newEntry)
 	}
 
+	// Track the addresses that weren't in the db yet, so the size can be updated without a scan
+	insertedAddresses := make(map[common.Address]bool)
+	onInsertedEntry := func(batch db.Batch, entry db.GenericEntry) error {
+		if err := onNewEntry(batch, entry); err != nil {
+			return err
+		}
+		savEntry, err := versionCertificateEntryFromGenericEntry(entry)
+		if err != nil {
+			return err
+		}
+		insertedAddresses[savEntry.Address] = true
+		return nil
+	}
+
 	entries := make([]db.GenericEntry, len(savEntries))
 	for i, sav := range savEntries {
 		entries[i] = db.GenericEntry(sav)
 	}
 
-	if err := svdb.gdb.Upsert(entries, getExistingEntry, onUpdatedEntry, onNewEntry); err != nil {
+	if err := svdb.gdb.Upsert(entries, getExistingEntry, onUpdatedEntry, onInsertedEntry); err != nil {
 		logger.Warn("Error upserting entries", "err", err)
 		return nil, err
 	}
+	svdb.addNumEntries(int64(len(insertedAddresses)))
 	return newEntries, nil
 }
 
@@ -229,25 +336,103 @@ func (svdb *VersionCertificateDB) GetAll() ([]*VersionCertificateEntry, error) {
 
 // Remove will remove an entry from the table
 func (svdb *VersionCertificateDB) Remove(address common.Address) error {
-	batch := new(leveldb.Batch)
+	svdb.writeMu.Lock()
+	defer svdb.writeMu.Unlock()
+	_, err := svdb.gdb.Get(addressKey(address))
+	if err == db.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	batch := svdb.gdb.NewBatch()
 	batch.Delete(addressKey(address))
-	return svdb.gdb.Write(batch)
+	return svdb.writeDeletes(batch)
 }
 
-// Prune will remove entries for all addresses not present in addressesToKeep
-func (svdb *VersionCertificateDB) Prune(addressesToKeep map[common.Address]bool) error {
-	batch := new(leveldb.Batch)
+// Prune will remove entries for all addresses not present in addressesToKeep, and returns the
+// addresses of the removed entries
+func (svdb *VersionCertificateDB) Prune(addressesToKeep map[common.Address]bool) ([]common.Address, error) {
+	svdb.writeMu.Lock()
+	defer svdb.writeMu.Unlock()
+	batch := svdb.gdb.NewBatch()
+	var removed []common.Address
 	err := svdb.iterate(func(address common.Address, entry *VersionCertificateEntry) error {
 		if !addressesToKeep[address] {
 			svdb.logger.Trace("Deleting entry", "address", address)
 			batch.Delete(addressKey(address))
+			removed = append(removed, address)
 		}
 		return nil
 	})
 	if err != nil {
+		return nil, err
+	}
+	if err := svdb.writeDeletes(batch); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// PruneByAge will remove entries for all addresses not present in addressesToKeep, as well as
+// entries whose Version (a unix timestamp) is less than minVersion. The entry for selfAddress is
+// never removed because of its age.  Returns the addresses of the removed entries.
+func (svdb *VersionCertificateDB) PruneByAge(addressesToKeep map[common.Address]bool, minVersion uint, selfAddress common.Address) ([]common.Address, error) {
+	svdb.writeMu.Lock()
+	defer svdb.writeMu.Unlock()
+	batch := svdb.gdb.NewBatch()
+	var removed []common.Address
+	err := svdb.iterate(func(address common.Address, entry *VersionCertificateEntry) error {
+		if !addressesToKeep[address] {
+			svdb.logger.Trace("Deleting entry", "address", address)
+			batch.Delete(addressKey(address))
+			removed = append(removed, address)
+		} else if entry.Version < minVersion && address != selfAddress {
+			svdb.logger.Trace("Deleting entry that is too old", "address", address, "version", entry.Version, "minVersion", minVersion)
+			batch.Delete(addressKey(address))
+			removed = append(removed, address)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := svdb.writeDeletes(batch); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// PruneOlderThan will remove all entries whose Version (a unix timestamp) is less than minVersion,
+// except for the entry for selfAddress.  Returns the number of removed entries.
+func (svdb *VersionCertificateDB) PruneOlderThan(minVersion uint, selfAddress common.Address) (int, error) {
+	svdb.writeMu.Lock()
+	defer svdb.writeMu.Unlock()
+	batch := svdb.gdb.NewBatch()
+	err := svdb.iterate(func(address common.Address, entry *VersionCertificateEntry) error {
+		if entry.Version < minVersion && address != selfAddress {
+			svdb.logger.Trace("Deleting entry that is too old", "address", address, "version", entry.Version, "minVersion", minVersion)
+			batch.Delete(addressKey(address))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := svdb.writeDeletes(batch); err != nil {
+		return 0, err
+	}
+	return batch.Len(), nil
+}
+
+// writeDeletes writes a batch consisting only of deletes of existing entries, and
+// decreases the number of entries in the db accordingly.  svdb.writeMu must be held
+func (svdb *VersionCertificateDB) writeDeletes(batch db.Batch) error {
+	if err := svdb.gdb.Write(batch); err != nil {
 		return err
 	}
-	return svdb.gdb.Write(batch)
+	svdb.addNumEntries(-int64(batch.Len()))
+	return nil
 }
 
 // iterate will call `onEntry` for each entry in the db
@@ -3,12 +3,15 @@ package enodes
 import (
 	"bytes"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul/kvstore"
 	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/celo-org/celo-blockchain/metrics"
 	"github.com/celo-org/celo-blockchain/rlp"
-	"github.com/syndtr/goleveldb/leveldb"
 )
 
 func TestVersionCertificateDBUpsert(t *testing.T) {
@@ -109,7 +112,7 @@ func TestVersionCertificateDBRemove(t *testing.T) {
 	}
 
 	if _, err := table.Get(entryA.Address); err != nil {
-		if err != leveldb.ErrNotFound {
+		if err != kvstore.ErrNotFound {
 			t.Fatalf("Can't get, different error: %v", err)
 		}
 	} else {
@@ -159,6 +162,91 @@ func TestVersionCertificateDBPrune(t *testing.T) {
 
 }
 
+func TestVersionCertificateDBSizeGauge(t *testing.T) {
+	table, err := OpenVersionCertificateDB("")
+	if err != nil {
+		t.Fatal("Failed to open DB")
+	}
+	table.sizeGauge = &metrics.StandardGauge{}
+
+	checkSize := func(want int64) {
+		t.Helper()
+		if have := table.Size(); have != want {
+			t.Errorf("Size mismatch.  Want: %d, Have: %d", want, have)
+		}
+		if have := table.sizeGauge.Value(); have != want {
+			t.Errorf("Size gauge mismatch.  Want: %d, Have: %d", want, have)
+		}
+	}
+	checkSize(0)
+
+	entryA := &VersionCertificateEntry{Address: addressA, PublicKey: nodeA.Pubkey(), Version: 1, Signature: []byte("foo")}
+	entryB := &VersionCertificateEntry{Address: addressB, PublicKey: nodeB.Pubkey(), Version: 1, Signature: []byte("bar")}
+	if _, err := table.Upsert([]*VersionCertificateEntry{entryA, entryB}); err != nil {
+		t.Fatal("Failed to upsert entries")
+	}
+	checkSize(2)
+
+	// Updating an existing entry doesn't change the size
+	entryAUpdated := &VersionCertificateEntry{Address: addressA, PublicKey: nodeA.Pubkey(), Version: 2, Signature: []byte("foo")}
+	if _, err := table.Upsert([]*VersionCertificateEntry{entryAUpdated}); err != nil {
+		t.Fatal("Failed to upsert entry")
+	}
+	checkSize(2)
+
+	if _, err := table.Prune(map[common.Address]bool{addressB: true}); err != nil {
+		t.Fatal("Failed to prune")
+	}
+	checkSize(1)
+
+	// Removing a missing entry doesn't change the size
+	if err := table.Remove(addressA); err != nil {
+		t.Fatal("Failed to delete")
+	}
+	checkSize(1)
+
+	if err := table.Remove(addressB); err != nil {
+		t.Fatal("Failed to delete")
+	}
+	checkSize(0)
+}
+
+func TestVersionCertificateDBSizeConcurrentWrites(t *testing.T) {
+	table, err := OpenVersionCertificateDB("")
+	if err != nil {
+		t.Fatal("Failed to open DB")
+	}
+
+	// Concurrent upserts of the same new entries and removals must only count each entry once
+	entryA := &VersionCertificateEntry{Address: addressA, PublicKey: nodeA.Pubkey(), Version: 1, Signature: []byte("foo")}
+	entryB := &VersionCertificateEntry{Address: addressB, PublicKey: nodeB.Pubkey(), Version: 1, Signature: []byte("bar")}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := table.Upsert([]*VersionCertificateEntry{entryA, entryB}); err != nil {
+				t.Errorf("Failed to upsert entries: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := table.Remove(addressA); err != nil {
+				t.Errorf("Failed to delete: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	all, err := table.GetAll()
+	if err != nil {
+		t.Fatal("Failed to get all entries")
+	}
+	if have, want := table.Size(), int64(len(all)); have != want {
+		t.Errorf("Size mismatch.  Want: %d, Have: %d", want, have)
+	}
+}
+
 func TestVersionCertificateEntryRLP(t *testing.T) {
 	original := &VersionCertificateEntry{
 		Address:   addressA,
@@ -195,3 +283,162 @@ func versionCertificateEntriesEqual(a, b *VersionCertificateEntry) bool {
 		a.Version == b.Version &&
 		bytes.Equal(a.Signature, b.Signature)
 }
+
+func TestVersionCertificateDBPruneByAge(t *testing.T) {
+	table, err := OpenVersionCertificateDB("")
+	if err != nil {
+		t.Fatal("Failed to open DB")
+	}
+
+	// addressB is the self address
+	batch := []*VersionCertificateEntry{
+		{
+			Address:   addressA,
+			PublicKey: nodeA.Pubkey(),
+			Version:   10,
+			Signature: []byte("foo"),
+		},
+		{
+			Address:   addressB,
+			PublicKey: nodeB.Pubkey(),
+			Version:   5,
+			Signature: []byte("bar"),
+		},
+	}
+
+	_, err = table.Upsert(batch)
+	if err != nil {
+		t.Fatal("Failed to upsert entry")
+	}
+
+	addressesToKeep := map[common.Address]bool{addressA: true, addressB: true}
+
+	// Neither entry is too old
+	if _, err := table.PruneByAge(addressesToKeep, 5, addressB); err != nil {
+		t.Fatalf("Failed to prune: %v", err)
+	}
+	if _, err := table.Get(addressA); err != nil {
+		t.Errorf("It should have found %s after prune", addressA.Hex())
+	}
+
+	// Both entries are too old, but addressB is the self address
+	if _, err := table.PruneByAge(addressesToKeep, 15, addressB); err != nil {
+		t.Fatalf("Failed to prune: %v", err)
+	}
+	if _, err := table.Get(addressA); err == nil {
+		t.Errorf("It should have NOT found %s after prune", addressA.Hex())
+	}
+	if _, err := table.Get(addressB); err != nil {
+		t.Errorf("It should have found %s after prune", addressB.Hex())
+	}
+
+	// Entries not in addressesToKeep are pruned regardless of age
+	if _, err := table.PruneByAge(map[common.Address]bool{}, 0, addressB); err != nil {
+		t.Fatalf("Failed to prune: %v", err)
+	}
+	if _, err := table.Get(addressB); err == nil {
+		t.Errorf("It should have NOT found %s after prune", addressB.Hex())
+	}
+}
+
+func TestVersionCertificateDBLastUpdated(t *testing.T) {
+	table, err := OpenVersionCertificateDB("")
+	if err != nil {
+		t.Fatal("Failed to open DB")
+	}
+	entry := func(version uint) *VersionCertificateEntry {
+		return &VersionCertificateEntry{Address: addressA, PublicKey: nodeA.Pubkey(), Version: version}
+	}
+
+	if _, err := table.Upsert([]*VersionCertificateEntry{entry(2)}); err != nil {
+		t.Fatal("Failed to upsert entry")
+	}
+	first, err := table.Get(addressA)
+	if err != nil {
+		t.Fatalf("got %v", err)
+	}
+	if first.LastUpdated.IsZero() {
+		t.Errorf("LastUpdated was not set on insert")
+	}
+
+	// Re-receiving the same version refreshes LastUpdated, without returning a new entry
+	time.Sleep(10 * time.Millisecond)
+	newEntries, err := table.Upsert([]*VersionCertificateEntry{entry(2)})
+	if err != nil {
+		t.Fatal("Failed to upsert entry")
+	}
+	if len(newEntries) != 0 {
+		t.Errorf("Expected no new entries to be returned by Upsert with the same version, got %v", newEntries)
+	}
+	refreshed, err := table.Get(addressA)
+	if err != nil {
+		t.Fatalf("got %v", err)
+	}
+	if !refreshed.LastUpdated.After(first.LastUpdated) || refreshed.Version != 2 {
+		t.Errorf("LastUpdated did not advance on re-receipt: first %v, refreshed %v", first, refreshed)
+	}
+
+	// An older version doesn't refresh LastUpdated
+	if _, err := table.Upsert([]*VersionCertificateEntry{entry(1)}); err != nil {
+		t.Fatal("Failed to upsert entry")
+	}
+	all, err := table.GetAll()
+	if err != nil {
+		t.Fatalf("got %v", err)
+	}
+	if len(all) != 1 || !all[0].LastUpdated.Equal(refreshed.LastUpdated) {
+		t.Errorf("Incorrect entries after upserting an old version: %v", all)
+	}
+}
+
+func TestVersionCertificateEntryRLPWithoutLastUpdated(t *testing.T) {
+	// Entries stored before LastUpdated was added can still be decoded
+	rawEntry, err := rlp.EncodeToBytes([]interface{}{addressA, crypto.FromECDSAPub(nodeA.Pubkey()), uint(1), []byte("foo")})
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	var result VersionCertificateEntry
+	if err = rlp.DecodeBytes(rawEntry, &result); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if result.Version != 1 || !result.LastUpdated.IsZero() {
+		t.Errorf("Incorrect decoded entry: %v", &result)
+	}
+}
+
+func TestVersionCertificateDBWithStore(t *testing.T) {
+	table, err := NewVersionCertificateDBWithStore(kvstore.NewMemoryStore(), nil)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+
+	entryA := &VersionCertificateEntry{Address: addressA, PublicKey: nodeA.Pubkey(), Version: 1, Signature: []byte("foo")}
+	entryB := &VersionCertificateEntry{Address: addressB, PublicKey: nodeB.Pubkey(), Version: 1, Signature: []byte("bar")}
+	if _, err := table.Upsert([]*VersionCertificateEntry{entryA, entryB}); err != nil {
+		t.Fatalf("Failed to upsert: %v", err)
+	}
+
+	// Only newer versions are upserted
+	entryANew := &VersionCertificateEntry{Address: addressA, PublicKey: nodeA.Pubkey(), Version: 2, Signature: []byte("foo")}
+	newEntries, err := table.Upsert([]*VersionCertificateEntry{entryANew, entryB})
+	if err != nil {
+		t.Fatalf("Failed to upsert: %v", err)
+	}
+	if len(newEntries) != 1 || newEntries[0].Address != addressA {
+		t.Errorf("Unexpected new entries. Expected [%v], got %v", addressA, newEntries)
+	}
+	if version, err := table.GetVersion(addressA); err != nil || version != 2 {
+		t.Errorf("Unexpected version. Expected 2, got %d (err: %v)", version, err)
+	}
+
+	if _, err := table.Prune(map[common.Address]bool{addressA: true}); err != nil {
+		t.Fatalf("Failed to prune: %v", err)
+	}
+	allEntries, err := table.GetAll()
+	if err != nil {
+		t.Fatalf("Failed to get all entries: %v", err)
+	}
+	if len(allEntries) != 1 || allEntries[0].Address != addressA || table.Size() != 1 {
+		t.Errorf("Unexpected entries after pruning. Expected [%v], got %v (size: %d)", addressA, allEntries, table.Size())
+	}
+}
@@ -19,7 +19,6 @@ package replica
 import (
 	"sync"
 
-	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 
 	"github.com/celo-org/celo-blockchain/consensus/istanbul/backend/internal/db"
@@ -94,7 +93,7 @@ func (rsdb *ReplicaStateDB) StoreReplicaState(rs State) error {
 		return err
 	}
 
-	batch := new(leveldb.Batch)
+	batch := rsdb.gdb.NewBatch()
 	batch.Put([]byte(replicaStateKey), entryBytes)
 	err = rsdb.gdb.Write(batch)
 	if err != nil {
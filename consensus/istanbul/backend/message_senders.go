@@ -17,12 +17,30 @@
 package backend
 
 import (
+	"encoding/hex"
+	"errors"
+	"time"
+
 	"github.com/celo-org/celo-blockchain/common"
 	"github.com/celo-org/celo-blockchain/consensus"
 	"github.com/celo-org/celo-blockchain/consensus/istanbul"
 	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/celo-org/celo-blockchain/log"
+	"github.com/celo-org/celo-blockchain/metrics"
 	"github.com/celo-org/celo-blockchain/p2p"
 	"github.com/celo-org/celo-blockchain/p2p/enode"
+	"golang.org/x/time/rate"
+)
+
+// The burst size of a peer's outbound announce rate limiter, in seconds worth of the rate limit
+const announcePeerRateLimitBurstSeconds = 10
+
+var (
+	// errNoPeersToGossip is returned by Gossip when this node has no connected peers
+	errNoPeersToGossip = errors.New("no peers to gossip to")
+
+	// errAnnounceRateLimited is returned by SendToPeer when an announce message exceeds the peer's outbound rate limit
+	errAnnounceRateLimited = errors.New("announce message exceeds the peer's outbound rate limit")
 )
 
 // This function will return the peers with the addresses in the "destAddresses" parameter.
@@ -78,13 +96,31 @@ func (sb *Backend) Multicast(destAddresses []common.Address, payload []byte, eth
 	return err
 }
 
+// multicastToPeers sends the eth message to the nodes with the signing address in the destAddresses
+// param like Multicast, without sending it to self.  It also returns whether the message was sent to at
+// least one peer, or forwarded to the proxies if this node is proxied.
+func (sb *Backend) multicastToPeers(destAddresses []common.Address, payload []byte, ethMsgCode uint64) (bool, error) {
+	if sb.IsProxiedValidator() {
+		if err := sb.proxiedValidatorEngine.SendForwardMsgToAllProxies(destAddresses, ethMsgCode, payload); err != nil {
+			sb.logger.Warn("Error in sending forward message to the proxies", "func", "multicastToPeers", "err", err)
+			return false, err
+		}
+		return true, nil
+	}
+	return sb.asyncMulticast(sb.getPeersFromDestAddresses(destAddresses), payload, ethMsgCode) > 0, nil
+}
+
 // Gossip implements istanbul.Backend.Gossip
-// Gossip will gossip the eth message to all connected peers
+// Gossip will gossip the eth message to all connected peers.  errNoPeersToGossip is
+// returned if there are no connected peers, e.g. at startup.
 func (sb *Backend) Gossip(payload []byte, ethMsgCode uint64) error {
 	logger := sb.logger.New("func", "Gossip")
 
 	// Get all connected peers
 	peersToSendMsg := sb.broadcaster.FindPeers(nil, p2p.AnyPurpose)
+	if len(peersToSendMsg) == 0 {
+		return errNoPeersToGossip
+	}
 
 	// Mark that this node gossiped/processed this message, so that it will ignore it if
 	// one of it's peers sends the message to it.
@@ -98,6 +134,12 @@ func (sb *Backend) Gossip(payload []byte, ethMsgCode uint64) error {
 			delete(peersToSendMsg, nodeID)
 			logger.Trace("Peer already gossiped this message.  Not sending message to it", "peer", peer)
 			continue
+		} else if size := len(compressAnnouncePayload(peer, ethMsgCode, payload)); sb.exceedsAnnounceBurst(ethMsgCode, size) {
+			// The message can never be sent to the peer, so don't mark it as processed by the peer
+			delete(peersToSendMsg, nodeID)
+			sb.announceOversizedCounter.Inc(1)
+			sb.announceWarnings.Warn(logger, "Not gossiping announce message that exceeds the peer's outbound rate limit burst", "msgCode", ethMsgCode, "size", size)
+			continue
 		} else {
 			sb.markMessageProcessedByPeer(nodeAddr, payload)
 		}
@@ -109,18 +151,32 @@ func (sb *Backend) Gossip(payload []byte, ethMsgCode uint64) error {
 }
 
 // sendMsg will asynchronously send the the Celo messages to all the peers in the destPeers param.
-func (sb *Backend) asyncMulticast(destPeers map[enode.ID]consensus.Peer, payload []byte, ethMsgCode uint64) {
+// It returns the number of peers the messages are sent to, which excludes the peers whose outbound
+// rate limit dropped them.
+func (sb *Backend) asyncMulticast(destPeers map[enode.ID]consensus.Peer, payload []byte, ethMsgCode uint64) int {
 	logger := sb.logger.New("func", "AsyncMulticastCeloMsg", "msgCode", ethMsgCode)
+	if len(destPeers) > 0 {
+		sb.tracePayload(logger, "sent", ethMsgCode, payload, "numPeers", len(destPeers))
+	}
 
+	sent := 0
 	for _, peer := range destPeers {
 		peer := peer // Create new instance of peer for the goroutine
+		data := compressAnnouncePayload(peer, ethMsgCode, payload)
+		if !sb.allowAnnounceSend(peer, ethMsgCode, len(data)) {
+			logger.Debug("Dropping announce message that exceeds the peer's outbound rate limit", "peer", peer, "size", len(data))
+			continue
+		}
+		sb.recordAnnouncePeerSend(peer, ethMsgCode, len(data))
+		sent++
 		go func() {
 			logger.Trace("Sending istanbul message(s) to peer", "peer", peer, "node", peer.Node())
-			if err := peer.Send(ethMsgCode, payload); err != nil {
+			if err := peer.Send(ethMsgCode, data); err != nil {
 				logger.Warn("Error in sending message", "peer", peer, "ethMsgCode", ethMsgCode, "err", err)
 			}
 		}()
 	}
+	return sent
 }
 
 // Unicast asynchronously sends a message to a single peer.
@@ -128,3 +184,120 @@ func (sb *Backend) Unicast(peer consensus.Peer, payload []byte, ethMsgCode uint6
 	peerMap := map[enode.ID]consensus.Peer{peer.Node().ID(): peer}
 	sb.asyncMulticast(peerMap, payload, ethMsgCode)
 }
+
+// SendToPeer synchronously sends a message to a single peer, and returns the error of the send.
+// Like with Unicast, announce messages are compressed and subject to the peer's outbound rate limit.
+func (sb *Backend) SendToPeer(peer consensus.Peer, payload []byte, ethMsgCode uint64) error {
+	logger := sb.logger.New("func", "SendToPeer", "msgCode", ethMsgCode)
+	sb.tracePayload(logger, "sent", ethMsgCode, payload, "peer", peer)
+
+	data := compressAnnouncePayload(peer, ethMsgCode, payload)
+	if !sb.allowAnnounceSend(peer, ethMsgCode, len(data)) {
+		return errAnnounceRateLimited
+	}
+	sb.recordAnnouncePeerSend(peer, ethMsgCode, len(data))
+	return peer.Send(ethMsgCode, data)
+}
+
+// isAnnounceMsg returns whether messages with the given code are part of the announce protocol
+func isAnnounceMsg(ethMsgCode uint64) bool {
+	return ethMsgCode == istanbul.QueryEnodeMsg || ethMsgCode == istanbul.VersionCertificatesMsg || ethMsgCode == istanbul.EnodeCertificateMsg || ethMsgCode == istanbul.AnnounceSnapshotMsg
+}
+
+// tracePayload logs the hex of an announce message payload at trace level, capped at
+// config.AnnounceTracePayloadBytes bytes, to debug wire issues.  Nothing is logged if that's 0.
+func (sb *Backend) tracePayload(logger log.Logger, direction string, ethMsgCode uint64, payload []byte, ctx ...interface{}) {
+	maxBytes := sb.config.AnnounceTracePayloadBytes
+	if maxBytes <= 0 || !isAnnounceMsg(ethMsgCode) {
+		return
+	}
+	logged := payload
+	if len(logged) > maxBytes {
+		logged = logged[:maxBytes]
+	}
+	ctx = append(ctx, "direction", direction, "msgCode", ethMsgCode, "size", len(payload), "truncated", len(logged) < len(payload), "payload", hex.EncodeToString(logged))
+	logger.Trace("Announce message payload", ctx...)
+}
+
+// announcePeerCounters are the per peer announce metrics
+type announcePeerCounters struct {
+	messages metrics.Counter // Counter for the announce messages sent to the peer
+	bytes    metrics.Counter // Counter for the bytes of the announce messages sent to the peer
+	prefix   string          // The common prefix of the counters' names
+}
+
+// unregisterAnnouncePeerCounters removes the counters of a peer evicted from the announce
+// peer metrics cache from the metrics registry, so that they don't accumulate.
+func unregisterAnnouncePeerCounters(key interface{}, value interface{}) {
+	counters := value.(*announcePeerCounters)
+	metrics.Unregister(counters.prefix + "/sent/messages")
+	metrics.Unregister(counters.prefix + "/sent/bytes")
+}
+
+// recordAnnouncePeerSend counts an announce message of the given size sent to the peer in the
+// peer's announce metrics, if config.AnnounceVerbosePeerMetrics and expensive metrics are enabled.
+// Non announce messages aren't counted.
+func (sb *Backend) recordAnnouncePeerSend(peer consensus.Peer, ethMsgCode uint64, size int) {
+	if !sb.config.AnnounceVerbosePeerMetrics || !metrics.EnabledExpensive || !isAnnounceMsg(ethMsgCode) {
+		return
+	}
+	counters := sb.getAnnouncePeerCounters(peer.Node().ID())
+	counters.messages.Inc(1)
+	counters.bytes.Inc(int64(size))
+}
+
+// getAnnouncePeerCounters returns the announce metrics of the peer, creating them if needed
+func (sb *Backend) getAnnouncePeerCounters(id enode.ID) *announcePeerCounters {
+	sb.announcePeerMetricsMu.Lock()
+	defer sb.announcePeerMetricsMu.Unlock()
+	if cached, ok := sb.announcePeerMetrics.Get(id); ok {
+		return cached.(*announcePeerCounters)
+	}
+	prefix := "consensus/istanbul/announce/peer/" + id.String()
+	counters := &announcePeerCounters{
+		messages: sb.newAnnouncePeerCounter(prefix + "/sent/messages"),
+		bytes:    sb.newAnnouncePeerCounter(prefix + "/sent/bytes"),
+		prefix:   prefix,
+	}
+	sb.announcePeerMetrics.Add(id, counters)
+	return counters
+}
+
+// exceedsAnnounceBurst returns whether an announce message of the given size is larger than the
+// burst of the peers' outbound announce rate limiters, in which case it can never be sent.
+func (sb *Backend) exceedsAnnounceBurst(ethMsgCode uint64, size int) bool {
+	bytesPerSecond := sb.config.AnnouncePeerRateLimit
+	if bytesPerSecond == 0 || !isAnnounceMsg(ethMsgCode) {
+		return false
+	}
+	return size > int(bytesPerSecond*announcePeerRateLimitBurstSeconds)
+}
+
+// allowAnnounceSend returns whether sending an announce message of the given size to the peer
+// is within the peer's outbound announce rate limit.  Non announce messages are always allowed.
+func (sb *Backend) allowAnnounceSend(peer consensus.Peer, ethMsgCode uint64, size int) bool {
+	bytesPerSecond := sb.config.AnnouncePeerRateLimit
+	if bytesPerSecond == 0 || !isAnnounceMsg(ethMsgCode) {
+		return true
+	}
+	if sb.exceedsAnnounceBurst(ethMsgCode, size) {
+		sb.announceOversizedCounter.Inc(1)
+		return false
+	}
+
+	sb.announcePeerRateLimitersMu.Lock()
+	var limiter *rate.Limiter
+	if cached, ok := sb.announcePeerRateLimiters.Get(peer.Node().ID()); ok {
+		limiter = cached.(*rate.Limiter)
+	} else {
+		limiter = rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond*announcePeerRateLimitBurstSeconds))
+		sb.announcePeerRateLimiters.Add(peer.Node().ID(), limiter)
+	}
+	sb.announcePeerRateLimitersMu.Unlock()
+
+	if limiter.AllowN(time.Now(), size) {
+		return true
+	}
+	sb.announceRateLimitedMeter.Mark(1)
+	return false
+}
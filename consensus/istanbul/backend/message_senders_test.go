@@ -0,0 +1,250 @@
+package backend
+
+import (
+	"crypto/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/consensus"
+	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/celo-org/celo-blockchain/log"
+	"github.com/celo-org/celo-blockchain/metrics"
+	"github.com/celo-org/celo-blockchain/p2p/enode"
+)
+
+func TestAnnouncePeerRateLimit(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+	engine.announceRateLimitedMeter = metrics.NewMeterForced()
+
+	// Allows a burst of 1000 bytes
+	engine.config.AnnouncePeerRateLimit = 100
+	payload := make([]byte, 600)
+
+	peer := newVersionedMockPeer(istanbul.Celo66)
+	engine.Unicast(peer, payload, istanbul.QueryEnodeMsg)
+	peer.waitForSend(t)
+
+	// The second announce message exceeds the burst and should be dropped
+	engine.Unicast(peer, payload, istanbul.QueryEnodeMsg)
+	select {
+	case <-peer.sentCh:
+		t.Errorf("Announce message exceeding the rate limit was sent")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if count := engine.announceRateLimitedMeter.Count(); count != 1 {
+		t.Errorf("Incorrect rate limited count.  Want: 1, Have: %d", count)
+	}
+
+	// Non announce messages are not rate limited
+	engine.Unicast(peer, payload, istanbul.ConsensusMsg)
+	peer.waitForSend(t)
+
+	// Other peers have their own rate limit
+	otherPeer := newVersionedMockPeer(istanbul.Celo66)
+	engine.Unicast(otherPeer, payload, istanbul.QueryEnodeMsg)
+	otherPeer.waitForSend(t)
+}
+
+func TestGossipOversizedAnnounceMessage(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+	engine.announceOversizedCounter = metrics.NewCounterForced()
+
+	// Allows a burst of 1000 bytes
+	engine.config.AnnouncePeerRateLimit = 100
+	payload := make([]byte, 2000)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatalf("Error in generating the payload.  Error: %v", err)
+	}
+
+	peer := newVersionedMockPeer(istanbul.Celo66)
+	engine.SetBroadcaster(&peersBroadcaster{peers: map[enode.ID]consensus.Peer{peer.Node().ID(): peer}})
+	if err := engine.Gossip(payload, istanbul.QueryEnodeMsg); err != nil {
+		t.Fatalf("Error in gossiping the message.  Error: %v", err)
+	}
+
+	// The message is never sent, and the peer isn't marked as having processed it
+	select {
+	case <-peer.sentCh:
+		t.Errorf("Announce message exceeding the rate limit burst was sent")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if engine.checkIfMessageProcessedByPeer(crypto.PubkeyToAddress(*peer.Node().Pubkey()), payload) {
+		t.Errorf("Peer was marked as having processed the oversized message")
+	}
+	if count := engine.announceOversizedCounter.Count(); count != 1 {
+		t.Errorf("Incorrect oversized count.  Want: 1, Have: %d", count)
+	}
+}
+
+func TestAnnouncePeerMetrics(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+	engine.config.AnnounceVerbosePeerMetrics = true
+	defer func(enabled bool) { metrics.EnabledExpensive = enabled }(metrics.EnabledExpensive)
+	metrics.EnabledExpensive = true
+
+	var countersMu sync.Mutex
+	counters := make(map[string]metrics.Counter)
+	engine.newAnnouncePeerCounter = func(name string) metrics.Counter {
+		countersMu.Lock()
+		defer countersMu.Unlock()
+		counters[name] = metrics.NewCounterForced()
+		return counters[name]
+	}
+	count := func(peer *versionedMockPeer, metric string) int64 {
+		countersMu.Lock()
+		defer countersMu.Unlock()
+		counter, ok := counters["consensus/istanbul/announce/peer/"+peer.Node().ID().String()+"/sent/"+metric]
+		if !ok {
+			return 0
+		}
+		return counter.Count()
+	}
+
+	peer0 := newVersionedMockPeer(istanbul.Celo66)
+	peer1 := newVersionedMockPeer(istanbul.Celo66)
+	otherPeer := newVersionedMockPeer(istanbul.Celo66)
+	destPeers := map[enode.ID]consensus.Peer{peer0.Node().ID(): peer0, peer1.Node().ID(): peer1}
+
+	payload := make([]byte, 100)
+	for i := 0; i < 2; i++ {
+		engine.asyncMulticast(destPeers, payload, istanbul.QueryEnodeMsg)
+		peer0.waitForSend(t)
+		peer1.waitForSend(t)
+	}
+	for _, peer := range []*versionedMockPeer{peer0, peer1} {
+		if messages := count(peer, "messages"); messages != 2 {
+			t.Errorf("Incorrect message count.  Want: 2, Have: %d", messages)
+		}
+		if bytes := count(peer, "bytes"); bytes != int64(2*len(payload)) {
+			t.Errorf("Incorrect byte count.  Want: %d, Have: %d", 2*len(payload), bytes)
+		}
+	}
+	if messages := count(otherPeer, "messages"); messages != 0 {
+		t.Errorf("Incorrect message count for a peer that isn't a destination.  Want: 0, Have: %d", messages)
+	}
+
+	// Non announce messages are not counted
+	engine.asyncMulticast(destPeers, payload, istanbul.ConsensusMsg)
+	peer0.waitForSend(t)
+	peer1.waitForSend(t)
+	if messages := count(peer0, "messages"); messages != 2 {
+		t.Errorf("Incorrect message count after a non announce message.  Want: 2, Have: %d", messages)
+	}
+
+	// The full version certificate table sent to registering peers is counted as well
+	if err := engine.sendVersionCertificateTable(peer0); err != nil {
+		t.Fatalf("Error in sending version certificate table.  Error: %v", err)
+	}
+	peer0.waitForSend(t)
+	if messages := count(peer0, "messages"); messages != 3 {
+		t.Errorf("Incorrect message count after sending the version certificate table.  Want: 3, Have: %d", messages)
+	}
+
+	// Nothing is counted without expensive metrics
+	metrics.EnabledExpensive = false
+	engine.asyncMulticast(destPeers, payload, istanbul.QueryEnodeMsg)
+	peer0.waitForSend(t)
+	peer1.waitForSend(t)
+	if messages := count(peer1, "messages"); messages != 2 {
+		t.Errorf("Incorrect message count without expensive metrics.  Want: 2, Have: %d", messages)
+	}
+}
+
+func TestAnnouncePeerMetricsEviction(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+
+	// The counters of the least recently used peer are unregistered once the cache is full
+	var ids []enode.ID
+	for i := 0; i <= inmemoryAnnouncePeerMetrics; i++ {
+		id := enode.ID{byte(i >> 8), byte(i)}
+		engine.getAnnouncePeerCounters(id)
+		ids = append(ids, id)
+	}
+	for i, id := range []enode.ID{ids[0], ids[len(ids)-1]} {
+		name := "consensus/istanbul/announce/peer/" + id.String() + "/sent/messages"
+		if registered := metrics.DefaultRegistry.Get(name) != nil; registered != (i == 1) {
+			t.Errorf("Incorrect registration of %s.  Want: %v, Have: %v", name, i == 1, registered)
+		}
+	}
+	for _, id := range ids {
+		metrics.Unregister("consensus/istanbul/announce/peer/" + id.String() + "/sent/messages")
+		metrics.Unregister("consensus/istanbul/announce/peer/" + id.String() + "/sent/bytes")
+	}
+}
+
+func TestTraceAnnouncePayloads(t *testing.T) {
+	engine := newBackend()
+	defer engine.StopAnnouncing()
+
+	// The logged payloads, by direction
+	var loggedMu sync.Mutex
+	logged := make(map[string][]map[string]interface{})
+	handler := log.Root().GetHandler()
+	log.Root().SetHandler(log.FuncHandler(func(r *log.Record) error {
+		if r.Lvl != log.LvlTrace || r.Msg != "Announce message payload" {
+			return nil
+		}
+		ctx := make(map[string]interface{})
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			if key, ok := r.Ctx[i].(string); ok {
+				ctx[key] = r.Ctx[i+1]
+			}
+		}
+		loggedMu.Lock()
+		defer loggedMu.Unlock()
+		direction, _ := ctx["direction"].(string)
+		logged[direction] = append(logged[direction], ctx)
+		return nil
+	}))
+	defer log.Root().SetHandler(handler)
+	getLogged := func(direction string) []map[string]interface{} {
+		loggedMu.Lock()
+		defer loggedMu.Unlock()
+		return logged[direction]
+	}
+
+	payload := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	peer := newVersionedMockPeer(istanbul.Celo66)
+
+	// Disabled by default
+	engine.Unicast(peer, payload, istanbul.VersionCertificatesMsg)
+	peer.waitForSend(t)
+	if have := getLogged("sent"); len(have) != 0 {
+		t.Fatalf("Payload was logged while disabled: %v", have)
+	}
+
+	engine.config.AnnounceTracePayloadBytes = 4
+	engine.Unicast(peer, payload, istanbul.VersionCertificatesMsg)
+	peer.waitForSend(t)
+	// Non announce messages aren't logged
+	engine.Unicast(peer, payload, istanbul.ConsensusMsg)
+	peer.waitForSend(t)
+	if _, err := engine.HandleMsg(common.Address{}, makeMsg(istanbul.QueryEnodeMsg, payload), peer); err != nil {
+		t.Fatalf("Error in handling message.  Error: %v", err)
+	}
+
+	for _, direction := range []string{"sent", "received"} {
+		have := getLogged(direction)
+		if len(have) != 1 {
+			t.Fatalf("Incorrect number of %s payloads logged.  Want: 1, Have: %d", direction, len(have))
+		}
+		if have[0]["payload"] != "01020304" || have[0]["truncated"] != true || have[0]["size"] != len(payload) {
+			t.Errorf("Incorrect %s payload log.  Want: payload 01020304, truncated, size %d, Have: %v", direction, len(payload), have[0])
+		}
+	}
+	// The version certificate table sent to a connected peer is logged as well
+	if err := engine.sendVersionCertificateTable(peer); err != nil {
+		t.Fatalf("Error in sending the version certificate table.  Error: %v", err)
+	}
+	peer.waitForSend(t)
+	if have := getLogged("sent"); len(have) != 2 {
+		t.Errorf("Incorrect number of sent payloads logged.  Want: 2, Have: %d", len(have))
+	}
+}
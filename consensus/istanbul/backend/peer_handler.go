@@ -17,6 +17,7 @@
 package backend
 
 import (
+	"sort"
 	"sync"
 	"time"
 
@@ -50,7 +51,9 @@ func (vph *validatorPeerHandler) startThread() error {
 		return istanbul.ErrStartedVPHThread
 	}
 
+	vph.threadWg.Add(1)
 	go vph.thread()
+	vph.threadRunning = true
 
 	return nil
 }
@@ -71,7 +74,6 @@ func (vph *validatorPeerHandler) stopThread() error {
 }
 
 func (vph *validatorPeerHandler) thread() {
-	vph.threadWg.Add(1)
 	defer vph.threadWg.Done()
 
 	refreshValidatorPeersTicker := time.NewTicker(1 * time.Minute)
@@ -156,6 +158,61 @@ func (vph *validatorPeerHandler) ClearValidatorPeers() {
 	}
 }
 
+// PeerReconciliation lists the discrepancies between the val enode table and the active
+// validator peers that were found by ReconcilePeers.  Intended for RPC use.
+type PeerReconciliation struct {
+	Disconnected   []string `json:"disconnected"`   // Validators in the validator conn set with a known enode that weren't peered, and were re-added as validator peers
+	UntrackedPeers []string `json:"untrackedPeers"` // Validator peers whose enode isn't in the val enode table
+}
+
+// ReconcilePeers compares the val enode table against the active validator peers.  The
+// connections to validators in the validator conn set whose enode is known but that aren't
+// peered are re-initiated, if this node maintains validator connections and is in the
+// validator conn set itself.  Validator peers that aren't in the table are only reported,
+// since they are removed by the next ReplaceValidatorPeers.
+func (sb *Backend) ReconcilePeers() (*PeerReconciliation, error) {
+	valEnodeEntries, err := sb.valEnodeTable.GetValEnodes(nil)
+	if err != nil {
+		return nil, err
+	}
+	validatorConnSet, err := sb.RetrieveValidatorConnSet()
+	if err != nil {
+		return nil, err
+	}
+	validatorPeers := sb.broadcaster.FindPeers(nil, p2p.ValidatorPurpose)
+	// The same conditions as in AddValidatorPeer
+	reconnect := sb.vph.MaintainValConnections() && validatorConnSet[sb.ValidatorAddress()]
+
+	reconciliation := &PeerReconciliation{
+		Disconnected:   make([]string, 0),
+		UntrackedPeers: make([]string, 0),
+	}
+	tableNodeIDs := make(map[enode.ID]bool, len(valEnodeEntries))
+	for address, entry := range valEnodeEntries {
+		if entry.Node == nil {
+			continue
+		}
+		tableNodeIDs[entry.Node.ID()] = true
+		if !reconnect || address == sb.ValidatorAddress() || !validatorConnSet[address] {
+			continue
+		}
+		if _, ok := validatorPeers[entry.Node.ID()]; !ok {
+			reconciliation.Disconnected = append(reconciliation.Disconnected, address.Hex())
+			sb.p2pserver.AddPeer(entry.Node, p2p.ValidatorPurpose)
+			sb.p2pserver.AddTrustedPeer(entry.Node, p2p.ValidatorPurpose)
+		}
+	}
+	for id := range validatorPeers {
+		if !tableNodeIDs[id] {
+			reconciliation.UntrackedPeers = append(reconciliation.UntrackedPeers, id.String())
+		}
+	}
+	sort.Strings(reconciliation.Disconnected)
+	sort.Strings(reconciliation.UntrackedPeers)
+
+	return reconciliation, nil
+}
+
 func (sb *Backend) AddPeer(node *enode.Node, purpose p2p.PurposeFlag) {
 	sb.p2pserver.AddPeer(node, purpose)
 }
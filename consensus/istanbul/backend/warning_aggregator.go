@@ -0,0 +1,98 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common/mclock"
+	"github.com/celo-org/celo-blockchain/log"
+)
+
+// warningAggregator coalesces identical warnings, which are the ones with the same message and
+// error (the "err" context value), to not flood the logs during a sustained issue.  The first warning is logged right away,
+// and the ones repeated within the window after it are counted and logged as a single line
+// at the end of the window, with the context of the latest one.
+type warningAggregator struct {
+	clock  mclock.Clock
+	window time.Duration
+
+	mu       sync.Mutex
+	warnings map[string]*aggregatedWarning
+}
+
+// aggregatedWarning is a warning that was repeated within the current window
+type aggregatedWarning struct {
+	logger   log.Logger
+	ctx      []interface{}
+	repeated int
+}
+
+// newWarningAggregator creates a warning aggregator.  A window of 0 disables the aggregation.
+func newWarningAggregator(clock mclock.Clock, window time.Duration) *warningAggregator {
+	return &warningAggregator{
+		clock:    clock,
+		window:   window,
+		warnings: make(map[string]*aggregatedWarning),
+	}
+}
+
+// Warn logs a warning with the logger, unless an identical one was logged within the window
+func (a *warningAggregator) Warn(logger log.Logger, msg string, ctx ...interface{}) {
+	if a.window <= 0 {
+		logger.Warn(msg, ctx...)
+		return
+	}
+
+	key := warningKey(msg, ctx)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if warning, ok := a.warnings[key]; ok {
+		warning.logger, warning.ctx = logger, ctx
+		warning.repeated++
+		return
+	}
+	a.warnings[key] = &aggregatedWarning{logger: logger, ctx: ctx}
+	logger.Warn(msg, ctx...)
+	a.clock.AfterFunc(a.window, func() { a.flush(key, msg) })
+}
+
+// warningKey returns the key that identical warnings share: the message, followed by the
+// error string if the context has an "err" value
+func warningKey(msg string, ctx []interface{}) string {
+	for i := 0; i+1 < len(ctx); i += 2 {
+		if ctx[i] == "err" {
+			return msg + "\x00" + fmt.Sprint(ctx[i+1])
+		}
+	}
+	return msg
+}
+
+// flush ends the window of a warning, and logs it if it was repeated within the window
+func (a *warningAggregator) flush(key, msg string) {
+	a.mu.Lock()
+	warning := a.warnings[key]
+	delete(a.warnings, key)
+	a.mu.Unlock()
+
+	if warning != nil && warning.repeated > 0 {
+		warning.logger.Warn(msg, append(warning.ctx, "repeated", warning.repeated, "window", a.window)...)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 The celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/celo-org/celo-blockchain/common/mclock"
+	"github.com/celo-org/celo-blockchain/log"
+)
+
+// recordingLogger returns a logger whose records are appended to records
+func recordingLogger(records *[]*log.Record) log.Logger {
+	logger := log.New()
+	logger.SetHandler(log.FuncHandler(func(r *log.Record) error {
+		*records = append(*records, r)
+		return nil
+	}))
+	return logger
+}
+
+func TestWarningAggregator(t *testing.T) {
+	var records []*log.Record
+	logger := recordingLogger(&records)
+	clock := &mclock.Simulated{}
+	window := time.Minute
+	aggregator := newWarningAggregator(clock, window)
+
+	// The first warning is logged right away, and its repetitions within the window are coalesced
+	for i := 0; i < 10; i++ {
+		aggregator.Warn(logger, "Error in sending announce message", "attempt", i)
+	}
+	aggregator.Warn(logger, "Error decrypting endpoint")
+	if len(records) != 2 {
+		t.Fatalf("Incorrect number of log records within the window.  Want: 2, Have: %d", len(records))
+	}
+
+	// At the end of the window, the repeated warning is logged once with its count and latest context
+	clock.Run(window)
+	if len(records) != 3 {
+		t.Fatalf("Incorrect number of log records after the window.  Want: 3, Have: %d", len(records))
+	}
+	aggregated := records[2]
+	if aggregated.Msg != "Error in sending announce message" {
+		t.Errorf("Incorrect aggregated message.  Want: %v, Have: %v", "Error in sending announce message", aggregated.Msg)
+	}
+	if want := []interface{}{"attempt", 9, "repeated", 9, "window", window}; !reflect.DeepEqual(aggregated.Ctx, want) {
+		t.Errorf("Incorrect aggregated context.  Want: %v, Have: %v", want, aggregated.Ctx)
+	}
+
+	// A new window starts with the next warning
+	aggregator.Warn(logger, "Error in sending announce message", "attempt", 10)
+	if len(records) != 4 {
+		t.Errorf("Incorrect number of log records in a new window.  Want: 4, Have: %d", len(records))
+	}
+}
+
+func TestWarningAggregatorDistinguishesErrors(t *testing.T) {
+	var records []*log.Record
+	logger := recordingLogger(&records)
+	aggregator := newWarningAggregator(&mclock.Simulated{}, time.Minute)
+
+	// Warnings with the same message but different errors aren't coalesced
+	aggregator.Warn(logger, "Error in gossiping announce message", "err", errors.New("no peers"))
+	aggregator.Warn(logger, "Error in gossiping announce message", "err", errors.New("rate limited"))
+	aggregator.Warn(logger, "Error in gossiping announce message", "err", errors.New("no peers"))
+	if len(records) != 2 {
+		t.Errorf("Incorrect number of log records.  Want: 2, Have: %d", len(records))
+	}
+}
+
+func TestWarningAggregatorDisabled(t *testing.T) {
+	var records []*log.Record
+	logger := recordingLogger(&records)
+	aggregator := newWarningAggregator(&mclock.Simulated{}, 0)
+
+	for i := 0; i < 3; i++ {
+		aggregator.Warn(logger, "Error in sending announce message")
+	}
+	if len(records) != 3 {
+		t.Errorf("Incorrect number of log records without aggregation.  Want: 3, Have: %d", len(records))
+	}
+}
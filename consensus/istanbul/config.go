@@ -38,22 +38,83 @@ const (
 	ShuffledRoundRobin
 )
 
+// EnodeURLSelector selects which of a node's enode URLs is advertised to a remote validator
+type EnodeURLSelector int
+
+const (
+	ExternalEnodeURL EnodeURLSelector = iota // The enode of the external network interface
+	InternalEnodeURL                         // The enode of the internal network interface
+)
+
+// AnswerPolicy controls whether the origin of an answered query enode message is upserted into
+// the val enode table, which designates it as a ValidatorPurpose peer and causes connection attempts
+type AnswerPolicy int
+
+const (
+	AlwaysUpsert      AnswerPolicy = iota // Always upsert the origin
+	OnlyExistingPeers                     // Only upsert origins that are already peers
+	Allowlist                             // Only upsert origins in AnnounceAnswerAllowlist
+)
+
+// SignatureScheme selects the signature scheme of announce messages
+type SignatureScheme int
+
+const (
+	ECDSAScheme SignatureScheme = iota // ECDSA over secp256k1 with the validator's signing key
+	BLSScheme                          // BLS with the validator's BLS key, looked up for the members of the validator conn set
+)
+
+// VersionMode selects how this node's announce version is generated
+type VersionMode int
+
+const (
+	TimestampVersion    VersionMode = iota // The unix timestamp of the version update
+	EpochCounterVersion                    // The current epoch number in the upper 32 bits, and a counter within the epoch in the lower 32 bits
+)
+
+// EnodeCertPolicy selects how a proxy handles an enode certificate from its proxied validator
+// that has a lower version than its current one, or an enode that isn't the proxy's own
+type EnodeCertPolicy int
+
+const (
+	RejectEnodeCert            EnodeCertPolicy = iota // Ignore the certificate
+	AcceptEnodeCertWithWarning                        // Use the certificate for handshakes anyway, and log a warning
+	RequestEnodeCertRefresh                           // Ignore the certificate, and request the current one from the proxied validator
+)
+
+// WireFormat selects how this node encodes the query enode data, enode certificates and version
+// certificates of its announce messages.  Every wire format is decoded regardless.
+type WireFormat int
+
+const (
+	RLPWireFormat      WireFormat = iota // The original RLP encoding, understood by every node
+	ProtobufWireFormat                   // Protobuf prefixed with a message version byte, which allows schema evolution
+)
+
 // Config represents the istanbul consensus engine
 type Config struct {
-	RequestTimeout              uint64         `toml:",omitempty"` // The timeout for each Istanbul round in milliseconds.
-	TimeoutBackoffFactor        uint64         `toml:",omitempty"` // Timeout at subsequent rounds is: RequestTimeout + 2**round * TimeoutBackoffFactor (in milliseconds)
-	MinResendRoundChangeTimeout uint64         `toml:",omitempty"` // Minimum interval with which to resend RoundChange messages for same round
-	MaxResendRoundChangeTimeout uint64         `toml:",omitempty"` // Maximum interval with which to resend RoundChange messages for same round
-	BlockPeriod                 uint64         `toml:",omitempty"` // Default minimum difference between two consecutive block's timestamps in second
-	ProposerPolicy              ProposerPolicy `toml:",omitempty"` // The policy for proposer selection
-	Epoch                       uint64         `toml:",omitempty"` // The number of blocks after which to checkpoint and reset the pending votes
-	DefaultLookbackWindow       uint64         `toml:",omitempty"` // The default value for how many blocks in a row a validator must miss to be considered "down"
-	ReplicaStateDBPath          string         `toml:",omitempty"` // The location for the validator replica state DB
-	ValidatorEnodeDBPath        string         `toml:",omitempty"` // The location for the validator enodes DB
-	VersionCertificateDBPath    string         `toml:",omitempty"` // The location for the signed announce version DB
-	RoundStateDBPath            string         `toml:",omitempty"` // The location for the round states DB
-	Validator                   bool           `toml:",omitempty"` // Specified if this node is configured to validate  (specifically if --mine command line is set)
-	Replica                     bool           `toml:",omitempty"` // Specified if this node is configured to be a replica
+	RequestTimeout                     uint64         `toml:",omitempty"` // The timeout for each Istanbul round in milliseconds.
+	TimeoutBackoffFactor               uint64         `toml:",omitempty"` // Timeout at subsequent rounds is: RequestTimeout + 2**round * TimeoutBackoffFactor (in milliseconds)
+	MinResendRoundChangeTimeout        uint64         `toml:",omitempty"` // Minimum interval with which to resend RoundChange messages for same round
+	MaxResendRoundChangeTimeout        uint64         `toml:",omitempty"` // Maximum interval with which to resend RoundChange messages for same round
+	BlockPeriod                        uint64         `toml:",omitempty"` // Default minimum difference between two consecutive block's timestamps in second
+	ProposerPolicy                     ProposerPolicy `toml:",omitempty"` // The policy for proposer selection
+	Epoch                              uint64         `toml:",omitempty"` // The number of blocks after which to checkpoint and reset the pending votes
+	DefaultLookbackWindow              uint64         `toml:",omitempty"` // The default value for how many blocks in a row a validator must miss to be considered "down"
+	ReplicaStateDBPath                 string         `toml:",omitempty"` // The location for the validator replica state DB
+	ValidatorEnodeDBPath               string         `toml:",omitempty"` // The location for the validator enodes DB
+	VersionCertificateDBPath           string         `toml:",omitempty"` // The location for the signed announce version DB
+	EnodeDBBlockCacheCapacity          int            `toml:",omitempty"` // The size (in bytes) of the block cache of the validator enodes and signed announce version DBs. Costs up to this much memory per DB. 0 uses the leveldb default of 8 MiB
+	EnodeDBBloomFilterBits             int            `toml:",omitempty"` // The bits per key of the bloom filter of the validator enodes and signed announce version DBs. Costs this many bits of memory per key. 0 disables the filter
+	EnodeDBCompactionDeletionThreshold int            `toml:",omitempty"` // The number of keys deleted from the validator enodes or signed announce version DB after which it's compacted. 0 disables compaction
+	EnodeDBMemoryFallback              bool           `toml:",omitempty"` // Specifies if the validator enodes and signed announce version DBs fall back to in-memory DBs when they can't be opened, instead of failing to start
+	EnodeDBOpenRetryAttempts           int            `toml:",omitempty"` // The number of times opening the validator enodes or signed announce version DB is retried, e.g. while the lock of a just exited process lingers. 0 disables retries
+	EnodeDBOpenRetryInterval           uint64         `toml:",omitempty"` // The delay (in milliseconds) before the first retry of opening the validator enodes or signed announce version DB, which doubles after each retry. 0 uses the default
+	EnodeDBCorruptionRotationThreshold int            `toml:",omitempty"` // The number of recoveries of a corrupted validator enodes or signed announce version DB within EnodeDBCorruptionRotationWindow after which the next corrupted DB is moved aside and a fresh one is started, since persistent corruption may indicate a failing disk. 0 disables rotation
+	EnodeDBCorruptionRotationWindow    uint64         `toml:",omitempty"` // The window (in seconds) in which recoveries count towards EnodeDBCorruptionRotationThreshold. 0 uses the default of a day
+	RoundStateDBPath                   string         `toml:",omitempty"` // The location for the round states DB
+	Validator                          bool           `toml:",omitempty"` // Specified if this node is configured to validate  (specifically if --mine command line is set)
+	Replica                            bool           `toml:",omitempty"` // Specified if this node is configured to be a replica
 
 	// Proxy Configs
 	Proxy                   bool           `toml:",omitempty"` // Specifies if this node is a proxy
@@ -64,9 +125,54 @@ type Config struct {
 	ProxyConfigs []*ProxyConfig `toml:",omitempty"` // The set of proxy configs for this proxied validator at startup
 
 	// Announce Configs
-	AnnounceQueryEnodeGossipPeriod                 uint64 `toml:",omitempty"` // Time duration (in seconds) between gossiped query enode messages
-	AnnounceAggressiveQueryEnodeGossipOnEnablement bool   `toml:",omitempty"` // Specifies if this node should aggressively query enodes on announce enablement
-	AnnounceAdditionalValidatorsToGossip           int64  `toml:",omitempty"` // Specifies the number of additional non-elected validators to gossip an announce
+	AnnounceQueryEnodeGossipPeriod                 uint64           `toml:",omitempty"` // Time duration (in seconds) between gossiped query enode messages
+	AnnounceAggressiveQueryEnodeGossipPeriod       uint64           `toml:",omitempty"` // Time duration (in seconds) between gossiped query enode messages while aggressively querying enodes. 0 uses the default
+	AnnounceCheckIfShouldAnnouncePeriod            uint64           `toml:",omitempty"` // Time duration (in seconds) between checks of whether this node should query and announce. 0 uses the default
+	AnnounceInitialQueryEnodeDelay                 uint64           `toml:",omitempty"` // Time duration (in seconds) between this node starting to query enodes and its first query enode message. The delay allows the receivers to refresh their cached validator conn set, so that they recognize this node as a member. 0 uses the default of 1 minute, or 5 seconds with an epoch of at most 10 blocks
+	AnnounceShareVersionCertificatesPeriod         uint64           `toml:",omitempty"` // Time duration (in seconds) between shares of the version certificates with all peers. A share only includes the entries that changed since the previous share, unless AnnounceFullShareVersionCertificatesPeriod passed since the last share of the entire table. 0 uses the default
+	AnnounceFullShareVersionCertificatesPeriod     uint64           `toml:",omitempty"` // Time duration (in seconds) between shares of the entire version certificate table. The shares in between only include the entries that changed since the previous share. 0 uses the default
+	AnnouncePruneDataStructuresPeriod              uint64           `toml:",omitempty"` // Time duration (in seconds) between prunes of the announce data structures. 0 uses the default
+	AnnounceUpdateVersionPeriod                    uint64           `toml:",omitempty"` // Time duration (in seconds) between updates of this node's announce version. 0 uses the default
+	AnnounceAggressiveQueryEnodeGossipOnEnablement bool             `toml:",omitempty"` // Specifies if this node should aggressively query enodes on announce enablement
+	AnnounceAdditionalValidatorsToGossip           int64            `toml:",omitempty"` // Specifies the number of additional non-elected validators to gossip an announce
+	AnnounceMaxQueriesPerRound                     uint64           `toml:",omitempty"` // The maximum number of validators queried in a single query enode message. The rest are queried in subsequent rounds. 0 is unlimited
+	AnnounceEIP191SignedQueryEnode                 bool             `toml:",omitempty"` // Specifies if query enode messages are signed and verified with the EIP-191 personal message prefix. Must be set uniformly across the network
+	AnnounceSignatureScheme                        SignatureScheme  `toml:",omitempty"` // The signature scheme this node signs its query enode messages with. Messages signed with either scheme are verified
+	AnnounceProbeReachability                      bool             `toml:",omitempty"` // Specifies if newly learned validator enodes are probed for reachability with a TCP dial. Off by default, as it opens connections to the validators
+	AnnounceReachabilityProbeTimeout               uint64           `toml:",omitempty"` // Time duration (in seconds) after which a reachability probe's TCP dial fails. 0 uses the default
+	AnnouncePartitionWindow                        uint64           `toml:",omitempty"` // Time duration (in seconds) without receiving a version certificate from a validator in the validator conn set after which it's flagged as possibly partitioned. 0 uses the default
+	AnnounceReconcilePeersPeriod                   uint64           `toml:",omitempty"` // Time duration (in seconds) between reconciliations of the val enode table with the validator peers, which re-initiate the connections to unpeered validators. 0 disables the periodic reconciliation
+	AnnounceVersionHistoryDepth                    uint64           `toml:",omitempty"` // The number of recent version certificate versions retained per validator for debugging. 0 disables the history
+	AnnounceVersionMode                            VersionMode      `toml:",omitempty"` // How this node's announce version is generated. Switching from timestamps to epoch+counter versions keeps them increasing, but not vice versa
+	AnnounceWireFormat                             WireFormat       `toml:",omitempty"` // The wire format of this node's announce messages. Protobuf must only be used once every node of the network decodes it
+	AnnounceInternalEnodeURLValidators             []common.Address `toml:",omitempty"` // The remote validators that are sent the internal enode URL of this node's proxy instead of the external one
+	AnnounceAdvertiseAddress                       string           `toml:",omitempty"` // An IP address, or the name of a network interface whose first IP address is used, that replaces the IP of this node's own enode URL in its announce messages. For multi-homed standalone validators, as proxied validators announce their proxies' enode URLs
+	AnnounceMaxEnodeURLLength                      uint64           `toml:",omitempty"` // The maximum length of a decrypted enode URL in a query enode message. 0 disables the check
+	AnnounceMaxEncryptedEnodeURLLength             uint64           `toml:",omitempty"` // The maximum length of an encrypted enode URL in a query enode message. 0 disables the check
+	AnnounceMaxTimestampSkew                       uint64           `toml:",omitempty"` // Time duration (in seconds) that the timestamp of a query enode message may be ahead of the local time. 0 (the default) disables the check. Query enode messages of validators whose clocks are off by more are dropped
+	AnnounceMaxVersionSkew                         uint64           `toml:",omitempty"` // Time duration (in seconds) that an announce version set with SetAnnounceVersion may be ahead of the local time, in the timestamp version mode. 0 (the default) disables the check. Only bounds the explicitly set versions, not the ones of received announce messages
+	AnnounceQueryEnodeMaxAge                       uint64           `toml:",omitempty"` // Time duration (in seconds) after the timestamp of a query enode message when it expires. 0 (the default) disables the check. Query enode messages of validators whose clocks are off by more are dropped
+	AnnouncePeerRateLimit                          uint64           `toml:",omitempty"` // The maximum outbound rate (in bytes per second) of announce messages sent to a single peer. 0 is unlimited
+	AnnounceVerbosePeerMetrics                     bool             `toml:",omitempty"` // Specifies if the number of announce messages and bytes sent to each peer are counted in per peer metrics. Off by default, as it registers metrics for every peer. Requires expensive metrics (--metrics.expensive)
+	AnnounceVersionCertificateMaxAge               uint64           `toml:",omitempty"` // Time duration (in seconds) after which a version certificate is pruned, forcing a fresh exchange. 0 disables pruning by age, as does the epoch+counter version mode
+	AnnounceJSONLogs                               bool             `toml:",omitempty"` // Specifies if the content of announce messages is logged as JSON objects instead of their String() representation
+	AnnounceNodeTag                                string           `toml:",omitempty"` // An optional human-readable tag included in enode certificate and query enode messages, for debugging only. It's only carried by the protobuf wire format
+	AnnounceECIESKDFSharedInfo                     string           `toml:",omitempty"` // The ECIES shared information (s1) that is mixed into the key derivation when encrypting and decrypting enode URLs. At most 64 bytes, must be set uniformly across the network
+	AnnounceECIESMACSharedInfo                     string           `toml:",omitempty"` // The ECIES shared information (s2) that is included in the MAC of encrypted enode URLs. At most 64 bytes, must be set uniformly across the network. The KDF hash and cipher are always the defaults of the key's curve
+	AnnounceInsecurePlaintextEnodeURLs             bool             `toml:",omitempty"` // INSECURE: Specifies if enode URLs are sent and accepted unencrypted in query enode messages. Only for fully trusted private networks, and must be set uniformly across the network
+	AnnounceCacheEncryptedEnodeURLs                bool             `toml:",omitempty"` // Specifies if the enode URL encrypted for a recipient is reused while neither changes, instead of being encrypted again for every query enode message. Saves CPU, but lets observers tell that consecutive messages carry the same enode URL
+	AnnounceCacheSignatures                        bool             `toml:",omitempty"` // Specifies if the signature of this node's last enode certificate for each enode, and of its last version certificate, is reused while their content is unchanged, instead of signing them again. Saves expensive signing with an HSM
+	AnnounceEncryptionRandBufferSize               int              `toml:",omitempty"` // The number of bytes of randomness read at once from the OS for encrypting enode URLs, saving syscalls when encrypting for many validators. Every byte is still used for a single ciphertext. 0 reads for every encryption
+	AnnounceTracePayloadBytes                      int              `toml:",omitempty"` // The maximum number of bytes of the payloads of sent and received announce messages that are logged in hex at trace level, to debug wire issues. 0 disables the logging
+	AnnounceWarningAggregationWindow               uint64           `toml:",omitempty"` // Time duration (in seconds) within which the repetitions of the noisiest announce warnings are logged as a single line with a count. 0 logs every warning
+	AnnounceAnswerPolicy                           AnswerPolicy     `toml:",omitempty"` // The policy for upserting the origins of answered query enode messages into the val enode table
+	AnnounceAnswerAllowlist                        []common.Address `toml:",omitempty"` // The query enode origins that are upserted into the val enode table with the Allowlist answer policy
+	AnnounceEnodeCertificateAllowlist              []common.Address `toml:",omitempty"` // If set, enode certificates are only accepted from these validators, in addition to the validator conn set check
+	AnnounceProxyEnodeCertificatePolicy            EnodeCertPolicy  `toml:",omitempty"` // How a proxy handles an enode certificate from its proxied validator with a too low version or a different enode than the proxy's. Rejecting is the default, as a mismatch usually means a misconfiguration
+	AnnounceEnodeCertificateMaxAge                 uint64           `toml:",omitempty"` // Time duration (in seconds) after the version of an enode certificate when it's rejected as stale. Only applies with timestamp versions. 0 disables the check
+	ValidatorEnodeDBIndexLayout                    []common.Address `toml:",omitempty"` // If set, the validator enodes DB keys its entries by the index of the validator within this fixed validator set, which is more compact. Other validators aren't stored. Changing it requires deleting the DB
+	AnnounceLightweightQueryEnodeRegossip          bool             `toml:",omitempty"` // Specifies if a node that is neither a validator nor a proxy regossips query enode messages after only verifying their signer, without decoding or validating their content, to reduce its CPU usage. Messages are only deduplicated by their hash, and the messages regossiped on behalf of each peer are rate limited
+	AnnounceSuppressSelfRegossip                   bool             `toml:",omitempty"` // Specifies if received query enode messages and version certificates originating from this node's own address are not regossiped, to not amplify its own traffic. Ignored by proxies, which must regossip their proxied validator's messages
 }
 
 // ProxyConfig represents the configuration for validator's proxies
@@ -77,25 +183,46 @@ type ProxyConfig struct {
 
 // DefaultConfig for istanbul consensus engine
 var DefaultConfig = &Config{
-	RequestTimeout:                 3000,
-	TimeoutBackoffFactor:           1000,
-	MinResendRoundChangeTimeout:    15 * 1000,
-	MaxResendRoundChangeTimeout:    2 * 60 * 1000,
-	BlockPeriod:                    5,
-	ProposerPolicy:                 ShuffledRoundRobin,
-	Epoch:                          30000,
-	DefaultLookbackWindow:          12,
-	ReplicaStateDBPath:             "replicastate",
-	ValidatorEnodeDBPath:           "validatorenodes",
-	VersionCertificateDBPath:       "versioncertificates",
-	RoundStateDBPath:               "roundstates",
-	Validator:                      false,
-	Replica:                        false,
-	Proxy:                          false,
-	Proxied:                        false,
-	AnnounceQueryEnodeGossipPeriod: 300, // 5 minutes
+	RequestTimeout:                     3000,
+	TimeoutBackoffFactor:               1000,
+	MinResendRoundChangeTimeout:        15 * 1000,
+	MaxResendRoundChangeTimeout:        2 * 60 * 1000,
+	BlockPeriod:                        5,
+	ProposerPolicy:                     ShuffledRoundRobin,
+	Epoch:                              30000,
+	DefaultLookbackWindow:              12,
+	ReplicaStateDBPath:                 "replicastate",
+	ValidatorEnodeDBPath:               "validatorenodes",
+	VersionCertificateDBPath:           "versioncertificates",
+	EnodeDBBlockCacheCapacity:          2 * 1024 * 1024, // The tables hold at most a few hundred entries
+	EnodeDBBloomFilterBits:             10,
+	EnodeDBCompactionDeletionThreshold: 1000,
+	EnodeDBOpenRetryAttempts:           3,
+	EnodeDBOpenRetryInterval:           100,
+	RoundStateDBPath:                   "roundstates",
+	Validator:                          false,
+	Replica:                            false,
+	Proxy:                              false,
+	Proxied:                            false,
+	AnnounceQueryEnodeGossipPeriod:     300, // 5 minutes
 	AnnounceAggressiveQueryEnodeGossipOnEnablement: true,
+	AnnounceAggressiveQueryEnodeGossipPeriod:       60,   // 1 minute
+	AnnounceCheckIfShouldAnnouncePeriod:            5,    // 5 seconds
+	AnnounceShareVersionCertificatesPeriod:         300,  // 5 minutes
+	AnnounceFullShareVersionCertificatesPeriod:     1800, // 30 minutes
+	AnnouncePruneDataStructuresPeriod:              600,  // 10 minutes
+	AnnounceUpdateVersionPeriod:                    300,  // 5 minutes
 	AnnounceAdditionalValidatorsToGossip:           10,
+	AnnounceMaxQueriesPerRound:                     50,
+	AnnounceMaxEnodeURLLength:                      512,
+	AnnounceMaxEncryptedEnodeURLLength:             1024,
+	AnnounceAnswerPolicy:                           AlwaysUpsert,
+	AnnounceSignatureScheme:                        ECDSAScheme,
+	AnnounceVersionMode:                            TimestampVersion,
+	AnnounceReachabilityProbeTimeout:               5,     // 5 seconds
+	AnnouncePartitionWindow:                        1800,  // 30 minutes
+	AnnounceEnodeCertificateMaxAge:                 86400, // 1 day, to accept certificates relayed during a long sync
+	AnnounceWarningAggregationWindow:               60,    // 1 minute
 }
 
 //ApplyParamsChainConfigToConfig applies the istanbul config values from params.chainConfig to the istanbul.Config config
@@ -44,4 +44,6 @@ var (
 	ErrValidatorNotProxied = errors.New("validator not proxied")
 	// ErrInvalidEnodeCertMsgMapOldVersion is returned if a validator sends old enode certificate message
 	ErrInvalidEnodeCertMsgMapOldVersion = errors.New("invalid enode certificate message map because of old version")
+	// ErrNonCanonicalSignature is returned if a signature isn't in the canonical low-S form, or has an invalid recovery id
+	ErrNonCanonicalSignature = errors.New("non-canonical signature")
 )
@@ -34,6 +34,13 @@ type MessageWithPeerIDEvent struct {
 	Payload []byte
 }
 
+// AnnouncingStateEvent is posted when a node starts or stops announcing
+type AnnouncingStateEvent struct {
+	Announcing bool
+	Reason     string
+	Seq        uint64 // Increases by one with every posted event, a gap means events were dropped
+}
+
 // FinalCommittedEvent is posted when a proposal is committed
 type FinalCommittedEvent struct {
 }
@@ -0,0 +1,57 @@
+// Copyright 2017 The Celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package kvstore defines the key-value store that the validator enode and version certificate
+// tables are backed by, so that embedders can supply their own store instead of leveldb.
+package kvstore
+
+import (
+	"errors"
+)
+
+// ErrNotFound is returned by a KVStore for a missing key.  Stores translate the
+// not found error of their backend to it.
+var ErrNotFound = errors.New("kvstore: not found")
+
+// KVStore is the minimal key-value store that the announce tables are backed by.
+// The default store is leveldb, but any store that implements this interface can be used.
+type KVStore interface {
+	// Get returns the value of the key, or ErrNotFound if the key is missing
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	// NewIterator returns an iterator over the keys with the given prefix, in ascending key order
+	NewIterator(prefix []byte) Iterator
+	NewBatch() Batch
+	Close() error
+}
+
+// Batch collects the writes of a KVStore that are applied atomically by Write
+type Batch interface {
+	Put(key, value []byte)
+	Delete(key []byte)
+	Len() int
+	Write() error
+}
+
+// Iterator iterates over the entries of a KVStore.  It must be released after use.
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Error() error
+	Release()
+}
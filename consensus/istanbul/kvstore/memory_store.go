@@ -0,0 +1,122 @@
+// Copyright 2017 The Celo Authors
+// This file is part of the celo library.
+//
+// The celo library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The celo library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the celo library. If not, see <http://www.gnu.org/licenses/>.
+
+package kvstore
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore is a KVStore backed by a map.  Its entries are lost when it is dropped.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Get(key []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.entries[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *MemoryStore) Put(key, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *MemoryStore) Delete(key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, string(key))
+	return nil
+}
+
+func (s *MemoryStore) NewIterator(prefix []byte) Iterator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	iter := &memoryStoreIterator{index: -1}
+	for key := range s.entries {
+		if strings.HasPrefix(key, string(prefix)) {
+			iter.keys = append(iter.keys, key)
+		}
+	}
+	sort.Strings(iter.keys)
+	for _, key := range iter.keys {
+		iter.values = append(iter.values, s.entries[key])
+	}
+	return iter
+}
+
+func (s *MemoryStore) NewBatch() Batch { return &memoryStoreBatch{store: s} }
+
+func (s *MemoryStore) Close() error { return nil }
+
+// memoryStoreIterator iterates over a snapshot of a MemoryStore's entries
+type memoryStoreIterator struct {
+	keys   []string
+	values [][]byte
+	index  int
+}
+
+func (it *memoryStoreIterator) Next() bool {
+	it.index++
+	return it.index < len(it.keys)
+}
+
+func (it *memoryStoreIterator) Key() []byte   { return []byte(it.keys[it.index]) }
+func (it *memoryStoreIterator) Value() []byte { return it.values[it.index] }
+func (it *memoryStoreIterator) Error() error  { return nil }
+func (it *memoryStoreIterator) Release()      {}
+
+// memoryStoreBatch applies its writes to a MemoryStore under a single lock
+type memoryStoreBatch struct {
+	store *MemoryStore
+	ops   []func(entries map[string][]byte)
+}
+
+func (b *memoryStoreBatch) Put(key, value []byte) {
+	key, value = append([]byte(nil), key...), append([]byte(nil), value...)
+	b.ops = append(b.ops, func(entries map[string][]byte) { entries[string(key)] = value })
+}
+
+func (b *memoryStoreBatch) Delete(key []byte) {
+	key = append([]byte(nil), key...)
+	b.ops = append(b.ops, func(entries map[string][]byte) { delete(entries, string(key)) })
+}
+
+func (b *memoryStoreBatch) Len() int { return len(b.ops) }
+
+func (b *memoryStoreBatch) Write() error {
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+	for _, op := range b.ops {
+		op(b.store.entries)
+	}
+	return nil
+}
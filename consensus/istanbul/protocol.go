@@ -25,6 +25,7 @@ const (
 	Celo64 = 64 // eth/63 + the istanbul messages
 	Celo65 = 65 // incorporates changes from eth/64 (EIP)
 	Celo66 = 66 // incorporates changes from eth/65 (EIP-2464)
+	Celo67 = 67 // supports compressed enode certificate and version certificates messages, enode certificate requests and announce snapshots
 )
 
 // protocolName is the official short name of the protocol used during capability negotiation.
@@ -32,7 +33,7 @@ const ProtocolName = "istanbul"
 
 // ProtocolVersions are the supported versions of the istanbul protocol (first is primary).
 // (First is primary in the sense that it's the most current one supported, not in the sense of IsPrimary() below)
-var ProtocolVersions = []uint{Celo66, Celo65, Celo64}
+var ProtocolVersions = []uint{Celo67, Celo66, Celo65, Celo64}
 
 // Returns whether this version of Istanbul should have Primary: true (a legacy property that was needed to work
 // around an upstream bug in the LES protocol which prevented two LES servers from connecting to each other).
@@ -43,7 +44,7 @@ func IsPrimary(version uint) bool {
 }
 
 // protocolLengths are the number of implemented message corresponding to different protocol versions.
-var ProtocolLengths = map[uint]uint64{Celo64: 22, Celo65: 27, Celo66: 27}
+var ProtocolLengths = map[uint]uint64{Celo64: 22, Celo65: 27, Celo66: 27, Celo67: 28}
 
 // Message codes for istanbul related messages
 // If you want to add a code, you need to increment the protocolLengths Array size
@@ -57,10 +58,33 @@ const (
 	VersionCertificatesMsg = 0x16
 	EnodeCertificateMsg    = 0x17
 	ValidatorHandshakeMsg  = 0x18
+
+	// Only sent to peers with protocol version Celo67 or higher
+	EnodeCertificateRequestMsg = 0x19
+	AnnounceSnapshotRequestMsg = 0x1a
+	AnnounceSnapshotMsg        = 0x1b
 )
 
 func IsIstanbulMsg(msg p2p.Msg) bool {
-	return msg.Code >= ConsensusMsg && msg.Code <= ValidatorHandshakeMsg
+	return msg.Code >= ConsensusMsg && msg.Code <= AnnounceSnapshotMsg
+}
+
+// SupportsAnnounceCompression returns whether peers using the given protocol version support
+// compressed enode certificate and version certificates messages.
+func SupportsAnnounceCompression(version int) bool {
+	return version >= Celo67
+}
+
+// SupportsEnodeCertificateRequest returns whether peers using the given protocol version
+// support the enode certificate request message.
+func SupportsEnodeCertificateRequest(version int) bool {
+	return version >= Celo67
+}
+
+// SupportsAnnounceSnapshot returns whether peers using the given protocol version support
+// announce snapshot requests.
+func SupportsAnnounceSnapshot(version int) bool {
+	return version >= Celo67
 }
 
 // IsGossipedMsg specifies which messages should be gossiped throughout the network (as opposed to directly sent to a peer).
@@ -18,11 +18,21 @@ package proxy
 
 import (
 	"encoding/hex"
+	"time"
 
 	"github.com/celo-org/celo-blockchain/consensus"
 	"github.com/celo-org/celo-blockchain/consensus/istanbul"
+	"github.com/celo-org/celo-blockchain/crypto"
+	"github.com/celo-org/celo-blockchain/log"
 	"github.com/celo-org/celo-blockchain/p2p/enode"
-	"github.com/celo-org/celo-blockchain/rlp"
+)
+
+const (
+	// The maximum number of times the same enode certificate is forwarded to the proxied validators
+	// within enodeCertificateForwardWindow.  A remote validator may legitimately resend its certificate
+	// a few times, but more than that indicates that the certificate is looping.
+	maxEnodeCertificateForwards   = 3
+	enodeCertificateForwardWindow = time.Minute
 )
 
 // handleEnodeCertificateMsgFromRemoteVal will handle an enode certificate sent from
@@ -39,6 +49,13 @@ func (p *proxyEngine) handleEnodeCertificateMsgFromRemoteVal(peer consensus.Peer
 		return true, istanbul.ErrUnauthorizedAddress
 	}
 
+	// Break forwarding loops between this proxy and a validator.  The peer isn't dropped, since
+	// an honest validator's retries may also exceed the limit.
+	if !p.recordEnodeCertificateForward(peer, payload) {
+		logger.Debug("Not forwarding an enode certificate message that the peer sent too often, it may be looping", "from", peer.Node().ID(), "msg address", msg.Address, "maxForwards", maxEnodeCertificateForwards)
+		return true, nil
+	}
+
 	// Need to forward the message to the proxied validators
 	logger.Trace("Forwarding enode certificate message to proxied validators", "from", peer.Node().ID())
 	for proxiedValidator := range p.proxiedValidators {
@@ -51,6 +68,33 @@ func (p *proxyEngine) handleEnodeCertificateMsgFromRemoteVal(peer consensus.Peer
 	return true, nil
 }
 
+// recordEnodeCertificateForward counts a forward of the enode certificate with the given payload sent
+// by the peer, and returns false if the peer's certificate was already forwarded maxEnodeCertificateForwards
+// times within the forward window.
+func (p *proxyEngine) recordEnodeCertificateForward(peer consensus.Peer, payload []byte) bool {
+	p.enodeCertForwardsMu.Lock()
+	defer p.enodeCertForwardsMu.Unlock()
+
+	now := time.Now()
+	for key, forwards := range p.enodeCertForwards {
+		if now.Sub(forwards.since) > enodeCertificateForwardWindow {
+			delete(p.enodeCertForwards, key)
+		}
+	}
+
+	key := enodeCertificateForwardKey{peer: peer.Node().ID(), hash: crypto.Keccak256Hash(payload)}
+	forwards, ok := p.enodeCertForwards[key]
+	if !ok {
+		forwards = &enodeCertificateForwards{since: now}
+		p.enodeCertForwards[key] = forwards
+	}
+	if forwards.count >= maxEnodeCertificateForwards {
+		return false
+	}
+	forwards.count++
+	return true
+}
+
 // handleEnodeCertificateFromProxiedValidator will handle an enode certifcate message sent from the proxied validator
 func (p *proxyEngine) handleEnodeCertificateMsgFromProxiedValidator(peer consensus.Peer, payload []byte) (bool, error) {
 	logger := p.logger.New("func", "handleEnodeCertificateMsgFromProxiedValidator")
@@ -59,7 +103,7 @@ func (p *proxyEngine) handleEnodeCertificateMsgFromProxiedValidator(peer consens
 
 	msg := new(istanbul.Message)
 	// Decode message
-	err := msg.FromPayload(payload, istanbul.GetSignatureAddress)
+	err := msg.FromPayload(payload, istanbul.GetCanonicalSignatureAddress)
 	if err != nil {
 		logger.Error("Error in decoding received Enode Certificate message from forward message", "err", err, "payload", hex.EncodeToString(payload))
 		return false, err
@@ -72,7 +116,7 @@ func (p *proxyEngine) handleEnodeCertificateMsgFromProxiedValidator(peer consens
 	}
 
 	var enodeCertificate istanbul.EnodeCertificate
-	if err := rlp.DecodeBytes(msg.Msg, &enodeCertificate); err != nil {
+	if err := istanbul.DecodeAnnounceData(msg.Msg, &enodeCertificate); err != nil {
 		logger.Warn("Error in decoding received Istanbul Enode Certificate message content", "err", err, "IstanbulMsg", msg.String())
 		return false, err
 	}
@@ -84,19 +128,58 @@ func (p *proxyEngine) handleEnodeCertificateMsgFromProxiedValidator(peer consens
 	}
 
 	// If this enode certificate's nodeID is the same as the node's external nodeID, then save it.
+	// Otherwise it's handled according to the configured policy.
+	policy := p.config.AnnounceProxyEnodeCertificatePolicy
 	selfNode := p.backend.SelfNode()
-	if enodeCertificateNode.ID() == selfNode.ID() {
-		enodeCertMsgMap := make(map[enode.ID]*istanbul.EnodeCertMsg)
-		enodeCertMsgMap[selfNode.ID()] = &istanbul.EnodeCertMsg{Msg: msg}
-		if err := p.backend.SetEnodeCertificateMsgMap(enodeCertMsgMap); err != nil {
-			logger.Warn("Error in setting proxy's enode certificate", "err", err, "enodeCertificate", enodeCertificate)
-			// Don't drop validators when switching over.
-			if err == istanbul.ErrInvalidEnodeCertMsgMapOldVersion {
-				return true, nil
+	if enodeCertificateNode.ID() != selfNode.ID() {
+		switch policy {
+		case istanbul.AcceptEnodeCertWithWarning:
+			logger.Warn("Accepting enode certificate with a different enode than this proxy's", "enodeCertificate", enodeCertificate, "selfNode", selfNode)
+		case istanbul.RequestEnodeCertRefresh:
+			p.requestEnodeCertificateRefresh(peer, enodeCertificate.Version, logger)
+			return true, nil
+		default:
+			return true, nil
+		}
+	}
+
+	enodeCertMsgMap := make(map[enode.ID]*istanbul.EnodeCertMsg)
+	enodeCertMsgMap[selfNode.ID()] = &istanbul.EnodeCertMsg{Msg: msg}
+	if err := p.backend.SetEnodeCertificateMsgMap(enodeCertMsgMap); err != nil {
+		logger.Warn("Error in setting proxy's enode certificate", "err", err, "enodeCertificate", enodeCertificate)
+		// Don't drop validators when switching over.
+		if err == istanbul.ErrInvalidEnodeCertMsgMapOldVersion {
+			switch policy {
+			case istanbul.AcceptEnodeCertWithWarning:
+				if err := p.backend.OverrideEnodeCertificateMsgMap(enodeCertMsgMap); err != nil {
+					return true, err
+				}
+			case istanbul.RequestEnodeCertRefresh:
+				p.requestEnodeCertificateRefresh(peer, enodeCertificate.Version, logger)
 			}
-			return true, err
+			return true, nil
 		}
+		return true, err
 	}
 
 	return true, nil
 }
+
+// requestEnodeCertificateRefresh requests the current enode certificate from the proxied validator,
+// in response to an ignored enode certificate with the given version.  The refresh is requested only
+// once per version, since the proxied validator answers with the same certificate if it has no other.
+func (p *proxyEngine) requestEnodeCertificateRefresh(peer consensus.Peer, version uint, logger log.Logger) {
+	p.enodeCertRefreshesMu.Lock()
+	if refreshedVersion, ok := p.enodeCertRefreshes[peer.Node().ID()]; ok && refreshedVersion == version {
+		p.enodeCertRefreshesMu.Unlock()
+		logger.Debug("Already requested the current enode certificate for this version from the proxied validator", "version", version)
+		return
+	}
+	p.enodeCertRefreshes[peer.Node().ID()] = version
+	p.enodeCertRefreshesMu.Unlock()
+
+	logger.Debug("Requesting the current enode certificate from the proxied validator", "version", version)
+	if err := p.backend.RequestEnodeCertificate(peer); err != nil {
+		logger.Warn("Error in requesting the current enode certificate from the proxied validator", "err", err)
+	}
+}
@@ -53,6 +53,9 @@ type BackendForProxiedValidatorEngine interface {
 	// Unicast will asynchronously send a celo message to peer
 	Unicast(peer consensus.Peer, payload []byte, ethMsgCode uint64)
 
+	// SendToPeer will synchronously send a celo message to peer, and return the error of the send
+	SendToPeer(peer consensus.Peer, payload []byte, ethMsgCode uint64) error
+
 	// GetValEnodeTableEntries retrieves the entries in the valEnodeTable filtered on the "validators" parameter.
 	// If the parameter is nil, then no filter will be applied.
 	GetValEnodeTableEntries(validators []common.Address) (map[common.Address]*istanbul.AddressEntry, error)
@@ -108,8 +111,6 @@ type proxiedValidatorEngine struct {
 
 	sendValEnodeShareMsgsCh chan struct{} // Used to notify the thread to send a val enode share message to all of the proxies
 
-	sendEnodeCertsCh chan map[enode.ID]*istanbul.EnodeCertMsg // Used to notify the thread to send the enode certs to the appropriate proxy.
-
 	sendFwdMsgsCh chan *fwdMsgInfo // Used to send a forward message to all of the proxies
 
 	newBlockchainEpoch chan struct{} // Used to notify to the thread that a new blockchain epoch has started
@@ -138,7 +139,6 @@ func NewProxiedValidatorEngine(backend BackendForProxiedValidatorEngine, config
 		proxiedValThreadOpDoneCh: make(chan struct{}),
 
 		sendValEnodeShareMsgsCh: make(chan struct{}),
-		sendEnodeCertsCh:        make(chan map[enode.ID]*istanbul.EnodeCertMsg),
 		sendFwdMsgsCh:           make(chan *fwdMsgInfo),
 		newBlockchainEpoch:      make(chan struct{}),
 	}
@@ -324,20 +324,55 @@ func (pv *proxiedValidatorEngine) SendValEnodesShareMsgToAllProxies() error {
 	return nil
 }
 
-// SendEnodeCertsToAllProxies will signal to the running thread to share the given enode certs to the appropriate proxy
-func (pv *proxiedValidatorEngine) SendEnodeCertsToAllProxies(enodeCerts map[enode.ID]*istanbul.EnodeCertMsg) error {
+// SendEnodeCertsToAllProxies will share the given enode certs to the appropriate proxy.  The proxy peers are
+// retrieved from the running thread, but the enode certs are sent from the calling goroutine, so that the
+// error of each send can be returned.  The returned map has the error of each proxy whose enode cert couldn't
+// be sent, including the proxies that aren't connected.
+func (pv *proxiedValidatorEngine) SendEnodeCertsToAllProxies(enodeCerts map[enode.ID]*istanbul.EnodeCertMsg) (map[enode.ID]error, error) {
+	logger := pv.logger.New("func", "SendEnodeCertsToAllProxies")
+
 	if !pv.Running() {
-		return istanbul.ErrStoppedProxiedValidatorEngine
+		return nil, istanbul.ErrStoppedProxiedValidatorEngine
 	}
 
+	proxyPeers := make(map[enode.ID]consensus.Peer)
 	select {
-	case pv.sendEnodeCertsCh <- enodeCerts:
+	case pv.proxiedValThreadOpCh <- func(ps *proxySet) {
+		for proxyID, proxy := range ps.proxiesByID {
+			if enodeCerts[proxyID] != nil {
+				proxyPeers[proxyID] = proxy.peer
+			}
+		}
+	}:
+		<-pv.proxiedValThreadOpDoneCh
 
 	case <-pv.quit:
-		return istanbul.ErrStoppedProxiedValidatorEngine
+		return nil, istanbul.ErrStoppedProxiedValidatorEngine
 	}
 
-	return nil
+	failures := make(map[enode.ID]error)
+	for proxyID, proxyPeer := range proxyPeers {
+		if err := pv.sendEnodeCert(proxyPeer, enodeCerts[proxyID]); err != nil {
+			logger.Debug("Error in sharing enode certificate to proxy", "proxyID", proxyID, "err", err)
+			failures[proxyID] = err
+			continue
+		}
+		logger.Info("Shared enode certificate to proxy", "proxy peer", proxyPeer, "proxyID", proxyID)
+	}
+	return failures, nil
+}
+
+// sendEnodeCert synchronously sends an enode certificate to a proxy peer, which is nil if the proxy isn't connected
+func (pv *proxiedValidatorEngine) sendEnodeCert(proxyPeer consensus.Peer, enodeCert *istanbul.EnodeCertMsg) error {
+	if proxyPeer == nil {
+		return errProxyNotConnected
+	}
+	// Note that the enode cert is already signed by the validator
+	payload, err := enodeCert.Msg.Payload()
+	if err != nil {
+		return err
+	}
+	return pv.backend.SendToPeer(proxyPeer, payload, istanbul.EnodeCertificateMsg)
 }
 
 // SendForwardMsgToAllProxies will signal to the running thread to send a forward message to all proxies.
@@ -484,9 +519,6 @@ loop:
 		case <-pv.sendValEnodeShareMsgsCh:
 			pv.sendValEnodeShareMsgs(ps)
 
-		case enodeCerts := <-pv.sendEnodeCertsCh:
-			pv.sendEnodeCerts(ps, enodeCerts)
-
 		case fwdMsg := <-pv.sendFwdMsgsCh:
 			pv.sendForwardMsg(ps, fwdMsg.destAddresses, fwdMsg.ethMsgCode, fwdMsg.payload)
 
@@ -18,6 +18,7 @@ package proxy
 
 import (
 	"sync"
+	"time"
 
 	"github.com/celo-org/celo-blockchain/common"
 	"github.com/celo-org/celo-blockchain/consensus"
@@ -53,9 +54,15 @@ type BackendForProxyEngine interface {
 	// SetEnodeCertificateMsgs will set this node's enodeCertificate to be used for connection handshakes
 	SetEnodeCertificateMsgMap(enodeCertificateMsgMap map[enode.ID]*istanbul.EnodeCertMsg) error
 
+	// OverrideEnodeCertificateMsgMap will set this node's enodeCertificate even if it's older than the current one
+	OverrideEnodeCertificateMsgMap(enodeCertificateMsgMap map[enode.ID]*istanbul.EnodeCertMsg) error
+
 	// RetrieveEnodeCertificateMsgMap will retrieve this node's handshake enodeCertificate
 	RetrieveEnodeCertificateMsgMap() map[enode.ID]*istanbul.EnodeCertMsg
 
+	// RequestEnodeCertificate requests the peer's current enode certificate
+	RequestEnodeCertificate(peer consensus.Peer) error
+
 	// VerifyPendingBlockValidatorSignature is a message validation function to verify that a message's sender is within the validator set
 	// of the current pending block and that the message's address field matches the message's signature's signer
 	VerifyPendingBlockValidatorSignature(data []byte, sig []byte) (common.Address, error)
@@ -77,6 +84,26 @@ type proxyEngine struct {
 	proxiedValidators   map[consensus.Peer]bool
 	proxiedValidatorIDs map[enode.ID]bool
 	proxiedValidatorsMu sync.RWMutex
+
+	// The enode certificates forwarded to the proxied validators, keyed by sending peer and payload
+	enodeCertForwards   map[enodeCertificateForwardKey]*enodeCertificateForwards
+	enodeCertForwardsMu sync.Mutex
+
+	// The enode certificate version that a refresh was last requested for, keyed by proxied validator node
+	enodeCertRefreshes   map[enode.ID]uint
+	enodeCertRefreshesMu sync.Mutex
+}
+
+// enodeCertificateForwardKey identifies an enode certificate sent by a peer
+type enodeCertificateForwardKey struct {
+	peer enode.ID
+	hash common.Hash // The hash of the certificate's payload
+}
+
+// enodeCertificateForwards counts the forwards of a single enode certificate sent by a peer
+type enodeCertificateForwards struct {
+	count int
+	since time.Time // When the certificate was first forwarded
 }
 
 // NewProxyEngine creates a new proxy engine.
@@ -91,6 +118,8 @@ func NewProxyEngine(backend BackendForProxyEngine, config *istanbul.Config) (Pro
 		backend:             backend,
 		proxiedValidators:   make(map[consensus.Peer]bool),
 		proxiedValidatorIDs: make(map[enode.ID]bool),
+		enodeCertForwards:   make(map[enodeCertificateForwardKey]*enodeCertificateForwards),
+		enodeCertRefreshes:  make(map[enode.ID]uint),
 	}
 
 	return p, nil
@@ -142,6 +171,10 @@ func (p *proxyEngine) UnregisterProxiedValidatorPeer(proxiedValidatorPeer consen
 	delete(p.proxiedValidators, proxiedValidatorPeer)
 	delete(p.proxiedValidatorIDs, proxiedValidatorPeer.Node().ID())
 
+	p.enodeCertRefreshesMu.Lock()
+	delete(p.enodeCertRefreshes, proxiedValidatorPeer.Node().ID())
+	p.enodeCertRefreshesMu.Unlock()
+
 }
 
 func (p *proxyEngine) GetProxiedValidatorsInfo() ([]*ProxiedValidatorInfo, error) {
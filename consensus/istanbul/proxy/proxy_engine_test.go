@@ -29,6 +29,7 @@ import (
 	"github.com/celo-org/celo-blockchain/consensus/istanbul/backend/backendtest"
 	"github.com/celo-org/celo-blockchain/crypto"
 	"github.com/celo-org/celo-blockchain/p2p"
+	"github.com/celo-org/celo-blockchain/p2p/enode"
 	"github.com/celo-org/celo-blockchain/rlp"
 )
 
@@ -380,3 +381,148 @@ func testConsensusMsgFromProxiedVal(t *testing.T, proxiedValBE BackendForProxied
 		t.Errorf("Unexpectedly handled a consensus message from the proxied validator")
 	}
 }
+
+func TestEnodeCertificateForwardLoop(t *testing.T) {
+	// Create a proxied validator (val0), its proxy, and a remote validator (val1)
+	numValidators := 2
+	genesisCfg, nodeKeys := backendtest.GetGenesisAndKeys(numValidators, true)
+
+	val0BEi, _ := backendtest.NewTestBackend(false, common.Address{}, true, genesisCfg, nodeKeys[0])
+	val0BE := val0BEi.(BackendForProxiedValidatorEngine)
+	val0Peer := consensustest.NewMockPeer(val0BE.SelfNode(), p2p.AnyPurpose)
+
+	proxyBEi, _ := backendtest.NewTestBackend(true, val0BE.Address(), false, genesisCfg, nil)
+	proxyBE := proxyBEi.(BackendForProxyEngine)
+
+	val1BEi, _ := backendtest.NewTestBackend(false, common.Address{}, false, genesisCfg, nodeKeys[1])
+	val1BE := val1BEi.(BackendForProxiedValidatorEngine)
+	val1Peer := consensustest.NewMockPeer(val1BE.SelfNode(), p2p.AnyPurpose)
+
+	p := proxyBE.GetProxyEngine().(*proxyEngine)
+	p.RegisterProxiedValidatorPeer(val0Peer)
+
+	// Sleep for 6 seconds so that val1BE will generate it's enode certificate.
+	time.Sleep(6 * time.Second)
+
+	val1EnodeCert := val1BE.RetrieveEnodeCertificateMsgMap()[val1BE.SelfNode().ID()].Msg
+	val1EnodeCertPayload, _ := val1EnodeCert.Payload()
+
+	// Simulate the proxied validator sending the certificate back to the proxy over and over
+	for i := 0; i < maxEnodeCertificateForwards; i++ {
+		if handled, err := p.handleEnodeCertificateMsgFromRemoteVal(val1Peer, val1EnodeCertPayload); !handled || err != nil {
+			t.Fatalf("Error in forwarding enode certificate msg %d.  Handled: %v, Error: %v", i, handled, err)
+		}
+	}
+	// The looping certificate isn't forwarded anymore, but the peer isn't dropped
+	if handled, err := p.handleEnodeCertificateMsgFromRemoteVal(val1Peer, val1EnodeCertPayload); !handled || err != nil {
+		t.Errorf("Error in handling looping enode certificate msg.  Handled: %v, Error: %v", handled, err)
+	}
+	val1Forwards := enodeCertificateForwardKey{peer: val1Peer.Node().ID(), hash: crypto.Keccak256Hash(val1EnodeCertPayload)}
+	if forwards := p.enodeCertForwards[val1Forwards]; forwards == nil || forwards.count != maxEnodeCertificateForwards {
+		t.Errorf("Looping enode certificate msg was forwarded.  Want: %d forwards, Have: %v", maxEnodeCertificateForwards, forwards)
+	}
+
+	// The same certificate from another peer is still forwarded
+	otherKey, _ := crypto.GenerateKey()
+	otherPeer := consensustest.NewMockPeer(enode.NewV4(&otherKey.PublicKey, nil, 0, 0), p2p.AnyPurpose)
+	if !p.recordEnodeCertificateForward(otherPeer, val1EnodeCertPayload) {
+		t.Errorf("Enode certificate from another peer was not forwarded")
+	}
+
+	// The certificate is forwarded again once the forward window passed
+	p.enodeCertForwardsMu.Lock()
+	for _, forwards := range p.enodeCertForwards {
+		forwards.since = forwards.since.Add(-2 * enodeCertificateForwardWindow)
+	}
+	p.enodeCertForwardsMu.Unlock()
+	if handled, err := p.handleEnodeCertificateMsgFromRemoteVal(val1Peer, val1EnodeCertPayload); !handled || err != nil {
+		t.Errorf("Error in forwarding enode certificate msg after the forward window.  Handled: %v, Error: %v", handled, err)
+	}
+}
+
+// requestRecordingPeer is a mock peer that records the codes of the messages sent to it
+type requestRecordingPeer struct {
+	*consensustest.MockPeer
+	sentCodes []uint64
+}
+
+func (p *requestRecordingPeer) Send(msgCode uint64, data interface{}) error {
+	p.sentCodes = append(p.sentCodes, msgCode)
+	return nil
+}
+
+func (p *requestRecordingPeer) Version() int {
+	return istanbul.Celo67
+}
+
+func TestEnodeCertificatePolicyOnOldVersion(t *testing.T) {
+	genesisCfg, nodeKeys := backendtest.GetGenesisAndKeys(2, true)
+	valKey := nodeKeys[0]
+	valAddress := crypto.PubkeyToAddress(valKey.PublicKey)
+
+	testCases := []struct {
+		policy          istanbul.EnodeCertPolicy
+		wantVersion     uint
+		wantRefreshSent bool
+	}{
+		{istanbul.RejectEnodeCert, 10, false},
+		{istanbul.AcceptEnodeCertWithWarning, 5, false},
+		{istanbul.RequestEnodeCertRefresh, 10, true},
+	}
+	for _, tc := range testCases {
+		proxyBEi, _ := backendtest.NewTestBackend(true, valAddress, false, genesisCfg, nil)
+		proxyBE := proxyBEi.(BackendForProxyEngine)
+		p := proxyBE.GetProxyEngine().(*proxyEngine)
+		p.config.AnnounceProxyEnodeCertificatePolicy = tc.policy
+
+		valPeer := &requestRecordingPeer{MockPeer: consensustest.NewMockPeer(nil, p2p.AnyPurpose)}
+		// The second certificate with version 5 is the proxied validator's answer to a refresh request
+		for _, version := range []uint{10, 5, 5} {
+			payload := newProxyEnodeCertificatePayload(t, valKey, proxyBE.SelfNode().URLv4(), version)
+			if handled, err := p.handleEnodeCertificateMsgFromProxiedValidator(valPeer, payload); !handled || err != nil {
+				t.Fatalf("Error in handling enode certificate msg.  Policy: %d, Version: %d, Handled: %v, Error: %v", tc.policy, version, handled, err)
+			}
+		}
+
+		enodeCertMsg := proxyBE.RetrieveEnodeCertificateMsgMap()[proxyBE.SelfNode().ID()]
+		if enodeCertMsg == nil {
+			t.Fatalf("Proxy has no enode certificate.  Policy: %d", tc.policy)
+		}
+		var enodeCertificate istanbul.EnodeCertificate
+		if err := rlp.DecodeBytes(enodeCertMsg.Msg.Msg, &enodeCertificate); err != nil {
+			t.Fatalf("Error in decoding enode certificate.  Error: %v", err)
+		}
+		if enodeCertificate.Version != tc.wantVersion {
+			t.Errorf("Incorrect enode certificate version.  Policy: %d, Want: %d, Have: %d", tc.policy, tc.wantVersion, enodeCertificate.Version)
+		}
+		var wantSentCodes []uint64
+		if tc.wantRefreshSent {
+			wantSentCodes = []uint64{istanbul.EnodeCertificateRequestMsg}
+		}
+		if !reflect.DeepEqual(valPeer.sentCodes, wantSentCodes) {
+			t.Errorf("Incorrect messages sent to the proxied validator.  Policy: %d, Want: %v, Have: %v", tc.policy, wantSentCodes, valPeer.sentCodes)
+		}
+	}
+}
+
+func newProxyEnodeCertificatePayload(t *testing.T, key *ecdsa.PrivateKey, enodeURL string, version uint) []byte {
+	ecBytes, err := rlp.EncodeToBytes(&istanbul.EnodeCertificate{EnodeURL: enodeURL, Version: version})
+	if err != nil {
+		t.Fatalf("Error in encoding enode certificate.  Error: %v", err)
+	}
+	ecMsg := &istanbul.Message{
+		Code:    istanbul.EnodeCertificateMsg,
+		Address: crypto.PubkeyToAddress(key.PublicKey),
+		Msg:     ecBytes,
+	}
+	if err := ecMsg.Sign(func(data []byte) ([]byte, error) {
+		return crypto.Sign(crypto.Keccak256(data), key)
+	}); err != nil {
+		t.Fatalf("Error in signing enode certificate message.  Error: %v", err)
+	}
+	payload, err := ecMsg.Payload()
+	if err != nil {
+		t.Fatalf("Error in encoding enode certificate message.  Error: %v", err)
+	}
+	return payload
+}
@@ -47,6 +47,9 @@ var (
 	// ErrNoProxiedValidator is returned if the proxy has no connected proxied validator
 	ErrNoProxiedValidator = errors.New("no connected proxied validator")
 
+	// errProxyNotConnected is returned if an enode certificate can't be sent to a proxy that isn't connected
+	errProxyNotConnected = errors.New("proxy not connected")
+
 	// ErrNoCelostatsProxy is returned if there is no connected proxy that sent the celostats message to be signed
 	ErrNoCelostatsProxy = errors.New("no connected proxy that sent the celostats message to be signed")
 )
@@ -111,8 +114,9 @@ type ProxiedValidatorEngine interface {
 	// connected proxy.
 	SendValEnodesShareMsgToAllProxies() error
 
-	// SendEnodeCertsToAllProxies will send the enode certs to the appropriate proxy.
-	SendEnodeCertsToAllProxies(map[enode.ID]*istanbul.EnodeCertMsg) error
+	// SendEnodeCertsToAllProxies will send the enode certs to the appropriate proxy, and return the
+	// error of each proxy whose enode cert couldn't be sent.
+	SendEnodeCertsToAllProxies(map[enode.ID]*istanbul.EnodeCertMsg) (map[enode.ID]error, error)
 
 	// GetValidatorProxyAssignments will retrieve all the remote validator to proxy assignments.
 	GetValidatorProxyAssignments(validators []common.Address) (map[common.Address]*Proxy, error)
@@ -143,6 +147,10 @@ func (p *Proxy) ID() enode.ID {
 	return p.node.ID()
 }
 
+func (p *Proxy) InternalNode() *enode.Node {
+	return p.node
+}
+
 func (p *Proxy) ExternalNode() *enode.Node {
 	return p.externalNode
 }
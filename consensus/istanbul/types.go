@@ -357,6 +357,15 @@ func (m *Message) Sign(signingFn func(data []byte) ([]byte, error)) error {
 	return err
 }
 
+// SignEIP191 is like Sign, except that the payload is wrapped with the EIP-191
+// personal message prefix before it is signed. Messages signed this way must
+// be verified with GetEIP191SignatureAddress.
+func (m *Message) SignEIP191(signingFn func(data []byte) ([]byte, error)) error {
+	return m.Sign(func(data []byte) ([]byte, error) {
+		return signingFn(EIP191Message(data))
+	})
+}
+
 func (m *Message) FromPayload(b []byte, validateFn func([]byte, []byte) (common.Address, error)) error {
 	// Decode Message
 	err := rlp.DecodeBytes(b, &m)
@@ -428,24 +437,32 @@ func MapMessagesToSenders(messages []Message) []common.Address {
 type EnodeCertificate struct {
 	EnodeURL string
 	Version  uint
+	NodeTag  string // Optional and informational only, see SanitizeNodeTag
 }
 
 // EncodeRLP serializes ec into the Ethereum RLP format.
+// The node tag is never encoded, since older versions reject certificates with more fields.  It's
+// only carried by the protobuf wire format.
 func (ec *EnodeCertificate) EncodeRLP(w io.Writer) error {
 	return rlp.Encode(w, []interface{}{ec.EnodeURL, ec.Version})
 }
 
 // DecodeRLP implements rlp.Decoder, and load the ec fields from a RLP stream.
+// A trailing node tag is still accepted.
 func (ec *EnodeCertificate) DecodeRLP(s *rlp.Stream) error {
 	var msg struct {
 		EnodeURL string
 		Version  uint
+		Optional []string `rlp:"tail"`
 	}
 
 	if err := s.Decode(&msg); err != nil {
 		return err
 	}
-	ec.EnodeURL, ec.Version = msg.EnodeURL, msg.Version
+	ec.EnodeURL, ec.Version, ec.NodeTag = msg.EnodeURL, msg.Version, ""
+	if len(msg.Optional) > 0 {
+		ec.NodeTag = SanitizeNodeTag(msg.Optional[0])
+	}
 	return nil
 }
 
@@ -465,6 +482,27 @@ type AddressEntry struct {
 	HighestKnownVersion          uint
 	NumQueryAttemptsForHKVersion uint
 	LastQueryTimestamp           *time.Time
+	Reachability                 Reachability
+}
+
+// Reachability is the result of the latest reachability probe of an AddressEntry's Node
+type Reachability uint
+
+const (
+	ReachabilityUnknown Reachability = iota // The node wasn't probed
+	Reachable                               // A TCP connection to the node could be established
+	Unreachable                             // A TCP connection to the node couldn't be established
+)
+
+func (r Reachability) String() string {
+	switch r {
+	case Reachable:
+		return "reachable"
+	case Unreachable:
+		return "unreachable"
+	default:
+		return "unknown"
+	}
 }
 
 func (ae *AddressEntry) String() string {
@@ -484,6 +522,9 @@ type AddressEntryRLP struct {
 	HighestKnownVersion          uint
 	NumQueryAttemptsForHKVersion uint
 	LastQueryTimestamp           []byte
+	// Reachability is optional (empty if unknown), so that entries stored
+	// before it was added still decode.
+	Reachability []Reachability `rlp:"tail"`
 }
 
 // EncodeRLP serializes AddressEntry into the Ethereum RLP format.
@@ -505,13 +546,19 @@ func (ae *AddressEntry) EncodeRLP(w io.Writer) error {
 		}
 	}
 
+	var reachability []Reachability
+	if ae.Reachability != ReachabilityUnknown {
+		reachability = []Reachability{ae.Reachability}
+	}
+
 	return rlp.Encode(w, AddressEntryRLP{Address: ae.Address,
 		CompressedPublicKey:          publicKeyBytes,
 		EnodeURL:                     nodeString,
 		Version:                      ae.Version,
 		HighestKnownVersion:          ae.HighestKnownVersion,
 		NumQueryAttemptsForHKVersion: ae.NumQueryAttemptsForHKVersion,
-		LastQueryTimestamp:           lastQueryTimestampBytes})
+		LastQueryTimestamp:           lastQueryTimestampBytes,
+		Reachability:                 reachability})
 }
 
 // DecodeRLP implements rlp.Decoder, and load the AddressEntry fields from a RLP stream.
@@ -543,13 +590,19 @@ func (ae *AddressEntry) DecodeRLP(s *rlp.Stream) error {
 		}
 	}
 
+	reachability := ReachabilityUnknown
+	if len(entry.Reachability) > 0 {
+		reachability = entry.Reachability[0]
+	}
+
 	*ae = AddressEntry{Address: entry.Address,
 		PublicKey:                    publicKey,
 		Node:                         node,
 		Version:                      entry.Version,
 		HighestKnownVersion:          entry.HighestKnownVersion,
 		NumQueryAttemptsForHKVersion: entry.NumQueryAttemptsForHKVersion,
-		LastQueryTimestamp:           lastQueryTimestamp}
+		LastQueryTimestamp:           lastQueryTimestamp,
+		Reachability:                 reachability}
 	return nil
 }
 
@@ -21,8 +21,10 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/celo-org/celo-blockchain/accounts"
 	"github.com/celo-org/celo-blockchain/common"
 	"github.com/celo-org/celo-blockchain/core/types"
+	"github.com/celo-org/celo-blockchain/crypto"
 	"github.com/celo-org/celo-blockchain/rlp"
 )
 
@@ -305,3 +307,126 @@ func TestForwardMessageRLPEncoding(t *testing.T) {
 		t.Fatalf("RLP Encode/Decode mismatch. Got %v, expected %v", result, original)
 	}
 }
+
+func TestEnodeCertificateRLPEncoding(t *testing.T) {
+	enodeURL := "enode://1dd9d65c4552b5eb43d5ad55a2ee3f56c6cbc1c64a5c8d659f51fcd51bace24351232b8d7821617d2b29b54b81cdefb9b3e9c37d7fd5f63270bcc9e1a6f6a439@127.0.0.1:52150"
+	var result *EnodeCertificate
+	original := &EnodeCertificate{EnodeURL: enodeURL, Version: 5}
+
+	rawVal, err := rlp.EncodeToBytes(original)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+
+	if err = rlp.DecodeBytes(rawVal, &result); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+
+	if !reflect.DeepEqual(original, result) {
+		t.Fatalf("RLP Encode/Decode mismatch. Got %v, expected %v", result, original)
+	}
+
+	// The node tag isn't encoded, so that older versions can decode the certificate
+	legacyVal, _ := rlp.EncodeToBytes([]interface{}{enodeURL, uint(5)})
+	rawVal, _ = rlp.EncodeToBytes(&EnodeCertificate{EnodeURL: enodeURL, Version: 5, NodeTag: "validator-1"})
+	if !reflect.DeepEqual(legacyVal, rawVal) {
+		t.Fatalf("Encoding with node tag mismatch. Got %x, expected %x", rawVal, legacyVal)
+	}
+
+	// Received node tags are still decoded, and sanitized
+	rawVal, _ = rlp.EncodeToBytes([]interface{}{enodeURL, uint(5), "bad\ntag\x00" + string(make([]byte, 2*MaxNodeTagLength))})
+	var tagged EnodeCertificate
+	if err := rlp.DecodeBytes(rawVal, &tagged); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if tagged.NodeTag != "badtag" {
+		t.Fatalf("Node tag not sanitized. Got %q, expected %q", tagged.NodeTag, "badtag")
+	}
+}
+
+func TestAddressEntryRLPEncoding(t *testing.T) {
+	address := common.HexToAddress("0x1234")
+	for _, reachability := range []Reachability{ReachabilityUnknown, Reachable, Unreachable} {
+		rawVal, err := rlp.EncodeToBytes(&AddressEntry{Address: address, Version: 5, Reachability: reachability})
+		if err != nil {
+			t.Fatalf("Error %v", err)
+		}
+
+		var result AddressEntry
+		if err = rlp.DecodeBytes(rawVal, &result); err != nil {
+			t.Fatalf("Error %v", err)
+		}
+		if result.Address != address || result.Version != 5 || result.Reachability != reachability {
+			t.Fatalf("RLP Encode/Decode mismatch. Got %v, expected reachability %v", result.String(), reachability)
+		}
+	}
+
+	// An entry of unknown reachability keeps the encoding of older versions
+	legacyVal, _ := rlp.EncodeToBytes([]interface{}{address, []byte{}, "", uint(5), uint(0), uint(0), []byte{}})
+	rawVal, _ := rlp.EncodeToBytes(&AddressEntry{Address: address, Version: 5})
+	if !reflect.DeepEqual(legacyVal, rawVal) {
+		t.Fatalf("Encoding without reachability mismatch. Got %x, expected %x", rawVal, legacyVal)
+	}
+}
+
+func TestSanitizeNodeTag(t *testing.T) {
+	longTag := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef-truncated"
+	testCases := []struct {
+		tag      string
+		expected string
+	}{
+		{"", ""},
+		{"validator-1 (eu-west)", "validator-1 (eu-west)"},
+		{"tag\twith\rcontrol\u00e9chars", "tagwithcontrolchars"},
+		{longTag, longTag[:MaxNodeTagLength]},
+	}
+	for _, tc := range testCases {
+		if sanitized := SanitizeNodeTag(tc.tag); sanitized != tc.expected {
+			t.Errorf("SanitizeNodeTag(%q) mismatch. Got %q, expected %q", tc.tag, sanitized, tc.expected)
+		}
+	}
+}
+
+func TestMessageSignEIP191(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	signFn := func(data []byte) ([]byte, error) {
+		return crypto.Sign(crypto.Keccak256(data), key)
+	}
+
+	msg := &Message{Code: QueryEnodeMsg, Msg: []byte{1, 2, 3}, Address: addr}
+	if err := msg.SignEIP191(signFn); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	payload, err := msg.Payload()
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+
+	// Round trip through the EIP-191 validation function
+	var decoded Message
+	if err := decoded.FromPayload(payload, GetEIP191SignatureAddress); err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if decoded.Address != addr {
+		t.Errorf("Signer mismatch. Got %v, expected %v", decoded.Address, addr)
+	}
+
+	// The signature must match a standard personal_sign over the unsigned payload
+	data, err := msg.PayloadNoSig()
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	pubKey, err := crypto.SigToPub(accounts.TextHash(data), msg.Signature)
+	if err != nil {
+		t.Fatalf("Error %v", err)
+	}
+	if crypto.PubkeyToAddress(*pubKey) != addr {
+		t.Errorf("personal_sign signer mismatch. Got %v, expected %v", crypto.PubkeyToAddress(*pubKey), addr)
+	}
+
+	// Verifying without the prefix must fail
+	if err := new(Message).FromPayload(payload, GetSignatureAddress); err != ErrInvalidSigner {
+		t.Errorf("Expected ErrInvalidSigner when verifying without EIP-191 prefix, got %v", err)
+	}
+}
@@ -24,6 +24,7 @@ import (
 
 	blscrypto "github.com/celo-org/celo-blockchain/crypto/bls"
 
+	"github.com/celo-org/celo-blockchain/accounts"
 	"github.com/celo-org/celo-blockchain/common"
 	"github.com/celo-org/celo-blockchain/crypto"
 	"github.com/celo-org/celo-blockchain/log"
@@ -51,6 +52,67 @@ func GetSignatureAddress(data []byte, sig []byte) (common.Address, error) {
 	return crypto.PubkeyToAddress(*pubkey), nil
 }
 
+// CheckCanonicalSignature returns an error if the signature is malleable, i.e. if its S value is
+// in the upper half of the curve order.  Every signature has such a malleated counterpart, which is
+// just as valid but changes the hash of the signed message.
+func CheckCanonicalSignature(sig []byte) error {
+	if len(sig) != crypto.SignatureLength {
+		return ErrNonCanonicalSignature
+	}
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:64])
+	if !crypto.ValidateSignatureValues(sig[64], r, s, true) {
+		return ErrNonCanonicalSignature
+	}
+	return nil
+}
+
+// GetCanonicalSignatureAddress is like GetSignatureAddress, except that non-canonical
+// signatures are rejected
+func GetCanonicalSignatureAddress(data []byte, sig []byte) (common.Address, error) {
+	if err := CheckCanonicalSignature(sig); err != nil {
+		return common.Address{}, err
+	}
+	return GetSignatureAddress(data, sig)
+}
+
+// GetCanonicalEIP191SignatureAddress is like GetEIP191SignatureAddress, except that
+// non-canonical signatures are rejected
+func GetCanonicalEIP191SignatureAddress(data []byte, sig []byte) (common.Address, error) {
+	if err := CheckCanonicalSignature(sig); err != nil {
+		return common.Address{}, err
+	}
+	return GetEIP191SignatureAddress(data, sig)
+}
+
+// MaxNodeTagLength is the maximum length of the node tag carried by announce messages
+const MaxNodeTagLength = 64
+
+// SanitizeNodeTag restricts a node tag to printable ASCII characters and truncates it
+// to MaxNodeTagLength. Node tags are informational only and must not be trusted.
+func SanitizeNodeTag(tag string) string {
+	sanitized := make([]byte, 0, len(tag))
+	for i := 0; i < len(tag) && len(sanitized) < MaxNodeTagLength; i++ {
+		if tag[i] >= 0x20 && tag[i] <= 0x7e {
+			sanitized = append(sanitized, tag[i])
+		}
+	}
+	return string(sanitized)
+}
+
+// EIP191Message wraps data with the EIP-191 personal message prefix
+// ("\x19Ethereum Signed Message:\n" + len(data) + data). Signing the keccak256
+// hash of the returned bytes is equivalent to a personal_sign over data.
+func EIP191Message(data []byte) []byte {
+	_, msg := accounts.TextAndHash(data)
+	return []byte(msg)
+}
+
+// GetEIP191SignatureAddress gets the signer address from a signature over
+// the EIP-191 prefixed data
+func GetEIP191SignatureAddress(data []byte, sig []byte) (common.Address, error) {
+	return GetSignatureAddress(EIP191Message(data), sig)
+}
+
 func CheckValidatorSignature(valSet ValidatorSet, data []byte, sig []byte) (common.Address, error) {
 	// 1. Get signature address
 	signer, err := GetSignatureAddress(data, sig)
@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/crypto"
 	blscrypto "github.com/celo-org/celo-blockchain/crypto/bls"
 )
 
@@ -283,3 +284,32 @@ func TestGetEpochNumber(t *testing.T) {
 		})
 	}
 }
+
+func TestCanonicalSignatureAddress(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	data := []byte("announce payload")
+	sig, err := crypto.Sign(crypto.Keccak256(data), key)
+	if err != nil {
+		t.Fatalf("Error in signing.  Error: %v", err)
+	}
+	if signer, err := GetCanonicalSignatureAddress(data, sig); err != nil || signer != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Errorf("Incorrect signer of a canonical signature.  Want: %v, Have: %v, err: %v", crypto.PubkeyToAddress(key.PublicKey), signer, err)
+	}
+
+	// The malleated signature (r, N-s) with the flipped recovery id recovers the same signer
+	malleated := make([]byte, len(sig))
+	copy(malleated, sig)
+	s := new(big.Int).Sub(crypto.S256().Params().N, new(big.Int).SetBytes(sig[32:64]))
+	copy(malleated[32:64], common.LeftPadBytes(s.Bytes(), 32))
+	malleated[64] ^= 1
+	if signer, err := GetSignatureAddress(data, malleated); err != nil || signer != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("The malleated signature should recover the same signer.  Want: %v, Have: %v, err: %v", crypto.PubkeyToAddress(key.PublicKey), signer, err)
+	}
+	// but is rejected when canonical signatures are enforced
+	if _, err := GetCanonicalSignatureAddress(data, malleated); err != ErrNonCanonicalSignature {
+		t.Errorf("error mismatch.  Want: %v, Have: %v", ErrNonCanonicalSignature, err)
+	}
+	if _, err := GetCanonicalSignatureAddress(data, sig[:64]); err != ErrNonCanonicalSignature {
+		t.Errorf("error mismatch for a truncated signature.  Want: %v, Have: %v", ErrNonCanonicalSignature, err)
+	}
+}
@@ -767,6 +767,71 @@ web3._extend({
 			params: 2,
 			inputFormatter: [null, null]
 		}),
+		new web3._extend.Method({
+			name: 'verifyConsistency',
+			call: 'istanbul_verifyConsistency',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'reconcilePeers',
+			call: 'istanbul_reconcilePeers',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'setAnnounceVersion',
+			call: 'istanbul_setAnnounceVersion',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'pruneVersionCertificates',
+			call: 'istanbul_pruneVersionCertificates',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'setVersionCertificateTableRequest',
+			call: 'istanbul_setVersionCertificateTableRequest',
+			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'getOwnVersionCertificate',
+			call: 'istanbul_getOwnVersionCertificate',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'getVersionHistory',
+			call: 'istanbul_getVersionHistory',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'getPartitionDiagnostics',
+			call: 'istanbul_getPartitionDiagnostics',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'getAnnounceGoroutines',
+			call: 'istanbul_getAnnounceGoroutines',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'getCooldownStatus',
+			call: 'istanbul_getCooldownStatus',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'getEnodeConflicts',
+			call: 'istanbul_getEnodeConflicts',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'pauseAnnounce',
+			call: 'istanbul_pauseAnnounce',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'resumeAnnounce',
+			call: 'istanbul_resumeAnnounce',
+			params: 0
+		}),
 		new web3._extend.Method({
 			name: 'addProxy',
 			call: 'istanbul_addProxy',
@@ -803,10 +868,22 @@ web3._extend({
 			name: 'valEnodeTableInfo',
 			getter: 'istanbul_getValEnodeTable',
 		}),
+		new web3._extend.Property({
+			name: 'valEnodeTableFingerprint',
+			getter: 'istanbul_getValEnodeTableFingerprint',
+		}),
 		new web3._extend.Property({
 			name: 'versionCertificateTableInfo',
 			getter: 'istanbul_getVersionCertificateTableInfo',
 		}),
+		new web3._extend.Property({
+			name: 'knownEnodeURLs',
+			getter: 'istanbul_getKnownEnodeURLs',
+		}),
+		new web3._extend.Property({
+			name: 'announceReport',
+			getter: 'istanbul_getAnnounceReport',
+		}),
 		new web3._extend.Property({
 			name: 'currentRoundState',
 			getter: 'istanbul_getCurrentRoundState',
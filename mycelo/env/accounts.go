@@ -4,8 +4,11 @@ import (
 	"crypto/ecdsa"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"runtime"
+	"sync"
 
 	"github.com/celo-org/celo-blockchain/accounts"
 	"github.com/celo-org/celo-blockchain/common"
@@ -34,10 +37,30 @@ func NewMnemonic() (string, error) {
 	return bip39.NewMnemonic(entropy)
 }
 
+// errExternalAccount is returned for operations that need the private key of an external account
+var errExternalAccount = errors.New("operation needs the private key of an external account")
+
 // Account represents a Celo Account
 type Account struct {
 	Address    common.Address
 	PrivateKey *ecdsa.PrivateKey
+
+	// The public key of an external account, which has no private key
+	externalPublicKey []byte
+}
+
+// NewExternalAccount creates an account whose private key is held elsewhere (e.g. in an encrypted
+// keystore), so that only its address and optionally its public key are known
+func NewExternalAccount(address common.Address, publicKey []byte) Account {
+	return Account{
+		Address:           address,
+		externalPublicKey: publicKey,
+	}
+}
+
+// IsExternal returns whether the account's private key is held elsewhere
+func (a *Account) IsExternal() bool {
+	return a.PrivateKey == nil
 }
 
 // MarshalJSON implements json.Marshaler
@@ -46,8 +69,10 @@ func (a Account) MarshalJSON() ([]byte, error) {
 		PrivateKey string
 		Address    common.Address
 	}{
-		PrivateKey: hex.EncodeToString(crypto.FromECDSA(a.PrivateKey)),
-		Address:    a.Address,
+		Address: a.Address,
+	}
+	if !a.IsExternal() {
+		data.PrivateKey = hex.EncodeToString(crypto.FromECDSA(a.PrivateKey))
 	}
 
 	return json.Marshal(data)
@@ -64,6 +89,9 @@ func (a *Account) MustBLSProofOfPossession() []byte {
 
 // BLSProofOfPossession generates bls proof of possession
 func (a *Account) BLSProofOfPossession() ([]byte, error) {
+	if a.IsExternal() {
+		return nil, errExternalAccount
+	}
 	privateKeyBytes, err := blscrypto.ECDSAToBLS(a.PrivateKey)
 	if err != nil {
 		return nil, err
@@ -90,6 +118,9 @@ func (a *Account) BLSProofOfPossession() ([]byte, error) {
 
 // BLSPublicKey returns the bls public key
 func (a *Account) BLSPublicKey() (blscrypto.SerializedPublicKey, error) {
+	if a.IsExternal() {
+		return blscrypto.SerializedPublicKey{}, errExternalAccount
+	}
 	privateKey, err := blscrypto.ECDSAToBLS(a.PrivateKey)
 	if err != nil {
 		return blscrypto.SerializedPublicKey{}, err
@@ -98,13 +129,20 @@ func (a *Account) BLSPublicKey() (blscrypto.SerializedPublicKey, error) {
 	return blscrypto.PrivateToPublic(privateKey)
 }
 
-// PublicKeyHex hex representation of the public key
+// PublicKeyHex hex representation of the public key.
+// It's nil for an external account whose public key is unknown.
 func (a *Account) PublicKey() []byte {
+	if a.IsExternal() {
+		return a.externalPublicKey
+	}
 	return crypto.FromECDSAPub(&a.PrivateKey.PublicKey)
 }
 
-// PrivateKeyHex hex representation of the private key
+// PrivateKeyHex hex representation of the private key, which is empty for an external account
 func (a *Account) PrivateKeyHex() string {
+	if a.IsExternal() {
+		return ""
+	}
 	return common.Bytes2Hex(crypto.FromECDSA(a.PrivateKey))
 }
 
@@ -134,6 +172,67 @@ var (
 	AdminAT          AccountType = 11 // Not in celotool
 )
 
+// customAccountTypes holds the names of the account types registered with RegisterAccountType
+// hardenedKeyStart is the first hardened child index of a derivation path. Account types
+// are derivation indexes, so they must stay below it to not collide with hardened ones.
+const hardenedKeyStart = 0x80000000
+
+var (
+	customAccountTypes   = make(map[AccountType]string)
+	customAccountTypesMu sync.RWMutex
+)
+
+// RegisterAccountType registers a custom account type with the given name, so that
+// accounts of that type can be derived. The account type is used as the derivation
+// namespace, so it must not collide with a built-in or previously registered one, and
+// it must be below 2^31, the start of the hardened derivation indexes.
+func RegisterAccountType(accountType AccountType, name string) error {
+	if accountType < 0 || int64(accountType) >= hardenedKeyStart {
+		return fmt.Errorf("invalid account type %d", accountType)
+	}
+	if name == "" {
+		return fmt.Errorf("missing name for account type %d", accountType)
+	}
+	if _, err := accountType.MarshalText(); err == nil {
+		return fmt.Errorf("account type %d is already registered as %q", accountType, accountType.String())
+	}
+	var existing AccountType
+	if err := existing.UnmarshalText([]byte(name)); err == nil {
+		return fmt.Errorf("account type name %q is already registered for account type %d", name, existing)
+	}
+
+	customAccountTypesMu.Lock()
+	defer customAccountTypesMu.Unlock()
+	// Check again in case of a concurrent registration
+	for registeredType, registeredName := range customAccountTypes {
+		if registeredType == accountType || registeredName == name {
+			return fmt.Errorf("account type %d (%q) is already registered", registeredType, registeredName)
+		}
+	}
+	customAccountTypes[accountType] = name
+	return nil
+}
+
+// customAccountTypeName returns the name of a registered custom account type
+func customAccountTypeName(accountType AccountType) (string, bool) {
+	customAccountTypesMu.RLock()
+	defer customAccountTypesMu.RUnlock()
+	name, ok := customAccountTypes[accountType]
+	return name, ok
+}
+
+// customAccountTypeFromName returns the registered custom account type with the given name
+func customAccountTypeFromName(name string) (AccountType, bool) {
+	customAccountTypesMu.RLock()
+	defer customAccountTypesMu.RUnlock()
+	for accountType, registeredName := range customAccountTypes {
+		if registeredName == name {
+			return accountType, true
+		}
+	}
+	return 0, false
+}
+
 // String implements the stringer interface.
 func (accountType AccountType) String() string {
 	switch accountType {
@@ -162,6 +261,9 @@ func (accountType AccountType) String() string {
 	case AdminAT:
 		return "admin"
 	default:
+		if name, ok := customAccountTypeName(accountType); ok {
+			return name
+		}
 		return "unknown"
 	}
 }
@@ -194,6 +296,9 @@ func (accountType AccountType) MarshalText() ([]byte, error) {
 	case AdminAT:
 		return []byte("admin"), nil
 	default:
+		if name, ok := customAccountTypeName(accountType); ok {
+			return []byte(name), nil
+		}
 		return nil, fmt.Errorf("unknown account type %d", accountType)
 	}
 }
@@ -226,6 +331,10 @@ func (accountType *AccountType) UnmarshalText(text []byte) error {
 	case "admin":
 		*accountType = AdminAT
 	default:
+		if customAccountType, ok := customAccountTypeFromName(string(text)); ok {
+			*accountType = customAccountType
+			return nil
+		}
 		return fmt.Errorf(`unknown account type %q, want "validator", "developer", "txNode", "faucet", "attestation", "priceOracle", "proxy", "attestationBot", "votingBot", "txNodePrivate", "validatorGroup", "admin"`, text)
 	}
 	return nil
@@ -257,30 +366,60 @@ func DeriveAccount(mnemonic string, accountType AccountType, idx int) (*Account,
 	}, nil
 }
 
-// DeriveAccountList will generate the desired number of accounts using mnemonic & accountType
+// DeriveAccountList will generate the desired number of accounts using mnemonic & accountType.
+// Accounts are derived in parallel, and returned ordered by index.
 func DeriveAccountList(mnemonic string, accountType AccountType, qty int) ([]Account, error) {
+	return deriveAccountList(mnemonic, accountType, qty, runtime.NumCPU())
+}
+
+// deriveAccountList derives the accounts with the given number of workers, each of which
+// derives the accounts whose index is congruent to the worker's number
+func deriveAccountList(mnemonic string, accountType AccountType, qty int, workers int) ([]Account, error) {
 	wallet, err := hdwallet.NewFromMnemonic(mnemonic)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	accounts := make([]Account, qty)
+	errs := make([]error, qty)
 
-	for i := 0; i < qty; i++ {
-		account, err := wallet.Derive(mustDerivationPath(accountType, i), false)
-		if err != nil {
-			return nil, err
-		}
-		pk, err := wallet.PrivateKey(account)
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > qty {
+		workers = qty
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < qty; i += workers {
+				account, err := wallet.Derive(mustDerivationPath(accountType, i), false)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				pk, err := wallet.PrivateKey(account)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				accounts[i] = Account{
+					Address:    account.Address,
+					PrivateKey: pk,
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Return the error of the lowest index, as a sequential derivation would
+	for _, err := range errs {
 		if err != nil {
 			return nil, err
 		}
-		accounts[i] = Account{
-			Address:    account.Address,
-			PrivateKey: pk,
-		}
 	}
-
 	return accounts, nil
 }
 
@@ -0,0 +1,102 @@
+package env
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+// unregisterAccountType removes a custom account type registered with RegisterAccountType
+func unregisterAccountType(accountType AccountType) {
+	customAccountTypesMu.Lock()
+	defer customAccountTypesMu.Unlock()
+	delete(customAccountTypes, accountType)
+}
+
+func TestRegisterAccountType(t *testing.T) {
+	RegisterTestingT(t)
+
+	cfg := AccountsConfig{
+		Mnemonic: "tag volcano eight thank tide danger coast health above argue embrace heavy",
+	}
+
+	const oracleAT AccountType = 100
+
+	// Unregistered account types can't be derived
+	_, err := cfg.DeriveAccounts(oracleAT, 2)
+	Ω(err).Should(HaveOccurred())
+
+	Ω(RegisterAccountType(oracleAT, "customOracle")).Should(Succeed())
+	// The registry is package global, so don't leak the account type into other tests or runs
+	defer unregisterAccountType(oracleAT)
+	Ω(oracleAT.String()).Should(Equal("customOracle"))
+
+	accounts, err := cfg.DeriveAccounts(oracleAT, 2)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(accounts).Should(HaveLen(2))
+	for i := range accounts {
+		acc, err := cfg.Account(oracleAT, i)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(accounts[i].Address).Should(Equal(acc.Address))
+	}
+
+	// Accounts of a custom type live in their own derivation namespace
+	validators, err := cfg.DeriveAccounts(ValidatorAT, 2)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(accounts[0].Address).ShouldNot(Equal(validators[0].Address))
+
+	// Custom account types are marshalled by name
+	raw, err := json.Marshal(oracleAT)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(string(raw)).Should(Equal(`"customOracle"`))
+	var accType AccountType
+	Ω(json.Unmarshal(raw, &accType)).Should(Succeed())
+	Ω(accType).Should(Equal(oracleAT))
+
+	// Collisions with built-in or registered account types are rejected
+	Ω(RegisterAccountType(oracleAT, "anotherOracle")).ShouldNot(Succeed())
+	Ω(RegisterAccountType(ValidatorAT, "anotherValidator")).ShouldNot(Succeed())
+	Ω(RegisterAccountType(101, "validator")).ShouldNot(Succeed())
+	Ω(RegisterAccountType(101, "customOracle")).ShouldNot(Succeed())
+	Ω(RegisterAccountType(-1, "negative")).ShouldNot(Succeed())
+	Ω(RegisterAccountType(AccountType(int64(hardenedKeyStart)), "hardened")).ShouldNot(Succeed())
+	Ω(RegisterAccountType(101, "")).ShouldNot(Succeed())
+}
+
+func TestDeriveAccountListParallel(t *testing.T) {
+	RegisterTestingT(t)
+
+	const mnemonic = "tag volcano eight thank tide danger coast health above argue embrace heavy"
+
+	sequential, err := deriveAccountList(mnemonic, DeveloperAT, 50, 1)
+	Ω(err).ShouldNot(HaveOccurred())
+	for _, idx := range []int{0, 49} {
+		acc, err := DeriveAccount(mnemonic, DeveloperAT, idx)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(sequential[idx].Address).Should(Equal(acc.Address))
+	}
+
+	for _, workers := range []int{2, 7, 64} {
+		parallel, err := deriveAccountList(mnemonic, DeveloperAT, 50, workers)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(parallel).Should(HaveLen(len(sequential)))
+		for i := range sequential {
+			Ω(parallel[i].Address).Should(Equal(sequential[i].Address))
+			Ω(parallel[i].PrivateKey.D).Should(Equal(sequential[i].PrivateKey.D))
+		}
+	}
+
+	accounts, err := DeriveAccountList(mnemonic, DeveloperAT, 0)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(accounts).Should(BeEmpty())
+}
+
+func BenchmarkDeriveAccountList(b *testing.B) {
+	const mnemonic = "tag volcano eight thank tide danger coast health above argue embrace heavy"
+	for i := 0; i < b.N; i++ {
+		if _, err := DeriveAccountList(mnemonic, DeveloperAT, 5000); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
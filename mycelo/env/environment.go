@@ -17,6 +17,9 @@ type Environment struct {
 
 // New creates a new environment
 func New(envpath string, cfg *Config) (*Environment, error) {
+	if err := cfg.Accounts.Validate(); err != nil {
+		return nil, err
+	}
 	env := &Environment{
 		paths:  paths{Workdir: envpath},
 		Config: *cfg,
@@ -34,6 +37,9 @@ func Load(envpath string) (*Environment, error) {
 	if err := utils.ReadJson(&env.Config, env.paths.envJSON()); err != nil {
 		return nil, err
 	}
+	if err := env.Config.Accounts.Validate(); err != nil {
+		return nil, err
+	}
 
 	return env, nil
 }
@@ -1,7 +1,12 @@
 package env
 
 import (
+	"fmt"
 	"math/big"
+
+	"github.com/celo-org/celo-blockchain/common"
+	"github.com/celo-org/celo-blockchain/common/hexutil"
+	"github.com/celo-org/celo-blockchain/crypto"
 )
 
 // Config represents mycelo environment parameters
@@ -15,8 +20,26 @@ type AccountsConfig struct {
 	Mnemonic             string `json:"mnemonic"`            // Accounts mnemonic
 	NumValidators        int    `json:"validators"`          // Number of initial validators
 	ValidatorsPerGroup   int    `json:"validatorsPerGroup"`  // Number of validators per group in the initial set
+	MaxGroups            int    `json:"maxGroups,omitempty"` // Optional maximum number of groups in the initial set, extra validators are spread across larger groups
 	NumDeveloperAccounts int    `json:"developerAccounts"`   // Number of developers accounts
 	UseValidatorAsAdmin  bool   `json:"useValidatorAsAdmin"` // Whether to use the first validator as the admin (for compatibility with monorepo)
+	// Optional externally managed accounts per account type, which are used instead of deriving them from the mnemonic
+	ExternalAccounts map[AccountType][]ExternalAccount `json:"externalAccounts,omitempty"`
+}
+
+// ExternalAccount is an account whose private key is held elsewhere, e.g. in an encrypted keystore
+type ExternalAccount struct {
+	Address   common.Address `json:"address"`
+	PublicKey hexutil.Bytes  `json:"publicKey,omitempty"` // Optional, required for accounts that are registered in genesis
+}
+
+// AllocationBalances represents the initial balance of each account category in a genesis allocation.
+// Categories with a nil balance are left out of the allocation.
+type AllocationBalances struct {
+	Admin     *big.Int // Balance of the admin account
+	Validator *big.Int // Balance of each validator account
+	Developer *big.Int // Balance of each developer account
+	Faucet    *big.Int // Balance of the faucet account
 }
 
 // ValidatorGroup represents a group plus its validators members
@@ -25,12 +48,129 @@ type ValidatorGroup struct {
 	Validators []Account
 }
 
+// AccountSummary represents an environment account in a compact form
+type AccountSummary struct {
+	Type         AccountType    `json:"type"`
+	Index        int            `json:"index"`
+	Address      common.Address `json:"address"`
+	PublicKeyHex string         `json:"publicKey"`
+}
+
+// Validate checks that the accounts configuration is valid
+func (ac *AccountsConfig) Validate() error {
+	if ac.ValidatorsPerGroup <= 0 {
+		return fmt.Errorf("validatorsPerGroup must be positive, got %d", ac.ValidatorsPerGroup)
+	}
+	if ac.MaxGroups < 0 {
+		return fmt.Errorf("maxGroups must be positive when set, got %d", ac.MaxGroups)
+	}
+	for accType, externalAccounts := range ac.ExternalAccounts {
+		if err := ac.validateExternalAccounts(accType, externalAccounts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateExternalAccounts checks that the external accounts can replace the derived accounts of the given type
+func (ac *AccountsConfig) validateExternalAccounts(accType AccountType, externalAccounts []ExternalAccount) error {
+	if _, err := accType.MarshalText(); err != nil {
+		return err
+	}
+	switch accType {
+	case ValidatorAT:
+		// Validators need their private keys for their BLS keys, and to run their nodes
+		return fmt.Errorf("%s accounts can't be external", accType)
+	case AdminAT:
+		if ac.UseValidatorAsAdmin {
+			return fmt.Errorf("%s accounts can't be external when the first validator is the admin", accType)
+		}
+	}
+	if bound, hasBound := ac.numAccounts(accType); hasBound && len(externalAccounts) != bound {
+		return fmt.Errorf("got %d external %s accounts, want %d", len(externalAccounts), accType, bound)
+	}
+	for i, externalAccount := range externalAccounts {
+		if len(externalAccount.PublicKey) == 0 {
+			if accType == ValidatorGroupAT {
+				// Registering a group sets its account data encryption key
+				return fmt.Errorf("missing public key of external %s account %d", accType, i)
+			}
+			continue
+		}
+		publicKey, err := crypto.UnmarshalPubkey(externalAccount.PublicKey)
+		if err != nil {
+			return fmt.Errorf("invalid public key of external %s account %d: %v", accType, i, err)
+		}
+		if address := crypto.PubkeyToAddress(*publicKey); address != externalAccount.Address {
+			return fmt.Errorf("public key of external %s account %d is for %s, not %s", accType, i, address.Hex(), externalAccount.Address.Hex())
+		}
+	}
+	return nil
+}
+
+// accountList returns the first qty accounts of the given type, which are either external
+// or derived from the mnemonic
+func (ac *AccountsConfig) accountList(accType AccountType, qty int) ([]Account, error) {
+	externalAccounts, ok := ac.ExternalAccounts[accType]
+	if !ok {
+		return DeriveAccountList(ac.Mnemonic, accType, qty)
+	}
+	if qty > len(externalAccounts) {
+		return nil, fmt.Errorf("requested %d %s accounts, but there are only %d external ones", qty, accType, len(externalAccounts))
+	}
+	accounts := make([]Account, qty)
+	for i := range accounts {
+		accounts[i] = NewExternalAccount(externalAccounts[i].Address, externalAccounts[i].PublicKey)
+	}
+	return accounts, nil
+}
+
+// account returns the account of the given type at the given index, which is either external
+// or derived from the mnemonic
+func (ac *AccountsConfig) account(accType AccountType, idx int) (*Account, error) {
+	externalAccounts, ok := ac.ExternalAccounts[accType]
+	if !ok {
+		return DeriveAccount(ac.Mnemonic, accType, idx)
+	}
+	if idx < 0 || idx >= len(externalAccounts) {
+		return nil, fmt.Errorf("external %s account index %d out of range, there are %d", accType, idx, len(externalAccounts))
+	}
+	acc := NewExternalAccount(externalAccounts[idx].Address, externalAccounts[idx].PublicKey)
+	return &acc, nil
+}
+
 // NumValidatorGroups retrieves the number of validator groups for the genesis
 func (ac *AccountsConfig) NumValidatorGroups() int {
+	numGroups := ac.NumValidators / ac.ValidatorsPerGroup
 	if (ac.NumValidators % ac.ValidatorsPerGroup) > 0 {
-		return (ac.NumValidators / ac.ValidatorsPerGroup) + 1
+		numGroups++
+	}
+	if ac.MaxGroups > 0 && numGroups > ac.MaxGroups {
+		return ac.MaxGroups
+	}
+	return numGroups
+}
+
+// MaxValidatorsPerGroup retrieves the number of validators in the largest group for the genesis,
+// which exceeds ValidatorsPerGroup when the number of groups is capped by MaxGroups
+func (ac *AccountsConfig) MaxValidatorsPerGroup() int {
+	numGroups := ac.NumValidatorGroups()
+	if numGroups == 0 || numGroups*ac.ValidatorsPerGroup >= ac.NumValidators {
+		return ac.ValidatorsPerGroup
+	}
+	return (ac.NumValidators + numGroups - 1) / numGroups
+}
+
+// ValidateMaxGroupSize checks that the groups capped by MaxGroups don't have more validators than
+// the validators contract's maximum group size, which would otherwise fail the genesis deployment
+func (ac *AccountsConfig) ValidateMaxGroupSize(maxGroupSize uint64) error {
+	if ac.MaxGroups == 0 {
+		return nil
+	}
+	if size := ac.MaxValidatorsPerGroup(); uint64(size) > maxGroupSize {
+		return fmt.Errorf("maxGroups packs %d validators into a group, more than the maximum group size %d", size, maxGroupSize)
 	}
-	return ac.NumValidators / ac.ValidatorsPerGroup
+	return nil
 }
 
 // AdminAccount returns the environment's admin account
@@ -39,7 +179,7 @@ func (ac *AccountsConfig) AdminAccount() *Account {
 	if ac.UseValidatorAsAdmin {
 		at = ValidatorAT
 	}
-	acc, err := DeriveAccount(ac.Mnemonic, at, 0)
+	acc, err := ac.account(at, 0)
 	if err != nil {
 		panic(err)
 	}
@@ -48,7 +188,7 @@ func (ac *AccountsConfig) AdminAccount() *Account {
 
 // DeveloperAccounts returns the environment's developers accounts
 func (ac *AccountsConfig) DeveloperAccounts() []Account {
-	accounts, err := DeriveAccountList(ac.Mnemonic, DeveloperAT, ac.NumDeveloperAccounts)
+	accounts, err := ac.accountList(DeveloperAT, ac.NumDeveloperAccounts)
 	if err != nil {
 		panic(err)
 	}
@@ -57,12 +197,62 @@ func (ac *AccountsConfig) DeveloperAccounts() []Account {
 
 // Account retrieves the account corresponding to the (accountType, idx)
 func (ac *AccountsConfig) Account(accType AccountType, idx int) (*Account, error) {
-	return DeriveAccount(ac.Mnemonic, accType, idx)
+	return ac.account(accType, idx)
+}
+
+// DeriveAccounts returns the first qty accounts of the given type, which is either
+// a built-in account type or one registered with RegisterAccountType
+func (ac *AccountsConfig) DeriveAccounts(accType AccountType, qty int) ([]Account, error) {
+	if _, err := accType.MarshalText(); err != nil {
+		return nil, err
+	}
+	return ac.accountList(accType, qty)
+}
+
+// Accounts returns the accounts of the given type at the given indices, in the same order.
+// Indices must be non-negative, and within the configured number of accounts for account
+// types that have one.
+func (ac *AccountsConfig) Accounts(accType AccountType, indices []int) ([]Account, error) {
+	if _, err := accType.MarshalText(); err != nil {
+		return nil, err
+	}
+	bound, hasBound := ac.numAccounts(accType)
+	accounts := make([]Account, len(indices))
+	for i, idx := range indices {
+		if idx < 0 {
+			return nil, fmt.Errorf("invalid negative account index %d", idx)
+		}
+		if hasBound && idx >= bound {
+			return nil, fmt.Errorf("account index %d out of range, there are %d %s accounts", idx, bound, accType)
+		}
+		acc, err := ac.account(accType, idx)
+		if err != nil {
+			return nil, err
+		}
+		accounts[i] = *acc
+	}
+	return accounts, nil
+}
+
+// numAccounts returns the configured number of accounts of the given type, if it has one
+func (ac *AccountsConfig) numAccounts(accType AccountType) (int, bool) {
+	switch accType {
+	case AdminAT:
+		return 1, true
+	case ValidatorAT:
+		return ac.NumValidators, true
+	case ValidatorGroupAT:
+		return ac.NumValidatorGroups(), true
+	case DeveloperAT:
+		return ac.NumDeveloperAccounts, true
+	default:
+		return 0, false
+	}
 }
 
 // ValidatorAccounts returns the environment's validators accounts
 func (ac *AccountsConfig) ValidatorAccounts() []Account {
-	accounts, err := DeriveAccountList(ac.Mnemonic, ValidatorAT, ac.NumValidators)
+	accounts, err := ac.accountList(ValidatorAT, ac.NumValidators)
 	if err != nil {
 		panic(err)
 	}
@@ -71,7 +261,7 @@ func (ac *AccountsConfig) ValidatorAccounts() []Account {
 
 // ValidatorGroupAccounts returns the environment's validators group accounts
 func (ac *AccountsConfig) ValidatorGroupAccounts() []Account {
-	accounts, err := DeriveAccountList(ac.Mnemonic, ValidatorGroupAT, ac.NumValidatorGroups())
+	accounts, err := ac.accountList(ValidatorGroupAT, ac.NumValidatorGroups())
 	if err != nil {
 		panic(err)
 	}
@@ -85,19 +275,116 @@ func (ac *AccountsConfig) ValidatorGroups() []ValidatorGroup {
 	groupAccounts := ac.ValidatorGroupAccounts()
 	validatorAccounts := ac.ValidatorAccounts()
 
-	for i := 0; i < (len(groups) - 1); i++ {
+	if len(groups)*ac.ValidatorsPerGroup < len(validatorAccounts) {
+		// the number of groups is capped, so the validators are spread evenly across the groups,
+		// with the first groups taking one extra validator each for the remainder
+		start := 0
+		for i := range groups {
+			end := start + len(validatorAccounts)/len(groups)
+			if i < len(validatorAccounts)%len(groups) {
+				end++
+			}
+			groups[i] = ValidatorGroup{
+				Account:    groupAccounts[i],
+				Validators: validatorAccounts[start:end],
+			}
+			start = end
+		}
+		return groups
+	}
+
+	for i := range groups {
+		// the last group might not be full, so the upper bound is clamped to the number of validators
+		start := ac.ValidatorsPerGroup * i
+		end := start + ac.ValidatorsPerGroup
+		if end > len(validatorAccounts) {
+			end = len(validatorAccounts)
+		}
 		groups[i] = ValidatorGroup{
 			Account:    groupAccounts[i],
-			Validators: validatorAccounts[ac.ValidatorsPerGroup*i : ac.ValidatorsPerGroup*(i+1)],
+			Validators: validatorAccounts[start:end],
+		}
+	}
+
+	return groups
+}
+
+// AccountSummaries returns a summary of all the environment's accounts, in the order:
+// admin, validators, validator groups and developers.
+// The admin is omitted when the first validator is used as the admin.
+func (ac *AccountsConfig) AccountSummaries() []AccountSummary {
+	var summaries []AccountSummary
+	appendSummaries := func(accType AccountType, accounts []Account) {
+		for i := range accounts {
+			summaries = append(summaries, AccountSummary{
+				Type:         accType,
+				Index:        i,
+				Address:      accounts[i].Address,
+				PublicKeyHex: hexutil.Encode(accounts[i].PublicKey()),
+			})
 		}
 	}
 
-	// last group might not be full, use an open slice for validators
-	i := len(groups) - 1
-	groups[i] = ValidatorGroup{
-		Account:    groupAccounts[i],
-		Validators: validatorAccounts[ac.ValidatorsPerGroup*i:],
+	if !ac.UseValidatorAsAdmin {
+		appendSummaries(AdminAT, []Account{*ac.AdminAccount()})
 	}
+	appendSummaries(ValidatorAT, ac.ValidatorAccounts())
+	appendSummaries(ValidatorGroupAT, ac.ValidatorGroupAccounts())
+	appendSummaries(DeveloperAT, ac.DeveloperAccounts())
 
-	return groups
+	return summaries
+}
+
+// GenesisAllocation returns the initial balance of every account of the categories with a balance
+// in balances. The faucet balance goes to the first faucet account. An account that is in several
+// categories (e.g. the admin when the first validator is used as the admin) gets the sum of their balances.
+func (ac *AccountsConfig) GenesisAllocation(balances AllocationBalances) (map[common.Address]*big.Int, error) {
+	allocation := make(map[common.Address]*big.Int)
+	allocate := func(accounts []Account, balance *big.Int) {
+		if balance == nil {
+			return
+		}
+		for _, acc := range accounts {
+			if current, ok := allocation[acc.Address]; ok {
+				allocation[acc.Address] = new(big.Int).Add(current, balance)
+			} else {
+				allocation[acc.Address] = new(big.Int).Set(balance)
+			}
+		}
+	}
+
+	if balances.Admin != nil {
+		at := AdminAT
+		if ac.UseValidatorAsAdmin {
+			at = ValidatorAT
+		}
+		admin, err := ac.account(at, 0)
+		if err != nil {
+			return nil, err
+		}
+		allocate([]Account{*admin}, balances.Admin)
+	}
+	if balances.Validator != nil {
+		validators, err := ac.accountList(ValidatorAT, ac.NumValidators)
+		if err != nil {
+			return nil, err
+		}
+		allocate(validators, balances.Validator)
+	}
+	if balances.Developer != nil {
+		developers, err := ac.accountList(DeveloperAT, ac.NumDeveloperAccounts)
+		if err != nil {
+			return nil, err
+		}
+		allocate(developers, balances.Developer)
+	}
+	if balances.Faucet != nil {
+		faucet, err := ac.account(FaucetAT, 0)
+		if err != nil {
+			return nil, err
+		}
+		allocate([]Account{*faucet}, balances.Faucet)
+	}
+
+	return allocation, nil
 }
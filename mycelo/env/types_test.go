@@ -5,6 +5,8 @@ import (
 	"math/big"
 	"testing"
 
+	"github.com/celo-org/celo-blockchain/common/hexutil"
+	"github.com/celo-org/celo-blockchain/crypto"
 	. "github.com/onsi/gomega"
 )
 
@@ -62,3 +64,283 @@ func TestConfigReadJson(t *testing.T) {
 	Ω(resultCfg).Should(Equal(expectedCfg))
 
 }
+
+func TestAccountSummaries(t *testing.T) {
+	RegisterTestingT(t)
+
+	cfg := AccountsConfig{
+		Mnemonic:             "tag volcano eight thank tide danger coast health above argue embrace heavy",
+		NumValidators:        3,
+		ValidatorsPerGroup:   2,
+		NumDeveloperAccounts: 2,
+	}
+
+	summaries := cfg.AccountSummaries()
+	Ω(summaries).Should(HaveLen(1 + 3 + 2 + 2))
+
+	expectedTypes := []AccountType{AdminAT, ValidatorAT, ValidatorAT, ValidatorAT, ValidatorGroupAT, ValidatorGroupAT, DeveloperAT, DeveloperAT}
+	expectedIndices := []int{0, 0, 1, 2, 0, 1, 0, 1}
+	for i, summary := range summaries {
+		Ω(summary.Type).Should(Equal(expectedTypes[i]))
+		Ω(summary.Index).Should(Equal(expectedIndices[i]))
+
+		acc, err := cfg.Account(summary.Type, summary.Index)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(summary.Address).Should(Equal(acc.Address))
+		Ω(summary.PublicKeyHex).Should(Equal(hexutil.Encode(acc.PublicKey())))
+	}
+
+	// The first developer account is derived at m/1/0
+	Ω(summaries[6].Address.Hex()).Should(Equal("0x4aE929135C7ba9E0d2AB8583BffD1cA75db02931"))
+
+	// Output is stable across calls
+	Ω(cfg.AccountSummaries()).Should(Equal(summaries))
+
+	// The admin is omitted when the first validator is used as admin
+	cfg.UseValidatorAsAdmin = true
+	Ω(cfg.AccountSummaries()).Should(Equal(summaries[1:]))
+}
+
+func TestValidatorGroups(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := []struct {
+		name               string
+		numValidators      int
+		validatorsPerGroup int
+		maxGroups          int
+		expectedGroupSizes []int
+	}{
+		{"divisible", 6, 2, 0, []int{2, 2, 2}},
+		{"non divisible", 7, 3, 0, []int{3, 3, 1}},
+		{"single validator", 1, 1, 0, []int{1}},
+		{"single group not full", 1, 3, 0, []int{1}},
+		{"no validators", 0, 2, 0, []int{}},
+		{"uncapped", 6, 2, 3, []int{2, 2, 2}},
+		{"uncapped below max groups", 6, 2, 5, []int{2, 2, 2}},
+		{"capped divisible", 6, 2, 2, []int{3, 3}},
+		{"capped non divisible", 7, 2, 3, []int{3, 2, 2}},
+		{"capped single group", 7, 3, 1, []int{7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RegisterTestingT(t)
+
+			cfg := AccountsConfig{
+				Mnemonic:           "tag volcano eight thank tide danger coast health above argue embrace heavy",
+				NumValidators:      tt.numValidators,
+				ValidatorsPerGroup: tt.validatorsPerGroup,
+				MaxGroups:          tt.maxGroups,
+			}
+			Ω(cfg.Validate()).Should(Succeed())
+
+			groups := cfg.ValidatorGroups()
+			Ω(groups).Should(HaveLen(len(tt.expectedGroupSizes)))
+			Ω(cfg.NumValidatorGroups()).Should(Equal(len(tt.expectedGroupSizes)))
+
+			maxGroupSize := tt.validatorsPerGroup
+			for _, size := range tt.expectedGroupSizes {
+				if size > maxGroupSize {
+					maxGroupSize = size
+				}
+			}
+			Ω(cfg.MaxValidatorsPerGroup()).Should(Equal(maxGroupSize))
+
+			groupAccounts := cfg.ValidatorGroupAccounts()
+			var groupedValidators []Account
+			for i, group := range groups {
+				Ω(group.Account).Should(Equal(groupAccounts[i]))
+				Ω(group.Validators).Should(HaveLen(tt.expectedGroupSizes[i]))
+				groupedValidators = append(groupedValidators, group.Validators...)
+			}
+
+			// Every validator belongs to exactly one group, in order
+			validators := cfg.ValidatorAccounts()
+			Ω(groupedValidators).Should(HaveLen(len(validators)))
+			for i := range validators {
+				Ω(groupedValidators[i]).Should(Equal(validators[i]))
+			}
+		})
+	}
+}
+
+func TestAccountsConfigValidate(t *testing.T) {
+	RegisterTestingT(t)
+
+	cfg := AccountsConfig{NumValidators: 6, ValidatorsPerGroup: 2}
+	Ω(cfg.Validate()).Should(Succeed())
+
+	cfg.MaxGroups = -1
+	Ω(cfg.Validate()).ShouldNot(Succeed())
+
+	cfg.MaxGroups = 0
+	cfg.ValidatorsPerGroup = 0
+	Ω(cfg.Validate()).ShouldNot(Succeed())
+}
+
+func TestAccountsConfigValidateMaxGroupSize(t *testing.T) {
+	RegisterTestingT(t)
+
+	// Uncapped groups are never larger than validatorsPerGroup
+	cfg := AccountsConfig{NumValidators: 12, ValidatorsPerGroup: 2}
+	Ω(cfg.ValidateMaxGroupSize(1)).Should(Succeed())
+
+	// Capping the number of groups packs 4 validators into each group
+	cfg.MaxGroups = 3
+	Ω(cfg.ValidateMaxGroupSize(5)).Should(Succeed())
+	Ω(cfg.ValidateMaxGroupSize(4)).Should(Succeed())
+	Ω(cfg.ValidateMaxGroupSize(3)).ShouldNot(Succeed())
+}
+
+func TestAccountsAtIndices(t *testing.T) {
+	RegisterTestingT(t)
+
+	cfg := AccountsConfig{
+		Mnemonic:             "tag volcano eight thank tide danger coast health above argue embrace heavy",
+		NumValidators:        10,
+		ValidatorsPerGroup:   3,
+		NumDeveloperAccounts: 5,
+	}
+
+	validators := cfg.ValidatorAccounts()
+	accounts, err := cfg.Accounts(ValidatorAT, []int{3, 7})
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(accounts).Should(HaveLen(2))
+	Ω(accounts[0].Address).Should(Equal(validators[3].Address))
+	Ω(accounts[1].Address).Should(Equal(validators[7].Address))
+
+	// Indices are returned in the requested order
+	accounts, err = cfg.Accounts(ValidatorGroupAT, []int{3, 0})
+	Ω(err).ShouldNot(HaveOccurred())
+	groups := cfg.ValidatorGroupAccounts()
+	Ω(accounts[0].Address).Should(Equal(groups[3].Address))
+	Ω(accounts[1].Address).Should(Equal(groups[0].Address))
+
+	_, err = cfg.Accounts(ValidatorAT, []int{-1})
+	Ω(err).Should(HaveOccurred())
+	_, err = cfg.Accounts(ValidatorAT, []int{10})
+	Ω(err).Should(HaveOccurred())
+	_, err = cfg.Accounts(ValidatorGroupAT, []int{4})
+	Ω(err).Should(HaveOccurred())
+	_, err = cfg.Accounts(DeveloperAT, []int{5})
+	Ω(err).Should(HaveOccurred())
+}
+
+func TestExternalAccounts(t *testing.T) {
+	RegisterTestingT(t)
+
+	newExternalAccount := func() ExternalAccount {
+		key, err := crypto.GenerateKey()
+		Ω(err).ShouldNot(HaveOccurred())
+		return ExternalAccount{Address: crypto.PubkeyToAddress(key.PublicKey), PublicKey: crypto.FromECDSAPub(&key.PublicKey)}
+	}
+	admin := newExternalAccount()
+	groups := []ExternalAccount{newExternalAccount(), newExternalAccount()}
+
+	cfg := AccountsConfig{
+		Mnemonic:             "tag volcano eight thank tide danger coast health above argue embrace heavy",
+		NumValidators:        4,
+		ValidatorsPerGroup:   2,
+		NumDeveloperAccounts: 2,
+		ExternalAccounts: map[AccountType][]ExternalAccount{
+			AdminAT:          {admin},
+			ValidatorGroupAT: groups,
+		},
+	}
+	Ω(cfg.Validate()).Should(Succeed())
+
+	// The external categories use the supplied addresses and public keys
+	Ω(cfg.AdminAccount().Address).Should(Equal(admin.Address))
+	Ω(cfg.AdminAccount().IsExternal()).Should(BeTrue())
+	groupAccounts := cfg.ValidatorGroupAccounts()
+	Ω(groupAccounts).Should(HaveLen(2))
+	for i, acc := range groupAccounts {
+		Ω(acc.Address).Should(Equal(groups[i].Address))
+		Ω(acc.PublicKey()).Should(Equal([]byte(groups[i].PublicKey)))
+		Ω(acc.IsExternal()).Should(BeTrue())
+	}
+	acc, err := cfg.Account(ValidatorGroupAT, 1)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(acc.Address).Should(Equal(groups[1].Address))
+
+	// The other categories are still derived
+	derived, err := DeriveAccountList(cfg.Mnemonic, ValidatorAT, 4)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(cfg.ValidatorAccounts()).Should(Equal(derived))
+	for _, acc := range cfg.DeveloperAccounts() {
+		Ω(acc.IsExternal()).Should(BeFalse())
+	}
+	Ω(cfg.ValidatorGroups()[1].Validators).Should(Equal(derived[2:]))
+
+	// The number of external accounts must match the configured number
+	cfg.NumValidators = 6
+	Ω(cfg.Validate()).ShouldNot(Succeed())
+	cfg.NumValidators = 4
+
+	// Validators can't be external
+	cfg.ExternalAccounts[ValidatorAT] = []ExternalAccount{newExternalAccount(), newExternalAccount(), newExternalAccount(), newExternalAccount()}
+	Ω(cfg.Validate()).ShouldNot(Succeed())
+	delete(cfg.ExternalAccounts, ValidatorAT)
+
+	// Public keys must match the addresses, and groups need them
+	cfg.ExternalAccounts[ValidatorGroupAT] = []ExternalAccount{groups[0], {Address: groups[1].Address, PublicKey: groups[0].PublicKey}}
+	Ω(cfg.Validate()).ShouldNot(Succeed())
+	cfg.ExternalAccounts[ValidatorGroupAT] = []ExternalAccount{groups[0], {Address: groups[1].Address}}
+	Ω(cfg.Validate()).ShouldNot(Succeed())
+
+	// External accounts survive a JSON round trip
+	cfg.ExternalAccounts[ValidatorGroupAT] = groups
+	raw, err := json.Marshal(cfg)
+	Ω(err).ShouldNot(HaveOccurred())
+	var resultCfg AccountsConfig
+	Ω(json.Unmarshal(raw, &resultCfg)).Should(Succeed())
+	Ω(resultCfg).Should(Equal(cfg))
+}
+
+func TestGenesisAllocation(t *testing.T) {
+	RegisterTestingT(t)
+
+	cfg := AccountsConfig{
+		Mnemonic:             "tag volcano eight thank tide danger coast health above argue embrace heavy",
+		NumValidators:        3,
+		ValidatorsPerGroup:   1,
+		NumDeveloperAccounts: 2,
+	}
+	balances := AllocationBalances{
+		Admin:     big.NewInt(1000),
+		Validator: big.NewInt(100),
+		Developer: big.NewInt(10),
+		Faucet:    big.NewInt(1),
+	}
+
+	allocation, err := cfg.GenesisAllocation(balances)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(allocation).Should(HaveLen(1 + 3 + 2 + 1))
+	Ω(allocation[cfg.AdminAccount().Address]).Should(Equal(big.NewInt(1000)))
+	for _, acc := range cfg.ValidatorAccounts() {
+		Ω(allocation[acc.Address]).Should(Equal(big.NewInt(100)))
+	}
+	for _, acc := range cfg.DeveloperAccounts() {
+		Ω(allocation[acc.Address]).Should(Equal(big.NewInt(10)))
+	}
+	faucet, err := cfg.Account(FaucetAT, 0)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(allocation[faucet.Address]).Should(Equal(big.NewInt(1)))
+
+	// The balances aren't aliased by the allocation
+	allocation[faucet.Address].SetInt64(2)
+	Ω(balances.Faucet).Should(Equal(big.NewInt(1)))
+
+	// Categories without a balance are left out
+	allocation, err = cfg.GenesisAllocation(AllocationBalances{Developer: big.NewInt(10)})
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(allocation).Should(HaveLen(2))
+
+	// The admin balance adds up with the validator balance of the first validator when it's the admin
+	cfg.UseValidatorAsAdmin = true
+	allocation, err = cfg.GenesisAllocation(balances)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(allocation).Should(HaveLen(3 + 2 + 1))
+	Ω(allocation[cfg.ValidatorAccounts()[0].Address]).Should(Equal(big.NewInt(1100)))
+}
@@ -17,6 +17,9 @@ var genesisMsgHash = common.HexToHash("ecc833a7747eaa8327335e8e0c6b6d8aa3a38d006
 
 // GenerateGenesis will create a new genesis block with full celo blockchain already configured
 func GenerateGenesis(accounts *env.AccountsConfig, cfg *Config, contractsBuildPath string) (*core.Genesis, error) {
+	if err := accounts.ValidateMaxGroupSize(cfg.Validators.MaxGroupSize); err != nil {
+		return nil, err
+	}
 
 	extraData, err := generateGenesisExtraData(accounts.ValidatorAccounts())
 	if err != nil {
@@ -848,7 +848,7 @@ func (ctx *deployContext) registerValidatorGroups() error {
 
 	groupRequiredGold := new(big.Int).Mul(
 		ctx.genesisConfig.Validators.GroupLockedGoldRequirements.Value,
-		big.NewInt(int64(ctx.accounts.ValidatorsPerGroup)),
+		big.NewInt(int64(ctx.accounts.MaxValidatorsPerGroup())),
 	)
 	groupCommission := ctx.genesisConfig.Validators.Commission.BigInt()
 
@@ -920,7 +920,7 @@ func (ctx *deployContext) voteForGroups() error {
 	// value previously locked on registerValidatorGroups()
 	lockedGoldOnGroup := new(big.Int).Mul(
 		ctx.genesisConfig.Validators.GroupLockedGoldRequirements.Value,
-		big.NewInt(int64(ctx.accounts.ValidatorsPerGroup)),
+		big.NewInt(int64(ctx.accounts.MaxValidatorsPerGroup())),
 	)
 
 	// current group order (see `addFirstMember` on addValidatorsToGroup) is: